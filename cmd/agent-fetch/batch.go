@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,95 +17,370 @@ import (
 type fetchFunc func(context.Context, string, fetcher.Config) (fetcher.Result, error)
 
 type taskResult struct {
-	index    int
-	inputURL string
-	finalURL string
-	source   string
-	markdown string
-	err      error
+	index        int
+	inputURL     string
+	finalURL     string
+	canonicalURL string
+	links        []string
+	source       string
+	markdown     string
+	attempts     int
+	diagnostics  *jsonlDiagnostics
+	err          error
+	// skipped is set for tasks a resumed run does not fetch again. Writers
+	// only use it to keep seq order.
+	skipped bool
 }
 
-func fetchBatch(ctx context.Context, urls []string, cfg fetcher.Config, concurrency int, fetch fetchFunc) []taskResult {
-	if concurrency < 1 {
-		concurrency = 1
+func newTaskResult(index int, inputURL string, res fetcher.Result, err error) taskResult {
+	return taskResult{
+		index:        index,
+		inputURL:     inputURL,
+		finalURL:     res.FinalURL,
+		canonicalURL: res.CanonicalURL,
+		links:        res.Links,
+		source:       res.Source,
+		markdown:     res.Markdown,
+		attempts:     res.Attempts,
+		diagnostics:  newJSONLDiagnostics(res),
+		err:          err,
 	}
+}
 
+func fetchBatch(ctx context.Context, urls []string, cfg fetcher.Config, limits batchLimits, fetch fetchFunc) []taskResult {
 	results := make([]taskResult, len(urls))
-	sem := make(chan struct{}, concurrency)
+	fetchBatchStream(ctx, urls, cfg, limits, fetch, func(result taskResult) {
+		results[result.index-1] = result
+	})
+	return results
+}
+
+// fetchBatchStream runs the batch like fetchBatch but hands each result to emit as soon as
+// it completes. emit is never called concurrently; results arrive in completion order.
+func fetchBatchStream(ctx context.Context, urls []string, cfg fetcher.Config, limits batchLimits, fetch fetchFunc, emit func(taskResult)) {
+	tasks := make(chan batchTask)
+	go func() {
+		defer close(tasks)
+		for _, url := range urls {
+			tasks <- batchTask{url: url, cfg: cfg}
+		}
+	}()
+	fetchTasks(ctx, tasks, limits, fetch, emit)
+}
+
+// batchTask is one URL of a batch and the config to fetch it with. A task with
+// err set is reported as failed without being fetched; a task with skip set is
+// neither fetched nor reported.
+type batchTask struct {
+	url  string
+	cfg  fetcher.Config
+	err  error
+	skip bool
+}
+
+type queuedTask struct {
+	index int
+	host  string
+	task  batchTask
+}
+
+// fetchTasks fetches tasks as they arrive, numbering them from 1 in arrival
+// order, with at most limits.concurrency fetches (and goroutines) in flight.
+// Like fetchBatchStream, it hands results to emit one at a time in completion
+// order.
+//
+// A task whose host is at its limits.hosts cap or rate, or has to wait for
+// its robots.txt Crawl-delay, or that would exceed the total a shared
+// limits.hosts allows, waits in a queue of up to batchLookahead tasks
+// while tasks for other hosts go ahead of it. Once ctx is done, queued tasks
+// start regardless of host limits and fail promptly.
+//
+// With limits.window set, no task is taken while the window of seqs from the
+// lowest unfinished task is full.
+func fetchTasks(ctx context.Context, tasks <-chan batchTask, limits batchLimits, fetch fetchFunc, emit func(taskResult)) {
+	concurrency := max(limits.concurrency, 1)
+	hosts := limits.hosts
+	if hosts == nil {
+		// Crawl-delay is kept per host even without host limits.
+		hosts = &hostLimiter{hosts: map[string]*hostSlots{}, wake: make(chan struct{})}
+	}
 
-	var wg sync.WaitGroup
-	for i, url := range urls {
-		i, url := i, url
+	var (
+		emitMu   sync.Mutex
+		wg       sync.WaitGroup
+		done     = make(chan int)
+		in       = tasks
+		queue    []queuedTask
+		index    int
+		running  int
+		lowest   = 1
+		finished = map[int]bool{}
+	)
+	start := func(q queuedTask, limited bool, started time.Time) {
+		running++
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			reqCtx, cancel := context.WithTimeout(ctx, maxDuration(cfg.Timeout, cfg.BrowserTimeout)+5*time.Second)
-			defer cancel()
-
-			res, err := fetch(reqCtx, url, cfg)
-			results[i] = taskResult{
-				index:    i + 1,
-				inputURL: url,
-				finalURL: res.FinalURL,
-				source:   res.Source,
-				markdown: res.Markdown,
-				err:      err,
+			if limited && q.task.cfg.Robots != nil {
+				// The host's later tasks wait for the Crawl-delay in the
+				// queue, not in a concurrency slot.
+				cfg := q.task.cfg.ForURL(q.task.url)
+				hosts.setCrawlDelay(q.host, started, cfg.Robots.CrawlDelay(ctx, q.task.url, cfg))
 			}
+			result := runTask(ctx, q.index, q.task, fetch)
+			if limited {
+				hosts.release(q.host)
+			}
+			emitMu.Lock()
+			emit(result)
+			emitMu.Unlock()
+			done <- q.index
 		}()
 	}
+
+	for {
+		// Take the wake channel before looking at the hosts so that a
+		// release in between is not missed.
+		wake := hosts.changed()
+		wait := time.Duration(-1)
+		now := time.Now()
+		kept := queue[:0]
+		for _, q := range queue {
+			if running >= concurrency {
+				kept = append(kept, q)
+				continue
+			}
+			if q.task.err != nil || q.task.skip || ctx.Err() != nil {
+				start(q, false, now)
+				continue
+			}
+			ok, d := hosts.acquire(q.host, now, q.task.cfg.Robots != nil)
+			if ok {
+				start(q, true, now)
+				continue
+			}
+			if d > 0 && (wait < 0 || d < wait) {
+				wait = d
+			}
+			kept = append(kept, q)
+		}
+		queue = kept
+
+		if in == nil && len(queue) == 0 && running == 0 {
+			break
+		}
+
+		recv := in
+		if running >= concurrency || len(queue) >= batchLookahead ||
+			limits.window > 0 && index-lowest+1 >= limits.window {
+			recv = nil
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait >= 0 && running < concurrency {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		var cancelled <-chan struct{}
+		if len(queue) > 0 && ctx.Err() == nil {
+			cancelled = ctx.Done()
+		}
+
+		select {
+		case task, ok := <-recv:
+			if !ok {
+				in = nil
+				continue
+			}
+			index++
+			queue = append(queue, queuedTask{index: index, host: taskHost(task.url), task: task})
+		case seq := <-done:
+			running--
+			finished[seq] = true
+			for finished[lowest] {
+				delete(finished, lowest)
+				lowest++
+			}
+		case <-timeout:
+		case <-wake:
+		case <-cancelled:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
 	wg.Wait()
+}
 
-	return results
+func runTask(ctx context.Context, index int, task batchTask, fetch fetchFunc) taskResult {
+	if task.skip {
+		return taskResult{index: index, inputURL: task.url, skipped: true}
+	}
+	if task.err != nil {
+		return newTaskResult(index, task.url, fetcher.Result{}, task.err)
+	}
+	cfg := task.cfg.ForURL(task.url)
+	reqCtx, cancel := context.WithTimeout(ctx, fetchTimeout(cfg))
+	defer cancel()
+
+	res, err := fetch(reqCtx, task.url, cfg)
+	return newTaskResult(index, task.url, res, err)
 }
 
+// writeBatchMarkdown writes results, already in input order, as task blocks
+// followed by the count comment.
 func writeBatchMarkdown(w io.Writer, results []taskResult) error {
-	total := len(results)
-	failed := failedCount(results)
-	succeeded := total - failed
-
-	if _, err := fmt.Fprintf(w, "<!-- count: %d, succeeded: %d, failed: %d -->\n", total, succeeded, failed); err != nil {
-		return err
+	out := newBatchWriter(w, formatMarkdown, jsonlOptions{})
+	for _, result := range results {
+		out.write(result)
 	}
+	return out.finish()
+}
 
-	for i, result := range results {
-		if i > 0 {
-			if _, err := io.WriteString(w, "\n"); err != nil {
-				return err
-			}
+// batchWriter writes task results as they are handed to it. An ordered writer
+// holds a result back until every lower seq has been written, so output keeps
+// input order yet starts as soon as the first task completes.
+//
+// Markdown output ends with the count comment. JSONL output ends with a
+// summary row only when jsonlSummary is set, as for --stream.
+type batchWriter struct {
+	w            io.Writer
+	format       string
+	jsonlOpts    jsonlOptions
+	jsonlSummary bool
+
+	ordered bool
+	next    int
+	pending map[int]taskResult
+	// onWrite, if set, is called after each result has been written.
+	onWrite func(taskResult)
+
+	count  int
+	failed int
+	err    error
+}
+
+// newBatchWriter returns a writer that emits results in the order they are
+// written, i.e. completion order.
+func newBatchWriter(w io.Writer, format string, jsonlOpts jsonlOptions) *batchWriter {
+	return &batchWriter{w: w, format: format, jsonlOpts: jsonlOpts}
+}
+
+// newOrderedBatchWriter returns a writer that emits results by seq, starting
+// at 1.
+func newOrderedBatchWriter(w io.Writer, format string, jsonlOpts jsonlOptions) *batchWriter {
+	return &batchWriter{w: w, format: format, jsonlOpts: jsonlOpts, ordered: true, next: 1, pending: map[int]taskResult{}}
+}
+
+type jsonlSummaryPayload struct {
+	Summary   bool `json:"summary"`
+	Count     int  `json:"count"`
+	Succeeded int  `json:"succeeded"`
+	Failed    int  `json:"failed"`
+}
+
+func (bw *batchWriter) write(result taskResult) {
+	if !bw.ordered {
+		bw.emit(result)
+		return
+	}
+	bw.pending[result.index] = result
+	for {
+		next, ok := bw.pending[bw.next]
+		if !ok {
+			return
 		}
+		delete(bw.pending, bw.next)
+		bw.next++
+		bw.emit(next)
+	}
+}
 
-		url := sanitizeForComment(result.inputURL)
-		if result.err != nil {
-			if _, err := fmt.Fprintf(w, "<!-- task[%d](failed): %s -->\n", result.index, url); err != nil {
-				return err
-			}
-			errMsg := sanitizeForComment(result.err.Error())
-			if _, err := fmt.Fprintf(w, "<!-- error[%d]: %s -->\n", result.index, errMsg); err != nil {
-				return err
-			}
-			continue
+func (bw *batchWriter) emit(result taskResult) {
+	if result.skipped {
+		return
+	}
+	bw.count++
+	if result.err != nil {
+		bw.failed++
+	}
+	if bw.err != nil {
+		return
+	}
+	if bw.format == formatJSONL {
+		bw.err = writeBatchJSONL(bw.w, []taskResult{result}, bw.jsonlOpts)
+	} else {
+		if bw.count > 1 {
+			_, bw.err = io.WriteString(bw.w, "\n")
+		}
+		if bw.err == nil {
+			bw.err = writeMarkdownTask(bw.w, result)
 		}
+	}
+	if bw.err == nil && bw.onWrite != nil {
+		bw.onWrite(result)
+	}
+}
 
-		if _, err := fmt.Fprintf(w, "<!-- task[%d]: %s -->\n", result.index, url); err != nil {
-			return err
+func (bw *batchWriter) failures() int {
+	return bw.failed
+}
+
+// finish writes any results still held back (only possible when seqs were
+// skipped) and the trailing summary, and returns the first write error.
+func (bw *batchWriter) finish() error {
+	if len(bw.pending) > 0 {
+		seqs := make([]int, 0, len(bw.pending))
+		for seq := range bw.pending {
+			seqs = append(seqs, seq)
 		}
-		if _, err := io.WriteString(w, result.markdown); err != nil {
-			return err
+		sort.Ints(seqs)
+		for _, seq := range seqs {
+			bw.emit(bw.pending[seq])
 		}
-		if !strings.HasSuffix(result.markdown, "\n") {
-			if _, err := io.WriteString(w, "\n"); err != nil {
-				return err
-			}
+		clear(bw.pending)
+	}
+	if bw.err != nil {
+		return bw.err
+	}
+
+	succeeded := bw.count - bw.failed
+	if bw.format == formatJSONL {
+		if !bw.jsonlSummary {
+			return nil
 		}
-		if _, err := fmt.Fprintf(w, "<!-- /task[%d] -->\n", result.index); err != nil {
+		enc := json.NewEncoder(bw.w)
+		return enc.Encode(jsonlSummaryPayload{Summary: true, Count: bw.count, Succeeded: succeeded, Failed: bw.failed})
+	}
+	_, err := fmt.Fprintf(bw.w, "<!-- count: %d, succeeded: %d, failed: %d -->\n", bw.count, succeeded, bw.failed)
+	return err
+}
+
+// writeMarkdownTask writes one task block of the multi-URL Markdown format.
+func writeMarkdownTask(w io.Writer, result taskResult) error {
+	url := sanitizeForComment(result.inputURL)
+	if result.err != nil {
+		if _, err := fmt.Fprintf(w, "<!-- task[%d](failed): %s -->\n", result.index, url); err != nil {
 			return err
 		}
+		errMsg := sanitizeForComment(result.err.Error())
+		_, err := fmt.Fprintf(w, "<!-- error[%d]: %s -->\n", result.index, errMsg)
+		return err
 	}
 
-	return nil
+	if _, err := fmt.Fprintf(w, "<!-- task[%d]: %s -->\n", result.index, url); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, result.markdown); err != nil {
+		return err
+	}
+	if !strings.HasSuffix(result.markdown, "\n") {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "<!-- /task[%d] -->\n", result.index)
+	return err
 }
 
 func failedCount(results []taskResult) int {
@@ -127,68 +403,188 @@ func sanitizeForComment(s string) string {
 type jsonlMeta struct {
 	Title       string `json:"title,omitempty"`
 	Description string `json:"description,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Published   string `json:"published,omitempty"`
+	Modified    string `json:"modified,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	Lang        string `json:"lang,omitempty"`
+	Canonical   string `json:"canonical,omitempty"`
+	Pages       int    `json:"pages,omitempty"`
+}
+
+type jsonlDiagnostics struct {
+	StatusCode  int                `json:"status_code,omitempty"`
+	ContentType string             `json:"content_type,omitempty"`
+	Charset     string             `json:"charset,omitempty"`
+	Headers     map[string]string  `json:"headers,omitempty"`
+	BodyBytes   int                `json:"body_bytes"`
+	Timings     []jsonlStageTiming `json:"timings,omitempty"`
+	Trace       []jsonlTraceEvent  `json:"trace,omitempty"`
+}
+
+type jsonlStageTiming struct {
+	Stage string  `json:"stage"`
+	MS    float64 `json:"ms"`
+}
+
+type jsonlTraceEvent struct {
+	Stage    string `json:"stage"`
+	Decision string `json:"decision"`
+	Detail   string `json:"detail,omitempty"`
 }
 
 type jsonlSuccessPayload struct {
-	Seq          int        `json:"seq"`
-	URL          string     `json:"url"`
-	ResolvedURL  string     `json:"resolved_url,omitempty"`
-	ResolvedMode string     `json:"resolved_mode"`
-	Content      string     `json:"content"`
-	Meta         *jsonlMeta `json:"meta,omitempty"`
+	Seq          int               `json:"seq"`
+	URL          string            `json:"url"`
+	ResolvedURL  string            `json:"resolved_url,omitempty"`
+	ResolvedMode string            `json:"resolved_mode"`
+	Attempts     int               `json:"attempts,omitempty"`
+	Chunk        int               `json:"chunk,omitempty"`
+	ChunkCount   int               `json:"chunk_count,omitempty"`
+	HeadingPath  []string          `json:"heading_path,omitempty"`
+	Content      string            `json:"content"`
+	Meta         *jsonlMeta        `json:"meta,omitempty"`
+	Diagnostics  *jsonlDiagnostics `json:"diagnostics,omitempty"`
 }
 
 type jsonlErrorPayload struct {
-	Seq   int    `json:"seq"`
-	URL   string `json:"url"`
-	Error string `json:"error"`
+	Seq         int               `json:"seq"`
+	URL         string            `json:"url"`
+	Error       string            `json:"error"`
+	Attempts    int               `json:"attempts,omitempty"`
+	Diagnostics *jsonlDiagnostics `json:"diagnostics,omitempty"`
+}
+
+type jsonlOptions struct {
+	includeMeta        bool
+	includeDiagnostics bool
+	// chunkTokens splits successful content into rows of at most this many
+	// tokens, as estimated by countTokens (fetcher.EstimateTokens when nil).
+	chunkTokens int
+	countTokens fetcher.TokenCounter
+}
+
+func newJSONLDiagnostics(res fetcher.Result) *jsonlDiagnostics {
+	if res.StatusCode == 0 && len(res.Timings) == 0 && len(res.Trace) == 0 {
+		return nil
+	}
+
+	d := &jsonlDiagnostics{
+		StatusCode:  res.StatusCode,
+		ContentType: res.ContentType,
+		Charset:     res.Charset,
+		BodyBytes:   res.BodyBytes,
+	}
+	if len(res.Header) > 0 {
+		d.Headers = make(map[string]string, len(res.Header))
+		for k, vals := range res.Header {
+			d.Headers[k] = strings.Join(vals, ", ")
+		}
+	}
+	for _, timing := range res.Timings {
+		ms := float64(timing.Duration.Microseconds()) / 1000
+		d.Timings = append(d.Timings, jsonlStageTiming{Stage: timing.Stage, MS: ms})
+	}
+	for _, ev := range res.Trace {
+		d.Trace = append(d.Trace, jsonlTraceEvent{Stage: ev.Stage, Decision: ev.Decision, Detail: ev.Detail})
+	}
+	return d
 }
 
-func writeBatchJSONL(w io.Writer, results []taskResult, includeMeta bool) error {
+func writeBatchJSONL(w io.Writer, results []taskResult, opts jsonlOptions) error {
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
 
 	for _, result := range results {
-		if result.err != nil {
-			payload := jsonlErrorPayload{
-				Seq:   result.index,
-				URL:   result.inputURL,
-				Error: strings.TrimSpace(result.err.Error()),
-			}
-			if err := enc.Encode(payload); err != nil {
-				return err
+		if opts.chunkTokens > 0 && result.err == nil {
+			for _, payload := range newJSONLChunkPayloads(result, opts) {
+				if err := enc.Encode(payload); err != nil {
+					return err
+				}
 			}
 			continue
 		}
-
-		content := result.markdown
-		var meta *jsonlMeta
-		if includeMeta {
-			trimmed, extracted, ok := extractInjectableMeta(content)
-			if ok {
-				content = trimmed
-				if extracted.Title != "" || extracted.Description != "" {
-					meta = &extracted
-				}
-			}
+		if err := enc.Encode(newJSONLPayload(result, opts)); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
+
+// newJSONLChunkPayloads splits a successful task into one row per chunk. Every
+// row repeats the task seq, URLs and meta; diagnostics are only attached to the
+// first chunk.
+func newJSONLChunkPayloads(result taskResult, opts jsonlOptions) []jsonlSuccessPayload {
+	base := newJSONLPayload(result, opts).(jsonlSuccessPayload)
+	chunks := fetcher.ChunkMarkdown(base.Content, opts.chunkTokens, opts.countTokens)
+	if len(chunks) == 0 {
+		chunks = []fetcher.Chunk{{}}
+	}
 
-		payload := jsonlSuccessPayload{
-			Seq:          result.index,
-			URL:          result.inputURL,
-			ResolvedMode: resolveMode(result.source),
-			Content:      content,
-			Meta:         meta,
+	payloads := make([]jsonlSuccessPayload, len(chunks))
+	for i, chunk := range chunks {
+		payload := base
+		payload.Chunk = i + 1
+		payload.ChunkCount = len(chunks)
+		payload.HeadingPath = chunk.HeadingPath
+		payload.Content = chunk.Markdown
+		if i > 0 {
+			payload.Diagnostics = nil
 		}
-		if strings.TrimSpace(result.finalURL) != "" && result.finalURL != result.inputURL {
-			payload.ResolvedURL = result.finalURL
+		payloads[i] = payload
+	}
+	return payloads
+}
+
+// newJSONLPayload returns the jsonlSuccessPayload or jsonlErrorPayload for a task.
+func newJSONLPayload(result taskResult, opts jsonlOptions) any {
+	var diagnostics *jsonlDiagnostics
+	if opts.includeDiagnostics {
+		diagnostics = result.diagnostics
+	}
+
+	// Attempts are only reported when a retry happened.
+	attempts := 0
+	if result.attempts > 1 {
+		attempts = result.attempts
+	}
+
+	if result.err != nil {
+		return jsonlErrorPayload{
+			Seq:         result.index,
+			URL:         result.inputURL,
+			Error:       strings.TrimSpace(result.err.Error()),
+			Attempts:    attempts,
+			Diagnostics: diagnostics,
 		}
-		if err := enc.Encode(payload); err != nil {
-			return err
+	}
+
+	content := result.markdown
+	var meta *jsonlMeta
+	if opts.includeMeta {
+		trimmed, extracted, ok := extractInjectableMeta(content)
+		if ok {
+			content = trimmed
+			if extracted != (jsonlMeta{}) {
+				meta = &extracted
+			}
 		}
 	}
 
-	return nil
+	payload := jsonlSuccessPayload{
+		Seq:          result.index,
+		URL:          result.inputURL,
+		ResolvedMode: resolveMode(result.source),
+		Attempts:     attempts,
+		Content:      content,
+		Meta:         meta,
+		Diagnostics:  diagnostics,
+	}
+	if strings.TrimSpace(result.finalURL) != "" && result.finalURL != result.inputURL {
+		payload.ResolvedURL = result.finalURL
+	}
+	return payload
 }
 
 func resolveMode(source string) string {
@@ -201,6 +597,8 @@ func resolveMode(source string) string {
 		return "browser"
 	case "http-raw":
 		return "raw"
+	case "http-pdf":
+		return "pdf"
 	default:
 		return strings.TrimSpace(source)
 	}
@@ -269,6 +667,31 @@ func parseInjectableMeta(lines []string) (jsonlMeta, bool) {
 		case "description":
 			meta.Description = v
 			knownFieldCount++
+		case "author":
+			meta.Author = v
+			knownFieldCount++
+		case "published":
+			meta.Published = v
+			knownFieldCount++
+		case "modified":
+			meta.Modified = v
+			knownFieldCount++
+		case "site_name":
+			meta.SiteName = v
+			knownFieldCount++
+		case "lang":
+			meta.Lang = v
+			knownFieldCount++
+		case "canonical":
+			meta.Canonical = v
+			knownFieldCount++
+		case "pages":
+			pages, err := strconv.Atoi(v)
+			if err != nil || pages < 0 {
+				return jsonlMeta{}, false
+			}
+			meta.Pages = pages
+			knownFieldCount++
 		default:
 			return jsonlMeta{}, false
 		}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -37,7 +39,6 @@ func TestWriteBatchMarkdown(t *testing.T) {
 
 	got := b.String()
 	want := strings.Join([]string{
-		"<!-- count: 3, succeeded: 2, failed: 1 -->",
 		"<!-- task[1]: https://example.com/hello -->",
 		"# hello",
 		"<!-- /task[1] -->",
@@ -48,6 +49,7 @@ func TestWriteBatchMarkdown(t *testing.T) {
 		"<!-- task[3]: https://example.net/hi -->",
 		"hi",
 		"<!-- /task[3] -->",
+		"<!-- count: 3, succeeded: 2, failed: 1 -->",
 		"",
 	}, "\n")
 
@@ -56,6 +58,71 @@ func TestWriteBatchMarkdown(t *testing.T) {
 	}
 }
 
+func TestOrderedBatchWriterFlushesLowestPendingSeq(t *testing.T) {
+	var b strings.Builder
+	out := newOrderedBatchWriter(&b, formatJSONL, jsonlOptions{})
+
+	out.write(taskResult{index: 2, inputURL: "https://example.com/2", markdown: "two"})
+	if b.Len() != 0 {
+		t.Fatalf("seq 2 written before seq 1:\n%s", b.String())
+	}
+	out.write(taskResult{index: 1, inputURL: "https://example.com/1", markdown: "one"})
+	if got := strings.Count(b.String(), "\n"); got != 2 {
+		t.Fatalf("expected seq 1 and 2 flushed, got %d rows:\n%s", got, b.String())
+	}
+	out.write(taskResult{index: 3, inputURL: "https://example.com/3", err: errors.New("boom")})
+	if err := out.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("ordered jsonl must not add a summary row, got:\n%s", b.String())
+	}
+	for i, line := range lines {
+		var row struct {
+			Seq int `json:"seq"`
+		}
+		if err := json.Unmarshal([]byte(line), &row); err != nil || row.Seq != i+1 {
+			t.Fatalf("row %d: seq %d err %v", i, row.Seq, err)
+		}
+	}
+	if out.failed != 1 {
+		t.Fatalf("failed = %d, want 1", out.failed)
+	}
+}
+
+func TestBatchWriterStream(t *testing.T) {
+	var b strings.Builder
+	out := newBatchWriter(&b, formatJSONL, jsonlOptions{})
+	out.jsonlSummary = true
+	out.write(taskResult{index: 2, inputURL: "https://example.com/2", source: "http-static", markdown: "two"})
+	out.write(taskResult{index: 1, inputURL: "https://example.com/1", err: errors.New("boom")})
+	if err := out.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	want := `{"seq":2,"url":"https://example.com/2","resolved_mode":"static","content":"two"}` + "\n" +
+		`{"seq":1,"url":"https://example.com/1","error":"boom"}` + "\n" +
+		`{"summary":true,"count":2,"succeeded":1,"failed":1}` + "\n"
+	if b.String() != want {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+
+	b.Reset()
+	out = newBatchWriter(&b, formatMarkdown, jsonlOptions{})
+	out.write(taskResult{index: 2, inputURL: "https://example.com/b", err: errors.New("boom")})
+	out.write(taskResult{index: 1, inputURL: "https://example.com/a", markdown: "# A\n"})
+	if err := out.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	wantMD := "<!-- task[2](failed): https://example.com/b -->\n<!-- error[2]: boom -->\n\n" +
+		"<!-- task[1]: https://example.com/a -->\n# A\n<!-- /task[1] -->\n" +
+		"<!-- count: 2, succeeded: 1, failed: 1 -->\n"
+	if b.String() != wantMD {
+		t.Fatalf("unexpected markdown:\n%s", b.String())
+	}
+}
+
 func TestWriteBatchJSONL(t *testing.T) {
 	results := []taskResult{
 		{
@@ -77,7 +144,7 @@ func TestWriteBatchJSONL(t *testing.T) {
 	}
 
 	var b strings.Builder
-	if err := writeBatchJSONL(&b, results, true); err != nil {
+	if err := writeBatchJSONL(&b, results, jsonlOptions{includeMeta: true}); err != nil {
 		t.Fatalf("write batch jsonl: %v", err)
 	}
 
@@ -145,7 +212,7 @@ func TestWriteBatchJSONL_MetaDisabledKeepsFrontMatter(t *testing.T) {
 	}
 
 	var b strings.Builder
-	if err := writeBatchJSONL(&b, results, false); err != nil {
+	if err := writeBatchJSONL(&b, results, jsonlOptions{}); err != nil {
 		t.Fatalf("write batch jsonl: %v", err)
 	}
 
@@ -164,6 +231,27 @@ func TestWriteBatchJSONL_MetaDisabledKeepsFrontMatter(t *testing.T) {
 	}
 }
 
+func TestExtractInjectableMeta_RichFields(t *testing.T) {
+	input := "---\n" +
+		"title: 'Hello'\n" +
+		"author: 'Ada Lovelace'\n" +
+		"published: '2024-03-01'\n" +
+		"modified: '2024-03-02'\n" +
+		"site_name: 'Example Docs'\n" +
+		"lang: 'en'\n" +
+		"canonical: 'https://example.com/hello'\n" +
+		"---\n\n" +
+		"Body\n"
+	body, meta, ok := extractInjectableMeta(input)
+	if !ok || body != "Body\n" {
+		t.Fatalf("expected front matter stripped: ok=%v body=%q", ok, body)
+	}
+	want := jsonlMeta{Title: "Hello", Author: "Ada Lovelace", Published: "2024-03-01", Modified: "2024-03-02", SiteName: "Example Docs", Lang: "en", Canonical: "https://example.com/hello"}
+	if meta != want {
+		t.Fatalf("meta %+v, want %+v", meta, want)
+	}
+}
+
 func TestExtractInjectableMeta_UnknownFieldsNotStripped(t *testing.T) {
 	input := "---\n" +
 		"title: 'Hello'\n" +
@@ -179,6 +267,117 @@ func TestExtractInjectableMeta_UnknownFieldsNotStripped(t *testing.T) {
 	}
 }
 
+func TestWriteBatchJSONL_PDFPagesMeta(t *testing.T) {
+	results := []taskResult{
+		{
+			index:    1,
+			inputURL: "https://example.com/report.pdf",
+			source:   "http-pdf",
+			markdown: "---\n" +
+				"title: 'Annual Report'\n" +
+				"pages: 12\n" +
+				"---\n\n" +
+				"# Annual Report\n",
+		},
+	}
+
+	var b strings.Builder
+	if err := writeBatchJSONL(&b, results, jsonlOptions{includeMeta: true}); err != nil {
+		t.Fatalf("write batch jsonl: %v", err)
+	}
+
+	var row struct {
+		ResolvedMode string    `json:"resolved_mode"`
+		Content      string    `json:"content"`
+		Meta         jsonlMeta `json:"meta"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(b.String())), &row); err != nil {
+		t.Fatalf("unmarshal row: %v", err)
+	}
+	if row.ResolvedMode != "pdf" {
+		t.Fatalf("unexpected resolved_mode: %q", row.ResolvedMode)
+	}
+	if row.Meta.Title != "Annual Report" || row.Meta.Pages != 12 {
+		t.Fatalf("unexpected meta: %+v", row.Meta)
+	}
+	if row.Content != "# Annual Report\n" {
+		t.Fatalf("expected front matter stripped, got: %q", row.Content)
+	}
+}
+
+func TestWriteBatchJSONL_ChunkTokens(t *testing.T) {
+	results := []taskResult{
+		{
+			index:    1,
+			inputURL: "https://example.com/guide",
+			source:   "http-static",
+			markdown: "---\n" +
+				"title: 'Guide'\n" +
+				"---\n\n" +
+				"# Guide\n\n" +
+				"## Install\n\n" +
+				"Download the archive and unpack it.\n\n" +
+				"## Usage\n\n" +
+				"Pass one or more URLs on the command line.\n",
+			diagnostics: &jsonlDiagnostics{StatusCode: 200},
+		},
+		{
+			index:    2,
+			inputURL: "https://bad.example",
+			err:      errors.New("boom"),
+		},
+	}
+
+	words := func(s string) int { return len(strings.Fields(s)) }
+	opts := jsonlOptions{includeMeta: true, includeDiagnostics: true, chunkTokens: 12, countTokens: words}
+	var b strings.Builder
+	if err := writeBatchJSONL(&b, results, opts); err != nil {
+		t.Fatalf("write batch jsonl: %v", err)
+	}
+
+	type row struct {
+		Seq         int               `json:"seq"`
+		Chunk       int               `json:"chunk"`
+		ChunkCount  int               `json:"chunk_count"`
+		HeadingPath []string          `json:"heading_path"`
+		Content     string            `json:"content"`
+		Meta        *jsonlMeta        `json:"meta"`
+		Diagnostics *jsonlDiagnostics `json:"diagnostics"`
+		Error       string            `json:"error"`
+	}
+	var rows []row
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var r row
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		rows = append(rows, r)
+	}
+
+	if len(rows) != 3 {
+		t.Fatalf("expected 2 chunk rows and 1 error row, got %d: %s", len(rows), b.String())
+	}
+	first, second := rows[0], rows[1]
+	if first.Seq != 1 || first.Chunk != 1 || first.ChunkCount != 2 || second.Seq != 1 || second.Chunk != 2 || second.ChunkCount != 2 {
+		t.Fatalf("unexpected chunk numbering: %+v %+v", first, second)
+	}
+	if first.Content != "# Guide\n\n## Install\n\nDownload the archive and unpack it." {
+		t.Fatalf("unexpected first chunk: %q", first.Content)
+	}
+	if strings.Join(first.HeadingPath, "/") != "Guide" || strings.Join(second.HeadingPath, "/") != "Guide/Usage" {
+		t.Fatalf("unexpected heading paths: %q %q", first.HeadingPath, second.HeadingPath)
+	}
+	if first.Meta == nil || first.Meta.Title != "Guide" || second.Meta == nil || second.Meta.Title != "Guide" {
+		t.Fatalf("expected meta on every chunk: %+v %+v", first.Meta, second.Meta)
+	}
+	if first.Diagnostics == nil || second.Diagnostics != nil {
+		t.Fatalf("expected diagnostics on the first chunk only: %+v %+v", first.Diagnostics, second.Diagnostics)
+	}
+	if rows[2].Seq != 2 || rows[2].Error != "boom" || rows[2].Chunk != 0 {
+		t.Fatalf("unexpected error row: %+v", rows[2])
+	}
+}
+
 func TestFetchBatchPreservesInputOrder(t *testing.T) {
 	urls := []string{
 		"https://example.com/1",
@@ -202,7 +401,7 @@ func TestFetchBatchPreservesInputOrder(t *testing.T) {
 	}
 
 	cfg := fetcher.DefaultConfig()
-	results := fetchBatch(context.Background(), urls, cfg, 3, fetch)
+	results := fetchBatch(context.Background(), urls, cfg, batchLimits{concurrency: 3}, fetch)
 	if len(results) != 3 {
 		t.Fatalf("unexpected result count: %d", len(results))
 	}
@@ -219,3 +418,135 @@ func TestFetchBatchPreservesInputOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestFetchTasksReorderWindow(t *testing.T) {
+	var started atomic.Int32
+	var startedWhileFirstRan int32
+	fetch := func(ctx context.Context, url string, cfg fetcher.Config) (fetcher.Result, error) {
+		started.Add(1)
+		if strings.HasSuffix(url, "/1") {
+			time.Sleep(50 * time.Millisecond)
+			startedWhileFirstRan = started.Load()
+		}
+		return fetcher.Result{Markdown: url}, nil
+	}
+
+	tasks := make(chan batchTask)
+	go func() {
+		defer close(tasks)
+		for i := range 10 {
+			tasks <- batchTask{url: "https://example.com/" + strconv.Itoa(i+1), cfg: fetcher.DefaultConfig()}
+		}
+	}()
+	var b strings.Builder
+	out := newOrderedBatchWriter(&b, formatJSONL, jsonlOptions{})
+	fetchTasks(context.Background(), tasks, batchLimits{concurrency: 8, window: 3}, fetch, out.write)
+	if err := out.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	if startedWhileFirstRan != 3 {
+		t.Fatalf("%d tasks started while seq 1 ran, want the window of 3", startedWhileFirstRan)
+	}
+	if got := strings.Count(b.String(), "\n"); got != 10 {
+		t.Fatalf("got %d rows, want 10:\n%s", got, b.String())
+	}
+}
+
+func TestWriteBatchJSONL_Diagnostics(t *testing.T) {
+	res := fetcher.Result{
+		Markdown:    "# hello\n",
+		Source:      "browser",
+		StatusCode:  200,
+		ContentType: "text/html",
+		Charset:     "shift_jis",
+		Header:      map[string][]string{"Server": {"test"}},
+		BodyBytes:   42,
+		Timings: []fetcher.StageTiming{
+			{Stage: "http", Duration: 1500 * time.Microsecond},
+			{Stage: "browser", Duration: 2 * time.Second},
+		},
+		Trace: []fetcher.TraceEvent{
+			{Stage: "static", Decision: "rejected", Detail: "text too short (10 < 220)"},
+			{Stage: "browser", Decision: "fallback", Detail: "static extraction rejected"},
+		},
+	}
+	results := []taskResult{
+		newTaskResult(1, "https://example.com", res, nil),
+		newTaskResult(2, "https://bad.example", fetcher.Result{StatusCode: 503}, errors.New("unexpected HTTP status code: 503")),
+	}
+
+	var withDiag strings.Builder
+	if err := writeBatchJSONL(&withDiag, results, jsonlOptions{includeDiagnostics: true}); err != nil {
+		t.Fatalf("write batch jsonl: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(withDiag.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected line count: %d", len(lines))
+	}
+
+	var first struct {
+		Diagnostics jsonlDiagnostics `json:"diagnostics"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("unmarshal first line: %v", err)
+	}
+	d := first.Diagnostics
+	if d.StatusCode != 200 || d.ContentType != "text/html" || d.Charset != "shift_jis" || d.BodyBytes != 42 {
+		t.Fatalf("unexpected diagnostics: %+v", d)
+	}
+	if d.Headers["Server"] != "test" {
+		t.Fatalf("unexpected headers: %v", d.Headers)
+	}
+	if len(d.Timings) != 2 || d.Timings[0].Stage != "http" || d.Timings[0].MS != 1.5 {
+		t.Fatalf("unexpected timings: %+v", d.Timings)
+	}
+	if len(d.Trace) != 2 || d.Trace[0].Decision != "rejected" {
+		t.Fatalf("unexpected trace: %+v", d.Trace)
+	}
+
+	var second struct {
+		Diagnostics *jsonlDiagnostics `json:"diagnostics"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("unmarshal second line: %v", err)
+	}
+	if second.Diagnostics == nil || second.Diagnostics.StatusCode != 503 {
+		t.Fatalf("expected diagnostics on error row, got %+v", second.Diagnostics)
+	}
+
+	var withoutDiag strings.Builder
+	if err := writeBatchJSONL(&withoutDiag, results, jsonlOptions{}); err != nil {
+		t.Fatalf("write batch jsonl: %v", err)
+	}
+	if strings.Contains(withoutDiag.String(), "diagnostics") {
+		t.Fatalf("expected diagnostics omitted by default, got %s", withoutDiag.String())
+	}
+}
+
+func TestWriteBatchJSONL_Attempts(t *testing.T) {
+	results := []taskResult{
+		newTaskResult(1, "https://example.com/a", fetcher.Result{Markdown: "a", Source: "http-static", Attempts: 1}, nil),
+		newTaskResult(2, "https://example.com/b", fetcher.Result{Markdown: "b", Source: "http-static", Attempts: 2}, nil),
+		newTaskResult(3, "https://example.com/c", fetcher.Result{StatusCode: 503, Attempts: 3}, errors.New("unexpected HTTP status code: 503")),
+	}
+	var out strings.Builder
+	if err := writeBatchJSONL(&out, results, jsonlOptions{}); err != nil {
+		t.Fatalf("write batch jsonl: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected line count: %d", len(lines))
+	}
+	for i, want := range []int{0, 2, 3} {
+		var row struct {
+			Attempts int `json:"attempts"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &row); err != nil {
+			t.Fatalf("unmarshal line %d: %v", i+1, err)
+		}
+		if row.Attempts != want {
+			t.Errorf("line %d: attempts %d, want %d", i+1, row.Attempts, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+const cacheDirEnv = "AGENT_FETCH_CACHE_DIR"
+
+func cacheDirFlag(usage, value string) *cli.StringFlag {
+	return &cli.StringFlag{Name: "cache-dir", Value: value, Usage: usage, Sources: cli.EnvVars(cacheDirEnv)}
+}
+
+func newCacheCommand() *cli.Command {
+	defaultDir, _ := fetcher.DefaultCacheDir()
+	flags := func(extra ...cli.Flag) []cli.Flag {
+		return append([]cli.Flag{cacheDirFlag("cache directory to inspect", defaultDir)}, extra...)
+	}
+	return &cli.Command{
+		Name:      "cache",
+		Usage:     "inspect or clean the on-disk fetch cache",
+		UsageText: "agent-fetch cache stats [options]\n   agent-fetch cache prune [options]\n   agent-fetch cache clear [options]",
+		Commands: []*cli.Command{
+			{
+				Name:  "stats",
+				Usage: "show entry counts, freshness and size",
+				Flags: flags(),
+				Action: func(_ context.Context, c *cli.Command) error {
+					return withCache(c, func(cache *fetcher.Cache) error {
+						stats, err := cache.Stats()
+						if err != nil {
+							return err
+						}
+						return writeCacheStats(os.Stdout, cache.Dir(), stats)
+					})
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "remove stale entries that cannot be revalidated",
+				Flags: flags(
+					&cli.DurationFlag{Name: "older-than", Usage: "also remove any entry stored longer ago than this, e.g. 168h"},
+				),
+				Action: func(_ context.Context, c *cli.Command) error {
+					return withCache(c, func(cache *fetcher.Cache) error {
+						removed, freed, err := cache.Prune(c.Duration("older-than"))
+						if err != nil {
+							return err
+						}
+						_, err = fmt.Fprintf(os.Stdout, "pruned %d entries (%d bytes)\n", removed, freed)
+						return err
+					})
+				},
+			},
+			{
+				Name:  "clear",
+				Usage: "remove every cache entry",
+				Flags: flags(),
+				Action: func(_ context.Context, c *cli.Command) error {
+					return withCache(c, func(cache *fetcher.Cache) error {
+						removed, err := cache.Clear()
+						if err != nil {
+							return err
+						}
+						_, err = fmt.Fprintf(os.Stdout, "removed %d entries\n", removed)
+						return err
+					})
+				},
+			},
+		},
+		Action: func(_ context.Context, c *cli.Command) error {
+			_ = cli.ShowSubcommandHelp(c)
+			return &exitStatusError{code: 2}
+		},
+	}
+}
+
+func withCache(c *cli.Command, fn func(*fetcher.Cache) error) error {
+	dir := c.String("cache-dir")
+	if dir == "" {
+		return &exitStatusError{code: 2, msg: "cache dir is not set: use --cache-dir or " + cacheDirEnv}
+	}
+	cache, err := fetcher.OpenCache(dir, fetcher.DefaultCacheTTL)
+	if err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("open cache: %v", err)}
+	}
+	if err := fn(cache); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("cache %s failed: %v", c.Name, err)}
+	}
+	return nil
+}
+
+func writeCacheStats(w io.Writer, dir string, stats fetcher.CacheStats) error {
+	_, err := fmt.Fprintf(w,
+		"cache dir: %s\nhttp entries: %d\nmarkdown entries: %d\nfresh: %d\nstale: %d\nsize: %d bytes\n",
+		dir, stats.HTTPEntries, stats.MarkdownEntries, stats.Fresh, stats.Stale, stats.Bytes)
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func TestWriteCacheStats(t *testing.T) {
+	var out strings.Builder
+	stats := fetcher.CacheStats{HTTPEntries: 3, MarkdownEntries: 2, Fresh: 4, Stale: 1, Bytes: 2048}
+	if err := writeCacheStats(&out, "/tmp/cache", stats); err != nil {
+		t.Fatalf("write stats: %v", err)
+	}
+	want := "cache dir: /tmp/cache\nhttp entries: 3\nmarkdown entries: 2\nfresh: 4\nstale: 1\nsize: 2048 bytes\n"
+	if out.String() != want {
+		t.Fatalf("unexpected stats output:\n%s", out.String())
+	}
+}
+
+func TestCacheCommandIsNotRoutedToWeb(t *testing.T) {
+	var out strings.Builder
+	if err := runForTest([]string{"agent-fetch", "cache", "prune", "-h"}, &out, &out); err != nil {
+		t.Fatalf("run cache help failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "--older-than") || strings.Contains(out.String(), "--format string") {
+		t.Fatalf("unexpected cache prune help:\n%s", out.String())
+	}
+}
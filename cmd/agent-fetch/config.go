@@ -0,0 +1,706 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	configEnv  = "AGENT_FETCH_CONFIG"
+	profileEnv = "AGENT_FETCH_PROFILE"
+)
+
+// hostRuleFlags are the settings a [hosts."..."] table may override, by flag name.
+var hostRuleFlags = []string{"mode", "user-agent", "wait-selector", "timeout", "browser-timeout", "network-idle", "proxy"}
+
+func configFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "config", Usage: "TOML file of default settings, profiles and per-host rules (default: ~/.config/agent-fetch/config.toml)", Sources: cli.EnvVars(configEnv)},
+		&cli.StringFlag{Name: "profile", Usage: "apply the [profiles.NAME] settings of the config file", Sources: cli.EnvVars(profileEnv)},
+		&cli.StringFlag{Name: "rules", Usage: "TOML file of per-site extraction rules (default: ~/.config/agent-fetch/rules.toml)", Sources: cli.EnvVars(rulesEnv)},
+	}
+}
+
+func newConfigCommand(defaultCfg fetcher.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "config",
+		Usage:     "inspect the settings a config file and profile produce",
+		UsageText: "agent-fetch config show [options] [url]",
+		Commands: []*cli.Command{
+			{
+				Name:      "show",
+				Usage:     "print the effective settings; with a URL, after its host rules",
+				ArgsUsage: "[url]",
+				Flags:     fetchFlags(defaultCfg),
+				Action:    runConfigShow,
+			},
+		},
+		Action: func(_ context.Context, c *cli.Command) error {
+			_ = cli.ShowSubcommandHelp(c)
+			return &exitStatusError{code: 2}
+		},
+	}
+}
+
+// configSection is the top level of a config file or one of its profiles.
+type configSection struct {
+	// settings maps flag names to config values.
+	settings map[string]any
+	headers  http.Header
+	hosts    []fetcher.HostRule
+}
+
+type configFile struct {
+	configSection
+	profiles map[string]configSection
+}
+
+// appliedConfig records what the config file contributed to a command's settings.
+type appliedConfig struct {
+	path    string
+	profile string
+	// explicit holds the flags set on the command line or through the
+	// environment, which the config file never overrides.
+	explicit map[string]bool
+	// source maps the flags the config file set to "config" or "profile NAME".
+	source  map[string]string
+	headers http.Header
+	hosts   []fetcher.HostRule
+	// rules is the path of the rules file that was loaded, if any.
+	rules string
+}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "agent-fetch", "config.toml")
+}
+
+// applyConfigFile sets every flag of c that was not given explicitly from the
+// config file: top-level settings first, then those of the selected profile.
+// A missing default config file is not an error.
+func applyConfigFile(c *cli.Command) (*appliedConfig, error) {
+	applied := &appliedConfig{explicit: map[string]bool{}, source: map[string]string{}}
+	flags := map[string]bool{}
+	for _, f := range c.Flags {
+		for _, name := range f.Names() {
+			flags[name] = true
+			if c.IsSet(name) {
+				applied.explicit[name] = true
+			}
+		}
+	}
+
+	path := strings.TrimSpace(c.String("config"))
+	required := path != ""
+	if !required {
+		path = defaultConfigPath()
+	}
+	if path == "" {
+		return applied, nil
+	}
+	file, err := loadConfigFile(path, knownSettings(c.Root()))
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		if profile := c.String("profile"); profile != "" {
+			return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid profile: %q needs a config file, %s does not exist", profile, path)}
+		}
+		return applied, nil
+	}
+	if err != nil {
+		return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid config: %v", err)}
+	}
+	applied.path = path
+
+	settings := map[string]any{}
+	for name, val := range file.settings {
+		settings[name] = val
+		applied.source[name] = "config"
+	}
+	applied.headers = file.headers.Clone()
+	applied.hosts = append(applied.hosts, file.hosts...)
+
+	applied.profile = c.String("profile")
+	if p, ok := file.settings["profile"].(string); ok && !c.IsSet("profile") {
+		applied.profile = p
+	}
+	if applied.profile != "" {
+		profile, ok := file.profiles[applied.profile]
+		if !ok {
+			return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid profile: %q is not defined in %s", applied.profile, path)}
+		}
+		label := "profile " + applied.profile
+		for name, val := range profile.settings {
+			settings[name] = val
+			applied.source[name] = label
+		}
+		if len(profile.headers) > 0 && applied.headers == nil {
+			applied.headers = make(http.Header)
+		}
+		for k, vals := range profile.headers {
+			applied.headers[k] = vals
+		}
+		applied.hosts = mergeHostRules(applied.hosts, profile.hosts)
+	}
+	sortHostRules(applied.hosts)
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "profile" || !flags[name] || applied.explicit[name] {
+			delete(applied.source, name)
+			continue
+		}
+		for _, v := range configValues(settings[name]) {
+			if err := c.Set(name, v); err != nil {
+				return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid config: %s: %s: %v", path, name, err)}
+			}
+		}
+	}
+	return applied, nil
+}
+
+// mergeHeaders returns the config file headers with flagHeaders replacing
+// those of the same name.
+func (a *appliedConfig) mergeHeaders(flagHeaders http.Header) http.Header {
+	h := a.headers.Clone()
+	if h == nil {
+		return flagHeaders
+	}
+	for k, vals := range flagHeaders {
+		h[k] = vals
+	}
+	return h
+}
+
+// hostRules returns the config file host rules without the settings that
+// explicit flags fix for every host.
+func (a *appliedConfig) hostRules(flagHeaders http.Header) []fetcher.HostRule {
+	return overrideHostRules(a.hosts, func(name string) bool { return a.explicit[name] }, flagHeaders)
+}
+
+// overrideHostRules returns rules without the settings that an explicit flag
+// or request field replaces: set reports the flag names given, headers the
+// headers given.
+func overrideHostRules(rules []fetcher.HostRule, set func(name string) bool, headers http.Header) []fetcher.HostRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	out := make([]fetcher.HostRule, 0, len(rules))
+	for _, rule := range rules {
+		for _, name := range hostRuleFlags {
+			if set(name) {
+				clearHostRuleField(&rule, name)
+			}
+		}
+		if len(rule.Headers) > 0 && len(headers) > 0 {
+			rule.Headers = rule.Headers.Clone()
+			for k := range headers {
+				rule.Headers.Del(k)
+			}
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func clearHostRuleField(rule *fetcher.HostRule, name string) {
+	setHostRuleField(rule, fetcher.HostRule{}, name)
+}
+
+// setHostRuleField copies the setting for a flag from src to rule.
+func setHostRuleField(rule *fetcher.HostRule, src fetcher.HostRule, name string) {
+	switch name {
+	case "mode":
+		rule.Mode = src.Mode
+	case "user-agent":
+		rule.UserAgent = src.UserAgent
+	case "wait-selector":
+		rule.WaitSelector = src.WaitSelector
+	case "timeout":
+		rule.Timeout = src.Timeout
+	case "browser-timeout":
+		rule.BrowserTimeout = src.BrowserTimeout
+	case "network-idle":
+		rule.NetworkIdle = src.NetworkIdle
+	case "proxy":
+		rule.Proxy = src.Proxy
+	}
+}
+
+// hostRuleValue returns the value rule sets for a flag, or nil.
+func hostRuleValue(rule fetcher.HostRule, name string) any {
+	switch {
+	case name == "mode" && rule.Mode != "":
+		return rule.Mode
+	case name == "user-agent" && rule.UserAgent != "":
+		return rule.UserAgent
+	case name == "wait-selector" && rule.WaitSelector != "":
+		return rule.WaitSelector
+	case name == "timeout" && rule.Timeout > 0:
+		return rule.Timeout
+	case name == "browser-timeout" && rule.BrowserTimeout > 0:
+		return rule.BrowserTimeout
+	case name == "network-idle" && rule.NetworkIdle > 0:
+		return rule.NetworkIdle
+	case name == "proxy" && rule.Proxy != "":
+		return rule.Proxy
+	}
+	return nil
+}
+
+// knownSettings returns the flag names of every command, which are the
+// settings a config file may hold. A setting applies to the commands that
+// have the flag and is ignored by the others.
+func knownSettings(root *cli.Command) map[string]bool {
+	known := map[string]bool{}
+	var walk func(cmd *cli.Command)
+	walk = func(cmd *cli.Command) {
+		for _, f := range cmd.Flags {
+			for _, name := range f.Names() {
+				known[name] = true
+			}
+		}
+		for _, sub := range cmd.Commands {
+			walk(sub)
+		}
+	}
+	walk(root)
+	// The file cannot name itself, and headers have their own table.
+	delete(known, "config")
+	delete(known, "header")
+	delete(known, "help")
+	delete(known, "version")
+	return known
+}
+
+func loadConfigFile(path string, known map[string]bool) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	file := &configFile{profiles: map[string]configSection{}}
+	if file.configSection, err = decodeConfigSection(doc, known, file.profiles); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// decodeConfigSection decodes settings, [headers] and [hosts."..."] tables.
+// Only the top level, where profiles is not nil, may hold [profiles.NAME].
+func decodeConfigSection(t map[string]any, known map[string]bool, profiles map[string]configSection) (configSection, error) {
+	section := configSection{settings: map[string]any{}}
+	for _, key := range sortedKeys(t) {
+		val := t[key]
+		switch name := settingName(key); {
+		case name == "headers":
+			h, err := decodeHeaders(val)
+			if err != nil {
+				return configSection{}, err
+			}
+			section.headers = h
+		case name == "hosts":
+			hosts, ok := val.(map[string]any)
+			if !ok {
+				return configSection{}, errors.New("hosts: expected [hosts.\"<host>\"] tables")
+			}
+			for _, host := range sortedKeys(hosts) {
+				rule, err := decodeHostRule(host, hosts[host])
+				if err != nil {
+					return configSection{}, err
+				}
+				section.hosts = append(section.hosts, rule)
+			}
+		case name == "profiles" && profiles != nil:
+			tables, ok := val.(map[string]any)
+			if !ok {
+				return configSection{}, errors.New("profiles: expected [profiles.<name>] tables")
+			}
+			for _, profile := range sortedKeys(tables) {
+				pt, ok := tables[profile].(map[string]any)
+				if !ok {
+					return configSection{}, fmt.Errorf("profiles.%s: expected a table", profile)
+				}
+				ps, err := decodeConfigSection(pt, known, nil)
+				if err != nil {
+					return configSection{}, fmt.Errorf("profiles.%s: %w", profile, err)
+				}
+				profiles[profile] = ps
+			}
+		case name == "profile" && profiles == nil:
+			return configSection{}, errors.New("profile: a profile cannot select another profile")
+		case known[name]:
+			if _, err := configScalars(val); err != nil {
+				return configSection{}, fmt.Errorf("%s: %w", key, err)
+			}
+			section.settings[name] = val
+		default:
+			return configSection{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return section, nil
+}
+
+func decodeHeaders(val any) (http.Header, error) {
+	t, ok := val.(map[string]any)
+	if !ok {
+		return nil, errors.New("headers: expected a table of header names to values")
+	}
+	h := make(http.Header)
+	for _, k := range sortedKeys(t) {
+		vals, err := configScalars(t[k])
+		if err != nil {
+			return nil, fmt.Errorf("headers.%s: %w", k, err)
+		}
+		for _, v := range vals {
+			h.Add(k, v)
+		}
+	}
+	return h, nil
+}
+
+func decodeHostRule(host string, val any) (fetcher.HostRule, error) {
+	t, ok := val.(map[string]any)
+	if !ok {
+		return fetcher.HostRule{}, fmt.Errorf("hosts.%q: expected a table", host)
+	}
+	rule := fetcher.HostRule{Host: strings.ToLower(strings.TrimSpace(host))}
+	if rule.Host == "" {
+		return fetcher.HostRule{}, errors.New("hosts: empty host name")
+	}
+	for _, key := range sortedKeys(t) {
+		ok, err := decodeHostRuleKey(&rule, settingName(key), t[key])
+		if err != nil {
+			return fetcher.HostRule{}, fmt.Errorf("hosts.%q.%s: %w", host, key, err)
+		}
+		if !ok {
+			return fetcher.HostRule{}, fmt.Errorf("hosts.%q: unknown setting %q", host, key)
+		}
+	}
+	return rule, nil
+}
+
+// decodeHostRuleKey sets the host rule setting name from val. It reports
+// false for names that are not host rule settings.
+func decodeHostRuleKey(rule *fetcher.HostRule, name string, val any) (bool, error) {
+	if name == "headers" {
+		h, err := decodeHeaders(val)
+		if err != nil {
+			return true, err
+		}
+		rule.Headers = h
+		return true, nil
+	}
+	if !slices.Contains(hostRuleFlags, name) {
+		return false, nil
+	}
+	s, ok := val.(string)
+	if !ok {
+		return true, errors.New("expected a string")
+	}
+	switch name {
+	case "mode":
+		switch s {
+		case fetcher.ModeAuto, fetcher.ModeStatic, fetcher.ModeBrowser, fetcher.ModeRaw:
+			rule.Mode = s
+		default:
+			return true, fmt.Errorf("unsupported mode %q", s)
+		}
+	case "user-agent":
+		rule.UserAgent = s
+	case "wait-selector":
+		rule.WaitSelector = s
+	case "proxy":
+		if _, err := fetcher.ParseProxy(s); err != nil {
+			return true, err
+		}
+		rule.Proxy = strings.TrimSpace(s)
+	default:
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return true, fmt.Errorf("%q is not a positive duration", s)
+		}
+		switch name {
+		case "timeout":
+			rule.Timeout = d
+		case "browser-timeout":
+			rule.BrowserTimeout = d
+		default:
+			rule.NetworkIdle = d
+		}
+	}
+	return true, nil
+}
+
+// mergeHostRules adds the profile rules to rules. A profile rule for a host
+// that already has one overrides only the settings it gives.
+func mergeHostRules(rules, profile []fetcher.HostRule) []fetcher.HostRule {
+	out := append([]fetcher.HostRule(nil), rules...)
+	for _, pr := range profile {
+		i := slices.IndexFunc(out, func(r fetcher.HostRule) bool { return r.Host == pr.Host })
+		if i < 0 {
+			out = append(out, pr)
+			continue
+		}
+		rule := &out[i]
+		for _, name := range hostRuleFlags {
+			if hostRuleValue(pr, name) != nil {
+				setHostRuleField(rule, pr, name)
+			}
+		}
+		if len(pr.Headers) > 0 {
+			rule.Headers = rule.Headers.Clone()
+			if rule.Headers == nil {
+				rule.Headers = make(http.Header)
+			}
+			for k, vals := range pr.Headers {
+				rule.Headers[k] = vals
+			}
+		}
+	}
+	return out
+}
+
+// sortHostRules orders rules from least to most specific, since later rules
+// win: "*", then "*.example.com" before "*.docs.example.com", then exact
+// hosts.
+func sortHostRules(rules []fetcher.HostRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		ri, rj := rules[i].Specificity(), rules[j].Specificity()
+		if ri != rj {
+			return ri < rj
+		}
+		return rules[i].Host < rules[j].Host
+	})
+}
+
+// settingName maps a config key to its flag name: user_agent and
+// user-agent are the same setting.
+func settingName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// configScalars returns a config value, or each element of an array, in the
+// string form flags parse.
+func configScalars(val any) ([]string, error) {
+	items, ok := val.([]any)
+	if !ok {
+		items = []any{val}
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			out = append(out, v)
+		case int64:
+			out = append(out, strconv.FormatInt(v, 10))
+		case float64:
+			out = append(out, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			out = append(out, strconv.FormatBool(v))
+		default:
+			return nil, errors.New("expected a string, number, boolean or array of them")
+		}
+	}
+	return out, nil
+}
+
+func configValues(val any) []string {
+	out, _ := configScalars(val)
+	return out
+}
+
+func sortedKeys(t map[string]any) []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func runConfigShow(_ context.Context, c *cli.Command) error {
+	if c.Args().Len() > 1 {
+		_ = cli.ShowSubcommandHelp(c)
+		return &exitStatusError{code: 2}
+	}
+	cfg, applied, err := fetchSettingsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	rawURL := c.Args().First()
+	if rawURL != "" {
+		if u, err := url.Parse(rawURL); err != nil || u.Host == "" {
+			return &exitStatusError{code: 2, msg: fmt.Sprintf("invalid URL: %q", rawURL)}
+		}
+	}
+	w := c.Root().Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	if err := writeConfigShow(w, c, cfg, applied, rawURL); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	return nil
+}
+
+// writeConfigShow prints the effective settings as a config file, each
+// followed by where it came from unless it is the default. With rawURL, the
+// settings are those for its host and only the matching host rules count.
+func writeConfigShow(w io.Writer, c *cli.Command, cfg fetcher.Config, applied *appliedConfig, rawURL string) error {
+	var b strings.Builder
+	path := applied.path
+	if path == "" {
+		path = "none"
+	}
+	fmt.Fprintf(&b, "# config: %s\n", path)
+	if applied.profile != "" {
+		fmt.Fprintf(&b, "# profile: %s\n", applied.profile)
+	}
+	if applied.rules != "" {
+		fmt.Fprintf(&b, "# rules: %s\n", applied.rules)
+	}
+	var host string
+	var siteRule fetcher.SiteRule
+	var hasRule bool
+	headers := cfg.Headers
+	if rawURL != "" {
+		fmt.Fprintf(&b, "# url: %s\n", rawURL)
+		u, _ := url.Parse(rawURL)
+		host = u.Hostname()
+		headers = cfg.ForURL(rawURL).Headers
+		if siteRule, hasRule = cfg.MatchRule(rawURL); hasRule {
+			fmt.Fprintf(&b, "# rule: %s\n", siteRule.Name)
+		}
+	}
+	b.WriteString("\n")
+
+	for _, f := range c.Flags {
+		name := f.Names()[0]
+		switch name {
+		case "config", "profile", "rules", "header", "help":
+			continue
+		}
+		val, source := c.Value(name), ""
+		switch {
+		case applied.explicit[name]:
+			source = "command line"
+		case applied.source[name] != "":
+			source = applied.source[name]
+		}
+		if rawURL != "" {
+			for _, rule := range cfg.HostRules {
+				if v := hostRuleValue(rule, name); v != nil && rule.Matches(host) {
+					val, source = v, "host "+rule.Host
+				}
+			}
+			if v := hostRuleValue(siteRule.HostRule, name); v != nil && hasRule {
+				val, source = v, "rule "+siteRule.Name
+			}
+		}
+		b.WriteString(name + " = " + tomlValue(redactSetting(name, val)))
+		if source != "" {
+			b.WriteString("  # " + source)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(headers) > 0 {
+		b.WriteString("\n[headers]\n")
+		writeTOMLHeaders(&b, headers)
+	}
+	if rawURL == "" {
+		for _, rule := range cfg.HostRules {
+			fmt.Fprintf(&b, "\n[hosts.%s]\n", strconv.Quote(rule.Host))
+			writeHostRuleSettings(&b, rule)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHostRuleSettings(b *strings.Builder, rule fetcher.HostRule) {
+	for _, name := range hostRuleFlags {
+		if v := hostRuleValue(rule, name); v != nil {
+			b.WriteString(name + " = " + tomlValue(redactSetting(name, v)) + "\n")
+		}
+	}
+	if len(rule.Headers) > 0 {
+		b.WriteString("headers = { ")
+		for i, k := range sortedHeaderKeys(rule.Headers) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(k) + " = " + tomlValue(rule.Headers[k]))
+		}
+		b.WriteString(" }\n")
+	}
+}
+
+// redactSetting hides the password of a proxy URL in printed settings.
+func redactSetting(name string, val any) any {
+	if s, ok := val.(string); ok && name == "proxy" {
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+	}
+	return val
+}
+
+func writeTOMLHeaders(b *strings.Builder, h http.Header) {
+	for _, k := range sortedHeaderKeys(h) {
+		b.WriteString(strconv.Quote(k) + " = " + tomlValue(h[k]) + "\n")
+	}
+}
+
+func sortedHeaderKeys(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func tomlValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case time.Duration:
+		return strconv.Quote(v.String())
+	case []string:
+		if len(v) == 1 {
+			return strconv.Quote(v[0])
+		}
+		return tomlArray(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	defaultCrawlMaxDepth = 2
+	defaultCrawlMaxPages = 50
+)
+
+// crawlSkipExtensions are link targets that never yield Markdown, so the
+// crawler does not spend fetches on them. PDFs are converted and stay in.
+var crawlSkipExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".svg": true, ".ico": true,
+	".css": true, ".js": true, ".mjs": true, ".map": true, ".json": true, ".xml": true, ".rss": true,
+	".zip": true, ".gz": true, ".tgz": true, ".tar": true, ".bz2": true, ".xz": true, ".7z": true, ".dmg": true, ".exe": true,
+	".mp3": true, ".mp4": true, ".webm": true, ".mov": true, ".woff": true, ".woff2": true, ".ttf": true, ".eot": true,
+}
+
+func newCrawlCommand(defaultCfg fetcher.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "crawl",
+		Usage:     "fetch a page and follow its links within the same site",
+		UsageText: "agent-fetch crawl [options] <url>",
+		Description: "Pages are fetched breadth-first, starting at <url>. Links are followed when\n" +
+			"they stay on the same host under --path-prefix (default: the directory of\n" +
+			"<url>) and pass --include/--exclude. Pages sharing a canonical URL are\n" +
+			"emitted once. Results stream in completion order.",
+		Flags: append(append(outputFlags(),
+			&cli.IntFlag{Name: "max-depth", Value: defaultCrawlMaxDepth, Usage: "max link hops from the start URL (0 fetches only the start URL)"},
+			&cli.IntFlag{Name: "max-pages", Value: defaultCrawlMaxPages, Usage: "max pages to emit"},
+			&cli.StringFlag{Name: "path-prefix", Usage: "only follow links whose path starts with this prefix; '/' allows the whole host"},
+			&cli.StringSliceFlag{Name: "include", Usage: "only follow links whose URL matches this regex, repeatable"},
+			&cli.StringSliceFlag{Name: "exclude", Usage: "never follow links whose URL matches this regex, repeatable"},
+		), fetchFlags(defaultCfg)...),
+		Action: runCrawl,
+	}
+}
+
+func runCrawl(ctx context.Context, c *cli.Command) (err error) {
+	if c.Args().Len() != 1 {
+		_ = cli.ShowSubcommandHelp(c)
+		return &exitStatusError{code: 2}
+	}
+
+	cfg, err := fetchConfigFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer saveCookies(c, cfg.CookieJar, &err)
+	format, jsonlOpts, err := outputOptionsFromFlags(c, cfg)
+	if err != nil {
+		return err
+	}
+	limits, err := batchLimitsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	opts, err := crawlOptionsFromFlags(c, limits)
+	if err != nil {
+		return err
+	}
+	robots, err := robotsPolicyFromFlags(c)
+	if err != nil {
+		return err
+	}
+	robots.apply(&cfg, true)
+
+	pool, err := browserPoolFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	cfg.BrowserPool = pool
+
+	w, err := outputFileFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	var out resultWriter = newBatchWriter(w, format, jsonlOpts)
+	dir, err := outputDirFromFlags(c, format)
+	if err != nil {
+		return err
+	}
+	if dir != nil {
+		out = dir
+	}
+	crawl(ctx, c.Args().First(), cfg, opts, fetcher.Fetch, out.write)
+	if err := out.finish(); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	if err := w.Close(); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	if out.failures() > 0 {
+		return &exitStatusError{code: 1}
+	}
+	return nil
+}
+
+type crawlOptions struct {
+	maxDepth   int
+	maxPages   int
+	limits     batchLimits
+	pathPrefix string
+	include    []*regexp.Regexp
+	exclude    []*regexp.Regexp
+}
+
+func crawlOptionsFromFlags(c *cli.Command, limits batchLimits) (crawlOptions, error) {
+	opts := crawlOptions{
+		maxDepth:   c.Int("max-depth"),
+		maxPages:   c.Int("max-pages"),
+		limits:     limits,
+		pathPrefix: c.String("path-prefix"),
+	}
+	if opts.maxDepth < 0 {
+		return crawlOptions{}, &exitStatusError{code: 2, msg: "invalid max-depth: must be >= 0"}
+	}
+	if opts.maxPages < 1 {
+		return crawlOptions{}, &exitStatusError{code: 2, msg: "invalid max-pages: must be >= 1"}
+	}
+
+	start, err := url.Parse(c.Args().First())
+	if err != nil || (start.Scheme != "http" && start.Scheme != "https") || start.Host == "" {
+		return crawlOptions{}, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid URL: %q", c.Args().First())}
+	}
+	if opts.pathPrefix == "" {
+		opts.pathPrefix = defaultCrawlPathPrefix(start.Path)
+	}
+
+	if opts.include, err = regexpsFromFlag(c, "include"); err != nil {
+		return crawlOptions{}, err
+	}
+	if opts.exclude, err = regexpsFromFlag(c, "exclude"); err != nil {
+		return crawlOptions{}, err
+	}
+	return opts, nil
+}
+
+// regexpsFromFlag compiles the values of a repeatable regex flag.
+func regexpsFromFlag(c *cli.Command, name string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, expr := range c.StringSlice(name) {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid %s: %v", name, err)}
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// defaultCrawlPathPrefix scopes a crawl to the directory of the start page, so
+// starting at /docs/intro covers /docs/.
+func defaultCrawlPathPrefix(p string) string {
+	if i := strings.LastIndexByte(p, '/'); i >= 0 {
+		return p[:i+1]
+	}
+	return "/"
+}
+
+// crawl fetches start and, breadth-first, the in-scope pages it links to. Each
+// depth level runs through fetchBatchStream, so pages are handed to emit in
+// completion order, numbered by emission. Pages whose final or canonical URL
+// was already emitted are dropped.
+func crawl(ctx context.Context, start string, cfg fetcher.Config, opts crawlOptions, fetch fetchFunc, emit func(taskResult)) {
+	cfg.CollectLinks = true
+
+	hosts := map[string]bool{crawlHost(start): true}
+	queued := map[string]bool{crawlKey(start): true}
+	emitted := map[string]bool{}
+	seq := 0
+
+	level := []string{start}
+	for depth := 0; len(level) > 0 && seq < opts.maxPages && ctx.Err() == nil; depth++ {
+		if remaining := opts.maxPages - seq; len(level) > remaining {
+			level = level[:remaining]
+		}
+
+		var next []string
+		fetchBatchStream(ctx, level, cfg, opts.limits, fetch, func(result taskResult) {
+			if result.err == nil {
+				keys := []string{crawlKey(result.inputURL), crawlKey(result.finalURL), crawlKey(result.canonicalURL)}
+				for _, key := range keys {
+					if key != "" && emitted[key] {
+						return
+					}
+				}
+				for _, key := range keys {
+					if key != "" {
+						emitted[key] = true
+						queued[key] = true
+					}
+				}
+				// Follow the start page across a redirect to another host, e.g. www.
+				if depth == 0 {
+					if host := crawlHost(result.finalURL); host != "" {
+						hosts[host] = true
+					}
+				}
+			}
+			if seq >= opts.maxPages {
+				return
+			}
+
+			seq++
+			result.index = seq
+			emit(result)
+
+			if depth >= opts.maxDepth {
+				return
+			}
+			for _, link := range result.links {
+				key := crawlKey(link)
+				if key == "" || queued[key] || !opts.follows(link, hosts) {
+					continue
+				}
+				queued[key] = true
+				next = append(next, link)
+			}
+		})
+		level = next
+	}
+}
+
+// follows reports whether a discovered link is inside the crawl scope.
+func (o crawlOptions) follows(link string, hosts map[string]bool) bool {
+	u, err := url.Parse(link)
+	if err != nil || !hosts[crawlHost(link)] {
+		return false
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if !strings.HasPrefix(p, o.pathPrefix) && p+"/" != o.pathPrefix {
+		return false
+	}
+	if crawlSkipExtensions[strings.ToLower(path.Ext(p))] {
+		return false
+	}
+	return matchesURLFilters(link, o.include, o.exclude)
+}
+
+// matchesURLFilters reports whether u matches none of exclude and, when
+// include is non-empty, at least one of include.
+func matchesURLFilters(u string, include, exclude []*regexp.Regexp) bool {
+	for _, re := range exclude {
+		if re.MatchString(u) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, re := range include {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// crawlKey normalizes a URL for deduplication: lower-case scheme and host, no
+// default port, no fragment, "/" for an empty path and sorted query parameters.
+func crawlKey(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = normalizedHost(u)
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+	return u.String()
+}
+
+// crawlHost returns the normalized host[:port] of raw, or "" when it has none.
+func crawlHost(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return ""
+	}
+	return normalizedHost(u)
+}
+
+func normalizedHost(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(strings.EqualFold(u.Scheme, "http") && port == "80") && !(strings.EqualFold(u.Scheme, "https") && port == "443") {
+		host += ":" + port
+	}
+	return host
+}
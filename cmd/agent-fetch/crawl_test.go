@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func newCrawlTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	pages := map[string]string{
+		"/docs/": `<a href="/docs/a">A</a> <a href="b#intro">B</a> <a href="/docs/a#top">A again</a>
+			<a href="/blog/post">Blog</a> <a href="/docs/logo.png">Logo</a> <a href="https://other.example/docs/x">Elsewhere</a>`,
+		"/docs/a":           `<a href="/docs/c">C</a> <a href="/docs/">Home</a> <a href="/docs/private/key">Private</a>`,
+		"/docs/b":           `<link rel="canonical" href="/docs/"><a href="/docs/d">D</a>`,
+		"/docs/c":           `<p>leaf</p>`,
+		"/docs/private/key": `<p>secret</p>`,
+		"/blog/post":        `<p>blog</p>`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><head><title>%s</title></head><body><h1>Page %s</h1>%s</body></html>", r.URL.Path, r.URL.Path, body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func crawlForTest(t *testing.T, start string, opts crawlOptions) []taskResult {
+	t.Helper()
+	cfg := fetcher.DefaultConfig()
+	cfg.Mode = fetcher.ModeStatic
+	if opts.limits.concurrency == 0 {
+		opts.limits.concurrency = 2
+	}
+	if opts.pathPrefix == "" {
+		opts.pathPrefix = "/docs/"
+	}
+
+	var results []taskResult
+	crawl(context.Background(), start, cfg, opts, fetcher.Fetch, func(result taskResult) {
+		if result.index != len(results)+1 {
+			t.Errorf("result %s numbered %d, want %d", result.inputURL, result.index, len(results)+1)
+		}
+		results = append(results, result)
+	})
+	return results
+}
+
+func crawledPaths(t *testing.T, base string, results []taskResult) []string {
+	t.Helper()
+	var paths []string
+	for _, result := range results {
+		if result.err != nil {
+			t.Fatalf("fetch %s: %v", result.inputURL, result.err)
+		}
+		paths = append(paths, strings.TrimPrefix(result.inputURL, base))
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestCrawlFollowsLinksWithinScope(t *testing.T) {
+	ts := newCrawlTestSite(t)
+
+	results := crawlForTest(t, ts.URL+"/docs/", crawlOptions{maxDepth: 2, maxPages: 50})
+	got := strings.Join(crawledPaths(t, ts.URL, results), " ")
+	// b is dropped because its canonical URL is the start page; d is only linked from b.
+	if got != "/docs/ /docs/a /docs/c /docs/private/key" {
+		t.Fatalf("unexpected pages: %s", got)
+	}
+}
+
+func TestCrawlDepthPagesAndFilters(t *testing.T) {
+	ts := newCrawlTestSite(t)
+
+	results := crawlForTest(t, ts.URL+"/docs/", crawlOptions{maxDepth: 0, maxPages: 50})
+	if got := strings.Join(crawledPaths(t, ts.URL, results), " "); got != "/docs/" {
+		t.Fatalf("max-depth 0: unexpected pages: %s", got)
+	}
+
+	results = crawlForTest(t, ts.URL+"/docs/", crawlOptions{maxDepth: 5, maxPages: 2})
+	if len(results) != 2 {
+		t.Fatalf("max-pages 2: got %d pages", len(results))
+	}
+
+	results = crawlForTest(t, ts.URL+"/docs/", crawlOptions{
+		maxDepth: 5,
+		maxPages: 50,
+		exclude:  []*regexp.Regexp{regexp.MustCompile(`/private/`)},
+	})
+	if got := strings.Join(crawledPaths(t, ts.URL, results), " "); got != "/docs/ /docs/a /docs/c" {
+		t.Fatalf("exclude: unexpected pages: %s", got)
+	}
+
+	results = crawlForTest(t, ts.URL+"/docs/", crawlOptions{
+		maxDepth: 5,
+		maxPages: 50,
+		include:  []*regexp.Regexp{regexp.MustCompile(`/docs/a$`)},
+	})
+	if got := strings.Join(crawledPaths(t, ts.URL, results), " "); got != "/docs/ /docs/a" {
+		t.Fatalf("include: unexpected pages: %s", got)
+	}
+
+	results = crawlForTest(t, ts.URL+"/docs/", crawlOptions{maxDepth: 1, maxPages: 50, pathPrefix: "/"})
+	if got := strings.Join(crawledPaths(t, ts.URL, results), " "); got != "/blog/post /docs/ /docs/a" {
+		t.Fatalf("path-prefix /: unexpected pages: %s", got)
+	}
+}
+
+func TestCrawlKey(t *testing.T) {
+	tests := map[string]string{
+		"HTTPS://Example.com:443/docs#intro": "https://example.com/docs",
+		"http://example.com":                 "http://example.com/",
+		"http://example.com:8080/a?b=2&a=1":  "http://example.com:8080/a?a=1&b=2",
+		"/relative":                          "",
+	}
+	for in, want := range tests {
+		if got := crawlKey(in); got != want {
+			t.Errorf("crawlKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCrawlRespectsRobots(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /docs/private/\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><body><h1>Docs</h1><a href="/docs/private/key">Private</a></body></html>`))
+	}))
+	defer ts.Close()
+
+	cfg := fetcher.DefaultConfig()
+	cfg.Mode = fetcher.ModeStatic
+	robotsPolicy{robots: fetcher.NewRobots()}.apply(&cfg, true)
+
+	var results []taskResult
+	crawl(context.Background(), ts.URL+"/docs/", cfg, crawlOptions{maxDepth: 1, maxPages: 10, limits: batchLimits{concurrency: 1}, pathPrefix: "/docs/"}, fetcher.Fetch, func(result taskResult) {
+		results = append(results, result)
+	})
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if results[0].err != nil {
+		t.Fatalf("start page: %v", results[0].err)
+	}
+	if !errors.Is(results[1].err, fetcher.ErrDisallowedByRobots) {
+		t.Fatalf("private page: expected ErrDisallowedByRobots, got %v", results[1].err)
+	}
+}
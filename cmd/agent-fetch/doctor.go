@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"runtime"
 	"strings"
 	"time"
@@ -27,10 +29,23 @@ const (
 type doctorDeps struct {
 	resolveBrowser func(string) (string, []string, error)
 	runProbe       func(context.Context, string) (string, error)
+	dialProxy      func(context.Context, string) error
+	getenv         func(string) string
 	goos           string
 	goarch         string
 }
 
+// proxyCheck is the result of connecting to one configured proxy.
+type proxyCheck struct {
+	proxy  string // redacted
+	source string
+	err    error
+}
+
+// proxyEnvVars are the variables HTTP requests and the browser take their
+// proxy from when --proxy is not set.
+var proxyEnvVars = []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"}
+
 type browserCheck struct {
 	status      doctorStatus
 	candidates  []string
@@ -40,15 +55,21 @@ type browserCheck struct {
 	guidance    []string
 }
 
-func runDoctor(ctx context.Context, out io.Writer, browserPath string) (doctorStatus, error) {
-	deps := doctorDeps{
+func defaultDoctorDeps() doctorDeps {
+	return doctorDeps{
 		resolveBrowser: fetcher.ResolveBrowserExecutablePath,
 		runProbe:       runBrowserProbe,
+		dialProxy:      dialProxy,
+		getenv:         os.Getenv,
 		goos:           runtime.GOOS,
 		goarch:         runtime.GOARCH,
 	}
+}
 
+func runDoctor(ctx context.Context, out io.Writer, browserPath, proxy string) (doctorStatus, error) {
+	deps := defaultDoctorDeps()
 	check := diagnoseBrowser(ctx, deps, browserPath)
+	proxies := diagnoseProxies(ctx, deps, proxy)
 
 	if _, err := fmt.Fprintf(out, "version: %s\n", versionString()); err != nil {
 		return doctorStatusWarn, err
@@ -103,7 +124,71 @@ func runDoctor(ctx context.Context, out io.Writer, browserPath string) (doctorSt
 			}
 		}
 	}
-	return check.status, nil
+
+	status := check.status
+	for _, p := range proxies {
+		result := "reachable"
+		if p.err != nil {
+			result = fmt.Sprintf("unreachable: %v", p.err)
+			status = doctorStatusWarn
+		}
+		if _, err := fmt.Fprintf(out, "proxy: %s (%s) %s\n", p.proxy, p.source, result); err != nil {
+			return doctorStatusWarn, err
+		}
+	}
+	return status, nil
+}
+
+// diagnoseProxies connects to the proxy given with --proxy, or else to those
+// named by the environment.
+func diagnoseProxies(ctx context.Context, deps doctorDeps, proxy string) []proxyCheck {
+	type candidate struct{ proxy, source string }
+	var candidates []candidate
+	switch proxy = strings.TrimSpace(proxy); {
+	case proxy == fetcher.ProxyDirect:
+	case proxy != "":
+		candidates = append(candidates, candidate{proxy, "--proxy"})
+	case deps.getenv != nil:
+		seen := map[string]bool{}
+		for _, name := range proxyEnvVars {
+			v := strings.TrimSpace(deps.getenv(name))
+			if v == "" || seen[v] {
+				continue
+			}
+			seen[v] = true
+			if !strings.Contains(v, "://") {
+				// As for HTTP requests, a bare host:port is an HTTP proxy.
+				v = "http://" + v
+			}
+			candidates = append(candidates, candidate{v, name})
+		}
+	}
+
+	var checks []proxyCheck
+	for _, c := range candidates {
+		check := proxyCheck{proxy: c.proxy, source: c.source}
+		u, err := fetcher.ParseProxy(c.proxy)
+		if err != nil {
+			check.err = err
+			checks = append(checks, check)
+			continue
+		}
+		check.proxy = u.Redacted()
+		dialCtx, cancel := context.WithTimeout(ctx, doctorProbeTimeout)
+		check.err = deps.dialProxy(dialCtx, fetcher.ProxyHostPort(u))
+		cancel()
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func dialProxy(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
 }
 
 func diagnoseBrowser(ctx context.Context, deps doctorDeps, browserPath string) browserCheck {
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// batchLookahead bounds how many tasks a batch holds back while their hosts
+// are busy, so that tasks for idle hosts further down the input can start.
+const batchLookahead = 1024
+
+// batchReorderWindow bounds how far a batch written in input order runs ahead
+// of its slowest task, and so how many results the writer holds back.
+const batchReorderWindow = 256
+
+// batchLimits bounds the fetches of a batch: concurrency in total, and per
+// host through hosts, which may be shared by several batches.
+type batchLimits struct {
+	concurrency int
+	hosts       *hostLimiter
+	// window, if positive, keeps every task taken within window seqs of the
+	// lowest one that has not finished.
+	window int
+}
+
+func batchLimitsFromFlags(c *cli.Command) (batchLimits, error) {
+	concurrency := c.Int("concurrency")
+	if concurrency < 1 {
+		return batchLimits{}, &exitStatusError{code: 2, msg: "invalid concurrency: must be >= 1"}
+	}
+	hosts, err := hostLimiterFromFlags(c)
+	if err != nil {
+		return batchLimits{}, err
+	}
+	return batchLimits{concurrency: concurrency, hosts: hosts}, nil
+}
+
+// shared returns limits for a server that runs each request as its own
+// batch. The batches share one hostLimiter, so per-host caps, rates and
+// Crawl-delays hold across requests, and it also caps their fetches in total
+// at concurrency.
+func (b batchLimits) shared() batchLimits {
+	l := &hostLimiter{total: b.concurrency, hosts: map[string]*hostSlots{}, wake: make(chan struct{})}
+	if b.hosts != nil {
+		l.perHost, l.rates = b.hosts.perHost, b.hosts.rates
+	}
+	return batchLimits{concurrency: b.concurrency, hosts: l}
+}
+
+// hostLimiter caps the fetches in flight per host and spaces their starts
+// according to a requests-per-second rate. A nil *hostLimiter allows
+// everything.
+type hostLimiter struct {
+	// total, when > 0, caps the fetches in flight across all hosts and all
+	// batches sharing the limiter; running counts them.
+	total   int
+	running int
+	perHost int
+	// rates maps a lowercase host name, or "*" for any other host, to the
+	// requests per second allowed.
+	rates map[string]float64
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+	// wake is closed, and replaced, whenever a slot is released.
+	wake chan struct{}
+}
+
+type hostSlots struct {
+	running int
+	next    time.Time
+	// crawlDelay spaces the host's starts once a fetch has looked it up
+	// in robots.txt and set crawlDelayKnown.
+	crawlDelay      time.Duration
+	crawlDelayKnown bool
+}
+
+func newHostLimiter(perHost int, rates map[string]float64) *hostLimiter {
+	if perHost <= 0 && len(rates) == 0 {
+		return nil
+	}
+	return &hostLimiter{perHost: perHost, rates: rates, hosts: map[string]*hostSlots{}, wake: make(chan struct{})}
+}
+
+func hostLimiterFromFlags(c *cli.Command) (*hostLimiter, error) {
+	perHost := c.Int("per-host-concurrency")
+	if perHost < 0 {
+		return nil, &exitStatusError{code: 2, msg: "invalid per-host-concurrency: must be >= 0"}
+	}
+	rates := map[string]float64{}
+	for _, v := range c.StringSlice("rate-limit") {
+		host, rps, err := parseRateLimit(v)
+		if err != nil {
+			return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid rate-limit: %v", err)}
+		}
+		rates[host] = rps
+	}
+	return newHostLimiter(perHost, rates), nil
+}
+
+// parseRateLimit reads a "host=rps" value; host "*" applies to every host
+// without its own limit.
+func parseRateLimit(v string) (string, float64, error) {
+	host, rate, ok := strings.Cut(v, "=")
+	host = strings.ToLower(strings.TrimSpace(host))
+	if !ok || host == "" {
+		return "", 0, fmt.Errorf("%q is not host=requests-per-second", v)
+	}
+	rps, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil || rps <= 0 {
+		return "", 0, fmt.Errorf("%q: rate must be a number > 0", v)
+	}
+	return host, rps, nil
+}
+
+// taskHost returns the host a task's limits are keyed by, or "" when rawURL
+// has none.
+func taskHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func (l *hostLimiter) interval(host string) time.Duration {
+	rps, ok := l.rates[host]
+	if !ok {
+		rps, ok = l.rates["*"]
+	}
+	if !ok {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rps)
+}
+
+// acquire takes a slot for host if one is free and its rate and Crawl-delay
+// allow a request at now. Otherwise it returns false and, when only the rate
+// is in the way, how long until it allows the next request. With robots set,
+// a host whose Crawl-delay is not known yet gets one fetch at a time until
+// setCrawlDelay records it.
+func (l *hostLimiter) acquire(host string, now time.Time, robots bool) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.total > 0 && l.running >= l.total {
+		return false, 0
+	}
+	if host == "" {
+		l.running++
+		return true, 0
+	}
+	slots := l.hosts[host]
+	if slots == nil {
+		slots = &hostSlots{}
+		l.hosts[host] = slots
+	}
+	if l.perHost > 0 && slots.running >= l.perHost {
+		return false, 0
+	}
+	if robots && !slots.crawlDelayKnown && slots.running > 0 {
+		return false, 0
+	}
+	if now.Before(slots.next) {
+		return false, slots.next.Sub(now)
+	}
+	slots.running++
+	slots.next = now.Add(max(l.interval(host), slots.crawlDelay))
+	l.running++
+	return true, 0
+}
+
+// setCrawlDelay records the Crawl-delay of host, looked up by a fetch that
+// acquired a slot at started, and spaces the host's next start from it.
+func (l *hostLimiter) setCrawlDelay(host string, started time.Time, delay time.Duration) {
+	if l == nil || host == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots := l.hosts[host]
+	if slots == nil {
+		return
+	}
+	slots.crawlDelay, slots.crawlDelayKnown = delay, true
+	if next := started.Add(delay); next.After(slots.next) {
+		slots.next = next
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// release frees a slot taken by acquire.
+func (l *hostLimiter) release(host string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running > 0 {
+		l.running--
+	}
+	if slots := l.hosts[host]; host != "" && slots != nil && slots.running > 0 {
+		slots.running--
+		if slots.running == 0 && !time.Now().Before(slots.next) {
+			delete(l.hosts, host)
+		}
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// changed returns a channel that is closed the next time a slot is released
+// or a Crawl-delay is recorded.
+func (l *hostLimiter) changed() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.wake
+}
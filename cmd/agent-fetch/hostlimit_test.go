@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	host, rps, err := parseRateLimit(" Docs.Example.com = 2.5 ")
+	if err != nil || host != "docs.example.com" || rps != 2.5 {
+		t.Fatalf("got %q %v %v", host, rps, err)
+	}
+	if host, _, err := parseRateLimit("*=1"); err != nil || host != "*" {
+		t.Fatalf("wildcard: %q %v", host, err)
+	}
+	for _, bad := range []string{"example.com", "=1", "example.com=0", "example.com=fast"} {
+		if _, _, err := parseRateLimit(bad); err == nil {
+			t.Errorf("parseRateLimit(%q): expected error", bad)
+		}
+	}
+}
+
+func TestHostLimiter(t *testing.T) {
+	if newHostLimiter(0, nil) != nil {
+		t.Fatal("expected no limiter without limits")
+	}
+
+	l := newHostLimiter(2, map[string]float64{"slow.example": 2, "*": 10})
+	now := time.Unix(0, 0)
+	if ok, _ := l.acquire("a.example", now, false); !ok {
+		t.Fatal("first acquire refused")
+	}
+	if ok, wait := l.acquire("a.example", now, false); ok || wait != 100*time.Millisecond {
+		t.Fatalf("default rate: ok %v wait %v", ok, wait)
+	}
+	if ok, _ := l.acquire("a.example", now.Add(100*time.Millisecond), false); !ok {
+		t.Fatal("acquire after interval refused")
+	}
+	// The per-host cap applies before the rate.
+	if ok, wait := l.acquire("a.example", now.Add(time.Second), false); ok || wait != 0 {
+		t.Fatalf("cap: ok %v wait %v", ok, wait)
+	}
+	changed := l.changed()
+	l.release("a.example")
+	select {
+	case <-changed:
+	default:
+		t.Fatal("release did not signal waiters")
+	}
+	if ok, _ := l.acquire("a.example", now.Add(time.Second), false); !ok {
+		t.Fatal("acquire after release refused")
+	}
+
+	if ok, _ := l.acquire("slow.example", now, false); !ok {
+		t.Fatal("slow host refused")
+	}
+	if _, wait := l.acquire("slow.example", now, false); wait != 500*time.Millisecond {
+		t.Fatalf("host rate: wait %v", wait)
+	}
+}
+
+func TestSharedLimitsCapTotal(t *testing.T) {
+	l := batchLimits{concurrency: 2, hosts: newHostLimiter(1, nil)}.shared().hosts
+	now := time.Unix(0, 0)
+	for _, host := range []string{"a.example", ""} {
+		if ok, _ := l.acquire(host, now, false); !ok {
+			t.Fatalf("acquire %q refused", host)
+		}
+	}
+	if ok, _ := l.acquire("b.example", now, false); ok {
+		t.Fatal("acquire beyond the total allowed")
+	}
+	l.release("")
+	if ok, _ := l.acquire("a.example", now, false); ok {
+		t.Fatal("per-host cap not kept by shared limits")
+	}
+	if ok, _ := l.acquire("b.example", now, false); !ok {
+		t.Fatal("acquire after release refused")
+	}
+}
+
+func TestFetchTasksBusyHostDoesNotBlockOthers(t *testing.T) {
+	unblock := make(chan struct{})
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+	peak := map[string]int{}
+	fetch := func(ctx context.Context, url string, _ fetcher.Config) (fetcher.Result, error) {
+		host := taskHost(url)
+		mu.Lock()
+		inFlight[host]++
+		peak[host] = max(peak[host], inFlight[host])
+		mu.Unlock()
+		if host == "busy.example" {
+			<-unblock
+		}
+		mu.Lock()
+		inFlight[host]--
+		mu.Unlock()
+		return fetcher.Result{Markdown: url}, nil
+	}
+
+	var urls []string
+	for _, path := range []string{"1", "2", "3", "4"} {
+		urls = append(urls, "https://busy.example/"+path)
+	}
+	urls = append(urls, "https://a.example/", "https://b.example/", "https://c.example/")
+
+	limits := batchLimits{concurrency: 3, hosts: newHostLimiter(1, nil)}
+	var order []string
+	var once sync.Once
+	fetchBatchStream(context.Background(), urls, fetcher.DefaultConfig(), limits, fetch, func(result taskResult) {
+		order = append(order, taskHost(result.inputURL))
+		// Other hosts finish while the busy host's first fetch still runs.
+		if len(order) == 3 {
+			once.Do(func() { close(unblock) })
+		}
+	})
+
+	if len(order) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(order), len(urls))
+	}
+	if got := strings.Join(order[:3], " "); strings.Contains(got, "busy") {
+		t.Fatalf("busy host held up the batch: %s", got)
+	}
+	if peak["busy.example"] != 1 {
+		t.Fatalf("busy.example peak concurrency %d, want 1", peak["busy.example"])
+	}
+}
+
+func TestFetchTasksRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var starts []time.Time
+	fetch := func(_ context.Context, url string, _ fetcher.Config) (fetcher.Result, error) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+		return fetcher.Result{Markdown: url}, nil
+	}
+	urls := []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"}
+	limits := batchLimits{concurrency: 3, hosts: newHostLimiter(0, map[string]float64{"example.com": 25})}
+	results := fetchBatch(context.Background(), urls, fetcher.DefaultConfig(), limits, fetch)
+	for _, result := range results {
+		if result.err != nil {
+			t.Fatalf("fetch %s: %v", result.inputURL, result.err)
+		}
+	}
+	if len(starts) != 3 {
+		t.Fatalf("got %d fetches, want 3", len(starts))
+	}
+	if gap := starts[2].Sub(starts[0]); gap < 75*time.Millisecond {
+		t.Fatalf("3 requests at 25 rps took %v, want about 80ms", gap)
+	}
+}
+
+func TestFetchTasksCrawlDelayDoesNotHoldSlots(t *testing.T) {
+	delayed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("User-agent: *\nCrawl-delay: 0.3\n"))
+	}))
+	defer delayed.Close()
+	// Reached as localhost, so it is another host than 127.0.0.1.
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	var mu sync.Mutex
+	var delayedStarts []time.Time
+	fetch := func(_ context.Context, url string, _ fetcher.Config) (fetcher.Result, error) {
+		if strings.HasPrefix(url, delayed.URL) {
+			mu.Lock()
+			delayedStarts = append(delayedStarts, time.Now())
+			mu.Unlock()
+		}
+		return fetcher.Result{Markdown: url}, nil
+	}
+
+	var urls []string
+	for _, path := range []string{"/1", "/2", "/3"} {
+		urls = append(urls, delayed.URL+path)
+	}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		urls = append(urls, otherURL+path)
+	}
+	cfg := fetcher.DefaultConfig()
+	cfg.Robots = fetcher.NewRobots()
+	var order []string
+	fetchBatchStream(context.Background(), urls, cfg, batchLimits{concurrency: 2}, fetch, func(result taskResult) {
+		order = append(order, result.inputURL)
+	})
+
+	if len(order) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(order), len(urls))
+	}
+	// The other host's tasks run while the delayed host's wait.
+	for _, url := range order[1:4] {
+		if !strings.HasPrefix(url, otherURL) {
+			t.Fatalf("crawl delay held up the batch: %q", order)
+		}
+	}
+	for i := 1; i < len(delayedStarts); i++ {
+		if gap := delayedStarts[i].Sub(delayedStarts[i-1]); gap < 290*time.Millisecond {
+			t.Fatalf("delayed host fetches %v apart, want Crawl-delay 300ms", gap)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+// inputMaxLineBytes bounds a single --input line.
+const inputMaxLineBytes = 1 << 20
+
+// inputLine is a JSONL --input row: a URL with optional per-URL overrides of
+// the command-line fetch flags.
+type inputLine struct {
+	URL          string   `json:"url"`
+	Mode         string   `json:"mode"`
+	Headers      []string `json:"headers"`
+	WaitSelector string   `json:"wait_selector"`
+	Select       []string `json:"select"`
+	Exclude      []string `json:"exclude"`
+}
+
+// openInput opens the --input file, or stdin for "-".
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid input: %v", err)}
+	}
+	return f, nil
+}
+
+// readInput sends a task per URL line or JSONL object of r, as they are read.
+// Blank lines and lines starting with "#" are skipped. A line that cannot be
+// parsed becomes a failed task so the rest of the batch still runs; only read
+// errors and ctx cancellation are returned.
+func readInput(ctx context.Context, r io.Reader, base fetcher.Config, tasks chan<- batchTask) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), inputMaxLineBytes)
+	lineNo := 0
+	for sc.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "{") {
+			tasks <- batchTask{url: line, cfg: base}
+			continue
+		}
+
+		task, err := parseInputLine(line, base)
+		if err != nil {
+			if task.url == "" {
+				task.url = line
+			}
+			task.err = fmt.Errorf("input line %d: %w", lineNo, err)
+		}
+		tasks <- task
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read input line %d: %w", lineNo+1, err)
+	}
+	return nil
+}
+
+func parseInputLine(line string, base fetcher.Config) (batchTask, error) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.DisallowUnknownFields()
+	var in inputLine
+	if err := dec.Decode(&in); err != nil {
+		// Name the URL in the failed row when it was decoded before the error.
+		return batchTask{url: strings.TrimSpace(in.URL)}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	in.URL = strings.TrimSpace(in.URL)
+	if in.URL == "" {
+		return batchTask{}, errors.New("url is required")
+	}
+	req := fetchRequest{URL: in.URL, Mode: in.Mode, Headers: in.Headers, WaitSelector: in.WaitSelector, Select: in.Select, Exclude: in.Exclude}
+	cfg, err := req.config(base)
+	if err != nil {
+		return batchTask{url: in.URL}, err
+	}
+	return batchTask{url: in.URL, cfg: cfg}, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func TestReadInput(t *testing.T) {
+	input := strings.Join([]string{
+		"# docs to fetch",
+		"https://example.com/a",
+		"",
+		`  {"url": "https://example.com/b", "mode": "browser", "headers": ["X-Token: 1"], "wait_selector": "main", "select": ["article"], "exclude": [".ad"]}`,
+		`{"url": "https://example.com/c", "mode": "fast"}`,
+		`{"url": "https://example.com/d", "format": "jsonl"}`,
+		`{"mode": "static"}`,
+		`{not json`,
+	}, "\n")
+
+	base := fetcher.DefaultConfig()
+	tasks := make(chan batchTask, 16)
+	if err := readInput(context.Background(), strings.NewReader(input), base, tasks); err != nil {
+		t.Fatalf("read input: %v", err)
+	}
+	close(tasks)
+	var got []batchTask
+	for task := range tasks {
+		got = append(got, task)
+	}
+	if len(got) != 6 {
+		t.Fatalf("got %d tasks, want 6", len(got))
+	}
+
+	if got[0].url != "https://example.com/a" || got[0].err != nil || got[0].cfg.Mode != base.Mode {
+		t.Fatalf("plain URL task: %+v", got[0])
+	}
+	b := got[1]
+	if b.err != nil || b.cfg.Mode != fetcher.ModeBrowser || b.cfg.WaitSelector != "main" || b.cfg.Headers.Get("X-Token") != "1" {
+		t.Fatalf("override task: url %q err %v mode %q selector %q headers %v", b.url, b.err, b.cfg.Mode, b.cfg.WaitSelector, b.cfg.Headers)
+	}
+	if len(b.cfg.Select) != 1 || b.cfg.Select[0] != "article" || len(b.cfg.Exclude) != 1 || b.cfg.Exclude[0] != ".ad" {
+		t.Fatalf("override task selectors: %q %q", b.cfg.Select, b.cfg.Exclude)
+	}
+	if base.Headers.Get("X-Token") != "" {
+		t.Fatal("per-URL headers leaked into the base config")
+	}
+
+	wantErrs := []struct{ url, err string }{
+		{"https://example.com/c", `input line 5: unsupported mode "fast"`},
+		{"https://example.com/d", `input line 6: invalid JSON: json: unknown field "format"`},
+		{`{"mode": "static"}`, "input line 7: url is required"},
+		{`{not json`, "input line 8: invalid JSON"},
+	}
+	for i, want := range wantErrs {
+		task := got[i+2]
+		if task.url != want.url || task.err == nil || !strings.HasPrefix(task.err.Error(), want.err) {
+			t.Errorf("task %d: url %q err %v, want url %q err %q", i+3, task.url, task.err, want.url, want.err)
+		}
+	}
+}
+
+func TestFetchTasksStreamsWithBoundedConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	fetch := func(ctx context.Context, url string, cfg fetcher.Config) (fetcher.Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		return fetcher.Result{Markdown: cfg.Mode + " " + url}, nil
+	}
+
+	tasks := make(chan batchTask)
+	go func() {
+		defer close(tasks)
+		for i := range 20 {
+			cfg := fetcher.DefaultConfig()
+			if i%2 == 1 {
+				cfg.Mode = fetcher.ModeStatic
+			}
+			tasks <- batchTask{url: "https://example.com/" + string(rune('a'+i)), cfg: cfg}
+		}
+	}()
+
+	seen := map[int]string{}
+	fetchTasks(context.Background(), tasks, batchLimits{concurrency: 3}, fetch, func(result taskResult) {
+		seen[result.index] = result.markdown
+	})
+	if len(seen) != 20 {
+		t.Fatalf("got %d results, want 20", len(seen))
+	}
+	if seen[1] != "auto https://example.com/a" || seen[2] != "static https://example.com/b" {
+		t.Fatalf("unexpected results: %q, %q", seen[1], seen[2])
+	}
+	if peak.Load() > 3 {
+		t.Fatalf("peak concurrency %d exceeds 3", peak.Load())
+	}
+}
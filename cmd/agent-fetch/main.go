@@ -7,7 +7,9 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/firede/agent-fetch/internal/fetcher"
@@ -97,19 +99,27 @@ func newRootCommand(defaultCfg fetcher.Config) *cli.Command {
 			"Uses a three-stage fallback pipeline: native Markdown -> static HTML\n" +
 			"extraction -> headless browser rendering. Supports custom headers,\n" +
 			"CSS selectors, and concurrent multi-URL batch fetching.",
-		UsageText:                     "agent-fetch <url> [url ...]\n   agent-fetch web [options] <url> [url ...]\n   agent-fetch doctor [options]",
+		UsageText:                     "agent-fetch <url> [url ...]\n   agent-fetch web [options] <url> [url ...]\n   agent-fetch crawl [options] <url>\n   agent-fetch sitemap [options] <site-or-sitemap-url>\n   agent-fetch mcp [options]\n   agent-fetch serve [options]\n   agent-fetch cache <stats|prune|clear> [options]\n   agent-fetch config show [options] [url]\n   agent-fetch rules test [options] <url>\n   agent-fetch doctor [options]",
 		Version:                       versionString(),
 		CustomRootCommandHelpTemplate: rootHelpTemplate,
 		Commands: []*cli.Command{
 			newWebCommand(defaultCfg),
+			newCrawlCommand(defaultCfg),
+			newSitemapCommand(defaultCfg),
+			newMCPCommand(defaultCfg),
+			newServeCommand(defaultCfg),
+			newCacheCommand(),
+			newConfigCommand(defaultCfg),
+			newRulesCommand(defaultCfg),
 			{
 				Name:  "doctor",
 				Usage: "run environment checks (browser/runtime) and print remediation guidance",
 				Flags: []cli.Flag{
 					&cli.StringFlag{Name: "browser-path", Value: defaultCfg.BrowserPath, Usage: "browser executable path/name override for doctor checks"},
+					&cli.StringFlag{Name: "proxy", Usage: "proxy to check for reachability (default: HTTPS_PROXY/HTTP_PROXY)"},
 				},
 				Action: func(ctx context.Context, c *cli.Command) error {
-					status, err := runDoctor(ctx, os.Stdout, c.String("browser-path"))
+					status, err := runDoctor(ctx, os.Stdout, c.String("browser-path"), c.String("proxy"))
 					if err != nil {
 						return &exitStatusError{code: 1, msg: fmt.Sprintf("doctor failed: %v", err)}
 					}
@@ -133,37 +143,408 @@ func newWebCommand(defaultCfg fetcher.Config) *cli.Command {
 		Hidden: true,
 		Usage:  "fetch web pages",
 		UsageText: "agent-fetch [options] <url> [url ...]\n" +
+			"   agent-fetch [options] --input <file|-> [url ...]\n" +
 			"   agent-fetch web [options] <url> [url ...]",
-		Flags: []cli.Flag{
-			&cli.StringFlag{Name: "mode", Value: defaultCfg.Mode, Usage: "fetch mode: auto|static|browser|raw"},
-			&cli.StringFlag{Name: "format", Value: formatMarkdown, Usage: "output format: markdown|jsonl"},
-			&cli.BoolFlag{Name: "meta", Value: defaultCfg.IncludeMeta, Usage: "include title/description metadata (markdown: front matter; jsonl: meta field; default true)"},
-			&cli.DurationFlag{Name: "timeout", Value: defaultCfg.Timeout, Usage: "HTTP request timeout for static/auto modes"},
-			&cli.DurationFlag{Name: "browser-timeout", Value: defaultCfg.BrowserTimeout, Usage: "page-load timeout for browser/auto modes"},
-			&cli.DurationFlag{Name: "network-idle", Value: defaultCfg.NetworkIdle, Usage: "wait this long after last network activity before capturing page content"},
-			&cli.StringFlag{Name: "wait-selector", Usage: "CSS selector to wait for before capturing, e.g. 'article', '#content'"},
-			&cli.StringFlag{Name: "user-agent", Value: defaultCfg.UserAgent, Usage: "User-Agent header"},
-			&cli.Int64Flag{Name: "max-body-bytes", Value: defaultCfg.MaxBodyBytes, Usage: "max response bytes to read"},
-			&cli.IntFlag{Name: "concurrency", Value: 4, Usage: "max concurrent URL fetches when multiple URLs are provided"},
-			&cli.StringSliceFlag{
-				Name:  "header",
-				Usage: "custom request header, repeatable. Example: --header 'Authorization: Bearer token'",
-			},
-			&cli.StringFlag{Name: "browser-path", Value: defaultCfg.BrowserPath, Usage: "browser executable path/name override for browser/auto modes"},
-		},
+		Flags: append(append(outputFlags(),
+			&cli.StringFlag{Name: "input", Usage: "read more URLs from a file ('-' for stdin): one URL per line, or JSONL objects with url and optional mode, headers, wait_selector, select, exclude"},
+			streamFlag(),
+		), append(resumeFlags(), fetchFlags(defaultCfg)...)...),
 		Action: runWebFetch,
 	}
 }
 
-func runWebFetch(ctx context.Context, c *cli.Command) error {
-	if c.Args().Len() < 1 {
+// outputFlags are the result formatting flags shared by commands that print fetched pages.
+func outputFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{Name: "format", Value: formatMarkdown, Usage: "output format: markdown|jsonl"},
+		&cli.BoolFlag{Name: "diagnostics", Usage: "include HTTP status, headers, timings and pipeline trace as a diagnostics field (jsonl only)"},
+		&cli.IntFlag{Name: "chunk-tokens", Usage: "split each page into JSONL rows of at most N estimated tokens along heading/paragraph boundaries (jsonl only)"},
+		&cli.StringFlag{Name: "output", Usage: "write to this file instead of stdout (appended to with --resume)"},
+	}, outputDirFlags()...)
+}
+
+// streamFlag switches multi-URL output from input order to completion order.
+func streamFlag() cli.Flag {
+	return &cli.BoolFlag{Name: "stream", Usage: "write each result as soon as it completes instead of in input order; jsonl ends with a summary row"}
+}
+
+// fetchFlags are the fetcher.Config flags shared by every command that fetches pages.
+func fetchFlags(defaultCfg fetcher.Config) []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{Name: "mode", Value: defaultCfg.Mode, Usage: "fetch mode: auto|static|browser|raw"},
+		&cli.BoolFlag{Name: "meta", Value: defaultCfg.IncludeMeta, Usage: "include title/description metadata (markdown: front matter; jsonl: meta field; default true)"},
+		&cli.StringFlag{Name: "meta-fields", Value: "title,description", Usage: "comma-separated metadata fields to include: title, description, author, published, modified, site_name, lang, canonical, or all"},
+		&cli.DurationFlag{Name: "timeout", Value: defaultCfg.Timeout, Usage: "HTTP request timeout for static/auto modes"},
+		&cli.DurationFlag{Name: "browser-timeout", Value: defaultCfg.BrowserTimeout, Usage: "page-load timeout for browser/auto modes"},
+		&cli.DurationFlag{Name: "network-idle", Value: defaultCfg.NetworkIdle, Usage: "wait this long after last network activity before capturing page content"},
+		&cli.StringFlag{Name: "wait-selector", Usage: "CSS selector to wait for before capturing, e.g. 'article', '#content'"},
+		&cli.StringSliceFlag{Name: "select", Usage: "extract the elements matching this CSS selector instead of the readability article, repeatable"},
+		&cli.StringSliceFlag{Name: "exclude-selector", Usage: "remove the elements matching this CSS selector before extraction, repeatable, e.g. 'nav, .cookie-banner'"},
+		&cli.StringFlag{Name: "user-agent", Value: defaultCfg.UserAgent, Usage: "User-Agent header"},
+		&cli.Int64Flag{Name: "max-body-bytes", Value: defaultCfg.MaxBodyBytes, Usage: "max response bytes to read"},
+		&cli.IntFlag{Name: "concurrency", Value: 4, Usage: "max concurrent URL fetches when multiple URLs are provided"},
+		&cli.IntFlag{Name: "per-host-concurrency", Usage: "max concurrent fetches per host; busy hosts do not hold up others (0: only --concurrency applies)"},
+		&cli.StringSliceFlag{Name: "rate-limit", Usage: "max requests per second to a host, as host=rps (\"*\" for any other host), repeatable"},
+		&cli.StringSliceFlag{
+			Name:  "header",
+			Usage: "custom request header, repeatable. Example: --header 'Authorization: Bearer token'",
+		},
+		&cli.StringFlag{Name: "cookies", Usage: "load cookies from a Netscape cookies.txt file or a JSON cookie export; they are sent by domain and path, also across redirects"},
+		&cli.StringFlag{Name: "save-cookies", Usage: "when done, write the cookies loaded and set during the run to this file (JSON if it ends in .json, otherwise cookies.txt)"},
+		&cli.BoolFlag{Name: "netrc", Usage: "send basic auth from the .netrc entry of each host ($NETRC or ~/.netrc)"},
+		&cli.StringFlag{Name: "netrc-file", Usage: "like --netrc, with this .netrc file"},
+		&cli.StringFlag{Name: "credential-helper", Usage: "command that returns auth headers for each host, run with \"get\" like a git credential helper"},
+		&cli.StringSliceFlag{Name: "helper-host", Usage: "host (or *.example.com) whose browser subrequests also ask the --credential-helper; by default only the page's host and .netrc hosts do, repeatable"},
+		&cli.StringFlag{Name: "proxy", Usage: "proxy for HTTP requests and the browser: http://, https:// or socks5://[user:password@]host:port, or direct (default: HTTP_PROXY/HTTPS_PROXY)"},
+		&cli.StringSliceFlag{Name: "no-proxy", Usage: "hosts to reach without the proxy, as in NO_PROXY: example.com (and subdomains), .example.com, IPs or CIDR ranges, repeatable"},
+		&cli.StringFlag{Name: "browser-path", Value: defaultCfg.BrowserPath, Usage: "browser executable path/name override for browser/auto modes"},
+		&cli.IntFlag{Name: "browser-instances", Value: 1, Usage: "browser processes kept alive for browser renders when fetching multiple URLs"},
+		&cli.IntFlag{Name: "tabs-per-browser", Value: 4, Usage: "max concurrent tabs per pooled browser"},
+		cacheDirFlag("on-disk cache directory for HTTP responses and Markdown (enables caching)", ""),
+		&cli.DurationFlag{Name: "cache-ttl", Value: fetcher.DefaultCacheTTL, Usage: "freshness for cached Markdown and for responses without Cache-Control/Expires"},
+		&cli.BoolFlag{Name: "no-cache", Usage: "disable the cache even when --cache-dir or AGENT_FETCH_CACHE_DIR is set"},
+		&cli.BoolFlag{Name: "respect-robots", Usage: "obey robots.txt and its Crawl-delay for --user-agent (default: on when fetching multiple URLs)"},
+		&cli.BoolFlag{Name: "ignore-robots", Usage: "never fetch or obey robots.txt, even when fetching multiple URLs"},
+		&cli.IntFlag{Name: "retry-attempts", Value: defaultCfg.Retry.MaxAttempts, Usage: "tries per HTTP request or browser render, including the first (1 disables retries)"},
+		&cli.DurationFlag{Name: "retry-backoff", Value: defaultCfg.Retry.BaseDelay, Usage: "wait before the first retry, doubled on each further retry"},
+		&cli.DurationFlag{Name: "retry-max-backoff", Value: defaultCfg.Retry.MaxDelay, Usage: "cap for the retry backoff and for honored Retry-After waits"},
+		&cli.Float64Flag{Name: "retry-jitter", Value: defaultCfg.Retry.Jitter, Usage: "randomly shorten each backoff by up to this fraction (0-1)"},
+		&cli.StringFlag{Name: "retry-statuses", Value: joinStatuses(defaultCfg.Retry.Statuses), Usage: "comma-separated HTTP status codes to retry"},
+	}, configFlags()...)
+}
+
+func newMCPCommand(defaultCfg fetcher.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "mcp",
+		Usage: "run a Model Context Protocol server over stdio exposing fetch and fetch_batch tools",
+		Description: "Options set the defaults for tool calls; mode, headers, wait selector,\n" +
+			"select/exclude selectors and meta can be overridden per call.",
+		Flags: fetchFlags(defaultCfg),
+		Action: func(ctx context.Context, c *cli.Command) (err error) {
+			cfg, err := fetchConfigFromFlags(c)
+			if err != nil {
+				return err
+			}
+			defer saveCookies(c, cfg.CookieJar, &err)
+			limits, err := batchLimitsFromFlags(c)
+			if err != nil {
+				return err
+			}
+			robots, err := robotsPolicyFromFlags(c)
+			if err != nil {
+				return err
+			}
+			pool, err := browserPoolFromFlags(c)
+			if err != nil {
+				return err
+			}
+			defer pool.Close()
+			cfg.BrowserPool = pool
+
+			srv := newMCPServer(cfg, limits, fetcher.Fetch, robots)
+			if err := srv.serve(ctx, os.Stdin, os.Stdout); err != nil {
+				return &exitStatusError{code: 1, msg: fmt.Sprintf("mcp server failed: %v", err)}
+			}
+			return nil
+		},
+	}
+}
+
+func runWebFetch(ctx context.Context, c *cli.Command) (err error) {
+	input := c.String("input")
+	if c.Args().Len() < 1 && input == "" {
 		_ = cli.ShowSubcommandHelp(c)
 		return &exitStatusError{code: 2}
 	}
 
+	cfg, err := fetchConfigFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer saveCookies(c, cfg.CookieJar, &err)
+	format, jsonlOpts, err := outputOptionsFromFlags(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	urls := c.Args().Slice()
+	limits, err := batchLimitsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	robots, err := robotsPolicyFromFlags(c)
+	if err != nil {
+		return err
+	}
+	robots.apply(&cfg, len(urls) > 1 || input != "")
+
+	// --output-dir and --resume keep per-URL records, so even a single URL
+	// runs as a batch.
+	if len(urls) == 1 && input == "" && c.String("output-dir") == "" && c.String("resume") == "" {
+		return runSingleFetch(ctx, c, cfg, urls[0], format, jsonlOpts)
+	}
+
+	if input == "" {
+		return runBatch(ctx, c, cfg, limits, format, jsonlOpts, feedURLs(urls))
+	}
+	r, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return runBatch(ctx, c, cfg, limits, format, jsonlOpts, func(cfg fetcher.Config, tasks chan<- batchTask) error {
+		if err := feedURLs(urls)(cfg, tasks); err != nil {
+			return err
+		}
+		return readInput(ctx, r, cfg, tasks)
+	})
+}
+
+func runSingleFetch(ctx context.Context, c *cli.Command, cfg fetcher.Config, rawURL string, format string, jsonlOpts jsonlOptions) error {
+	w, err := outputFileFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	cfg = cfg.ForURL(rawURL)
+	reqCtx, cancel := context.WithTimeout(ctx, fetchTimeout(cfg))
+	defer cancel()
+
+	res, err := fetcher.Fetch(reqCtx, rawURL, cfg)
+	if err != nil {
+		if format == formatJSONL {
+			results := []taskResult{newTaskResult(1, rawURL, res, err)}
+			if writeErr := writeBatchJSONL(w, results, jsonlOpts); writeErr != nil {
+				return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", writeErr)}
+			}
+			return &exitStatusError{code: 1}
+		}
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("fetch failed: %v", err)}
+	}
+
+	if format == formatJSONL {
+		results := []taskResult{newTaskResult(1, rawURL, res, nil)}
+		if err := writeBatchJSONL(w, results, jsonlOpts); err != nil {
+			return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+		}
+	} else if _, err := io.WriteString(w, res.Markdown); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	if err := w.Close(); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	return nil
+}
+
+// batchFeed sends the tasks of a batch, fetched with cfg unless overridden.
+type batchFeed func(cfg fetcher.Config, tasks chan<- batchTask) error
+
+func feedURLs(urls []string) batchFeed {
+	return func(cfg fetcher.Config, tasks chan<- batchTask) error {
+		for _, url := range urls {
+			tasks <- batchTask{url: url, cfg: cfg}
+		}
+		return nil
+	}
+}
+
+// runBatch fetches the tasks of feed concurrently while feed is still
+// producing them, as a multi-URL web fetch does. Results are written as soon
+// as they can be: in input order, or in completion order with --stream.
+func runBatch(ctx context.Context, c *cli.Command, cfg fetcher.Config, limits batchLimits, format string, jsonlOpts jsonlOptions, feed batchFeed) error {
+	cp, err := checkpointFromFlags(c)
+	if err != nil {
+		return err
+	}
+	w, err := outputFileFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	out, err := batchOutputFromFlags(c, w, format, jsonlOpts)
+	if err != nil {
+		return err
+	}
+	if bw, ok := out.(*batchWriter); ok && bw.ordered {
+		limits.window = batchReorderWindow
+	}
+	if cp != nil {
+		if feed, err = cp.track(out, feed); err != nil {
+			return err
+		}
+		defer cp.close()
+	}
+	pool, err := browserPoolFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	cfg.BrowserPool = pool
+
+	tasks := make(chan batchTask)
+	var feedErr error
+	go func() {
+		defer close(tasks)
+		feedErr = feed(cfg, tasks)
+	}()
+	fetchTasks(ctx, tasks, limits, fetcher.Fetch, out.write)
+
+	if err := out.finish(); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	if err := w.Close(); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	if cp != nil {
+		if err := cp.close(); err != nil {
+			return &exitStatusError{code: 1, msg: fmt.Sprintf("resume manifest write failed: %v", err)}
+		}
+	}
+	if feedErr != nil {
+		return &exitStatusError{code: 1, msg: feedErr.Error()}
+	}
+	if out.failures() > 0 {
+		return &exitStatusError{code: 1}
+	}
+	return nil
+}
+
+// batchOutputFromFlags returns where a multi-URL run writes its results: files
+// in --output-dir, or w in input order, or completion order with --stream.
+func batchOutputFromFlags(c *cli.Command, w io.Writer, format string, jsonlOpts jsonlOptions) (resultWriter, error) {
+	dir, err := outputDirFromFlags(c, format)
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		return dir, nil
+	}
+	if c.Bool("stream") {
+		out := newBatchWriter(w, format, jsonlOpts)
+		out.jsonlSummary = true
+		return out, nil
+	}
+	return newOrderedBatchWriter(w, format, jsonlOpts), nil
+}
+
+// outputFileFromFlags opens the --output file, or returns stdout when it is
+// not set. The file is truncated, or appended to with --resume. Close may be
+// called more than once.
+func outputFileFromFlags(c *cli.Command) (io.WriteCloser, error) {
+	name := strings.TrimSpace(c.String("output"))
+	if name == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	if c.String("output-dir") != "" {
+		return nil, &exitStatusError{code: 2, msg: "invalid flags: --output and --output-dir are mutually exclusive"}
+	}
+	var (
+		f   *os.File
+		err error
+	)
+	if c.String("resume") != "" {
+		f, err = openAppend(name)
+	} else {
+		f, err = os.Create(name)
+	}
+	if err != nil {
+		return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid output: %v", err)}
+	}
+	return &onceCloser{File: f}, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// onceCloser is a file whose Close can be deferred and also checked.
+type onceCloser struct {
+	*os.File
+	once sync.Once
+	err  error
+}
+
+func (f *onceCloser) Close() error {
+	f.once.Do(func() { f.err = f.File.Close() })
+	return f.err
+}
+
+func fetchConfigFromFlags(c *cli.Command) (fetcher.Config, error) {
+	cfg, _, err := fetchSettingsFromFlags(c)
+	if err != nil {
+		return fetcher.Config{}, err
+	}
+	if dir := c.String("cache-dir"); dir != "" && !c.Bool("no-cache") {
+		ttl := c.Duration("cache-ttl")
+		if ttl < 0 {
+			return fetcher.Config{}, &exitStatusError{code: 2, msg: "invalid cache-ttl: must be >= 0"}
+		}
+		cache, err := fetcher.OpenCache(dir, ttl)
+		if err != nil {
+			return fetcher.Config{}, &exitStatusError{code: 1, msg: fmt.Sprintf("open cache: %v", err)}
+		}
+		cfg.Cache = cache
+	}
+	if path := c.String("cookies"); path != "" {
+		jar, err := fetcher.LoadCookieJar(path)
+		if err != nil {
+			return fetcher.Config{}, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid cookies: %v", err)}
+		}
+		cfg.CookieJar = jar
+	} else if c.String("save-cookies") != "" {
+		cfg.CookieJar = fetcher.NewCookieJar()
+	}
+	netrc := c.String("netrc-file")
+	if netrc == "" && c.Bool("netrc") {
+		netrc = fetcher.DefaultNetrcPath()
+	}
+	if helper := c.String("credential-helper"); netrc != "" || helper != "" {
+		creds, err := fetcher.NewCredentials(netrc, helper)
+		if err != nil {
+			return fetcher.Config{}, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid credentials: %v", err)}
+		}
+		creds.SetHelperHosts(c.StringSlice("helper-host")...)
+		errOut := c.Root().ErrWriter
+		if errOut == nil {
+			errOut = os.Stderr
+		}
+		var warnMu sync.Mutex
+		creds.SetWarn(func(err error) {
+			warnMu.Lock()
+			defer warnMu.Unlock()
+			fmt.Fprintf(errOut, "agent-fetch: warning: %v; using .netrc or no credentials\n", err)
+		})
+		cfg.Credentials = creds
+	}
+	return cfg, nil
+}
+
+// saveCookies writes jar to --save-cookies, if set, when a command ends.
+// A failure to save replaces a nil *err.
+func saveCookies(c *cli.Command, jar *fetcher.CookieJar, err *error) {
+	path := c.String("save-cookies")
+	if path == "" || jar == nil {
+		return
+	}
+	if saveErr := jar.Save(path); saveErr != nil && *err == nil {
+		*err = &exitStatusError{code: 1, msg: fmt.Sprintf("save cookies: %v", saveErr)}
+	}
+}
+
+// fetchSettingsFromFlags applies the config file to the flags of c, then
+// builds the fetcher.Config they describe, without opening the cache.
+func fetchSettingsFromFlags(c *cli.Command) (fetcher.Config, *appliedConfig, error) {
+	applied, err := applyConfigFile(c)
+	if err != nil {
+		return fetcher.Config{}, nil, err
+	}
 	cfg := fetcher.DefaultConfig()
 	cfg.Mode = c.String("mode")
 	cfg.IncludeMeta = c.Bool("meta")
+	if cfg.MetaFields, err = fetcher.ParseMetaFields(c.String("meta-fields")); err != nil {
+		return fetcher.Config{}, nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid meta-fields: %v", err)}
+	}
 	cfg.Timeout = c.Duration("timeout")
 	cfg.BrowserTimeout = c.Duration("browser-timeout")
 	cfg.BrowserPath = c.String("browser-path")
@@ -171,78 +552,150 @@ func runWebFetch(ctx context.Context, c *cli.Command) error {
 	cfg.WaitSelector = c.String("wait-selector")
 	cfg.UserAgent = c.String("user-agent")
 	cfg.MaxBodyBytes = c.Int64("max-body-bytes")
+	if cfg.Proxy = strings.TrimSpace(c.String("proxy")); cfg.Proxy != "" {
+		if _, err := fetcher.ParseProxy(cfg.Proxy); err != nil {
+			return fetcher.Config{}, nil, &exitStatusError{code: 2, msg: err.Error()}
+		}
+	}
+	cfg.NoProxy = c.StringSlice("no-proxy")
+	cfg.Select = c.StringSlice("select")
+	cfg.Exclude = c.StringSlice("exclude-selector")
+	for _, sel := range append(append([]string(nil), cfg.Select...), cfg.Exclude...) {
+		if err := fetcher.CheckSelector(sel); err != nil {
+			return fetcher.Config{}, nil, &exitStatusError{code: 2, msg: err.Error()}
+		}
+	}
 
 	parsedHeaders, err := parseHeaders(c.StringSlice("header"))
 	if err != nil {
-		return &exitStatusError{code: 2, msg: fmt.Sprintf("invalid header: %v", err)}
+		return fetcher.Config{}, nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid header: %v", err)}
 	}
-	cfg.Headers = parsedHeaders
+	cfg.Headers = applied.mergeHeaders(parsedHeaders)
+	cfg.HostRules = applied.hostRules(parsedHeaders)
+	siteRules, rulesPath, err := siteRulesFromFlags(c)
+	if err != nil {
+		return fetcher.Config{}, nil, err
+	}
+	applied.rules = rulesPath
+	cfg.SiteRules = overrideSiteRules(siteRules, func(name string) bool { return applied.explicit[name] }, parsedHeaders)
+
+	if cfg.Retry, err = retryPolicyFromFlags(c); err != nil {
+		return fetcher.Config{}, nil, err
+	}
+	return cfg, applied, nil
+}
+
+func outputOptionsFromFlags(c *cli.Command, cfg fetcher.Config) (string, jsonlOptions, error) {
 	format := strings.ToLower(strings.TrimSpace(c.String("format")))
 	switch format {
 	case formatMarkdown, formatJSONL:
 	default:
-		return &exitStatusError{code: 2, msg: "invalid format: must be markdown or jsonl"}
+		return "", jsonlOptions{}, &exitStatusError{code: 2, msg: "invalid format: must be markdown or jsonl"}
 	}
 
-	urls := c.Args().Slice()
-	concurrency := c.Int("concurrency")
-	if concurrency < 1 {
-		return &exitStatusError{code: 2, msg: "invalid concurrency: must be >= 1"}
+	chunkTokens := c.Int("chunk-tokens")
+	if chunkTokens < 0 {
+		return "", jsonlOptions{}, &exitStatusError{code: 2, msg: "invalid chunk-tokens: must be >= 0"}
+	}
+	if chunkTokens > 0 && format != formatJSONL {
+		return "", jsonlOptions{}, &exitStatusError{code: 2, msg: "invalid chunk-tokens: requires --format jsonl"}
 	}
 
-	if len(urls) == 1 {
-		reqCtx, cancel := context.WithTimeout(ctx, maxDuration(cfg.Timeout, cfg.BrowserTimeout)+5*time.Second)
-		defer cancel()
-
-		res, err := fetcher.Fetch(reqCtx, urls[0], cfg)
-		if err != nil {
-			if format == formatJSONL {
-				results := []taskResult{{
-					index:    1,
-					inputURL: urls[0],
-					err:      err,
-				}}
-				if writeErr := writeBatchJSONL(os.Stdout, results, cfg.IncludeMeta); writeErr != nil {
-					return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", writeErr)}
-				}
-				return &exitStatusError{code: 1}
-			}
-			return &exitStatusError{code: 1, msg: fmt.Sprintf("fetch failed: %v", err)}
-		}
+	return format, jsonlOptions{
+		includeMeta:        cfg.IncludeMeta,
+		includeDiagnostics: c.Bool("diagnostics"),
+		chunkTokens:        chunkTokens,
+	}, nil
+}
 
-		if format == formatJSONL {
-			results := []taskResult{{
-				index:    1,
-				inputURL: urls[0],
-				finalURL: res.FinalURL,
-				source:   res.Source,
-				markdown: res.Markdown,
-			}}
-			if err := writeBatchJSONL(os.Stdout, results, cfg.IncludeMeta); err != nil {
-				return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
-			}
-			return nil
+func retryPolicyFromFlags(c *cli.Command) (fetcher.RetryPolicy, error) {
+	policy := fetcher.RetryPolicy{
+		MaxAttempts: c.Int("retry-attempts"),
+		BaseDelay:   c.Duration("retry-backoff"),
+		MaxDelay:    c.Duration("retry-max-backoff"),
+		Jitter:      c.Float64("retry-jitter"),
+	}
+	switch {
+	case policy.MaxAttempts < 1:
+		return fetcher.RetryPolicy{}, &exitStatusError{code: 2, msg: "invalid retry-attempts: must be >= 1"}
+	case policy.BaseDelay < 0 || policy.MaxDelay < 0:
+		return fetcher.RetryPolicy{}, &exitStatusError{code: 2, msg: "invalid retry backoff: must be >= 0"}
+	case policy.Jitter < 0 || policy.Jitter > 1:
+		return fetcher.RetryPolicy{}, &exitStatusError{code: 2, msg: "invalid retry-jitter: must be between 0 and 1"}
+	}
+	for _, field := range strings.Split(c.String("retry-statuses"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
 		}
-		if _, err := os.Stdout.WriteString(res.Markdown); err != nil {
-			return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return fetcher.RetryPolicy{}, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid retry-statuses: %q is not an HTTP status code", field)}
 		}
-		return nil
+		policy.Statuses = append(policy.Statuses, code)
 	}
+	return policy, nil
+}
 
-	results := fetchBatch(ctx, urls, cfg, concurrency, fetcher.Fetch)
-	var writeErr error
-	if format == formatJSONL {
-		writeErr = writeBatchJSONL(os.Stdout, results, cfg.IncludeMeta)
-	} else {
-		writeErr = writeBatchMarkdown(os.Stdout, results)
+func joinStatuses(codes []int) string {
+	parts := make([]string, len(codes))
+	for i, code := range codes {
+		parts[i] = strconv.Itoa(code)
 	}
-	if writeErr != nil {
-		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", writeErr)}
+	return strings.Join(parts, ",")
+}
+
+// fetchTimeout bounds one fetch: every attempt the retry policy allows at
+// each stage the mode runs, their backoff waits, and some slack. Auto mode can
+// run the HTTP stage and then the browser stage, so it gets both.
+func fetchTimeout(cfg fetcher.Config) time.Duration {
+	var budget time.Duration
+	switch cfg.Mode {
+	case fetcher.ModeBrowser:
+		budget = cfg.Retry.Budget(cfg.BrowserTimeout)
+	case fetcher.ModeStatic, fetcher.ModeRaw:
+		budget = cfg.Retry.Budget(cfg.Timeout)
+	default:
+		budget = cfg.Retry.Budget(cfg.Timeout) + cfg.Retry.Budget(cfg.BrowserTimeout)
 	}
-	if failed := failedCount(results); failed > 0 {
-		return &exitStatusError{code: 1}
+	return budget + 5*time.Second
+}
+
+// robotsPolicy decides when fetches consult robots.txt: always for multi-URL
+// runs, and for single URLs only when --respect-robots is given.
+type robotsPolicy struct {
+	robots *fetcher.Robots
+	single bool
+}
+
+func robotsPolicyFromFlags(c *cli.Command) (robotsPolicy, error) {
+	if c.Bool("respect-robots") && c.Bool("ignore-robots") {
+		return robotsPolicy{}, &exitStatusError{code: 2, msg: "invalid flags: --respect-robots and --ignore-robots are mutually exclusive"}
 	}
-	return nil
+	if c.Bool("ignore-robots") {
+		return robotsPolicy{}, nil
+	}
+	return robotsPolicy{robots: fetcher.NewRobots(), single: c.Bool("respect-robots")}, nil
+}
+
+func (p robotsPolicy) apply(cfg *fetcher.Config, multi bool) {
+	if p.robots != nil && (multi || p.single) {
+		cfg.Robots = p.robots
+	}
+}
+
+// browserPoolFromFlags builds the shared browser pool for commands that render many pages.
+// Browsers start lazily, so the pool costs nothing when no page needs a browser.
+func browserPoolFromFlags(c *cli.Command) (*fetcher.BrowserPool, error) {
+	instances := c.Int("browser-instances")
+	if instances < 1 {
+		return nil, &exitStatusError{code: 2, msg: "invalid browser-instances: must be >= 1"}
+	}
+	tabs := c.Int("tabs-per-browser")
+	if tabs < 1 {
+		return nil, &exitStatusError{code: 2, msg: "invalid tabs-per-browser: must be >= 1"}
+	}
+	return fetcher.NewBrowserPool(instances, tabs), nil
 }
 
 func routeToDefaultWeb(args []string, root *cli.Command) []string {
@@ -300,10 +753,3 @@ func parseHeaders(raw []string) (http.Header, error) {
 	}
 	return h, nil
 }
-
-func maxDuration(a, b time.Duration) time.Duration {
-	if a >= b {
-		return a
-	}
-	return b
-}
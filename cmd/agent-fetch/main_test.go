@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/firede/agent-fetch/internal/fetcher"
 	"github.com/urfave/cli/v3"
@@ -129,3 +135,147 @@ func TestHelpOutputContracts(t *testing.T) {
 		}
 	})
 }
+
+func TestCookieFlags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "visit", Value: "2", Path: "/"})
+		w.Header().Set("Content-Type", "text/plain")
+		if c, err := r.Cookie("sid"); err == nil {
+			_, _ = w.Write([]byte("sid=" + c.Value))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cookies := filepath.Join(dir, "cookies.txt")
+	if err := os.WriteFile(cookies, []byte("127.0.0.1\tFALSE\t/\tFALSE\t0\tsid\tabc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.md")
+	saved := filepath.Join(dir, "saved.json")
+	var out strings.Builder
+	err := runForTest([]string{"agent-fetch", "--mode", "raw", "--cookies", cookies, "--save-cookies", saved, "--output", output, srv.URL}, &out, &out)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "sid=abc" {
+		t.Fatalf("server saw %q", data)
+	}
+	data, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"name": "sid"`) || !strings.Contains(string(data), `"name": "visit"`) {
+		t.Fatalf("saved cookies:\n%s", data)
+	}
+
+	if err := os.WriteFile(cookies, []byte("not a cookie line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = runForTest([]string{"agent-fetch", "--cookies", cookies, srv.URL}, &out, &out)
+	var exitErr *exitStatusError
+	if !errors.As(err, &exitErr) || exitErr.code != 2 || !strings.Contains(exitErr.msg, "invalid cookies") {
+		t.Fatalf("got %v, want exit 2 for a malformed cookies file", err)
+	}
+}
+
+func TestNetrcFlags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	if err := os.WriteFile(netrc, []byte("machine 127.0.0.1 login user password secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.md")
+	var out strings.Builder
+	if err := runForTest([]string{"agent-fetch", "--mode", "raw", "--netrc-file", netrc, "--output", output, srv.URL}, &out, &out); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("server saw Authorization %q", data)
+	}
+
+	// A failing helper leaves the host to .netrc with a warning.
+	var stderr strings.Builder
+	if err := runForTest([]string{"agent-fetch", "--mode", "raw", "--netrc-file", netrc, "--credential-helper", "exit 3;", "--output", output, srv.URL}, &out, &stderr); err != nil {
+		t.Fatalf("fetch with failing helper: %v", err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("server saw Authorization %q with a failing helper", data)
+	}
+	if !strings.Contains(stderr.String(), "warning: credential helper failed") {
+		t.Fatalf("stderr %q, want a helper warning", stderr.String())
+	}
+
+	t.Setenv("NETRC", filepath.Join(dir, "missing"))
+	err := runForTest([]string{"agent-fetch", "--netrc", srv.URL}, &out, &out)
+	var exitErr *exitStatusError
+	if !errors.As(err, &exitErr) || exitErr.code != 2 || !strings.Contains(exitErr.msg, "invalid credentials: netrc: ") {
+		t.Fatalf("got %v, want exit 2 for a missing netrc file", err)
+	}
+}
+
+func TestSelectorFlags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><body><aside>Sidebar</aside><main><div class="content"><h1>API</h1><pre><code>client.Get()</code></pre><p>Returns a page.</p><div class="cookie-banner">Accept</div></div></main></body></html>`))
+	}))
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "out.md")
+	var out strings.Builder
+	err := runForTest([]string{"agent-fetch", "--mode", "static", "--meta=false", "--select", "main .content", "--exclude-selector", "nav, .cookie-banner", "--output", output, srv.URL}, &out, &out)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "# API\n\n```\nclient.Get()\n```\n\nReturns a page.\n" {
+		t.Fatalf("markdown %q", got)
+	}
+
+	// --exclude is a URL regex in crawl and sitemap, so the CSS option has
+	// no such alias anywhere.
+	out.Reset()
+	if err := runForTest([]string{"agent-fetch", "-h"}, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "--exclude-selector string") || strings.Contains(out.String(), "--exclude string") {
+		t.Fatalf("help:\n%s", out.String())
+	}
+
+	err = runForTest([]string{"agent-fetch", "--select", "div[", srv.URL}, &out, &out)
+	var exitErr *exitStatusError
+	if !errors.As(err, &exitErr) || exitErr.code != 2 || !strings.Contains(exitErr.msg, `invalid selector "div["`) {
+		t.Fatalf("got %v, want exit 2 for an invalid selector", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	cfg := fetcher.Config{
+		Timeout:        10 * time.Second,
+		BrowserTimeout: 20 * time.Second,
+	}
+	tests := []struct {
+		mode string
+		want time.Duration
+	}{
+		{fetcher.ModeAuto, 35 * time.Second},
+		{fetcher.ModeStatic, 15 * time.Second},
+		{fetcher.ModeRaw, 15 * time.Second},
+		{fetcher.ModeBrowser, 25 * time.Second},
+	}
+	for _, tt := range tests {
+		cfg.Mode = tt.mode
+		if got := fetchTimeout(cfg); got != tt.want {
+			t.Errorf("%s: fetchTimeout = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+// MCP over stdio: newline-delimited JSON-RPC 2.0 messages.
+// See https://modelcontextprotocol.io/specification for the protocol.
+
+const (
+	mcpLatestProtocolVersion = "2025-06-18"
+	mcpMaxMessageBytes       = 16 << 20
+)
+
+var mcpSupportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent any          `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError,omitempty"`
+}
+
+type mcpServer struct {
+	cfg    fetcher.Config
+	limits batchLimits
+	fetch  fetchFunc
+	robots robotsPolicy
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu       sync.Mutex
+	inflight map[string]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func newMCPServer(cfg fetcher.Config, limits batchLimits, fetch fetchFunc, robots robotsPolicy) *mcpServer {
+	return &mcpServer{
+		cfg:      cfg,
+		limits:   limits.shared(),
+		fetch:    fetch,
+		robots:   robots,
+		inflight: make(map[string]context.CancelFunc),
+	}
+}
+
+// serve processes requests from in until EOF or ctx is done. Tool calls run
+// concurrently; every other request is answered in order.
+func (s *mcpServer) serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	s.enc = json.NewEncoder(out)
+	s.enc.SetEscapeHTML(false)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer s.wg.Wait()
+
+	r := bufio.NewReaderSize(in, 64<<10)
+	for {
+		line, err := readMessageLine(r)
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.handleMessage(ctx, line)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func readMessageLine(r *bufio.Reader) ([]byte, error) {
+	var buf []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		buf = append(buf, chunk...)
+		if len(buf) > mcpMaxMessageBytes {
+			return nil, fmt.Errorf("message exceeds %d bytes", mcpMaxMessageBytes)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		return buf, err
+	}
+}
+
+func (s *mcpServer) handleMessage(ctx context.Context, line []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.reply(json.RawMessage("null"), nil, &rpcError{Code: rpcParseError, Message: "parse error: " + err.Error()})
+		return
+	}
+	isNotification := len(req.ID) == 0
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if !isNotification {
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: "invalid request"})
+		}
+		return
+	}
+
+	if isNotification {
+		s.handleNotification(req)
+		return
+	}
+
+	switch req.Method {
+	case "initialize":
+		s.reply(req.ID, s.initialize(req.Params), nil)
+	case "ping":
+		s.reply(req.ID, struct{}{}, nil)
+	case "tools/list":
+		s.reply(req.ID, map[string]any{"tools": mcpTools()}, nil)
+	case "tools/call":
+		callCtx, cancel := context.WithCancel(ctx)
+		key := string(req.ID)
+		s.mu.Lock()
+		s.inflight[key] = cancel
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.inflight, key)
+				s.mu.Unlock()
+				cancel()
+			}()
+			result, err := s.callTool(callCtx, req.Params)
+			if err != nil {
+				var rpcErr *rpcError
+				if !errors.As(err, &rpcErr) {
+					rpcErr = &rpcError{Code: rpcInternalError, Message: err.Error()}
+				}
+				s.reply(req.ID, nil, rpcErr)
+				return
+			}
+			s.reply(req.ID, result, nil)
+		}()
+	default:
+		s.reply(req.ID, nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method})
+	}
+}
+
+func (s *mcpServer) handleNotification(req rpcRequest) {
+	if req.Method != "notifications/cancelled" {
+		return
+	}
+	var params struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return
+	}
+	s.mu.Lock()
+	cancel := s.inflight[string(params.RequestID)]
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *mcpServer) reply(id json.RawMessage, result any, rpcErr *rpcError) {
+	resp := rpcResponse{JSONRPC: "2.0", ID: id, Result: result, Error: rpcErr}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	// A broken stdout is unrecoverable and surfaces as EOF on stdin soon after.
+	_ = s.enc.Encode(resp)
+}
+
+func (s *mcpServer) initialize(params json.RawMessage) any {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	_ = json.Unmarshal(params, &p)
+
+	version := mcpLatestProtocolVersion
+	if slices.Contains(mcpSupportedProtocolVersions, p.ProtocolVersion) {
+		version = p.ProtocolVersion
+	}
+	v, _, _ := resolvedVersionInfo()
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities": map[string]any{
+			"tools": map[string]any{},
+		},
+		"serverInfo": map[string]any{
+			"name":    "agent-fetch",
+			"version": v,
+		},
+	}
+}
+
+func (s *mcpServer) callTool(ctx context.Context, params json.RawMessage) (mcpToolResult, error) {
+	var p struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+
+	var args fetchRequest
+	if len(p.Arguments) > 0 {
+		if err := json.Unmarshal(p.Arguments, &args); err != nil {
+			return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid arguments: " + err.Error()}
+		}
+	}
+
+	var urls []string
+	switch p.Name {
+	case "fetch":
+		if strings.TrimSpace(args.URL) == "" {
+			return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid arguments: url is required"}
+		}
+		urls = []string{args.URL}
+	case "fetch_batch":
+		if len(args.URLs) == 0 {
+			return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid arguments: urls must not be empty"}
+		}
+		urls = args.URLs
+	default:
+		return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "unknown tool: " + p.Name}
+	}
+
+	cfg, err := args.config(s.cfg)
+	if err != nil {
+		return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid arguments: " + err.Error()}
+	}
+	format, err := args.format()
+	if err != nil {
+		return mcpToolResult{}, &rpcError{Code: rpcInvalidParams, Message: "invalid arguments: " + err.Error()}
+	}
+	opts := args.jsonlOptions(cfg)
+	s.robots.apply(&cfg, len(urls) > 1)
+
+	limits := s.limits
+	limits.concurrency = args.concurrencyOr(limits.concurrency)
+	results := fetchBatch(ctx, urls, cfg, limits, s.fetch)
+	if p.Name == "fetch" {
+		return singleToolResult(results[0], format, opts)
+	}
+	return batchToolResult(results, format, opts)
+}
+
+func singleToolResult(result taskResult, format string, opts jsonlOptions) (mcpToolResult, error) {
+	payload := newJSONLPayload(result, opts)
+	out := mcpToolResult{StructuredContent: payload, IsError: result.err != nil}
+
+	if format == formatJSONL {
+		var b strings.Builder
+		if err := writeBatchJSONL(&b, []taskResult{result}, opts); err != nil {
+			return mcpToolResult{}, err
+		}
+		out.Content = []mcpContent{{Type: "text", Text: b.String()}}
+		return out, nil
+	}
+
+	text := result.markdown
+	if result.err != nil {
+		text = fmt.Sprintf("fetch failed: %v", result.err)
+	}
+	out.Content = []mcpContent{{Type: "text", Text: text}}
+	return out, nil
+}
+
+func batchToolResult(results []taskResult, format string, opts jsonlOptions) (mcpToolResult, error) {
+	payloads := make([]any, 0, len(results))
+	for _, result := range results {
+		payloads = append(payloads, newJSONLPayload(result, opts))
+	}
+
+	var b strings.Builder
+	var err error
+	if format == formatJSONL {
+		err = writeBatchJSONL(&b, results, opts)
+	} else {
+		err = writeBatchMarkdown(&b, results)
+	}
+	if err != nil {
+		return mcpToolResult{}, err
+	}
+
+	return mcpToolResult{
+		Content:           []mcpContent{{Type: "text", Text: b.String()}},
+		StructuredContent: map[string]any{"results": payloads},
+		IsError:           failedCount(results) == len(results),
+	}, nil
+}
+
+func mcpTools() []mcpTool {
+	common := map[string]any{
+		"mode": map[string]any{
+			"type":        "string",
+			"enum":        []string{fetcher.ModeAuto, fetcher.ModeStatic, fetcher.ModeBrowser, fetcher.ModeRaw},
+			"description": "fetch mode; auto falls back from native Markdown to static extraction to browser rendering",
+		},
+		"format": map[string]any{
+			"type":        "string",
+			"enum":        []string{formatMarkdown, formatJSONL},
+			"description": "text output format (default markdown)",
+		},
+		"headers": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "extra request headers as 'Key: Value' strings",
+		},
+		"wait_selector": map[string]any{
+			"type":        "string",
+			"description": "CSS selector to wait for before capturing (browser rendering)",
+		},
+		"select": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "CSS selectors of the elements to extract instead of the readability article",
+		},
+		"exclude": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"description": "CSS selectors of elements to remove before extraction",
+		},
+		"meta": map[string]any{
+			"type":        "boolean",
+			"description": "include title/description metadata (default true)",
+		},
+		"meta_fields": map[string]any{
+			"type":        "string",
+			"description": "comma-separated metadata fields: title, description, author, published, modified, site_name, lang, canonical, or all (default title,description)",
+		},
+		"diagnostics": map[string]any{
+			"type":        "boolean",
+			"description": "include HTTP status, headers, timings and pipeline trace in structured output",
+		},
+	}
+
+	fetchProps := map[string]any{
+		"url": map[string]any{"type": "string", "description": "URL to fetch"},
+	}
+	batchProps := map[string]any{
+		"urls": map[string]any{
+			"type":        "array",
+			"items":       map[string]any{"type": "string"},
+			"minItems":    1,
+			"description": "URLs to fetch; results keep input order",
+		},
+		"concurrency": map[string]any{
+			"type":        "integer",
+			"minimum":     1,
+			"description": "max concurrent fetches, up to the server's --concurrency",
+		},
+	}
+	for k, v := range common {
+		fetchProps[k] = v
+		batchProps[k] = v
+	}
+
+	return []mcpTool{
+		{
+			Name:        "fetch",
+			Description: "Fetch a web page and return its main content as clean Markdown.",
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": fetchProps,
+				"required":   []string{"url"},
+			},
+		},
+		{
+			Name: "fetch_batch",
+			Description: "Fetch several web pages concurrently and return Markdown with per-task markers " +
+				"(or one JSONL row per URL). Reported as an error only when every URL fails.",
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": batchProps,
+				"required":   []string{"urls"},
+			},
+		},
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+type mcpTestClient struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Scanner
+	nextID int
+	done   chan error
+}
+
+func startMCPTestServer(t *testing.T, cfg fetcher.Config) *mcpTestClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	srv := newMCPServer(cfg, batchLimits{concurrency: 2}, fetcher.Fetch, robotsPolicy{})
+	done := make(chan error, 1)
+	go func() {
+		err := srv.serve(context.Background(), inR, outW)
+		outW.Close()
+		done <- err
+	}()
+
+	scanner := bufio.NewScanner(outR)
+	scanner.Buffer(make([]byte, 0, 64<<10), 4<<20)
+	c := &mcpTestClient{t: t, in: inW, out: scanner, done: done}
+	t.Cleanup(c.close)
+	return c
+}
+
+func (c *mcpTestClient) close() {
+	c.in.Close()
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Errorf("serve returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Error("server did not stop after stdin closed")
+	}
+}
+
+func (c *mcpTestClient) send(raw string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.in, raw+"\n"); err != nil {
+		c.t.Fatalf("write request: %v", err)
+	}
+}
+
+func (c *mcpTestClient) call(method string, params any) rpcTestResponse {
+	c.t.Helper()
+	c.nextID++
+	msg := map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatalf("marshal request: %v", err)
+	}
+	c.send(string(raw))
+	return c.read()
+}
+
+func (c *mcpTestClient) read() rpcTestResponse {
+	c.t.Helper()
+	if !c.out.Scan() {
+		c.t.Fatalf("no response: %v", c.out.Err())
+	}
+	var resp rpcTestResponse
+	if err := json.Unmarshal(c.out.Bytes(), &resp); err != nil {
+		c.t.Fatalf("unmarshal response %q: %v", c.out.Text(), err)
+	}
+	return resp
+}
+
+type rpcTestResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func newMCPTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			fmt.Fprintf(w, "---\ntitle: 'Page %s'\n---\n\n# Page %s\n\nBody from %s.\n", r.URL.Path, r.URL.Path, r.Header.Get("X-Test"))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestMCPServerInitializeAndListTools(t *testing.T) {
+	c := startMCPTestServer(t, fetcher.DefaultConfig())
+
+	resp := c.call("initialize", map[string]any{
+		"protocolVersion": "2025-03-26",
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "test", "version": "1"},
+	})
+	if resp.Error != nil {
+		t.Fatalf("initialize failed: %v", resp.Error)
+	}
+	var init struct {
+		ProtocolVersion string         `json:"protocolVersion"`
+		Capabilities    map[string]any `json:"capabilities"`
+		ServerInfo      struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	if err := json.Unmarshal(resp.Result, &init); err != nil {
+		t.Fatalf("unmarshal initialize: %v", err)
+	}
+	if init.ProtocolVersion != "2025-03-26" {
+		t.Fatalf("expected negotiated protocol version, got %q", init.ProtocolVersion)
+	}
+	if _, ok := init.Capabilities["tools"]; !ok || init.ServerInfo.Name != "agent-fetch" {
+		t.Fatalf("unexpected initialize result: %s", resp.Result)
+	}
+
+	c.send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+
+	resp = c.call("tools/list", nil)
+	var list struct {
+		Tools []mcpTool `json:"tools"`
+	}
+	if err := json.Unmarshal(resp.Result, &list); err != nil {
+		t.Fatalf("unmarshal tools/list: %v", err)
+	}
+	if len(list.Tools) != 2 || list.Tools[0].Name != "fetch" || list.Tools[1].Name != "fetch_batch" {
+		t.Fatalf("unexpected tools: %+v", list.Tools)
+	}
+
+	resp = c.call("nope", nil)
+	if resp.Error == nil || resp.Error.Code != rpcMethodNotFound {
+		t.Fatalf("expected method not found, got %+v", resp)
+	}
+}
+
+func TestMCPServerFetchTool(t *testing.T) {
+	ts := newMCPTestSite(t)
+	cfg := fetcher.DefaultConfig()
+	cfg.Mode = fetcher.ModeStatic
+	cfg.Timeout = 5 * time.Second
+	c := startMCPTestServer(t, cfg)
+
+	resp := c.call("tools/call", map[string]any{
+		"name": "fetch",
+		"arguments": map[string]any{
+			"url":     ts.URL + "/one",
+			"headers": []string{"X-Test: mcp"},
+		},
+	})
+	if resp.Error != nil {
+		t.Fatalf("tools/call failed: %v", resp.Error)
+	}
+	var result struct {
+		Content           []mcpContent        `json:"content"`
+		StructuredContent jsonlSuccessPayload `json:"structuredContent"`
+		IsError           bool                `json:"isError"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result.IsError {
+		t.Fatalf("unexpected tool error: %s", resp.Result)
+	}
+	if len(result.Content) != 1 || !strings.Contains(result.Content[0].Text, "Body from mcp.") {
+		t.Fatalf("unexpected content: %+v", result.Content)
+	}
+	if !strings.HasPrefix(result.Content[0].Text, "---\n") {
+		t.Fatalf("expected markdown text to keep front matter, got %q", result.Content[0].Text)
+	}
+	sc := result.StructuredContent
+	if sc.Seq != 1 || sc.ResolvedMode != "markdown" || sc.Meta == nil || sc.Meta.Title != "Page /one" {
+		t.Fatalf("unexpected structured content: %+v", sc)
+	}
+	if strings.HasPrefix(sc.Content, "---") {
+		t.Fatalf("expected structured content without front matter, got %q", sc.Content)
+	}
+
+	resp = c.call("tools/call", map[string]any{
+		"name":      "fetch",
+		"arguments": map[string]any{"url": ts.URL + "/missing"},
+	})
+	var failed mcpToolResult
+	if err := json.Unmarshal(resp.Result, &failed); err != nil {
+		t.Fatalf("unmarshal failed result: %v", err)
+	}
+	if !failed.IsError || !strings.Contains(failed.Content[0].Text, "404") {
+		t.Fatalf("expected tool error for 404, got %s", resp.Result)
+	}
+
+	resp = c.call("tools/call", map[string]any{"name": "fetch", "arguments": map[string]any{}})
+	if resp.Error == nil || resp.Error.Code != rpcInvalidParams {
+		t.Fatalf("expected invalid params for missing url, got %+v", resp)
+	}
+}
+
+func TestMCPServerFetchBatchTool(t *testing.T) {
+	ts := newMCPTestSite(t)
+	cfg := fetcher.DefaultConfig()
+	cfg.Mode = fetcher.ModeStatic
+	cfg.Timeout = 5 * time.Second
+	c := startMCPTestServer(t, cfg)
+
+	resp := c.call("tools/call", map[string]any{
+		"name": "fetch_batch",
+		"arguments": map[string]any{
+			"urls":   []string{ts.URL + "/a", ts.URL + "/missing"},
+			"format": "jsonl",
+		},
+	})
+	if resp.Error != nil {
+		t.Fatalf("tools/call failed: %v", resp.Error)
+	}
+	var result struct {
+		Content           []mcpContent `json:"content"`
+		StructuredContent struct {
+			Results []map[string]any `json:"results"`
+		} `json:"structuredContent"`
+		IsError bool `json:"isError"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	if result.IsError {
+		t.Fatal("partial failure should not mark the batch as an error")
+	}
+	lines := strings.Split(strings.TrimSpace(result.Content[0].Text), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two jsonl rows, got %q", result.Content[0].Text)
+	}
+	if len(result.StructuredContent.Results) != 2 {
+		t.Fatalf("unexpected structured results: %+v", result.StructuredContent)
+	}
+	if result.StructuredContent.Results[0]["resolved_mode"] != "markdown" {
+		t.Fatalf("unexpected first result: %+v", result.StructuredContent.Results[0])
+	}
+	if _, ok := result.StructuredContent.Results[1]["error"]; !ok {
+		t.Fatalf("expected error row second, got %+v", result.StructuredContent.Results[1])
+	}
+}
+
+func TestMCPServerFetchBatchCapsConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	fetch := func(ctx context.Context, rawURL string, cfg fetcher.Config) (fetcher.Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return fetcher.Result{Markdown: rawURL, Source: "http-static"}, nil
+	}
+	srv := newMCPServer(fetcher.DefaultConfig(), batchLimits{concurrency: 2}, fetch, robotsPolicy{})
+
+	var urls []string
+	for i := range 8 {
+		urls = append(urls, fmt.Sprintf("https://example.com/%d", i))
+	}
+	params, _ := json.Marshal(map[string]any{
+		"name":      "fetch_batch",
+		"arguments": map[string]any{"urls": urls, "concurrency": 100},
+	})
+	if _, err := srv.callTool(context.Background(), params); err != nil {
+		t.Fatalf("callTool: %v", err)
+	}
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("%d fetches ran at once, want at most the server's 2", got)
+	}
+}
+
+func TestMCPServerCapsConcurrencyAcrossCalls(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	fetch := func(ctx context.Context, rawURL string, cfg fetcher.Config) (fetcher.Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return fetcher.Result{Markdown: rawURL, Source: "http-static"}, nil
+	}
+	srv := newMCPServer(fetcher.DefaultConfig(), batchLimits{concurrency: 2}, fetch, robotsPolicy{})
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		params, _ := json.Marshal(map[string]any{
+			"name":      "fetch_batch",
+			"arguments": map[string]any{"urls": []string{fmt.Sprintf("https://a.example/%d", i), fmt.Sprintf("https://b.example/%d", i)}},
+		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := srv.callTool(context.Background(), params); err != nil {
+				t.Errorf("callTool: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("%d fetches ran at once across calls, want at most the server's 2", got)
+	}
+}
+
+func TestMCPServerParseError(t *testing.T) {
+	c := startMCPTestServer(t, fetcher.DefaultConfig())
+	c.send("{not json")
+	resp := c.read()
+	if resp.Error == nil || resp.Error.Code != rpcParseError {
+		t.Fatalf("expected parse error, got %+v", resp)
+	}
+	if string(resp.ID) != "null" {
+		t.Fatalf("expected null id, got %s", resp.ID)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	collisionSuffix = "suffix"
+	collisionSkip   = "skip"
+
+	outputManifestName = "index.jsonl"
+	// outputMaxSegmentBytes bounds each directory or file name derived from
+	// a URL path segment.
+	outputMaxSegmentBytes = 100
+)
+
+// pageExtensions are the path extensions replaced by .md in output file names.
+var pageExtensions = map[string]bool{
+	".html": true, ".htm": true, ".xhtml": true, ".shtml": true,
+	".php": true, ".asp": true, ".aspx": true, ".jsp": true,
+	".md": true, ".markdown": true, ".txt": true,
+}
+
+func outputDirFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "output-dir", Usage: "write each page to DIR/<host>/<path>.md and list it in DIR/index.jsonl instead of printing it"},
+		&cli.StringFlag{Name: "on-collision", Value: collisionSuffix, Usage: "when a page's file name is already taken: suffix (name-2.md)|skip"},
+		&cli.BoolFlag{Name: "overwrite", Usage: "replace files left in --output-dir by earlier runs instead of treating them as taken"},
+	}
+}
+
+// resultWriter receives the results of a batch one at a time.
+type resultWriter interface {
+	write(taskResult)
+	// finish flushes the output and returns the first write error.
+	finish() error
+	failures() int
+}
+
+// outputDirFromFlags returns the --output-dir writer, or nil when the flag is
+// not set.
+func outputDirFromFlags(c *cli.Command, format string) (*dirWriter, error) {
+	dir := strings.TrimSpace(c.String("output-dir"))
+	if dir == "" {
+		return nil, nil
+	}
+	if format != formatMarkdown {
+		return nil, &exitStatusError{code: 2, msg: "invalid output-dir: pages are written as Markdown files, --format jsonl is not supported"}
+	}
+	collision := strings.ToLower(strings.TrimSpace(c.String("on-collision")))
+	if collision != collisionSuffix && collision != collisionSkip {
+		return nil, &exitStatusError{code: 2, msg: "invalid on-collision: must be suffix or skip"}
+	}
+	w, err := newDirWriter(dir, collision, c.Bool("overwrite"))
+	if err != nil {
+		return nil, &exitStatusError{code: 1, msg: fmt.Sprintf("invalid output-dir: %v", err)}
+	}
+	return w, nil
+}
+
+// dirWriter writes each successful page to its own Markdown file under dir
+// and appends a row per task, failed or not, to dir/index.jsonl.
+type dirWriter struct {
+	dir       string
+	collision string
+	overwrite bool
+	now       func() time.Time
+
+	manifest *os.File
+	enc      *json.Encoder
+	// taken holds the paths written by this run.
+	taken map[string]bool
+	// onWrite, if set, is called after each result has been written.
+	onWrite func(taskResult)
+
+	failed int
+	err    error
+}
+
+// outputManifestRow is an index.jsonl row. Path is relative to the output
+// directory and uses forward slashes.
+type outputManifestRow struct {
+	Seq          int    `json:"seq"`
+	URL          string `json:"url"`
+	ResolvedURL  string `json:"resolved_url,omitempty"`
+	ResolvedMode string `json:"resolved_mode,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Title        string `json:"title,omitempty"`
+	FetchedAt    string `json:"fetched_at"`
+	Skipped      string `json:"skipped,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func newDirWriter(dir, collision string, overwrite bool) (*dirWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	manifest, err := openAppend(filepath.Join(dir, outputManifestName))
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(manifest)
+	enc.SetEscapeHTML(false)
+	return &dirWriter{
+		dir:       dir,
+		collision: collision,
+		overwrite: overwrite,
+		now:       time.Now,
+		manifest:  manifest,
+		enc:       enc,
+		taken:     map[string]bool{},
+	}, nil
+}
+
+func (d *dirWriter) write(result taskResult) {
+	if result.skipped {
+		return
+	}
+	fetchedAt := d.now().UTC()
+	row := outputManifestRow{Seq: result.index, URL: result.inputURL, FetchedAt: fetchedAt.Format(time.RFC3339)}
+	if result.finalURL != "" && result.finalURL != result.inputURL {
+		row.ResolvedURL = result.finalURL
+	}
+
+	if result.err != nil {
+		d.failed++
+		row.Error = result.err.Error()
+		d.writeRow(row, result)
+		return
+	}
+	row.ResolvedMode = resolveMode(result.source)
+	if _, meta, ok := extractInjectableMeta(result.markdown); ok {
+		row.Title = meta.Title
+	}
+
+	pageURL := result.finalURL
+	if pageURL == "" {
+		pageURL = result.inputURL
+	}
+	rel, err := outputPath(pageURL)
+	if err != nil {
+		d.failed++
+		row.Error = err.Error()
+		d.writeRow(row, result)
+		return
+	}
+	rel, ok, err := d.claim(rel)
+	switch {
+	case err != nil:
+		d.failed++
+		row.Error = err.Error()
+	case !ok:
+		row.Skipped = "collision"
+	default:
+		content := withSourceFrontMatter(result.markdown, pageURL, fetchedAt)
+		if err := writeFileAtomic(filepath.Join(d.dir, filepath.FromSlash(rel)), []byte(content)); err != nil {
+			d.failed++
+			row.Error = fmt.Sprintf("write failed: %v", err)
+		} else {
+			row.Path = rel
+		}
+	}
+	d.writeRow(row, result)
+}
+
+func (d *dirWriter) writeRow(row outputManifestRow, result taskResult) {
+	if d.err != nil {
+		return
+	}
+	d.err = d.enc.Encode(row)
+	if d.err == nil && d.onWrite != nil {
+		d.onWrite(result)
+	}
+}
+
+// claim picks the file for a page whose natural path is rel: rel itself when
+// free, otherwise name-2.md, name-3.md, ... with the suffix policy. A path is
+// taken when this run wrote it or, without overwrite, when a file exists.
+func (d *dirWriter) claim(rel string) (string, bool, error) {
+	stem := strings.TrimSuffix(rel, ".md")
+	for n := 1; ; n++ {
+		candidate := rel
+		if n > 1 {
+			candidate = stem + "-" + strconv.Itoa(n) + ".md"
+		}
+		taken := d.taken[candidate]
+		if !taken && !d.overwrite {
+			_, err := os.Lstat(filepath.Join(d.dir, filepath.FromSlash(candidate)))
+			switch {
+			case err == nil:
+				taken = true
+			case !errors.Is(err, fs.ErrNotExist):
+				return "", false, err
+			}
+		}
+		if !taken {
+			d.taken[candidate] = true
+			return candidate, true, nil
+		}
+		if d.collision == collisionSkip {
+			return "", false, nil
+		}
+	}
+}
+
+func (d *dirWriter) finish() error {
+	if err := d.manifest.Close(); err != nil && d.err == nil {
+		d.err = err
+	}
+	return d.err
+}
+
+func (d *dirWriter) failures() int {
+	return d.failed
+}
+
+// outputPath maps a page URL to a relative, slash-separated file path:
+// <host>/<path segments>.md. Paths ending in "/" map to index.md, common page
+// extensions such as .html are replaced by .md, and a query string adds a
+// short hash so that query variants do not share a file.
+func outputPath(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("cannot derive a file name from %q", rawURL)
+	}
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" {
+		host += "_" + port
+	}
+	parts := []string{slugSegment(host)}
+
+	var segments []string
+	for seg := range strings.SplitSeq(u.Path, "/") {
+		if s := slugSegment(seg); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	name := "index"
+	if len(segments) > 0 && !strings.HasSuffix(u.Path, "/") {
+		name = segments[len(segments)-1]
+		segments = segments[:len(segments)-1]
+		if ext := strings.ToLower(path.Ext(name)); pageExtensions[ext] && len(name) > len(ext) {
+			name = name[:len(name)-len(ext)]
+		}
+	}
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+	parts = append(parts, segments...)
+	parts = append(parts, name+".md")
+	return path.Join(parts...), nil
+}
+
+// slugSegment keeps letters, digits, '.', '-' and '_' of a path segment and
+// turns every other run of characters into a single '-'. Leading and trailing
+// '.' and '-' are dropped so that no segment is hidden or refers upwards.
+func slugSegment(seg string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range seg {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	s := strings.Trim(b.String(), ".-")
+	if len(s) > outputMaxSegmentBytes {
+		cut := outputMaxSegmentBytes
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = strings.TrimRight(s[:cut], ".-")
+	}
+	return s
+}
+
+// withSourceFrontMatter adds source_url and fetched_at to the page's YAML
+// front matter, creating one when the page has none.
+func withSourceFrontMatter(md, sourceURL string, fetchedAt time.Time) string {
+	fields := "source_url: '" + strings.ReplaceAll(sourceURL, "'", "''") + "'\n" +
+		"fetched_at: " + fetchedAt.Format(time.RFC3339) + "\n"
+
+	body := strings.TrimPrefix(md, "\ufeff")
+	if rest, ok := strings.CutPrefix(body, "---\n"); ok {
+		for offset := 0; ; {
+			line, tail, more := nextLine(rest[offset:])
+			if !more {
+				break
+			}
+			if strings.TrimSpace(strings.TrimSuffix(line, "\r")) == "---" {
+				return "---\n" + rest[:offset] + fields + rest[offset:]
+			}
+			offset = len(rest) - len(tail)
+		}
+	}
+	return "---\n" + fields + "---\n\n" + md
+}
+
+// writeFileAtomic writes data to name through a temporary file in the same
+// directory, so readers never see a partial page.
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".agent-fetch-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
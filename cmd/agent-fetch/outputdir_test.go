@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://Example.com", "example.com/index.md"},
+		{"https://example.com/", "example.com/index.md"},
+		{"https://example.com/docs/", "example.com/docs/index.md"},
+		{"https://example.com/docs/intro", "example.com/docs/intro.md"},
+		{"https://example.com/docs/intro.html#setup", "example.com/docs/intro.md"},
+		{"https://example.com/files/report.pdf", "example.com/files/report.pdf.md"},
+		{"http://localhost:8080/a//b/../c", "localhost_8080/a/b/c.md"},
+		{"https://example.com/search?q=go", "example.com/search-" + queryHash("q=go") + ".md"},
+		{"https://example.com/%E6%96%87%E6%A1%A3/a%20b:c", "example.com/文档/a-b-c.md"},
+		{"https://example.com/.hidden/..", "example.com/hidden.md"},
+	}
+	for _, tt := range tests {
+		got, err := outputPath(tt.url)
+		if err != nil || got != tt.want {
+			t.Errorf("outputPath(%q) = %q, %v; want %q", tt.url, got, err, tt.want)
+		}
+	}
+	if _, err := outputPath("not a url"); err == nil {
+		t.Error("expected error for a URL without host")
+	}
+	long, _ := outputPath("https://example.com/" + strings.Repeat("é", 80))
+	if name := filepath.Base(long); len(name) > outputMaxSegmentBytes+len(".md") || !strings.HasSuffix(name, "é.md") {
+		t.Errorf("long segment not cut on a rune boundary: %q", name)
+	}
+}
+
+func queryHash(q string) string {
+	p, _ := outputPath("https://example.com/?" + q)
+	return strings.TrimSuffix(strings.TrimPrefix(p, "example.com/index-"), ".md")
+}
+
+func TestWithSourceFrontMatter(t *testing.T) {
+	at := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	got := withSourceFrontMatter("# Hi\n", "https://example.com/it's", at)
+	want := "---\nsource_url: 'https://example.com/it''s'\nfetched_at: 2025-03-04T05:06:07Z\n---\n\n# Hi\n"
+	if got != want {
+		t.Fatalf("without front matter:\n%s", got)
+	}
+
+	got = withSourceFrontMatter("---\ntitle: 'Hi'\n---\n\n# Hi\n", "https://example.com/", at)
+	want = "---\ntitle: 'Hi'\nsource_url: 'https://example.com/'\nfetched_at: 2025-03-04T05:06:07Z\n---\n\n# Hi\n"
+	if got != want {
+		t.Fatalf("with front matter:\n%s", got)
+	}
+}
+
+func readManifest(t *testing.T, dir string) []outputManifestRow {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, outputManifestName))
+	if err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	var rows []outputManifestRow
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var row outputManifestRow
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestDirWriter(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	run := func(collision string, overwrite bool, results ...taskResult) (*dirWriter, error) {
+		w, err := newDirWriter(dir, collision, overwrite)
+		if err != nil {
+			t.Fatalf("new dir writer: %v", err)
+		}
+		w.now = func() time.Time { return at }
+		for _, result := range results {
+			w.write(result)
+		}
+		return w, w.finish()
+	}
+
+	w, err := run(collisionSuffix, false,
+		taskResult{index: 1, inputURL: "https://example.com/a", finalURL: "https://example.com/docs/", source: "http-static", markdown: "---\ntitle: 'Docs'\n---\n\nfirst\n"},
+		taskResult{index: 2, inputURL: "https://example.com/docs/index.html", source: "http-static", markdown: "second\n"},
+		taskResult{index: 3, inputURL: "https://bad.example/", err: errors.New("timeout")},
+	)
+	if err != nil || w.failures() != 1 {
+		t.Fatalf("finish: %v, failures %d", err, w.failures())
+	}
+	first, _ := os.ReadFile(filepath.Join(dir, "example.com", "docs", "index.md"))
+	if !strings.Contains(string(first), "title: 'Docs'\nsource_url: 'https://example.com/docs/'\nfetched_at: 2025-03-04T05:06:07Z\n---\n\nfirst\n") {
+		t.Fatalf("unexpected first page:\n%s", first)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "docs", "index-2.md")); err != nil {
+		t.Fatalf("colliding page not suffixed: %v", err)
+	}
+
+	// A second run keeps existing files unless asked to overwrite them.
+	_, err = run(collisionSkip, false, taskResult{index: 1, inputURL: "https://example.com/docs/", source: "http-static", markdown: "third\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run(collisionSkip, true, taskResult{index: 1, inputURL: "https://example.com/docs/", source: "http-raw", markdown: "fourth\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := os.ReadFile(filepath.Join(dir, "example.com", "docs", "index.md"))
+	if !strings.HasSuffix(string(page), "\n---\n\nfourth\n") {
+		t.Fatalf("page not overwritten:\n%s", page)
+	}
+
+	rows := readManifest(t, dir)
+	want := []outputManifestRow{
+		{Seq: 1, URL: "https://example.com/a", ResolvedURL: "https://example.com/docs/", ResolvedMode: "static", Path: "example.com/docs/index.md", Title: "Docs"},
+		{Seq: 2, URL: "https://example.com/docs/index.html", ResolvedMode: "static", Path: "example.com/docs/index-2.md"},
+		{Seq: 3, URL: "https://bad.example/", Error: "timeout"},
+		{Seq: 1, URL: "https://example.com/docs/", ResolvedMode: "static", Skipped: "collision"},
+		{Seq: 1, URL: "https://example.com/docs/", ResolvedMode: "raw", Path: "example.com/docs/index.md"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d manifest rows, want %d", len(rows), len(want))
+	}
+	for i := range want {
+		want[i].FetchedAt = "2025-03-04T05:06:07Z"
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i+1, rows[i], want[i])
+		}
+	}
+}
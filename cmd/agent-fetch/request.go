@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+// fetchRequest is the JSON form of the fetch flags accepted by the mcp and serve commands.
+// Zero values keep the defaults configured on the command line.
+type fetchRequest struct {
+	URL          string   `json:"url"`
+	URLs         []string `json:"urls"`
+	Mode         string   `json:"mode"`
+	Format       string   `json:"format"`
+	Headers      []string `json:"headers"`
+	WaitSelector string   `json:"wait_selector"`
+	Select       []string `json:"select"`
+	Exclude      []string `json:"exclude"`
+	Meta         *bool    `json:"meta"`
+	MetaFields   string   `json:"meta_fields"`
+	Diagnostics  bool     `json:"diagnostics"`
+	Concurrency  int      `json:"concurrency"`
+}
+
+func (r fetchRequest) config(base fetcher.Config) (fetcher.Config, error) {
+	cfg := base
+	cfg.Headers = base.Headers.Clone()
+	if r.Mode != "" {
+		switch r.Mode {
+		case fetcher.ModeAuto, fetcher.ModeStatic, fetcher.ModeBrowser, fetcher.ModeRaw:
+			cfg.Mode = r.Mode
+		default:
+			return fetcher.Config{}, fmt.Errorf("unsupported mode %q", r.Mode)
+		}
+	}
+	var extra http.Header
+	if len(r.Headers) > 0 {
+		var err error
+		extra, err = parseHeaders(r.Headers)
+		if err != nil {
+			return fetcher.Config{}, fmt.Errorf("invalid header: %w", err)
+		}
+		if cfg.Headers == nil {
+			cfg.Headers = extra
+		} else {
+			for k, vals := range extra {
+				for _, v := range vals {
+					cfg.Headers.Add(k, v)
+				}
+			}
+		}
+	}
+	if r.WaitSelector != "" {
+		cfg.WaitSelector = r.WaitSelector
+	}
+	for _, sel := range append(append([]string(nil), r.Select...), r.Exclude...) {
+		if err := fetcher.CheckSelector(sel); err != nil {
+			return fetcher.Config{}, err
+		}
+	}
+	if len(r.Select) > 0 {
+		cfg.Select = r.Select
+	}
+	if len(r.Exclude) > 0 {
+		cfg.Exclude = r.Exclude
+	}
+	if r.Meta != nil {
+		cfg.IncludeMeta = *r.Meta
+	}
+	if r.MetaFields != "" {
+		fields, err := fetcher.ParseMetaFields(r.MetaFields)
+		if err != nil {
+			return fetcher.Config{}, err
+		}
+		cfg.MetaFields = fields
+	}
+	// Request fields beat host and site rules, as flags do.
+	set := func(name string) bool {
+		switch name {
+		case "mode":
+			return r.Mode != ""
+		case "wait-selector":
+			return r.WaitSelector != ""
+		case "select":
+			return len(r.Select) > 0
+		case "exclude-selector":
+			return len(r.Exclude) > 0
+		}
+		return false
+	}
+	cfg.HostRules = overrideHostRules(base.HostRules, set, extra)
+	cfg.SiteRules = overrideSiteRules(base.SiteRules, set, extra)
+	return cfg, nil
+}
+
+func (r fetchRequest) format() (string, error) {
+	format := strings.ToLower(strings.TrimSpace(r.Format))
+	switch format {
+	case "":
+		return formatMarkdown, nil
+	case formatMarkdown, formatJSONL:
+		return format, nil
+	default:
+		return "", errors.New("format must be markdown or jsonl")
+	}
+}
+
+// concurrencyOr returns the concurrency the client asked for, capped at
+// limit, the server's own, which it also is by default.
+func (r fetchRequest) concurrencyOr(limit int) int {
+	if r.Concurrency > 0 && r.Concurrency < limit {
+		return r.Concurrency
+	}
+	return limit
+}
+
+func (r fetchRequest) jsonlOptions(cfg fetcher.Config) jsonlOptions {
+	return jsonlOptions{includeMeta: cfg.IncludeMeta, includeDiagnostics: r.Diagnostics}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	checkpointOK     = "ok"
+	checkpointFailed = "failed"
+)
+
+func resumeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{Name: "resume", Usage: "record each URL's outcome in this JSONL manifest and skip URLs it lists as succeeded (may be an --output-dir index.jsonl)"},
+		&cli.BoolFlag{Name: "failed-only", Usage: "with --resume, fetch only the URLs the manifest lists as failed"},
+	}
+}
+
+// checkpoint is a --resume manifest: the outcome of every URL fetched by
+// earlier runs, and the file this run appends its own outcomes to.
+type checkpoint struct {
+	path       string
+	failedOnly bool
+	// status maps a URL to the outcome of its latest attempt.
+	status map[string]string
+
+	f   *os.File
+	enc *json.Encoder
+	err error
+}
+
+// checkpointRow is a manifest row. Rows of an --output-dir index.jsonl, which
+// have no status, are read as failed when they carry an error.
+type checkpointRow struct {
+	Seq        int    `json:"seq"`
+	URL        string `json:"url"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	FinishedAt string `json:"finished_at"`
+}
+
+func checkpointFromFlags(c *cli.Command) (*checkpoint, error) {
+	path := strings.TrimSpace(c.String("resume"))
+	if path == "" {
+		if c.Bool("failed-only") {
+			return nil, &exitStatusError{code: 2, msg: "invalid failed-only: requires --resume"}
+		}
+		return nil, nil
+	}
+	cp, err := openCheckpoint(path)
+	if err != nil {
+		return nil, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid resume: %v", err)}
+	}
+	cp.failedOnly = c.Bool("failed-only")
+	return cp, nil
+}
+
+// openCheckpoint loads the manifest at path, if it exists. A row cut short by
+// a crash is ignored.
+func openCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{path: path, status: map[string]string{}}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		err = cp.load(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) load(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), inputMaxLineBytes)
+	for sc.Scan() {
+		var row checkpointRow
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil || row.URL == "" {
+			continue
+		}
+		status := row.Status
+		if status == "" {
+			status = checkpointOK
+			if row.Error != "" {
+				status = checkpointFailed
+			}
+		}
+		cp.status[row.URL] = status
+	}
+	return sc.Err()
+}
+
+// skip reports whether url was handled by an earlier run: it succeeded, or,
+// with failedOnly, it did not fail.
+func (cp *checkpoint) skip(url string) bool {
+	status := cp.status[url]
+	if cp.failedOnly {
+		return status != checkpointFailed
+	}
+	return status == checkpointOK
+}
+
+// filter marks the tasks of feed that an earlier run handled as skipped.
+// They keep their seq so output numbering matches the first run.
+func (cp *checkpoint) filter(feed batchFeed) batchFeed {
+	return func(cfg fetcher.Config, tasks chan<- batchTask) error {
+		relay := make(chan batchTask)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for task := range relay {
+				task.skip = cp.skip(task.url)
+				tasks <- task
+			}
+		}()
+		err := feed(cfg, relay)
+		close(relay)
+		<-done
+		return err
+	}
+}
+
+// open starts appending to the manifest. When the manifest is the
+// index.jsonl of the --output-dir, dir records the outcomes instead.
+func (cp *checkpoint) open(dir *dirWriter) error {
+	if dir != nil && sameFile(cp.path, filepath.Join(dir.dir, outputManifestName)) {
+		return nil
+	}
+	f, err := openAppend(cp.path)
+	if err != nil {
+		return &exitStatusError{code: 2, msg: fmt.Sprintf("invalid resume: %v", err)}
+	}
+	cp.f = f
+	cp.enc = json.NewEncoder(f)
+	cp.enc.SetEscapeHTML(false)
+	return nil
+}
+
+// track records the outcome of every result out writes, and marks the tasks
+// of feed that an earlier run handled as skipped.
+func (cp *checkpoint) track(out resultWriter, feed batchFeed) (batchFeed, error) {
+	dir, _ := out.(*dirWriter)
+	if err := cp.open(dir); err != nil {
+		return nil, err
+	}
+	switch out := out.(type) {
+	case *batchWriter:
+		out.onWrite = cp.record
+	case *dirWriter:
+		out.onWrite = cp.record
+	}
+	return cp.filter(feed), nil
+}
+
+// record appends the outcome of a result once its output has been written.
+func (cp *checkpoint) record(result taskResult) {
+	if cp.enc == nil || cp.err != nil {
+		return
+	}
+	row := checkpointRow{Seq: result.index, URL: result.inputURL, Status: checkpointOK, FinishedAt: time.Now().UTC().Format(time.RFC3339)}
+	if result.err != nil {
+		row.Status = checkpointFailed
+		row.Error = result.err.Error()
+	}
+	cp.err = cp.enc.Encode(row)
+}
+
+func (cp *checkpoint) close() error {
+	if cp.f != nil {
+		if err := cp.f.Close(); err != nil && cp.err == nil {
+			cp.err = err
+		}
+		cp.f, cp.enc = nil, nil
+	}
+	return cp.err
+}
+
+// openAppend opens name for appending, creating it if needed. When the file
+// does not end in a newline, as after a crash mid-row, one is added so that
+// new rows start on a line of their own.
+func openAppend(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			_, err = f.Write([]byte{'\n'})
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
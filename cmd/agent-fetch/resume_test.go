@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func TestCheckpointLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.jsonl")
+	manifest := strings.Join([]string{
+		`{"seq":1,"url":"https://example.com/a","status":"ok"}`,
+		`{"seq":2,"url":"https://example.com/b","status":"failed","error":"timeout"}`,
+		`{"seq":3,"url":"https://example.com/c","status":"failed","error":"timeout"}`,
+		`{"seq":3,"url":"https://example.com/c","status":"ok"}`,
+		// Rows of an --output-dir index.jsonl.
+		`{"seq":4,"url":"https://example.com/d","path":"example.com/d.md","fetched_at":"2025-01-02T03:04:05Z"}`,
+		`{"seq":5,"url":"https://example.com/e","fetched_at":"2025-01-02T03:04:05Z","error":"404"}`,
+		`{"seq":6,"url":"https://example.com/f","sta`,
+	}, "\n")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatalf("open checkpoint: %v", err)
+	}
+	tests := []struct {
+		url              string
+		skip, failedOnly bool
+	}{
+		{"https://example.com/a", true, true},
+		{"https://example.com/b", false, false},
+		{"https://example.com/c", true, true},
+		{"https://example.com/d", true, true},
+		{"https://example.com/e", false, false},
+		{"https://example.com/f", false, true},
+		{"https://example.com/new", false, true},
+	}
+	for _, tt := range tests {
+		cp.failedOnly = false
+		if got := cp.skip(tt.url); got != tt.skip {
+			t.Errorf("skip(%s) = %v, want %v", tt.url, got, tt.skip)
+		}
+		cp.failedOnly = true
+		if got := cp.skip(tt.url); got != tt.failedOnly {
+			t.Errorf("failed-only skip(%s) = %v, want %v", tt.url, got, tt.failedOnly)
+		}
+	}
+
+	if _, err := openCheckpoint(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil {
+		t.Fatalf("missing manifest should start empty: %v", err)
+	}
+}
+
+func TestCheckpointResumesBatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.jsonl")
+	// The first run died while writing the row for c.
+	first := `{"seq":1,"url":"https://example.com/a","status":"ok"}` + "\n" +
+		`{"seq":2,"url":"https://example.com/b","status":"failed","error":"timeout"}` + "\n" +
+		`{"seq":3,"url":"https://exa`
+	if err := os.WriteFile(path, []byte(first), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched []string
+	fetch := func(_ context.Context, url string, _ fetcher.Config) (fetcher.Result, error) {
+		fetched = append(fetched, url)
+		if strings.HasSuffix(url, "/d") {
+			return fetcher.Result{}, errors.New("still down")
+		}
+		return fetcher.Result{Markdown: url + "\n", Source: "http-static"}, nil
+	}
+
+	cp, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout strings.Builder
+	out := newOrderedBatchWriter(&stdout, formatJSONL, jsonlOptions{})
+	urls := []string{"https://example.com/a", "https://example.com/b", "https://example.com/c", "https://example.com/d"}
+	feed, err := cp.track(out, feedURLs(urls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := make(chan batchTask)
+	go func() {
+		defer close(tasks)
+		_ = feed(fetcher.DefaultConfig(), tasks)
+	}()
+	fetchTasks(context.Background(), tasks, batchLimits{concurrency: 1}, fetch, out.write)
+	if err := out.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(fetched, " "); got != "https://example.com/b https://example.com/c https://example.com/d" {
+		t.Fatalf("fetched %s", got)
+	}
+	// Output keeps the seq of the full list.
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], `{"seq":2,`) || !strings.HasPrefix(lines[2], `{"seq":4,`) {
+		t.Fatalf("unexpected output:\n%s", stdout.String())
+	}
+
+	cp, err = openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for url, want := range map[string]string{
+		"https://example.com/a": checkpointOK,
+		"https://example.com/b": checkpointOK,
+		"https://example.com/c": checkpointOK,
+		"https://example.com/d": checkpointFailed,
+	} {
+		if got := cp.status[url]; got != want {
+			t.Errorf("status of %s = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestOpenAppendStartsNewLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.jsonl")
+	if err := os.WriteFile(path, []byte(`{"url":"a"}`+"\n"+`{"url":"b`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		f, err := openAppend(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString(`{"url":"c"}` + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	data, _ := os.ReadFile(path)
+	if got := string(data); got != `{"url":"a"}`+"\n"+`{"url":"b`+"\n"+`{"url":"c"}`+"\n"+`{"url":"c"}`+"\n" {
+		t.Fatalf("unexpected file:\n%s", got)
+	}
+}
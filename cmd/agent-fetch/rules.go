@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+const rulesEnv = "AGENT_FETCH_RULES"
+
+func newRulesCommand(defaultCfg fetcher.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "rules",
+		Usage:     "inspect the site rules of a rules file",
+		UsageText: "agent-fetch rules test [options] <url>",
+		Commands: []*cli.Command{
+			{
+				Name:      "test",
+				Usage:     "print the site rule that applies to a URL",
+				ArgsUsage: "<url>",
+				Flags:     fetchFlags(defaultCfg),
+				Action:    runRulesTest,
+			},
+		},
+		Action: func(_ context.Context, c *cli.Command) error {
+			_ = cli.ShowSubcommandHelp(c)
+			return &exitStatusError{code: 2}
+		},
+	}
+}
+
+func defaultRulesPath() string {
+	path := defaultConfigPath()
+	if path == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), "rules.toml")
+}
+
+// siteRulesFromFlags loads the rules file named by --rules, or the default
+// one if it exists, and returns its rules with the path it read.
+func siteRulesFromFlags(c *cli.Command) ([]fetcher.SiteRule, string, error) {
+	path := strings.TrimSpace(c.String("rules"))
+	required := path != ""
+	if !required {
+		path = defaultRulesPath()
+	}
+	if path == "" {
+		return nil, "", nil
+	}
+	rules, err := loadRulesFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", &exitStatusError{code: 2, msg: fmt.Sprintf("invalid rules: %v", err)}
+	}
+	return rules, path, nil
+}
+
+// loadRulesFile reads [rules.NAME] tables, returned in name order.
+func loadRulesFile(path string) ([]fetcher.SiteRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseTOML(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var rules []fetcher.SiteRule
+	for _, key := range sortedKeys(doc) {
+		if key != "rules" {
+			return nil, fmt.Errorf("%s: unknown setting %q, rules go in [rules.<name>] tables", path, key)
+		}
+		tables, ok := doc[key].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: rules: expected [rules.<name>] tables", path)
+		}
+		for _, name := range sortedKeys(tables) {
+			rule, err := decodeSiteRule(name, tables[name])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func decodeSiteRule(name string, val any) (fetcher.SiteRule, error) {
+	t, ok := val.(map[string]any)
+	if !ok {
+		return fetcher.SiteRule{}, fmt.Errorf("rules.%s: expected a table", name)
+	}
+	rule := fetcher.SiteRule{Name: name}
+	for _, key := range sortedKeys(t) {
+		if err := decodeSiteRuleKey(&rule, settingName(key), t[key]); err != nil {
+			return fetcher.SiteRule{}, fmt.Errorf("rules.%s.%s: %w", name, key, err)
+		}
+	}
+	if rule.Host == "" {
+		return fetcher.SiteRule{}, fmt.Errorf("rules.%s: host is required", name)
+	}
+	return rule, nil
+}
+
+func decodeSiteRuleKey(rule *fetcher.SiteRule, name string, val any) error {
+	switch name {
+	case "host", "path", "script":
+		s, ok := val.(string)
+		if !ok {
+			return errors.New("expected a string")
+		}
+		switch name {
+		case "host":
+			rule.Host = strings.ToLower(strings.TrimSpace(s))
+		case "path":
+			if s != "" && !strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "*") {
+				return fmt.Errorf("%q must start with / or *", s)
+			}
+			rule.Path = s
+		default:
+			rule.Script = s
+		}
+	case "select", "exclude":
+		sels, err := configScalars(val)
+		if err != nil {
+			return err
+		}
+		for _, sel := range sels {
+			if err := fetcher.CheckSelector(sel); err != nil {
+				return err
+			}
+		}
+		if name == "select" {
+			rule.Select = sels
+		} else {
+			rule.Exclude = sels
+		}
+	case "cookies":
+		t, ok := val.(map[string]any)
+		if !ok {
+			return errors.New("expected a table of cookie names to values")
+		}
+		for _, k := range sortedKeys(t) {
+			v, ok := t[k].(string)
+			if !ok {
+				return fmt.Errorf("%s: expected a string", k)
+			}
+			rule.Cookies = append(rule.Cookies, &http.Cookie{Name: k, Value: v})
+		}
+	case "strip-images", "strip-links":
+		b, ok := val.(bool)
+		if !ok {
+			return errors.New("expected a boolean")
+		}
+		if name == "strip-images" {
+			rule.Post.StripImages = b
+		} else {
+			rule.Post.StripLinks = b
+		}
+	case "replace":
+		pairs, ok := val.([]any)
+		if !ok {
+			return errors.New(`expected an array of ["pattern", "replacement"] pairs`)
+		}
+		for i, p := range pairs {
+			pair, ok := p.([]any)
+			if !ok || len(pair) != 2 {
+				return fmt.Errorf(`[%d]: expected ["pattern", "replacement"]`, i)
+			}
+			pattern, ok1 := pair[0].(string)
+			with, ok2 := pair[1].(string)
+			if !ok1 || !ok2 {
+				return fmt.Errorf(`[%d]: expected ["pattern", "replacement"]`, i)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+			rule.Post.Replace = append(rule.Post.Replace, fetcher.Replacement{Pattern: re, With: with})
+		}
+	default:
+		ok, err := decodeHostRuleKey(&rule.HostRule, name, val)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("unknown setting")
+		}
+	}
+	return nil
+}
+
+// overrideSiteRules is overrideHostRules for site rules, which also lose
+// their selectors to --select and --exclude-selector.
+func overrideSiteRules(rules []fetcher.SiteRule, set func(name string) bool, headers http.Header) []fetcher.SiteRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	out := make([]fetcher.SiteRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.HostRule = overrideHostRules([]fetcher.HostRule{rule.HostRule}, set, headers)[0]
+		if set("select") {
+			rule.Select = nil
+		}
+		if set("exclude-selector") {
+			rule.Exclude = nil
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func runRulesTest(_ context.Context, c *cli.Command) error {
+	if c.Args().Len() != 1 {
+		_ = cli.ShowSubcommandHelp(c)
+		return &exitStatusError{code: 2}
+	}
+	rawURL := c.Args().First()
+	if u, err := url.Parse(rawURL); err != nil || u.Host == "" {
+		return &exitStatusError{code: 2, msg: fmt.Sprintf("invalid URL: %q", rawURL)}
+	}
+	cfg, applied, err := fetchSettingsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	rule, ok := cfg.MatchRule(rawURL)
+	if !ok {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("no rule matches %s", rawURL)}
+	}
+	w := c.Root().Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# rules: %s\n# url: %s\n\n", applied.rules, rawURL)
+	writeSiteRule(&b, rule)
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+	}
+	return nil
+}
+
+// writeSiteRule prints rule as the [rules.NAME] table it was read from.
+func writeSiteRule(b *strings.Builder, rule fetcher.SiteRule) {
+	fmt.Fprintf(b, "[rules.%s]\n", tomlKey(rule.Name))
+	b.WriteString("host = " + tomlValue(rule.Host) + "\n")
+	if rule.Path != "" {
+		b.WriteString("path = " + tomlValue(rule.Path) + "\n")
+	}
+	writeHostRuleSettings(b, rule.HostRule)
+	if len(rule.Select) > 0 {
+		b.WriteString("select = " + tomlArray(rule.Select) + "\n")
+	}
+	if len(rule.Exclude) > 0 {
+		b.WriteString("exclude = " + tomlArray(rule.Exclude) + "\n")
+	}
+	if len(rule.Cookies) > 0 {
+		b.WriteString("cookies = { ")
+		for i, c := range rule.Cookies {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(c.Name) + " = " + tomlValue(c.Value))
+		}
+		b.WriteString(" }\n")
+	}
+	if rule.Script != "" {
+		b.WriteString("script = " + tomlValue(rule.Script) + "\n")
+	}
+	if rule.Post.StripImages {
+		b.WriteString("strip-images = true\n")
+	}
+	if rule.Post.StripLinks {
+		b.WriteString("strip-links = true\n")
+	}
+	if len(rule.Post.Replace) > 0 {
+		b.WriteString("replace = [")
+		for i, r := range rule.Post.Replace {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("[" + strconv.Quote(r.Pattern.String()) + ", " + strconv.Quote(r.With) + "]")
+		}
+		b.WriteString("]\n")
+	}
+}
+
+// tomlKey returns name as a bare key when it can be one.
+func tomlKey(name string) string {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return strconv.Quote(name)
+		}
+	}
+	if name == "" {
+		return `""`
+	}
+	return name
+}
+
+func tomlArray(vals []string) string {
+	quoted := make([]string, len(vals))
+	for i, s := range vals {
+		quoted[i] = strconv.Quote(s)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+const testRulesFile = `[rules.docs]
+host = "docs.example.com"
+path = "/guide/*"
+mode = "static"
+network_idle = "2s"
+select = ["main", "#content"]
+exclude = ".feedback"
+headers = { "X-Docs" = "1" }
+cookies = { consent = "yes" }
+script = "document.querySelectorAll('details').forEach(d => d.open = true)"
+strip-images = true
+replace = [['\s*Edit this page', ""]]
+
+[rules.site]
+host = "*.example.com"
+strip-links = true
+`
+
+func writeTestRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.toml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	rules, err := loadRulesFile(writeTestRules(t, testRulesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || rules[0].Name != "docs" || rules[1].Name != "site" {
+		t.Fatalf("rules %+v", rules)
+	}
+	docs := rules[0]
+	if docs.Mode != fetcher.ModeStatic || docs.NetworkIdle != 2*time.Second || docs.Path != "/guide/*" {
+		t.Fatalf("host settings %+v", docs.HostRule)
+	}
+	if strings.Join(docs.Select, "|") != "main|#content" || strings.Join(docs.Exclude, "|") != ".feedback" {
+		t.Fatalf("selectors %q %q", docs.Select, docs.Exclude)
+	}
+	if len(docs.Cookies) != 1 || docs.Cookies[0].Name != "consent" || docs.Headers.Get("X-Docs") != "1" {
+		t.Fatalf("cookies %v headers %v", docs.Cookies, docs.Headers)
+	}
+	if !docs.Post.StripImages || len(docs.Post.Replace) != 1 || docs.Post.Replace[0].Pattern.String() != `\s*Edit this page` {
+		t.Fatalf("post %+v", docs.Post)
+	}
+
+	for content, msg := range map[string]string{
+		"mode = \"static\"\n":                                       `unknown setting "mode", rules go in [rules.<name>] tables`,
+		"[rules.a]\npath = \"/x\"\n":                                "rules.a: host is required",
+		"[rules.a]\nhost = \"a.test\"\nselect = \"div[\"\n":         `rules.a.select: invalid selector "div["`,
+		"[rules.a]\nhost = \"a.test\"\nreplace = [[\"(\"]]\n":       `rules.a.replace: [0]: expected ["pattern", "replacement"]`,
+		"[rules.a]\nhost = \"a.test\"\nreplace = [[\"(\", \"\"]]\n": "rules.a.replace: [0]: error parsing regexp",
+		"[rules.a]\nhost = \"a.test\"\npath = \"docs\"\n":           `rules.a.path: "docs" must start with / or *`,
+		"[rules.a]\nhost = \"a.test\"\nmode = \"fast\"\n":           `rules.a.mode: unsupported mode "fast"`,
+		"[rules.a]\nhost = \"a.test\"\ncolour = 1\n":                "rules.a.colour: unknown setting",
+	} {
+		if _, err := loadRulesFile(writeTestRules(t, content)); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("loadRulesFile(%q) error = %v, want %q", content, err, msg)
+		}
+	}
+}
+
+func TestRulesTest(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := writeTestRules(t, testRulesFile)
+
+	var out strings.Builder
+	err := runForTest([]string{"agent-fetch", "rules", "test", "--rules", path, "https://docs.example.com/guide/intro"}, &out, &out)
+	if err != nil {
+		t.Fatalf("rules test: %v", err)
+	}
+	want := "# rules: " + path + "\n# url: https://docs.example.com/guide/intro\n\n" +
+		"[rules.docs]\n" +
+		"host = \"docs.example.com\"\n" +
+		"path = \"/guide/*\"\n" +
+		"mode = \"static\"\n" +
+		"network-idle = \"2s\"\n" +
+		"headers = { \"X-Docs\" = \"1\" }\n" +
+		"select = [\"main\", \"#content\"]\n" +
+		"exclude = [\".feedback\"]\n" +
+		"cookies = { \"consent\" = \"yes\" }\n" +
+		"script = \"document.querySelectorAll('details').forEach(d => d.open = true)\"\n" +
+		"strip-images = true\n" +
+		"replace = [[\"\\\\s*Edit this page\", \"\"]]\n"
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+	// The output is itself a valid rules file.
+	if _, err := loadRulesFile(writeTestRules(t, strings.SplitN(out.String(), "\n\n", 2)[1])); err != nil {
+		t.Fatalf("reload printed rule: %v", err)
+	}
+
+	out.Reset()
+	err = runForTest([]string{"agent-fetch", "rules", "test", "--rules", path, "https://blog.example.com/post"}, &out, &out)
+	if err != nil || !strings.Contains(out.String(), "[rules.site]\nhost = \"*.example.com\"\nstrip-links = true\n") {
+		t.Fatalf("rules test: %v\n%s", err, out.String())
+	}
+
+	err = runForTest([]string{"agent-fetch", "rules", "test", "--rules", path, "https://other.test/"}, &out, &out)
+	var exitErr *exitStatusError
+	if !errors.As(err, &exitErr) || exitErr.code != 1 || exitErr.msg != "no rule matches https://other.test/" {
+		t.Fatalf("got %v, want exit 1 for no match", err)
+	}
+}
+
+func TestConfigShowSiteRule(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	path := writeTestRules(t, testRulesFile)
+	var out strings.Builder
+	err := runForTest([]string{"agent-fetch", "config", "show", "--rules", path, "--network-idle", "1s", "https://docs.example.com/guide/a"}, &out, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# rules: " + path + "\n",
+		"# rule: docs\n",
+		`mode = "static"  # rule docs`,
+		`network-idle = "1s"  # command line`,
+		"\"X-Docs\" = \"1\"\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("missing %q in:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	defaultListenAddr    = "127.0.0.1:8080"
+	maxServeRequestBytes = 1 << 20
+	healthCacheTTL       = time.Minute
+	serveShutdownTimeout = 10 * time.Second
+	ndjsonContentType    = "application/x-ndjson"
+)
+
+func newServeCommand(defaultCfg fetcher.Config) *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "run a local HTTP API (POST /fetch, POST /batch, GET /healthz)",
+		Description: "Options set the defaults for every request; mode, headers, wait selector\n" +
+			"and meta can be overridden per request body.",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{Name: "listen", Value: defaultListenAddr, Usage: "address to listen on, e.g. ':8080'"},
+		}, fetchFlags(defaultCfg)...),
+		Action: runServe,
+	}
+}
+
+func runServe(ctx context.Context, c *cli.Command) (err error) {
+	cfg, err := fetchConfigFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer saveCookies(c, cfg.CookieJar, &err)
+	limits, err := batchLimitsFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	robots, err := robotsPolicyFromFlags(c)
+	if err != nil {
+		return err
+	}
+
+	pool, err := browserPoolFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	cfg.BrowserPool = pool
+
+	ln, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("listen failed: %v", err)}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	health := newHealthChecker(defaultDoctorDeps(), cfg.BrowserPath, healthCacheTTL)
+	srv := &http.Server{
+		Handler:           newServeHandler(cfg, limits, fetcher.Fetch, health, robots),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	fmt.Fprintf(os.Stderr, "agent-fetch serve: listening on http://%s\n", ln.Addr())
+
+	select {
+	case err := <-errCh:
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("serve failed: %v", err)}
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return &exitStatusError{code: 1, msg: fmt.Sprintf("shutdown failed: %v", err)}
+	}
+	return nil
+}
+
+type serveHandler struct {
+	cfg    fetcher.Config
+	limits batchLimits
+	fetch  fetchFunc
+	health *healthChecker
+	robots robotsPolicy
+}
+
+func newServeHandler(cfg fetcher.Config, limits batchLimits, fetch fetchFunc, health *healthChecker, robots robotsPolicy) http.Handler {
+	h := &serveHandler{cfg: cfg, limits: limits.shared(), fetch: fetch, health: health, robots: robots}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /fetch", h.handleFetch)
+	mux.HandleFunc("POST /batch", h.handleBatch)
+	mux.HandleFunc("GET /healthz", h.handleHealth)
+	return mux
+}
+
+type serveErrorBody struct {
+	Error string `json:"error"`
+}
+
+type serveBatchBody struct {
+	Count     int   `json:"count"`
+	Succeeded int   `json:"succeeded"`
+	Failed    int   `json:"failed"`
+	Results   []any `json:"results"`
+}
+
+func (h *serveHandler) handleFetch(w http.ResponseWriter, r *http.Request) {
+	req, cfg, ok := h.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	if strings.TrimSpace(req.URL) == "" {
+		writeJSON(w, http.StatusBadRequest, serveErrorBody{Error: "url is required"})
+		return
+	}
+
+	h.robots.apply(&cfg, false)
+	result := fetchBatch(r.Context(), []string{req.URL}, cfg, batchLimits{concurrency: 1, hosts: h.limits.hosts}, h.fetch)[0]
+	status := http.StatusOK
+	if result.err != nil {
+		status = http.StatusBadGateway
+	}
+
+	if req.Format == formatMarkdown {
+		if result.err != nil {
+			writeJSON(w, status, serveErrorBody{Error: strings.TrimSpace(result.err.Error())})
+			return
+		}
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, result.markdown)
+		return
+	}
+	writeJSON(w, status, newJSONLPayload(result, req.jsonlOptions(cfg)))
+}
+
+func (h *serveHandler) handleBatch(w http.ResponseWriter, r *http.Request) {
+	req, cfg, ok := h.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(req.URLs) == 0 {
+		writeJSON(w, http.StatusBadRequest, serveErrorBody{Error: "urls must not be empty"})
+		return
+	}
+	limits := h.limits
+	limits.concurrency = req.concurrencyOr(limits.concurrency)
+	opts := req.jsonlOptions(cfg)
+	h.robots.apply(&cfg, len(req.URLs) > 1)
+
+	if wantsNDJSON(r) {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		fetchBatchStream(r.Context(), req.URLs, cfg, limits, h.fetch, func(result taskResult) {
+			if err := enc.Encode(newJSONLPayload(result, opts)); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+		return
+	}
+
+	results := fetchBatch(r.Context(), req.URLs, cfg, limits, h.fetch)
+	if req.Format == formatMarkdown {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_ = writeBatchMarkdown(w, results)
+		return
+	}
+
+	body := serveBatchBody{
+		Count:   len(results),
+		Failed:  failedCount(results),
+		Results: make([]any, 0, len(results)),
+	}
+	body.Succeeded = body.Count - body.Failed
+	for _, result := range results {
+		body.Results = append(body.Results, newJSONLPayload(result, opts))
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (h *serveHandler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	// Static and raw fetching work without a browser, so a missing browser
+	// is reported in the body but does not fail the health check.
+	writeJSON(w, http.StatusOK, h.health.check(r.Context()))
+}
+
+func (h *serveHandler) decodeRequest(w http.ResponseWriter, r *http.Request) (fetchRequest, fetcher.Config, bool) {
+	var req fetchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, serveErrorBody{Error: "invalid request body: " + err.Error()})
+		return fetchRequest{}, fetcher.Config{}, false
+	}
+
+	cfg, err := req.config(h.cfg)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, serveErrorBody{Error: err.Error()})
+		return fetchRequest{}, fetcher.Config{}, false
+	}
+	// An empty format means JSON for the HTTP API; markdown must be asked for explicitly.
+	if req.Format != "" {
+		format, err := req.format()
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, serveErrorBody{Error: err.Error()})
+			return fetchRequest{}, fetcher.Config{}, false
+		}
+		req.Format = format
+	}
+	return req, cfg, true
+}
+
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("stream") == "true" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+type healthReport struct {
+	Status   doctorStatus  `json:"status"`
+	Version  string        `json:"version"`
+	Platform string        `json:"platform"`
+	Browser  healthBrowser `json:"browser"`
+}
+
+type healthBrowser struct {
+	Ready      bool     `json:"ready"`
+	Binary     string   `json:"binary,omitempty"`
+	Candidates []string `json:"candidates,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Guidance   []string `json:"guidance,omitempty"`
+}
+
+// healthChecker runs the doctor browser check and caches the report, since a probe launches a browser.
+type healthChecker struct {
+	deps        doctorDeps
+	browserPath string
+	ttl         time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	last      healthReport
+}
+
+func newHealthChecker(deps doctorDeps, browserPath string, ttl time.Duration) *healthChecker {
+	return &healthChecker{deps: deps, browserPath: browserPath, ttl: ttl}
+}
+
+func (h *healthChecker) check(ctx context.Context) healthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.checkedAt.IsZero() && time.Since(h.checkedAt) < h.ttl {
+		return h.last
+	}
+
+	check := diagnoseBrowser(ctx, h.deps, h.browserPath)
+	report := healthReport{
+		Status:   check.status,
+		Version:  versionString(),
+		Platform: h.deps.goos + "/" + h.deps.goarch,
+		Browser: healthBrowser{
+			Ready:      check.status == doctorStatusOK,
+			Binary:     check.selected,
+			Candidates: check.candidates,
+			Guidance:   check.guidance,
+		},
+	}
+	if check.err != nil {
+		report.Browser.Error = check.err.Error()
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// Do not cache results cut short by a disconnecting client.
+		return report
+	}
+	h.last = report
+	h.checkedAt = time.Now()
+	return report
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func newServeTestServer(t *testing.T, fetch fetchFunc, deps doctorDeps) *httptest.Server {
+	t.Helper()
+	cfg := fetcher.DefaultConfig()
+	health := newHealthChecker(deps, "", time.Minute)
+	ts := httptest.NewServer(newServeHandler(cfg, batchLimits{concurrency: 2}, fetch, health, robotsPolicy{}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func stubServeFetch(_ context.Context, url string, cfg fetcher.Config) (fetcher.Result, error) {
+	if strings.HasSuffix(url, "/fail") {
+		return fetcher.Result{StatusCode: http.StatusNotFound}, errors.New("unexpected HTTP status code: 404")
+	}
+	if strings.HasSuffix(url, "/slow") {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fetcher.Result{
+		Markdown: "---\ntitle: 'Stub'\n---\n\n# " + cfg.Mode + " " + cfg.Headers.Get("X-Test") + "\n",
+		Source:   "http-static",
+		FinalURL: url,
+	}, nil
+}
+
+func postJSON(t *testing.T, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post %s: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestServeFetch(t *testing.T) {
+	ts := newServeTestServer(t, stubServeFetch, doctorDeps{})
+
+	resp := postJSON(t, ts.URL+"/fetch", `{"url":"https://example.com/a","mode":"static","headers":["X-Test: yes"]}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	var payload jsonlSuccessPayload
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if payload.Content != "# static yes\n" || payload.Meta == nil || payload.Meta.Title != "Stub" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+
+	resp = postJSON(t, ts.URL+"/fetch", `{"url":"https://example.com/fail"}`, nil)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502 for failed fetch, got %d", resp.StatusCode)
+	}
+	var errPayload jsonlErrorPayload
+	if err := json.NewDecoder(resp.Body).Decode(&errPayload); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.Contains(errPayload.Error, "404") {
+		t.Fatalf("unexpected error payload: %+v", errPayload)
+	}
+
+	resp = postJSON(t, ts.URL+"/fetch", `{"url":"https://example.com/a","format":"markdown"}`, nil)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/markdown") {
+		t.Fatalf("expected markdown response, got %q", ct)
+	}
+}
+
+func TestServeFetchRejectsBadRequests(t *testing.T) {
+	ts := newServeTestServer(t, stubServeFetch, doctorDeps{})
+
+	for name, body := range map[string]string{
+		"missing url":   `{}`,
+		"unknown field": `{"url":"https://example.com","bogus":1}`,
+		"bad mode":      `{"url":"https://example.com","mode":"nope"}`,
+		"bad header":    `{"url":"https://example.com","headers":["nocolon"]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			resp := postJSON(t, ts.URL+"/fetch", body, nil)
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+
+	resp, err := http.Get(ts.URL + "/fetch")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET /fetch, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeBatchJSON(t *testing.T) {
+	ts := newServeTestServer(t, stubServeFetch, doctorDeps{})
+
+	resp := postJSON(t, ts.URL+"/batch", `{"urls":["https://example.com/slow","https://example.com/fail"]}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	var body struct {
+		Count     int              `json:"count"`
+		Succeeded int              `json:"succeeded"`
+		Failed    int              `json:"failed"`
+		Results   []map[string]any `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Count != 2 || body.Succeeded != 1 || body.Failed != 1 {
+		t.Fatalf("unexpected counts: %+v", body)
+	}
+	if body.Results[0]["seq"] != float64(1) || body.Results[1]["seq"] != float64(2) {
+		t.Fatalf("expected input order, got %+v", body.Results)
+	}
+}
+
+func TestServeBatchCapsConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	ts := newServeTestServer(t, func(ctx context.Context, url string, cfg fetcher.Config) (fetcher.Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		return stubServeFetch(ctx, url+"/slow", cfg)
+	}, doctorDeps{})
+
+	urls := make([]string, 8)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%q", fmt.Sprintf("https://example.com/%d", i))
+	}
+	resp := postJSON(t, ts.URL+"/batch", `{"concurrency":100,"urls":[`+strings.Join(urls, ",")+`]}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("%d fetches ran at once, want at most the server's 2", got)
+	}
+}
+
+func TestServeCapsConcurrencyAcrossRequests(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	ts := newServeTestServer(t, func(ctx context.Context, url string, cfg fetcher.Config) (fetcher.Result, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		return stubServeFetch(ctx, url+"/slow", cfg)
+	}, doctorDeps{})
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"urls":["https://a.example/%d","https://b.example/%d"]}`, i, i)
+			if resp := postJSON(t, ts.URL+"/batch", body, nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("batch status: %d", resp.StatusCode)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"url":"https://c.example/%d"}`, i)
+			if resp := postJSON(t, ts.URL+"/fetch", body, nil); resp.StatusCode != http.StatusOK {
+				t.Errorf("fetch status: %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("%d fetches ran at once across requests, want at most the server's 2", got)
+	}
+}
+
+func TestServeBatchNDJSONStreamsInCompletionOrder(t *testing.T) {
+	ts := newServeTestServer(t, stubServeFetch, doctorDeps{})
+
+	resp := postJSON(t, ts.URL+"/batch", `{"urls":["https://example.com/slow","https://example.com/fast"]}`, map[string]string{"Accept": ndjsonContentType})
+	if ct := resp.Header.Get("Content-Type"); ct != ndjsonContentType {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	var seqs []int
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var row struct {
+			Seq int `json:"seq"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("unmarshal row %q: %v", scanner.Text(), err)
+		}
+		seqs = append(seqs, row.Seq)
+	}
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 1 {
+		t.Fatalf("expected fast task first, got seqs %v", seqs)
+	}
+}
+
+func TestServeHealthzReusesDoctorCheckAndCaches(t *testing.T) {
+	var probes atomic.Int32
+	deps := doctorDeps{
+		resolveBrowser: func(string) (string, []string, error) {
+			return "/usr/bin/chromium", []string{"/usr/bin/chromium"}, nil
+		},
+		runProbe: func(context.Context, string) (string, error) {
+			probes.Add(1)
+			return "about:blank", nil
+		},
+		goos:   "linux",
+		goarch: "amd64",
+	}
+	ts := newServeTestServer(t, stubServeFetch, deps)
+
+	for range 2 {
+		resp, err := http.Get(ts.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("get healthz: %v", err)
+		}
+		var report healthReport
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || report.Status != doctorStatusOK || !report.Browser.Ready {
+			t.Fatalf("unexpected health report: %d %+v", resp.StatusCode, report)
+		}
+		if report.Browser.Binary != "/usr/bin/chromium" || report.Platform != "linux/amd64" {
+			t.Fatalf("unexpected browser report: %+v", report)
+		}
+	}
+	if got := probes.Load(); got != 1 {
+		t.Fatalf("expected cached probe, ran %d times", got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+	"github.com/urfave/cli/v3"
+)
+
+func newSitemapCommand(defaultCfg fetcher.Config) *cli.Command {
+	return &cli.Command{
+		Name:      "sitemap",
+		Usage:     "list or fetch the pages in a site's sitemaps",
+		UsageText: "agent-fetch sitemap [options] <site-or-sitemap-url>",
+		Description: "A site root (e.g. https://example.com) is looked up through the Sitemap\n" +
+			"lines of its robots.txt and /sitemap.xml; any other URL is read as a\n" +
+			"sitemap. Sitemap indexes, gzip and plain-text sitemaps are supported.\n" +
+			"Matching pages are fetched like a multi-URL run, or printed with --list.",
+		Flags: append(append(outputFlags(),
+			&cli.BoolFlag{Name: "list", Usage: "print the matching URLs instead of fetching them (jsonl: url and lastmod per row)"},
+			&cli.StringFlag{Name: "since", Usage: "only URLs whose lastmod is at or after this date (2006-01-02, RFC 3339) or duration ago (e.g. 168h)"},
+			&cli.StringSliceFlag{Name: "include", Usage: "only URLs matching this regex, repeatable"},
+			&cli.StringSliceFlag{Name: "exclude", Usage: "drop URLs matching this regex, repeatable"},
+			&cli.IntFlag{Name: "max-urls", Usage: "stop after this many matching URLs (0: no limit)"},
+			streamFlag(),
+		), append(resumeFlags(), fetchFlags(defaultCfg)...)...),
+		Action: runSitemap,
+	}
+}
+
+func runSitemap(ctx context.Context, c *cli.Command) (err error) {
+	if c.Args().Len() != 1 {
+		_ = cli.ShowSubcommandHelp(c)
+		return &exitStatusError{code: 2}
+	}
+
+	cfg, err := fetchConfigFromFlags(c)
+	if err != nil {
+		return err
+	}
+	defer saveCookies(c, cfg.CookieJar, &err)
+	format, jsonlOpts, err := outputOptionsFromFlags(c, cfg)
+	if err != nil {
+		return err
+	}
+	limits, err := batchLimitsFromFlags(c)
+	if err != nil {
+		return err
+	}
+	filter, err := sitemapFilterFromFlags(c, time.Now())
+	if err != nil {
+		return err
+	}
+	robots, err := robotsPolicyFromFlags(c)
+	if err != nil {
+		return err
+	}
+	robots.apply(&cfg, true)
+
+	rawURL := c.Args().First()
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &exitStatusError{code: 2, msg: fmt.Sprintf("invalid URL: %q", rawURL)}
+	}
+
+	entries, err := fetcher.Sitemap(ctx, rawURL, cfg)
+	if err != nil {
+		if len(entries) == 0 {
+			return &exitStatusError{code: 1, msg: fmt.Sprintf("sitemap failed: %v", err)}
+		}
+		// Some sitemaps of an index may fail while the rest are usable.
+		fmt.Fprintf(os.Stderr, "agent-fetch sitemap: %v\n", err)
+	}
+	entries = filter.apply(entries)
+
+	if c.Bool("list") {
+		w, err := outputFileFromFlags(c)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		if err := writeSitemapList(w, entries, format); err != nil {
+			return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+		}
+		if err := w.Close(); err != nil {
+			return &exitStatusError{code: 1, msg: fmt.Sprintf("write failed: %v", err)}
+		}
+		return nil
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	urls := make([]string, len(entries))
+	for i, entry := range entries {
+		urls[i] = entry.URL
+	}
+	return runBatch(ctx, c, cfg, limits, format, jsonlOpts, feedURLs(urls))
+}
+
+type sitemapFilter struct {
+	since   time.Time
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	maxURLs int
+}
+
+func sitemapFilterFromFlags(c *cli.Command, now time.Time) (sitemapFilter, error) {
+	var (
+		filter sitemapFilter
+		err    error
+	)
+	if since := strings.TrimSpace(c.String("since")); since != "" {
+		if filter.since, err = parseSince(since, now); err != nil {
+			return sitemapFilter{}, &exitStatusError{code: 2, msg: fmt.Sprintf("invalid since: %v", err)}
+		}
+	}
+	if filter.include, err = regexpsFromFlag(c, "include"); err != nil {
+		return sitemapFilter{}, err
+	}
+	if filter.exclude, err = regexpsFromFlag(c, "exclude"); err != nil {
+		return sitemapFilter{}, err
+	}
+	filter.maxURLs = c.Int("max-urls")
+	if filter.maxURLs < 0 {
+		return sitemapFilter{}, &exitStatusError{code: 2, msg: "invalid max-urls: must be >= 0"}
+	}
+	return filter, nil
+}
+
+// parseSince accepts a date, an RFC 3339 timestamp or a duration before now.
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date (2006-01-02), RFC 3339 time or duration", v)
+}
+
+// apply keeps the entries that pass the filters. With --since, entries
+// without a lastmod are dropped.
+func (f sitemapFilter) apply(entries []fetcher.SitemapEntry) []fetcher.SitemapEntry {
+	var kept []fetcher.SitemapEntry
+	for _, entry := range entries {
+		if f.maxURLs > 0 && len(kept) >= f.maxURLs {
+			break
+		}
+		if !f.since.IsZero() && (entry.LastMod.IsZero() || entry.LastMod.Before(f.since)) {
+			continue
+		}
+		if !matchesURLFilters(entry.URL, f.include, f.exclude) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+type sitemapListRow struct {
+	URL     string `json:"url"`
+	LastMod string `json:"lastmod,omitempty"`
+}
+
+// writeSitemapList prints one URL per line, or one JSON row per URL for jsonl.
+func writeSitemapList(w io.Writer, entries []fetcher.SitemapEntry, format string) error {
+	if format != formatJSONL {
+		for _, entry := range entries {
+			if _, err := fmt.Fprintln(w, entry.URL); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, entry := range entries {
+		row := sitemapListRow{URL: entry.URL}
+		if !entry.LastMod.IsZero() {
+			row.LastMod = entry.LastMod.Format(time.RFC3339)
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
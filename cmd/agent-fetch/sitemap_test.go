@@ -0,0 +1,85 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2025-01-02":                time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		"2025-01-02T03:04:05+02:00": time.Date(2025, 1, 2, 1, 4, 5, 0, time.UTC),
+		"48h":                       time.Date(2025, 6, 8, 12, 0, 0, 0, time.UTC),
+	}
+	for in, want := range tests {
+		got, err := parseSince(in, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseSince(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseSince("last week", now); err == nil {
+		t.Fatal("expected error for unparsable since")
+	}
+}
+
+func TestSitemapFilter(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	entries := []fetcher.SitemapEntry{
+		{URL: "https://example.com/docs/a", LastMod: day(5)},
+		{URL: "https://example.com/docs/b", LastMod: day(1)},
+		{URL: "https://example.com/docs/c"},
+		{URL: "https://example.com/blog/d", LastMod: day(9)},
+		{URL: "https://example.com/docs/e", LastMod: day(7)},
+	}
+	urls := func(entries []fetcher.SitemapEntry) string {
+		var out []string
+		for _, entry := range entries {
+			out = append(out, strings.TrimPrefix(entry.URL, "https://example.com"))
+		}
+		return strings.Join(out, " ")
+	}
+
+	if got := urls(sitemapFilter{}.apply(entries)); got != "/docs/a /docs/b /docs/c /blog/d /docs/e" {
+		t.Fatalf("no filter: %s", got)
+	}
+	if got := urls(sitemapFilter{since: day(5)}.apply(entries)); got != "/docs/a /blog/d /docs/e" {
+		t.Fatalf("since: %s", got)
+	}
+	filter := sitemapFilter{
+		include: []*regexp.Regexp{regexp.MustCompile(`/docs/`)},
+		exclude: []*regexp.Regexp{regexp.MustCompile(`/b$`)},
+		maxURLs: 2,
+	}
+	if got := urls(filter.apply(entries)); got != "/docs/a /docs/c" {
+		t.Fatalf("include/exclude/max-urls: %s", got)
+	}
+}
+
+func TestWriteSitemapList(t *testing.T) {
+	entries := []fetcher.SitemapEntry{
+		{URL: "https://example.com/a?x=1&y=2", LastMod: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{URL: "https://example.com/b"},
+	}
+
+	var b strings.Builder
+	if err := writeSitemapList(&b, entries, formatMarkdown); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if b.String() != "https://example.com/a?x=1&y=2\nhttps://example.com/b\n" {
+		t.Fatalf("unexpected list:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := writeSitemapList(&b, entries, formatJSONL); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := `{"url":"https://example.com/a?x=1&y=2","lastmod":"2025-01-02T03:04:05Z"}` + "\n" + `{"url":"https://example.com/b"}` + "\n"
+	if b.String() != want {
+		t.Fatalf("unexpected jsonl:\n%s", b.String())
+	}
+}
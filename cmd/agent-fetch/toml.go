@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML parses a TOML document. Tables become map[string]any, arrays
+// []any and integers int64; dates and arrays of tables keep the decoder's
+// types, which config keys reject as values of the wrong type.
+func parseTOML(src string) (map[string]any, error) {
+	doc := map[string]any{}
+	if _, err := toml.Decode(strings.TrimPrefix(src, "\ufeff"), &doc); err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return nil, fmt.Errorf("line %d: %s", perr.Position.Line, perr.Message)
+		}
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,252 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+var ErrBrowserPoolClosed = errors.New("browser pool is closed")
+
+// BrowserPool keeps a bounded set of browser processes alive and hands out one
+// isolated (incognito) tab per fetch, so batches do not launch a browser per URL.
+// Browsers are launched lazily on first use. A pool is safe for concurrent use.
+type BrowserPool struct {
+	instances      int
+	tabsPerBrowser int
+
+	keyFor func(cfg Config) (string, error)
+	launch func(ctx context.Context, key string, cfg Config) (*pooledBrowser, error)
+	newTab func(b *pooledBrowser) (context.Context, context.CancelFunc)
+
+	mu       sync.Mutex
+	browsers []*pooledBrowser
+	// launching counts the slots reserved for browsers being launched,
+	// which happens without holding mu.
+	launching int
+	changed   chan struct{}
+	closed    bool
+}
+
+type pooledBrowser struct {
+	key    string
+	ctx    context.Context
+	close  func()
+	active int
+}
+
+func NewBrowserPool(instances, tabsPerBrowser int) *BrowserPool {
+	if instances < 1 {
+		instances = 1
+	}
+	if tabsPerBrowser < 1 {
+		tabsPerBrowser = 1
+	}
+	return &BrowserPool{
+		instances:      instances,
+		tabsPerBrowser: tabsPerBrowser,
+		keyFor:         browserPoolKey,
+		launch:         launchPooledBrowser,
+		newTab:         newIncognitoTab,
+		changed:        make(chan struct{}),
+	}
+}
+
+// Close shuts down every browser in the pool. Tabs still in use are cancelled.
+func (p *BrowserPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for _, b := range p.browsers {
+		b.close()
+	}
+	p.browsers = nil
+	p.notifyLocked()
+	return nil
+}
+
+// acquire returns a tab context bound to ctx's cancellation, waiting while the
+// pool is at capacity. release must be called once the tab is no longer needed.
+func (p *BrowserPool) acquire(ctx context.Context, cfg Config) (context.Context, func(), error) {
+	key, err := p.keyFor(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, nil, ErrBrowserPoolClosed
+		}
+		b, launch, recycled := p.pickLocked(key)
+		if launch {
+			p.launching++
+			p.mu.Unlock()
+			if recycled != nil {
+				recycled.close()
+			}
+			b, err := p.startBrowser(ctx, key, cfg)
+			if err != nil {
+				return nil, nil, err
+			}
+			tabCtx, release := p.openTab(ctx, b)
+			return tabCtx, release, nil
+		}
+		if b != nil {
+			b.active++
+			p.mu.Unlock()
+			tabCtx, release := p.openTab(ctx, b)
+			return tabCtx, release, nil
+		}
+		wait := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// openTab opens a tab in b, whose active count the caller has raised, and
+// returns it with the func that releases it.
+func (p *BrowserPool) openTab(ctx context.Context, b *pooledBrowser) (context.Context, func()) {
+	tabCtx, cancelTab := p.newTab(b)
+	stop := context.AfterFunc(ctx, cancelTab)
+	release := func() {
+		stop()
+		cancelTab()
+		p.mu.Lock()
+		b.active--
+		p.notifyLocked()
+		p.mu.Unlock()
+	}
+	return tabCtx, release
+}
+
+// pickLocked selects a browser with a free tab for key. When none fits but
+// one may be launched, it reports launch, with the idle browser of other
+// settings it took out of the pool to make room, if any, for the caller to
+// close. It returns neither when the caller must wait.
+func (p *BrowserPool) pickLocked(key string) (b *pooledBrowser, launch bool, recycled *pooledBrowser) {
+	live := p.browsers[:0]
+	for _, b := range p.browsers {
+		if b.ctx.Err() != nil {
+			b.close()
+			continue
+		}
+		live = append(live, b)
+	}
+	p.browsers = live
+	slots := len(p.browsers) + p.launching
+
+	var best *pooledBrowser
+	for _, b := range p.browsers {
+		if b.key != key || b.active >= p.tabsPerBrowser {
+			continue
+		}
+		if best == nil || b.active < best.active {
+			best = b
+		}
+	}
+	// Spread tabs across instances before stacking them on one browser.
+	if best != nil && (best.active == 0 || slots >= p.instances) {
+		return best, false, nil
+	}
+
+	if slots < p.instances {
+		return nil, true, nil
+	}
+
+	// Recycle an idle browser launched with different settings.
+	for i, b := range p.browsers {
+		if b.key != key && b.active == 0 {
+			p.browsers = append(p.browsers[:i], p.browsers[i+1:]...)
+			return nil, true, b
+		}
+	}
+	return nil, false, nil
+}
+
+// startBrowser launches a browser into the slot the caller reserved, without
+// holding mu, and adds it to the pool with one tab taken.
+func (p *BrowserPool) startBrowser(ctx context.Context, key string, cfg Config) (*pooledBrowser, error) {
+	b, err := p.launch(ctx, key, cfg)
+	p.mu.Lock()
+	p.launching--
+	p.notifyLocked()
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	if p.closed {
+		p.mu.Unlock()
+		b.close()
+		return nil, ErrBrowserPoolClosed
+	}
+	b.active++
+	p.browsers = append(p.browsers, b)
+	p.mu.Unlock()
+	return b, nil
+}
+
+func (p *BrowserPool) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// browserPoolKey identifies the launch-time settings a pooled browser was started with.
+func browserPoolKey(cfg Config) (string, error) {
+	execPath, _, err := ResolveBrowserExecutablePath(cfg.BrowserPath)
+	if err != nil {
+		return "", fmt.Errorf("resolve browser executable: %w", err)
+	}
+	return execPath + "\x00" + cfg.UserAgent + "\x00" + cfg.Proxy, nil
+}
+
+func launchPooledBrowser(ctx context.Context, key string, cfg Config) (*pooledBrowser, error) {
+	execPath, _, err := ResolveBrowserExecutablePath(cfg.BrowserPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve browser executable: %w", err)
+	}
+
+	// The browser outlives the task that launched it, so it is not derived from ctx.
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), browserAllocatorOptions(execPath, cfg)...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+
+	started := make(chan error, 1)
+	go func() { started <- chromedp.Run(browserCtx) }()
+	select {
+	case err = <-started:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cancelBrowser()
+		cancelAlloc()
+		return nil, fmt.Errorf("launch pooled browser: %w", err)
+	}
+
+	return &pooledBrowser{
+		key: key,
+		ctx: browserCtx,
+		close: func() {
+			// Cancel waits for the browser process to exit; plain cancellation would not.
+			_ = chromedp.Cancel(browserCtx)
+			cancelBrowser()
+			cancelAlloc()
+		},
+	}, nil
+}
+
+func newIncognitoTab(b *pooledBrowser) (context.Context, context.CancelFunc) {
+	return chromedp.NewContext(b.ctx, chromedp.WithNewBrowserContext())
+}
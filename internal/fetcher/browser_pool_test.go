@@ -0,0 +1,310 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBrowsers struct {
+	mu       sync.Mutex
+	launched []string
+	closed   int
+}
+
+func newFakeBrowserPool(instances, tabs int) (*BrowserPool, *fakeBrowsers) {
+	fb := &fakeBrowsers{}
+	p := NewBrowserPool(instances, tabs)
+	p.keyFor = func(cfg Config) (string, error) {
+		return cfg.UserAgent, nil
+	}
+	p.launch = func(_ context.Context, key string, _ Config) (*pooledBrowser, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		fb.mu.Lock()
+		fb.launched = append(fb.launched, key)
+		fb.mu.Unlock()
+		return &pooledBrowser{key: key, ctx: ctx, close: func() {
+			cancel()
+			fb.mu.Lock()
+			fb.closed++
+			fb.mu.Unlock()
+		}}, nil
+	}
+	p.newTab = func(b *pooledBrowser) (context.Context, context.CancelFunc) {
+		return context.WithCancel(b.ctx)
+	}
+	return p, fb
+}
+
+func (fb *fakeBrowsers) counts() (launched, closed int) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return len(fb.launched), fb.closed
+}
+
+func TestBrowserPoolSpreadsTabsAndReusesBrowsers(t *testing.T) {
+	p, fb := newFakeBrowserPool(2, 2)
+	defer p.Close()
+
+	var releases []func()
+	for i := 0; i < 4; i++ {
+		_, release, err := p.acquire(context.Background(), Config{})
+		if err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if launched, _ := fb.counts(); launched != 2 {
+		t.Fatalf("expected 2 browsers for 4 tabs, got %d", launched)
+	}
+	for _, b := range p.browsers {
+		if b.active != 2 {
+			t.Fatalf("expected tabs spread evenly, got %d on one browser", b.active)
+		}
+	}
+
+	for _, release := range releases {
+		release()
+	}
+	_, release, err := p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	release()
+	if launched, _ := fb.counts(); launched != 2 {
+		t.Fatalf("expected released browsers to be reused, got %d launches", launched)
+	}
+}
+
+func TestBrowserPoolWaitsForFreeTab(t *testing.T) {
+	p, _ := newFakeBrowserPool(1, 1)
+	defer p.Close()
+
+	_, release, err := p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		_, release2, err := p.acquire(context.Background(), Config{})
+		if err == nil {
+			release2()
+		}
+		got <- err
+	}()
+
+	select {
+	case err := <-got:
+		t.Fatalf("second acquire should wait while the pool is full, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("second acquire: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second acquire did not proceed after release")
+	}
+}
+
+func TestBrowserPoolAcquireHonorsContext(t *testing.T) {
+	p, _ := newFakeBrowserPool(1, 1)
+	defer p.Close()
+
+	_, release, err := p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := p.acquire(ctx, Config{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestBrowserPoolTabCancelledWithCaller(t *testing.T) {
+	p, _ := newFakeBrowserPool(1, 1)
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tabCtx, release, err := p.acquire(ctx, Config{})
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	cancel()
+	select {
+	case <-tabCtx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("tab was not cancelled with the caller context")
+	}
+	if p.browsers[0].ctx.Err() != nil {
+		t.Fatal("cancelling a tab must not stop the pooled browser")
+	}
+}
+
+func TestBrowserPoolRecyclesIdleBrowserForOtherSettings(t *testing.T) {
+	p, fb := newFakeBrowserPool(1, 2)
+	defer p.Close()
+
+	_, release, err := p.acquire(context.Background(), Config{UserAgent: "a"})
+	if err != nil {
+		t.Fatalf("acquire a: %v", err)
+	}
+	release()
+
+	_, release, err = p.acquire(context.Background(), Config{UserAgent: "b"})
+	if err != nil {
+		t.Fatalf("acquire b: %v", err)
+	}
+	release()
+
+	launched, closed := fb.counts()
+	if launched != 2 || closed != 1 {
+		t.Fatalf("expected idle browser to be replaced, got launched=%d closed=%d", launched, closed)
+	}
+	if len(p.browsers) != 1 || p.browsers[0].key != "b" {
+		t.Fatalf("unexpected pool browsers: %+v", p.browsers)
+	}
+}
+
+func TestBrowserPoolReplacesDeadBrowser(t *testing.T) {
+	p, fb := newFakeBrowserPool(1, 1)
+	defer p.Close()
+
+	_, release, err := p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	release()
+	p.browsers[0].close()
+
+	_, release, err = p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("acquire after crash: %v", err)
+	}
+	release()
+	if launched, _ := fb.counts(); launched != 2 {
+		t.Fatalf("expected a crashed browser to be relaunched, got %d launches", launched)
+	}
+}
+
+func TestBrowserPoolClose(t *testing.T) {
+	p, fb := newFakeBrowserPool(2, 1)
+
+	_, release, err := p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	_, release2, err := p.acquire(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	waiting := make(chan error, 1)
+	go func() {
+		_, _, err := p.acquire(context.Background(), Config{})
+		waiting <- err
+	}()
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, closed := fb.counts(); closed != 2 {
+		t.Fatalf("expected both browsers closed, got %d", closed)
+	}
+	select {
+	case err := <-waiting:
+		if !errors.Is(err, ErrBrowserPoolClosed) {
+			t.Fatalf("expected ErrBrowserPoolClosed for waiter, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter was not woken by Close")
+	}
+
+	release()
+	release2()
+	if _, _, err := p.acquire(context.Background(), Config{}); !errors.Is(err, ErrBrowserPoolClosed) {
+		t.Fatalf("expected ErrBrowserPoolClosed, got %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("second close: %v", err)
+	}
+}
+
+func TestBrowserPoolLaunchesWithoutHoldingLock(t *testing.T) {
+	p, _ := newFakeBrowserPool(2, 1)
+	defer p.Close()
+	fakeLaunch := p.launch
+	var inFlight sync.WaitGroup
+	inFlight.Add(2)
+	// Each launch finishes only once the other has started, which cannot
+	// happen if the first holds the pool's lock.
+	p.launch = func(ctx context.Context, key string, cfg Config) (*pooledBrowser, error) {
+		inFlight.Done()
+		done := make(chan struct{})
+		go func() { inFlight.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return fakeLaunch(ctx, key, cfg)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, release, err := p.acquire(ctx, Config{})
+			if err == nil {
+				release()
+			}
+			errs <- err
+		}()
+	}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	}
+
+}
+
+func TestBrowserPoolFailedLaunchFreesSlot(t *testing.T) {
+	p, fb := newFakeBrowserPool(1, 1)
+	defer p.Close()
+	fakeLaunch := p.launch
+	fail := true
+	p.launch = func(ctx context.Context, key string, cfg Config) (*pooledBrowser, error) {
+		if fail {
+			fail = false
+			return nil, errors.New("no browser")
+		}
+		return fakeLaunch(ctx, key, cfg)
+	}
+
+	if _, _, err := p.acquire(context.Background(), Config{}); err == nil {
+		t.Fatal("expected the launch error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, release, err := p.acquire(ctx, Config{})
+	if err != nil {
+		t.Fatalf("acquire after a failed launch: %v", err)
+	}
+	release()
+	if launched, _ := fb.counts(); launched != 1 {
+		t.Fatalf("expected 1 browser, got %d", launched)
+	}
+}
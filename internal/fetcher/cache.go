@@ -0,0 +1,479 @@
+package fetcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultCacheTTL = time.Hour
+
+	cacheKindHTTP     = "http"
+	cacheKindMarkdown = "markdown"
+)
+
+// Cache is an on-disk store for HTTP response bodies and the Markdown produced
+// from them. HTTP entries follow the response's Cache-Control/Expires lifetime
+// and are revalidated with ETag/Last-Modified once stale; responses without an
+// explicit lifetime, and Markdown results, stay fresh for the cache TTL.
+// Entries are written atomically, so several processes may share a directory.
+type Cache struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+}
+
+type cacheEntry struct {
+	Kind      string    `json:"kind"`
+	URL       string    `json:"url"`
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	FinalURL   string      `json:"final_url"`
+	StatusCode int         `json:"status_code,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+
+	Mode         string   `json:"mode,omitempty"`
+	Source       string   `json:"source,omitempty"`
+	Markdown     string   `json:"markdown,omitempty"`
+	Pages        int      `json:"pages,omitempty"`
+	Charset      string   `json:"charset,omitempty"`
+	CanonicalURL string   `json:"canonical_url,omitempty"`
+	Links        []string `json:"links,omitempty"`
+}
+
+// CacheStats summarizes the entries found in a cache directory.
+type CacheStats struct {
+	HTTPEntries     int
+	MarkdownEntries int
+	Fresh           int
+	Stale           int
+	Bytes           int64
+}
+
+// DefaultCacheDir returns the per-user cache directory for agent-fetch.
+func DefaultCacheDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "agent-fetch"), nil
+}
+
+// OpenCache creates dir if needed and returns a cache rooted there.
+// A ttl <= 0 disables heuristic freshness: responses without an explicit
+// lifetime are always revalidated and Markdown results are not cached.
+func OpenCache(dir string, ttl time.Duration) (*Cache, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, errors.New("cache dir is empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+	return &Cache{dir: dir, ttl: ttl, now: time.Now}, nil
+}
+
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// roundTrip serves req from the cache when a fresh entry exists, otherwise
+// calls send, turning a stored validator into a conditional request.
+func (c *Cache) roundTrip(req *http.Request, creds http.Header, send func(*http.Request) (responseData, error)) (responseData, error) {
+	key := httpCacheKey(req, creds)
+	entry, cached := c.load(cacheKindHTTP, key)
+	if cached && !hasCacheDirective(req.Header, "no-cache") && c.now().Before(entry.ExpiresAt) {
+		return entry.response("hit"), nil
+	}
+
+	conditional := false
+	if cached && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+			conditional = true
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+			conditional = true
+		}
+	}
+
+	resp, err := send(req)
+	if err != nil {
+		return resp, err
+	}
+	now := c.now()
+
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		for k, vals := range resp.Header {
+			entry.Header[k] = vals
+		}
+		entry.StoredAt = now
+		entry.ExpiresAt = c.httpExpiry(entry.Header, now)
+		_ = c.store(cacheKindHTTP, key, entry)
+		return entry.response("revalidated"), nil
+	}
+
+	resp.CacheExpires = now
+	resp.CacheStatus = "miss"
+	if resp.StatusCode == http.StatusOK && !hasCacheDirective(resp.Header, "no-store") {
+		resp.CacheExpires = c.httpExpiry(resp.Header, now)
+		err := c.store(cacheKindHTTP, key, cacheEntry{
+			Kind:       cacheKindHTTP,
+			URL:        req.URL.String(),
+			StoredAt:   now,
+			ExpiresAt:  resp.CacheExpires,
+			FinalURL:   resp.FinalURL,
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       resp.Body,
+		})
+		if err == nil {
+			resp.CacheStatus = "stored"
+		}
+	}
+	return resp, nil
+}
+
+// httpExpiry derives the freshness deadline of a response stored at now.
+func (c *Cache) httpExpiry(h http.Header, now time.Time) time.Time {
+	if hasCacheDirective(h, "no-cache") {
+		return now
+	}
+	if maxAge, ok := cacheDirectiveSeconds(h, "max-age"); ok {
+		age, _ := strconv.Atoi(strings.TrimSpace(h.Get("Age")))
+		return now.Add(time.Duration(maxAge-age) * time.Second)
+	}
+	if raw := h.Get("Expires"); raw != "" {
+		expires, err := http.ParseTime(raw)
+		if err != nil {
+			return now
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return now.Add(expires.Sub(date))
+	}
+	return now.Add(c.ttl)
+}
+
+func (c *Cache) loadMarkdown(rawURL string, cfg Config, creds http.Header) (Result, bool) {
+	if c.ttl <= 0 {
+		return Result{}, false
+	}
+	entry, ok := c.load(cacheKindMarkdown, markdownCacheKey(rawURL, cfg, creds))
+	if !ok || !c.now().Before(entry.ExpiresAt) {
+		return Result{}, false
+	}
+	return Result{
+		Markdown:     entry.Markdown,
+		Source:       entry.Source,
+		FinalURL:     entry.FinalURL,
+		StatusCode:   entry.StatusCode,
+		ContentType:  entry.Header.Get("Content-Type"),
+		Header:       entry.Header,
+		Charset:      entry.Charset,
+		PageCount:    entry.Pages,
+		CanonicalURL: entry.CanonicalURL,
+		Links:        entry.Links,
+	}, true
+}
+
+// storeMarkdown caches res for the TTL, or until httpExpires when the HTTP
+// response it was built from goes stale sooner.
+func (c *Cache) storeMarkdown(rawURL string, cfg Config, creds http.Header, res Result, httpExpires time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	now := c.now()
+	expires := now.Add(c.ttl)
+	if !httpExpires.IsZero() && httpExpires.Before(expires) {
+		expires = httpExpires
+	}
+	if !expires.After(now) {
+		return
+	}
+	_ = c.store(cacheKindMarkdown, markdownCacheKey(rawURL, cfg, creds), cacheEntry{
+		Kind:         cacheKindMarkdown,
+		URL:          rawURL,
+		StoredAt:     now,
+		ExpiresAt:    expires,
+		FinalURL:     res.FinalURL,
+		StatusCode:   res.StatusCode,
+		Header:       res.Header,
+		Mode:         cfg.Mode,
+		Source:       res.Source,
+		Markdown:     res.Markdown,
+		Pages:        res.PageCount,
+		Charset:      res.Charset,
+		CanonicalURL: res.CanonicalURL,
+		Links:        res.Links,
+	})
+}
+
+func (c *Cache) entryPath(kind, key string) string {
+	return filepath.Join(c.dir, kind, key[:2], key+".json")
+}
+
+func (c *Cache) load(kind, key string) (cacheEntry, bool) {
+	data, err := os.ReadFile(c.entryPath(kind, key))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Kind != kind {
+		return cacheEntry{}, false
+	}
+	if entry.Header == nil {
+		entry.Header = make(http.Header)
+	}
+	return entry, true
+}
+
+func (c *Cache) store(kind, key string, entry cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := c.entryPath(kind, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Stats walks the cache directory and counts entries by kind and freshness.
+func (c *Cache) Stats() (CacheStats, error) {
+	var stats CacheStats
+	now := c.now()
+	err := c.walk(func(_ string, size int64, entry cacheEntry, ok bool) error {
+		stats.Bytes += size
+		if !ok {
+			return nil
+		}
+		switch entry.Kind {
+		case cacheKindHTTP:
+			stats.HTTPEntries++
+		case cacheKindMarkdown:
+			stats.MarkdownEntries++
+		}
+		if now.Before(entry.ExpiresAt) {
+			stats.Fresh++
+		} else {
+			stats.Stale++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Prune removes unreadable entries and stale entries that cannot be
+// revalidated (Markdown results and HTTP responses without ETag or
+// Last-Modified). When olderThan > 0, entries stored before now-olderThan
+// are removed as well. It returns the number of entries and bytes removed.
+func (c *Cache) Prune(olderThan time.Duration) (int, int64, error) {
+	now := c.now()
+	removed := 0
+	var freed int64
+	err := c.walk(func(path string, size int64, entry cacheEntry, ok bool) error {
+		drop := !ok
+		if ok && !now.Before(entry.ExpiresAt) {
+			drop = entry.Kind != cacheKindHTTP ||
+				(entry.Header.Get("ETag") == "" && entry.Header.Get("Last-Modified") == "")
+		}
+		if ok && olderThan > 0 && entry.StoredAt.Before(now.Add(-olderThan)) {
+			drop = true
+		}
+		if !drop {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		freed += size
+		return nil
+	})
+	return removed, freed, err
+}
+
+// Clear removes every cache entry and returns how many were removed.
+// Files outside the cache's own subdirectories are left untouched.
+func (c *Cache) Clear() (int, error) {
+	removed := 0
+	if err := c.walk(func(string, int64, cacheEntry, bool) error {
+		removed++
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	for _, kind := range []string{cacheKindHTTP, cacheKindMarkdown} {
+		if err := os.RemoveAll(filepath.Join(c.dir, kind)); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+func (c *Cache) walk(fn func(path string, size int64, entry cacheEntry, ok bool) error) error {
+	for _, kind := range []string{cacheKindHTTP, cacheKindMarkdown} {
+		root := filepath.Join(c.dir, kind)
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".json") {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var entry cacheEntry
+			ok := json.Unmarshal(data, &entry) == nil && entry.Kind == kind
+			if entry.Header == nil {
+				entry.Header = make(http.Header)
+			}
+			return fn(path, info.Size(), entry, ok)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e cacheEntry) response(status string) responseData {
+	return responseData{
+		Body:         e.Body,
+		ContentType:  e.Header.Get("Content-Type"),
+		Header:       e.Header.Clone(),
+		FinalURL:     e.FinalURL,
+		StatusCode:   e.StatusCode,
+		CacheStatus:  status,
+		CacheExpires: e.ExpiresAt,
+	}
+}
+
+// httpCacheKey covers the URL, every request header and the credential
+// headers the request will carry, so responses that vary by Accept,
+// credentials or custom headers never collide.
+func httpCacheKey(req *http.Request, creds http.Header) string {
+	if len(creds) == 0 {
+		return cacheKey(req.Method, req.URL.String(), canonicalHeaderLines(req.Header))
+	}
+	return cacheKey(req.Method, req.URL.String(), canonicalHeaderLines(req.Header), canonicalHeaderLines(creds))
+}
+
+// markdownCacheKey covers the settings that change the extracted Markdown.
+func markdownCacheKey(rawURL string, cfg Config, creds http.Header) string {
+	cookies := make([]string, 0, len(cfg.Cookies))
+	for _, c := range cfg.Cookies {
+		cookies = append(cookies, c.String())
+	}
+	return cacheKey(
+		rawURL,
+		cfg.Mode,
+		strconv.FormatBool(cfg.IncludeMeta),
+		strings.Join(cfg.metaFields(), ","),
+		strconv.Itoa(cfg.MinQualityText),
+		strconv.FormatInt(cfg.MaxBodyBytes, 10),
+		cfg.WaitSelector,
+		cfg.UserAgent,
+		canonicalHeaderLines(cfg.Headers),
+		strconv.FormatBool(cfg.CollectLinks),
+		strings.Join(cfg.Select, "\x00"),
+		strings.Join(cfg.Exclude, "\x00"),
+		strings.Join(cookies, "\x00"),
+		cfg.Script,
+		// Pages fetched with credentials are personal to them.
+		canonicalHeaderLines(creds),
+	)
+}
+
+func cacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func canonicalHeaderLines(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(http.CanonicalHeaderKey(k))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(h[k], ", "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func hasCacheDirective(h http.Header, name string) bool {
+	_, ok := cacheDirective(h, name)
+	return ok
+}
+
+func cacheDirectiveSeconds(h http.Header, name string) (int, bool) {
+	raw, ok := cacheDirective(h, name)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.Trim(raw, `"`))
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func cacheDirective(h http.Header, name string) (string, bool) {
+	for _, line := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(strings.TrimSpace(k), name) {
+				return strings.TrimSpace(v), true
+			}
+		}
+	}
+	return "", false
+}
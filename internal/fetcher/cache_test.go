@@ -0,0 +1,325 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, ttl time.Duration) (*Cache, *time.Time) {
+	t.Helper()
+	c, err := OpenCache(t.TempDir(), ttl)
+	if err != nil {
+		t.Fatalf("open cache: %v", err)
+	}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCacheServesFreshResponses(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "cached body")
+	}))
+	defer ts.Close()
+
+	cache, now := newTestCache(t, time.Hour)
+	cfg := DefaultConfig()
+	cfg.Cache = cache
+
+	first, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, "text/plain")
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if first.CacheStatus != "stored" {
+		t.Fatalf("expected first response to be stored, got %q", first.CacheStatus)
+	}
+
+	second, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, "text/plain")
+	if err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	if second.CacheStatus != "hit" || string(second.Body) != "cached body" || second.StatusCode != http.StatusOK {
+		t.Fatalf("expected cache hit, got %+v", second)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected one upstream request, got %d", hits.Load())
+	}
+
+	if _, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, "text/html"); err != nil {
+		t.Fatalf("fetch with other accept: %v", err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("expected a different Accept to miss the cache, got %d requests", hits.Load())
+	}
+
+	*now = now.Add(2 * time.Minute)
+	if _, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, "text/plain"); err != nil {
+		t.Fatalf("fetch after expiry: %v", err)
+	}
+	if hits.Load() != 3 {
+		t.Fatalf("expected stale entry to be refetched, got %d requests", hits.Load())
+	}
+}
+
+func TestCacheRevalidatesWithValidators(t *testing.T) {
+	var conditional atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") != "" {
+			conditional.Add(1)
+			w.Header().Set("X-Revalidated", "yes")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "original body")
+	}))
+	defer ts.Close()
+
+	cache, _ := newTestCache(t, time.Hour)
+	cfg := DefaultConfig()
+	cfg.Cache = cache
+
+	if _, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, ""); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	resp, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, "")
+	if err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	if conditional.Load() != 1 {
+		t.Fatal("expected a conditional request for a no-cache response")
+	}
+	if resp.CacheStatus != "revalidated" || string(resp.Body) != "original body" || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected revalidated cached body, got %+v", resp)
+	}
+	if resp.Header.Get("X-Revalidated") != "yes" || resp.ContentType != "text/plain" {
+		t.Fatalf("expected 304 headers merged into cached headers, got %v", resp.Header)
+	}
+}
+
+func TestCacheSkipsNoStoreAndErrors(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, "secret")
+	}))
+	defer ts.Close()
+
+	cache, _ := newTestCache(t, time.Hour)
+	cfg := DefaultConfig()
+	cfg.Cache = cache
+
+	for i := 0; i < 2; i++ {
+		resp, err := fetchHTTPWithAccept(context.Background(), ts.URL, cfg, "")
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if resp.CacheStatus != "miss" {
+			t.Fatalf("expected no-store response to miss, got %q", resp.CacheStatus)
+		}
+		if _, err := fetchHTTPWithAccept(context.Background(), ts.URL+"/missing", cfg, ""); err == nil {
+			t.Fatal("expected status error")
+		}
+	}
+	if hits.Load() != 4 {
+		t.Fatalf("expected every request to reach the server, got %d", hits.Load())
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.HTTPEntries != 0 {
+		t.Fatalf("expected nothing cached, got %+v", stats)
+	}
+}
+
+func TestCacheHTTPExpiry(t *testing.T) {
+	c := &Cache{ttl: 10 * time.Minute}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "no lifetime uses ttl", header: http.Header{}, want: 10 * time.Minute},
+		{name: "max-age", header: http.Header{"Cache-Control": {"public, max-age=120"}}, want: 2 * time.Minute},
+		{name: "max-age minus age", header: http.Header{"Cache-Control": {"max-age=120"}, "Age": {"20"}}, want: 100 * time.Second},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"no-cache"}}, want: 0},
+		{name: "expires relative to date", header: http.Header{
+			"Date":    {"Mon, 02 Jan 2006 15:04:05 GMT"},
+			"Expires": {"Mon, 02 Jan 2006 15:09:05 GMT"},
+		}, want: 5 * time.Minute},
+		{name: "invalid expires", header: http.Header{"Expires": {"0"}}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.httpExpiry(tt.header, now).Sub(now); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFetchCachesMarkdownPerMode(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Type", "text/markdown")
+		fmt.Fprint(w, "# Cached\n\nMarkdown body.\n")
+	}))
+	defer ts.Close()
+
+	cache, now := newTestCache(t, time.Hour)
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.IncludeMeta = false
+	cfg.Cache = cache
+
+	if _, err := Fetch(context.Background(), ts.URL, cfg); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	// A no-cache HTTP response must not be served from the Markdown cache either.
+	if _, err := Fetch(context.Background(), ts.URL, cfg); err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("expected no-cache response to be refetched, got %d requests", hits.Load())
+	}
+
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "text/markdown")
+		fmt.Fprint(w, "# Cached\n\nMarkdown body.\n")
+	}))
+	defer ts2.Close()
+	hits.Store(0)
+
+	first, err := Fetch(context.Background(), ts2.URL, cfg)
+	if err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	second, err := Fetch(context.Background(), ts2.URL, cfg)
+	if err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected markdown cache hit, got %d requests", hits.Load())
+	}
+	if second.Markdown != first.Markdown || second.Source != "http-markdown" || second.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected cached result: %+v", second)
+	}
+	if got := traceString(second.Trace); got != "cache:hit" {
+		t.Fatalf("unexpected trace for cached markdown: %s", got)
+	}
+
+	cfg.Mode = ModeRaw
+	if _, err := Fetch(context.Background(), ts2.URL, cfg); err != nil {
+		t.Fatalf("raw fetch: %v", err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("expected raw mode to reuse the cached HTTP response, got %d requests", hits.Load())
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.MarkdownEntries != 2 {
+		t.Fatalf("expected one markdown entry per mode, got %+v", stats)
+	}
+
+	*now = now.Add(2 * time.Hour)
+	if _, err := Fetch(context.Background(), ts2.URL, cfg); err != nil {
+		t.Fatalf("fetch after ttl: %v", err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("expected refetch after ttl, got %d requests", hits.Load())
+	}
+}
+
+func TestMarkdownCacheKeyFields(t *testing.T) {
+	base := markdownCacheKey("https://example.com/", DefaultConfig(), nil)
+	for name, change := range map[string]func(*Config){
+		"max body bytes": func(c *Config) { c.MaxBodyBytes = 1 << 10 },
+		"meta fields":    func(c *Config) { c.MetaFields = []string{MetaTitle} },
+		"select":         func(c *Config) { c.Select = []string{"main"} },
+		"exclude":        func(c *Config) { c.Exclude = []string{"nav"} },
+		"cookies":        func(c *Config) { c.Cookies = []*http.Cookie{{Name: "a", Value: "b"}} },
+		"script":         func(c *Config) { c.Script = "1" },
+	} {
+		cfg := DefaultConfig()
+		change(&cfg)
+		if markdownCacheKey("https://example.com/", cfg, nil) == base {
+			t.Errorf("%s: expected a different cache key", name)
+		}
+	}
+}
+
+func TestCacheStatsPruneClear(t *testing.T) {
+	cache, now := newTestCache(t, time.Hour)
+	base := *now
+	put := func(key string, entry cacheEntry) {
+		t.Helper()
+		if err := cache.store(entry.Kind, cacheKey(key), entry); err != nil {
+			t.Fatalf("store: %v", err)
+		}
+	}
+	put("fresh", cacheEntry{Kind: cacheKindHTTP, StoredAt: base, ExpiresAt: base.Add(time.Hour)})
+	put("stale-etag", cacheEntry{Kind: cacheKindHTTP, StoredAt: base, ExpiresAt: base, Header: http.Header{"Etag": {`"x"`}}})
+	put("stale", cacheEntry{Kind: cacheKindHTTP, StoredAt: base, ExpiresAt: base})
+	put("md-stale", cacheEntry{Kind: cacheKindMarkdown, StoredAt: base, ExpiresAt: base})
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.HTTPEntries != 3 || stats.MarkdownEntries != 1 || stats.Fresh != 1 || stats.Stale != 3 || stats.Bytes == 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	removed, freed, err := cache.Prune(0)
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if removed != 2 || freed == 0 {
+		t.Fatalf("expected stale entries without validators pruned, got %d (%d bytes)", removed, freed)
+	}
+
+	*now = base.Add(48 * time.Hour)
+	removed, _, err = cache.Prune(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("prune older-than: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected old entries pruned, got %d", removed)
+	}
+
+	put("again", cacheEntry{Kind: cacheKindMarkdown, StoredAt: base, ExpiresAt: base})
+	removed, err = cache.Clear()
+	if err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected one entry cleared, got %d", removed)
+	}
+	if stats, _ := cache.Stats(); stats.HTTPEntries+stats.MarkdownEntries != 0 {
+		t.Fatalf("expected empty cache, got %+v", stats)
+	}
+}
@@ -0,0 +1,154 @@
+package fetcher
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gogs/chardet"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// Where a body's character set came from, in the order they are tried.
+const (
+	charsetFromBOM      = "bom"
+	charsetFromHeader   = "header"
+	charsetFromMeta     = "meta"
+	charsetFromDefault  = "default"
+	charsetFromDetected = "detected"
+)
+
+var charsetBOMs = []struct {
+	bom  []byte
+	name string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "utf-8"},
+	{[]byte{0xFE, 0xFF}, "utf-16be"},
+	{[]byte{0xFF, 0xFE}, "utf-16le"},
+}
+
+// isTextContentType reports whether a response of contentType is text that
+// may need transcoding. An empty type is treated as text.
+func isTextContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "" ||
+		strings.HasPrefix(mediaType, "text/") ||
+		strings.Contains(mediaType, "html") ||
+		strings.Contains(mediaType, "xml")
+}
+
+// detectCharset returns the character set of body: from a byte order mark,
+// the Content-Type charset parameter, or a <meta charset> or http-equiv tag,
+// in that order. Undeclared bodies that are valid UTF-8 are taken as UTF-8;
+// others are guessed from their bytes. The name is the WHATWG name, e.g.
+// "shift_jis" or "gbk".
+func detectCharset(body []byte, contentType string) (enc encoding.Encoding, name, source string) {
+	for _, b := range charsetBOMs {
+		if bytes.HasPrefix(body, b.bom) {
+			enc, name = charset.Lookup(b.name)
+			return enc, name, charsetFromBOM
+		}
+	}
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if enc, name = charset.Lookup(params["charset"]); enc != nil {
+			return enc, name, charsetFromHeader
+		}
+	}
+	if enc, name = charset.Lookup(metaCharset(body)); enc != nil {
+		// A page that declares UTF-16 in a meta tag was not read as UTF-16
+		// to find it, so HTML takes it to mean UTF-8.
+		if strings.HasPrefix(name, "utf-16") {
+			enc, name = charset.Lookup("utf-8")
+		}
+		return enc, name, charsetFromMeta
+	}
+	if utf8.Valid(body) {
+		enc, name = charset.Lookup("utf-8")
+		return enc, name, charsetFromDefault
+	}
+	if res, err := chardet.NewHtmlDetector().DetectBest(body); err == nil {
+		if enc, name = charset.Lookup(res.Charset); enc != nil {
+			return enc, name, charsetFromDetected
+		}
+	}
+	enc, name = charset.Lookup("windows-1252")
+	return enc, name, charsetFromDetected
+}
+
+// metaCharsetScanLimit bounds how far into a page metaCharset looks. HTML
+// wants the declaration in the first 1024 bytes, but real pages put it after
+// long head scripts and styles too.
+const metaCharsetScanLimit = 64 << 10
+
+// metaCharset returns the charset label of the first <meta charset> or
+// <meta http-equiv="Content-Type"> tag before <body>, or "".
+func metaCharset(body []byte) string {
+	if len(body) > metaCharsetScanLimit {
+		body = body[:metaCharsetScanLimit]
+	}
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, hasAttr := z.TagName()
+			switch string(tag) {
+			case "body":
+				return ""
+			case "meta":
+				var charsetAttr, httpEquiv, content string
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					switch string(key) {
+					case "charset":
+						charsetAttr = string(val)
+					case "http-equiv":
+						httpEquiv = string(val)
+					case "content":
+						content = string(val)
+					}
+				}
+				if charsetAttr = strings.TrimSpace(charsetAttr); charsetAttr != "" {
+					return charsetAttr
+				}
+				if strings.EqualFold(strings.TrimSpace(httpEquiv), "content-type") {
+					if _, params, err := mime.ParseMediaType(content); err == nil && params["charset"] != "" {
+						return params["charset"]
+					}
+				}
+			}
+		}
+	}
+}
+
+// toUTF8 transcodes a text body to UTF-8 and drops its byte order mark. It
+// returns the body unchanged when it is not text or cannot be decoded, with
+// an empty charset name in the first case.
+func toUTF8(body []byte, contentType string) (out []byte, name, source string) {
+	if len(body) == 0 || !isTextContentType(contentType) {
+		return body, "", ""
+	}
+	enc, name, source := detectCharset(body, contentType)
+	if source == charsetFromBOM {
+		for _, b := range charsetBOMs {
+			if b.name == name {
+				body = body[len(b.bom):]
+				break
+			}
+		}
+	}
+	if name == "utf-8" {
+		return body, name, source
+	}
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, name, source
+	}
+	return decoded, name, source
+}
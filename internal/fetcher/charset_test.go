@@ -0,0 +1,138 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func mustEncode(t *testing.T, enc encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("encode %q: %v", s, err)
+	}
+	return b
+}
+
+func TestDetectCharset(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		wantName    string
+		wantSource  string
+	}{
+		{"utf-8 bom", []byte("\xEF\xBB\xBF<p>hi</p>"), "text/html; charset=shift_jis", "utf-8", charsetFromBOM},
+		{"utf-16le bom", []byte("\xFF\xFE<\x00p\x00>\x00"), "text/html", "utf-16le", charsetFromBOM},
+		{"header", []byte("<p>hi</p>"), "text/html; charset=Shift_JIS", "shift_jis", charsetFromHeader},
+		{"header label alias", []byte("<p>hi</p>"), "text/html; charset=gb2312", "gbk", charsetFromHeader},
+		{"header beats meta", []byte(`<meta charset="gbk"><p>hi</p>`), "text/html; charset=euc-jp", "euc-jp", charsetFromHeader},
+		{"meta charset", []byte(`<html><head><meta charset="windows-1252"></head>`), "text/html", "windows-1252", charsetFromMeta},
+		{"meta http-equiv", []byte(`<head><meta http-equiv="Content-Type" content="text/html; charset=shift_jis"></head>`), "", "shift_jis", charsetFromMeta},
+		{"meta utf-16 means utf-8", []byte(`<meta charset="utf-16">`), "text/html", "utf-8", charsetFromMeta},
+		{"meta after body ignored", []byte(`<body><meta charset="gbk">hi</body>`), "text/html", "utf-8", charsetFromDefault},
+		{"unknown header label", []byte("<p>hi</p>"), "text/html; charset=bogus", "utf-8", charsetFromDefault},
+		{"undeclared utf-8", []byte("<p>héllo</p>"), "text/html", "utf-8", charsetFromDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, name, source := detectCharset(tt.body, tt.contentType)
+			if name != tt.wantName || source != tt.wantSource {
+				t.Fatalf("detectCharset = %q from %q, want %q from %q", name, source, tt.wantName, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestDetectCharsetGuessesUndeclared(t *testing.T) {
+	body := mustEncode(t, japanese.ShiftJIS, "<html><body><p>"+strings.Repeat("日本語のテキストです。これは文字コードの判定のための文章です。", 10)+"</p></body></html>")
+	_, name, source := detectCharset(body, "text/html")
+	if name != "shift_jis" || source != charsetFromDetected {
+		t.Fatalf("detectCharset = %q from %q, want shift_jis from detected", name, source)
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name        string
+		enc         encoding.Encoding
+		contentType string
+		text        string
+		wantName    string
+	}{
+		{"shift_jis", japanese.ShiftJIS, "text/html; charset=Shift_JIS", "こんにちは世界", "shift_jis"},
+		{"gbk", simplifiedchinese.GBK, "text/html; charset=GBK", "你好，世界", "gbk"},
+		{"windows-1252", charmap.Windows1252, "text/plain; charset=windows-1252", "café “quoted”", "windows-1252"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, name, _ := toUTF8(mustEncode(t, tt.enc, tt.text), tt.contentType)
+			if string(out) != tt.text || name != tt.wantName {
+				t.Fatalf("toUTF8 = %q (%s), want %q (%s)", out, name, tt.text, tt.wantName)
+			}
+		})
+	}
+
+	if out, _, _ := toUTF8([]byte("\xEF\xBB\xBFhello"), "text/plain"); string(out) != "hello" {
+		t.Fatalf("UTF-8 BOM not dropped: %q", out)
+	}
+	binary := []byte{0x89, 'P', 'N', 'G', 0xFF}
+	if out, name, _ := toUTF8(binary, "image/png"); string(out) != string(binary) || name != "" {
+		t.Fatalf("non-text body changed: %q (%s)", out, name)
+	}
+}
+
+func TestFetchStaticTranscodesMetaCharset(t *testing.T) {
+	page := mustEncode(t, japanese.ShiftJIS, `<!doctype html>
+<html><head>
+<meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS">
+<title>日本語のページ</title>
+</head><body>
+<main>
+  <h1>見出し</h1>
+  <p>この本文は Shift_JIS で送られ、UTF-8 に変換されてから Markdown になります。品質チェックを通るだけの長さがあります。</p>
+</main>
+</body></html>`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write(page)
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.Timeout = 5 * time.Second
+	cfg.MinQualityText = 20
+
+	res, err := Fetch(context.Background(), ts.URL, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(res.Markdown, "title: '日本語のページ'") || !strings.Contains(res.Markdown, "UTF-8 に変換されてから") {
+		t.Fatalf("markdown not transcoded: %q", res.Markdown)
+	}
+	if res.Charset != "shift_jis" {
+		t.Fatalf("Charset = %q, want shift_jis", res.Charset)
+	}
+	if res.BodyBytes != len(page) {
+		t.Fatalf("BodyBytes = %d, want the %d bytes received", res.BodyBytes, len(page))
+	}
+	var noted bool
+	for _, ev := range res.Trace {
+		if ev.Stage == "charset" && ev.Decision == "transcoded" && ev.Detail == "shift_jis from meta" {
+			noted = true
+		}
+	}
+	if !noted {
+		t.Fatalf("charset decision missing from trace: %+v", res.Trace)
+	}
+}
@@ -0,0 +1,331 @@
+package fetcher
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenCounter estimates how many model tokens a piece of text occupies.
+// ChunkMarkdown only compares its results against the budget, so any
+// monotonic estimate (or a real tokenizer) can be plugged in.
+type TokenCounter func(string) int
+
+// EstimateTokens is the default TokenCounter. It assumes roughly four bytes per
+// token for Latin text and one token per CJK character, which tracks common BPE
+// tokenizers closely enough for sizing context windows.
+func EstimateTokens(s string) int {
+	units, wide := 0, 0
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			units++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			wide++
+		default:
+			units += 2
+		}
+	}
+	return (units+3)/4 + wide
+}
+
+// Chunk is one piece of a Markdown document split by ChunkMarkdown.
+type Chunk struct {
+	// Markdown is the chunk content, without surrounding blank lines.
+	Markdown string
+	// HeadingPath lists the titles of the headings enclosing the start of the
+	// chunk, outermost first. It is empty before the first heading.
+	HeadingPath []string
+	// Tokens is the chunk size according to the TokenCounter used.
+	Tokens int
+}
+
+type mdBlockKind int
+
+const (
+	mdBlockText mdBlockKind = iota
+	mdBlockHeading
+	// mdBlockAtomic blocks (code fences, tables, front matter) are never split.
+	mdBlockAtomic
+)
+
+type mdBlock struct {
+	kind  mdBlockKind
+	text  string
+	level int
+	title string
+}
+
+// ChunkMarkdown splits md into chunks of at most maxTokens tokens as measured by
+// count (EstimateTokens when nil). Sections under a heading are kept together
+// when they fit; otherwise they are split between paragraphs, then lines, then
+// sentences. Code blocks and tables are never split, so a chunk holding one
+// that is larger than the budget exceeds maxTokens. maxTokens <= 0 returns the
+// whole document as a single chunk.
+func ChunkMarkdown(md string, maxTokens int, count TokenCounter) []Chunk {
+	if count == nil {
+		count = EstimateTokens
+	}
+	blocks := splitMarkdownBlocks(md)
+	if len(blocks) == 0 {
+		return nil
+	}
+	if maxTokens <= 0 {
+		maxTokens = math.MaxInt
+	}
+
+	c := &chunker{max: maxTokens, count: count}
+	var path []string
+	for start := 0; start < len(blocks); {
+		end := start + 1
+		for end < len(blocks) && blocks[end].kind != mdBlockHeading {
+			end++
+		}
+		if blocks[start].kind == mdBlockHeading {
+			path = headingPath(path, blocks[start].level, blocks[start].title)
+		}
+		c.addSection(blocks[start:end], path)
+		start = end
+	}
+	c.flush()
+	return c.chunks
+}
+
+type chunker struct {
+	max    int
+	count  TokenCounter
+	chunks []Chunk
+	parts  []string
+	path   []string
+}
+
+func (c *chunker) addSection(section []mdBlock, path []string) {
+	texts := make([]string, len(section))
+	for i, b := range section {
+		texts[i] = b.text
+	}
+	whole := strings.Join(texts, "\n\n")
+	if c.fits(whole) {
+		c.add(whole, path)
+		return
+	}
+	if len(c.parts) > 0 {
+		c.flush()
+	}
+	for i, b := range section {
+		if c.fits(b.text) {
+			c.add(b.text, path)
+			continue
+		}
+		// Keep a heading together with the start of its content rather than
+		// emitting it as a chunk of its own.
+		first := c.max
+		if i == 1 && section[0].kind == mdBlockHeading && len(c.parts) == 1 {
+			first = max(c.max-c.count(section[0].text+"\n\n"), 1)
+		} else {
+			c.flush()
+		}
+		if b.kind == mdBlockAtomic {
+			c.add(b.text, path)
+			c.flush()
+			continue
+		}
+		for j, piece := range splitOversizedText(b.text, first, c.max, c.count) {
+			if j > 0 {
+				c.flush()
+			}
+			c.add(piece, path)
+		}
+	}
+}
+
+func (c *chunker) fits(text string) bool {
+	if len(c.parts) == 0 {
+		return c.count(text) <= c.max
+	}
+	return c.count(strings.Join(c.parts, "\n\n")+"\n\n"+text) <= c.max
+}
+
+func (c *chunker) add(text string, path []string) {
+	if len(c.parts) == 0 {
+		c.path = path
+	}
+	c.parts = append(c.parts, text)
+}
+
+func (c *chunker) flush() {
+	if len(c.parts) == 0 {
+		return
+	}
+	text := strings.Join(c.parts, "\n\n")
+	c.chunks = append(c.chunks, Chunk{
+		Markdown:    text,
+		HeadingPath: append([]string(nil), c.path...),
+		Tokens:      c.count(text),
+	})
+	c.parts = nil
+}
+
+func headingPath(path []string, level int, title string) []string {
+	out := make([]string, 0, level)
+	for i := 0; i < level-1 && i < len(path); i++ {
+		out = append(out, path[i])
+	}
+	return append(out, title)
+}
+
+// splitMarkdownBlocks breaks md into blank-line separated blocks, keeping fenced
+// code, tables and a leading front matter block whole.
+func splitMarkdownBlocks(md string) []mdBlock {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var blocks []mdBlock
+	var para []string
+	flushPara := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, mdBlock{kind: mdBlockText, text: strings.Join(para, "\n")})
+			para = nil
+		}
+	}
+
+	i := 0
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for j := 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == "---" {
+				blocks = append(blocks, mdBlock{kind: mdBlockAtomic, text: strings.Join(lines[:j+1], "\n")})
+				i = j + 1
+				break
+			}
+		}
+	}
+
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flushPara()
+			i++
+		case codeFence(trimmed) != "":
+			flushPara()
+			fence := codeFence(trimmed)
+			j := i + 1
+			for j < len(lines) && !closesFence(strings.TrimSpace(lines[j]), fence) {
+				j++
+			}
+			if j == len(lines) {
+				j--
+			}
+			blocks = append(blocks, mdBlock{kind: mdBlockAtomic, text: strings.Join(lines[i:j+1], "\n")})
+			i = j + 1
+		case strings.HasPrefix(trimmed, "|"):
+			flushPara()
+			j := i
+			for j < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[j]), "|") {
+				j++
+			}
+			blocks = append(blocks, mdBlock{kind: mdBlockAtomic, text: strings.Join(lines[i:j], "\n")})
+			i = j
+		default:
+			if level, title, ok := atxHeading(trimmed); ok && !strings.HasPrefix(line, "    ") {
+				flushPara()
+				blocks = append(blocks, mdBlock{kind: mdBlockHeading, text: trimmed, level: level, title: title})
+			} else {
+				para = append(para, line)
+			}
+			i++
+		}
+	}
+	flushPara()
+	return blocks
+}
+
+// codeFence returns the fence marker opening a fenced code block, or "".
+func codeFence(line string) string {
+	for _, ch := range []string{"`", "~"} {
+		if strings.HasPrefix(line, ch+ch+ch) {
+			n := len(line) - len(strings.TrimLeft(line, ch))
+			return strings.Repeat(ch, n)
+		}
+	}
+	return ""
+}
+
+func closesFence(line, fence string) bool {
+	return strings.HasPrefix(line, fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+func atxHeading(line string) (int, string, bool) {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level < 1 || level > 6 {
+		return 0, "", false
+	}
+	rest := line[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return 0, "", false
+	}
+	title := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "#"))
+	return level, title, true
+}
+
+// splitOversizedText splits a paragraph or list into pieces of at most limit
+// tokens (first for the first piece), preferring line breaks, then sentence
+// ends, then words.
+func splitOversizedText(text string, first, limit int, count TokenCounter) []string {
+	type unit struct{ sep, text string }
+	var units []unit
+	for _, line := range strings.Split(text, "\n") {
+		sep := "\n"
+		if count(line) <= first {
+			units = append(units, unit{sep, line})
+			continue
+		}
+		for _, sentence := range splitSentences(line) {
+			if count(sentence) <= first {
+				units = append(units, unit{sep, sentence})
+			} else {
+				for _, word := range strings.Fields(sentence) {
+					units = append(units, unit{sep, word})
+					sep = " "
+				}
+			}
+			sep = " "
+		}
+	}
+
+	var pieces []string
+	cur := ""
+	for _, u := range units {
+		budget := limit
+		if len(pieces) == 0 {
+			budget = first
+		}
+		if cur != "" && count(cur+u.sep+u.text) <= budget {
+			cur += u.sep + u.text
+			continue
+		}
+		if cur != "" {
+			pieces = append(pieces, cur)
+		}
+		cur = u.text
+	}
+	if cur != "" {
+		pieces = append(pieces, cur)
+	}
+	return pieces
+}
+
+func splitSentences(line string) []string {
+	var out []string
+	start := 0
+	for i := 0; i < len(line)-1; i++ {
+		if strings.IndexByte(".!?", line[i]) >= 0 && line[i+1] == ' ' {
+			out = append(out, strings.TrimSpace(line[start:i+1]))
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(line[start:]); rest != "" {
+		out = append(out, rest)
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package fetcher
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// wordCount counts whitespace separated words, which keeps budgets in tests readable.
+func wordCount(s string) int { return len(strings.Fields(s)) }
+
+func TestChunkMarkdownKeepsSectionsTogether(t *testing.T) {
+	md := "Intro text here.\n\n" +
+		"# Guide\n\n" +
+		"## Install\n\n" +
+		"Run the installer now.\n\n" +
+		"## Usage\n\n" +
+		"Call the tool with a URL.\n\n" +
+		"### Flags\n\n" +
+		"Flags change behavior.\n"
+
+	chunks := ChunkMarkdown(md, 9, wordCount)
+
+	var got [][]string
+	var texts []string
+	for _, c := range chunks {
+		got = append(got, c.HeadingPath)
+		texts = append(texts, c.Markdown)
+		if c.Tokens > 9 {
+			t.Fatalf("chunk over budget (%d): %q", c.Tokens, c.Markdown)
+		}
+	}
+	wantPaths := [][]string{
+		nil,
+		{"Guide", "Install"},
+		{"Guide", "Usage"},
+		{"Guide", "Usage", "Flags"},
+	}
+	if !reflect.DeepEqual(got, wantPaths) {
+		t.Fatalf("heading paths = %q, want %q (chunks %q)", got, wantPaths, texts)
+	}
+	if texts[0] != "Intro text here.\n\n# Guide" {
+		t.Fatalf("unexpected first chunk: %q", texts[0])
+	}
+	if texts[1] != "## Install\n\nRun the installer now." {
+		t.Fatalf("unexpected second chunk: %q", texts[1])
+	}
+}
+
+func TestChunkMarkdownNeverSplitsCodeOrTables(t *testing.T) {
+	code := "```go\nfunc main() {\n\n\tprintln(\"one two three four five six\")\n}\n```"
+	table := "| a | b |\n| --- | --- |\n| one two | three four |\n| five six | seven eight |"
+	md := "# API\n\nShort lead.\n\n" + code + "\n\nMiddle paragraph text.\n\n" + table + "\n"
+
+	chunks := ChunkMarkdown(md, 6, wordCount)
+
+	var sawCode, sawTable bool
+	for _, c := range chunks {
+		if strings.Contains(c.Markdown, "```") {
+			if !strings.Contains(c.Markdown, code) {
+				t.Fatalf("code block split across chunks: %q", c.Markdown)
+			}
+			sawCode = true
+		}
+		if strings.Contains(c.Markdown, "| a | b |") {
+			if !strings.Contains(c.Markdown, table) {
+				t.Fatalf("table split across chunks: %q", c.Markdown)
+			}
+			sawTable = true
+		}
+		if !reflect.DeepEqual(c.HeadingPath, []string{"API"}) {
+			t.Fatalf("unexpected heading path %q for %q", c.HeadingPath, c.Markdown)
+		}
+	}
+	if !sawCode || !sawTable {
+		t.Fatalf("missing code or table in chunks: %+v", chunks)
+	}
+}
+
+func TestChunkMarkdownSplitsLongParagraphs(t *testing.T) {
+	md := "## Story\n\nOne two three. Four five six. Seven eight nine. Ten eleven twelve.\n"
+
+	chunks := ChunkMarkdown(md, 8, wordCount)
+
+	want := []string{
+		"## Story\n\nOne two three. Four five six.",
+		"Seven eight nine. Ten eleven twelve.",
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v", len(chunks), len(want), chunks)
+	}
+	for i, c := range chunks {
+		if c.Markdown != want[i] {
+			t.Fatalf("chunk %d = %q, want %q", i, c.Markdown, want[i])
+		}
+		if !reflect.DeepEqual(c.HeadingPath, []string{"Story"}) {
+			t.Fatalf("chunk %d heading path = %q", i, c.HeadingPath)
+		}
+	}
+}
+
+func TestChunkMarkdownWithoutBudgetReturnsWholeDocument(t *testing.T) {
+	md := "# Title\n\nBody.\n"
+	chunks := ChunkMarkdown(md, 0, nil)
+	if len(chunks) != 1 || chunks[0].Markdown != "# Title\n\nBody." {
+		t.Fatalf("unexpected chunks: %+v", chunks)
+	}
+	if ChunkMarkdown("\n\n", 10, nil) != nil {
+		t.Fatal("expected no chunks for blank input")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if got := EstimateTokens("abcdefgh"); got != 2 {
+		t.Fatalf("latin estimate = %d, want 2", got)
+	}
+	if got := EstimateTokens("你好世界"); got != 4 {
+		t.Fatalf("cjk estimate = %d, want 4", got)
+	}
+	if got := EstimateTokens(""); got != 0 {
+		t.Fatalf("empty estimate = %d, want 0", got)
+	}
+}
@@ -0,0 +1,428 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookieJar is an http.CookieJar that also keeps every cookie's attributes,
+// so its cookies can be set in the browser and saved to a file.
+//
+// Cookies here follow the cookies.txt convention: a Domain with a leading
+// dot also matches subdomains, one without a dot is host-only.
+type CookieJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[string]*http.Cookie // by domain, path and name
+}
+
+// NewCookieJar returns an empty cookie jar. Like a browser, it refuses
+// cookies for public suffixes such as co.uk.
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &CookieJar{jar: jar, cookies: make(map[string]*http.Cookie)}
+}
+
+// LoadCookieJar returns a jar holding the cookies of a Netscape cookies.txt
+// file or a JSON cookie export; see ReadCookies.
+func LoadCookieJar(path string) (*CookieJar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cookies, err := ReadCookies(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	j := NewCookieJar()
+	j.Add(cookies...)
+	return j, nil
+}
+
+// SetCookies implements http.CookieJar.
+func (j *CookieJar) SetCookies(u *nurl.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		c := *c
+		c.Domain = strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+		switch {
+		case c.Domain == "" || c.Domain == host && net.ParseIP(host) != nil:
+			c.Domain = host
+		case isPublicSuffix(c.Domain):
+			// As the jar does: a host that is itself a public suffix may
+			// set a host-only cookie, nobody may set one for its domain.
+			if c.Domain != host {
+				continue
+			}
+			c.Domain = host
+		case host == c.Domain || strings.HasSuffix(host, "."+c.Domain):
+			c.Domain = "." + c.Domain
+		default:
+			continue // rejected by the jar too
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			c.Path = defaultCookiePath(u.Path)
+		}
+		if c.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		key := cookieKey(&c)
+		if c.MaxAge < 0 || !c.Expires.IsZero() && !c.Expires.After(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		c.MaxAge, c.Raw, c.RawExpires, c.Unparsed = 0, "", "", nil
+		j.cookies[key] = &c
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *nurl.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// Add stores cookies read from a file or a browser. Cookies without a
+// domain, for a public suffix domain, or expired are skipped.
+func (j *CookieJar) Add(cookies ...*http.Cookie) {
+	for _, c := range cookies {
+		host := strings.TrimPrefix(c.Domain, ".")
+		if host == "" || host != c.Domain && isPublicSuffix(host) {
+			continue
+		}
+		u := &nurl.URL{Scheme: "http", Host: host, Path: c.Path}
+		if c.Secure {
+			u.Scheme = "https"
+		}
+		c := *c
+		if !strings.HasPrefix(c.Domain, ".") {
+			c.Domain = ""
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			c.Path = "/"
+		}
+		j.SetCookies(u, []*http.Cookie{&c})
+	}
+}
+
+// All returns the cookies in the jar that have not expired, ordered by
+// domain, path and name.
+func (j *CookieJar) All() []*http.Cookie {
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := make([]*http.Cookie, 0, len(j.cookies))
+	for key, c := range j.cookies {
+		if !c.Expires.IsZero() && !c.Expires.After(now) {
+			delete(j.cookies, key)
+			continue
+		}
+		cc := *c
+		res = append(res, &cc)
+	}
+	sort.Slice(res, func(a, b int) bool { return cookieKey(res[a]) < cookieKey(res[b]) })
+	return res
+}
+
+// Save writes the cookies in the jar to path, as JSON when the path ends in
+// .json and as a Netscape cookies.txt file otherwise. The file is readable
+// by its owner only.
+func (j *CookieJar) Save(path string) error {
+	var (
+		data []byte
+		err  error
+	)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err = marshalJSONCookies(j.All())
+	} else {
+		data = marshalNetscapeCookies(j.All())
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// isPublicSuffix reports whether domain is a public suffix, such as "com",
+// "co.uk" or "github.io".
+func isPublicSuffix(domain string) bool {
+	return publicsuffix.List.PublicSuffix(domain) == domain
+}
+
+func cookieKey(c *http.Cookie) string {
+	return c.Domain + "\x00" + c.Path + "\x00" + c.Name
+}
+
+// defaultCookiePath is the path a cookie without one applies to: the
+// directory of the request path.
+func defaultCookiePath(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
+
+// ReadCookies parses a Netscape cookies.txt file, as written by curl, wget
+// and browser extensions, or a JSON array of cookies as exported by browser
+// extensions, Playwright or Puppeteer. A JSON object with a "cookies" array,
+// like a Playwright storage state, works too.
+func ReadCookies(data []byte) ([]*http.Cookie, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return readJSONCookies(trimmed)
+	}
+	return readNetscapeCookies(data)
+}
+
+const httpOnlyPrefix = "#HttpOnly_"
+
+func readNetscapeCookies(data []byte) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("line %d: want 7 tab-separated fields, got %d", n, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", n, fields[4])
+		}
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    strings.Join(fields[6:], "\t"),
+			Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			c.Domain = "." + c.Domain
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, sc.Err()
+}
+
+func marshalNetscapeCookies(cookies []*http.Cookie) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range cookies {
+		if c.HttpOnly {
+			b.WriteString(httpOnlyPrefix)
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			c.Domain, netscapeBool(strings.HasPrefix(c.Domain, ".")), c.Path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return b.Bytes()
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// jsonCookie is a cookie in the JSON exports of browser extensions
+// (expirationDate, hostOnly) and of Playwright and Puppeteer (expires).
+type jsonCookie struct {
+	Name           string  `json:"name"`
+	Value          string  `json:"value"`
+	Domain         string  `json:"domain"`
+	HostOnly       *bool   `json:"hostOnly,omitempty"`
+	Path           string  `json:"path"`
+	Secure         bool    `json:"secure"`
+	HTTPOnly       bool    `json:"httpOnly"`
+	SameSite       string  `json:"sameSite,omitempty"`
+	Session        bool    `json:"session,omitempty"`
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	Expires        float64 `json:"expires,omitempty"`
+}
+
+func readJSONCookies(data []byte) ([]*http.Cookie, error) {
+	var list []jsonCookie
+	if data[0] == '{' {
+		var state struct {
+			Cookies *[]jsonCookie `json:"cookies"`
+		}
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("invalid JSON cookies: %w", err)
+		}
+		if state.Cookies == nil {
+			return nil, fmt.Errorf(`invalid JSON cookies: want an array or an object with a "cookies" array`)
+		}
+		list = *state.Cookies
+	} else if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid JSON cookies: %w", err)
+	}
+
+	cookies := make([]*http.Cookie, 0, len(list))
+	for i, jc := range list {
+		if jc.Name == "" || jc.Domain == "" {
+			return nil, fmt.Errorf("cookie %d: name and domain are required", i+1)
+		}
+		hostOnly := !strings.HasPrefix(jc.Domain, ".")
+		if jc.HostOnly != nil {
+			hostOnly = *jc.HostOnly
+		}
+		c := &http.Cookie{
+			Name:     jc.Name,
+			Value:    jc.Value,
+			Domain:   strings.TrimPrefix(strings.ToLower(jc.Domain), "."),
+			Path:     jc.Path,
+			Secure:   jc.Secure,
+			HttpOnly: jc.HTTPOnly,
+			SameSite: parseSameSite(jc.SameSite),
+		}
+		if !hostOnly {
+			c.Domain = "." + c.Domain
+		}
+		if expires := max(jc.ExpirationDate, jc.Expires); expires > 0 && !jc.Session {
+			c.Expires = time.Unix(int64(expires), 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, nil
+}
+
+func marshalJSONCookies(cookies []*http.Cookie) ([]byte, error) {
+	list := make([]jsonCookie, 0, len(cookies))
+	for _, c := range cookies {
+		hostOnly := !strings.HasPrefix(c.Domain, ".")
+		jc := jsonCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			HostOnly: &hostOnly,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+			SameSite: sameSiteName(c.SameSite),
+			Session:  c.Expires.IsZero(),
+		}
+		if !c.Expires.IsZero() {
+			jc.ExpirationDate = float64(c.Expires.Unix())
+		}
+		list = append(list, jc)
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func parseSameSite(s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none", "no_restriction":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+func sameSiteName(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
+
+// browserCookies returns the jar's cookies as browser cookie parameters.
+func (j *CookieJar) browserCookies() []*network.CookieParam {
+	cookies := j.All()
+	res := make([]*network.CookieParam, 0, len(cookies))
+	for _, c := range cookies {
+		p := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+			SameSite: network.CookieSameSite(sameSiteName(c.SameSite)),
+		}
+		if strings.HasPrefix(c.Domain, ".") {
+			p.Domain = c.Domain
+		} else {
+			// Chrome makes a cookie with a domain a domain cookie, so
+			// host-only cookies are set by URL.
+			scheme := "http"
+			if c.Secure {
+				scheme = "https"
+			}
+			p.URL = scheme + "://" + c.Domain + c.Path
+		}
+		if !c.Expires.IsZero() {
+			expires := cdp.TimeSinceEpoch(c.Expires)
+			p.Expires = &expires
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// addBrowserCookies stores cookies read from the browser.
+func (j *CookieJar) addBrowserCookies(cookies []*network.Cookie) {
+	res := make([]*http.Cookie, 0, len(cookies))
+	for _, bc := range cookies {
+		c := &http.Cookie{
+			Name:     bc.Name,
+			Value:    bc.Value,
+			Domain:   bc.Domain,
+			Path:     bc.Path,
+			Secure:   bc.Secure,
+			HttpOnly: bc.HTTPOnly,
+			SameSite: parseSameSite(string(bc.SameSite)),
+		}
+		if !bc.Session && bc.Expires > 0 {
+			c.Expires = time.Unix(int64(bc.Expires), 0)
+		}
+		res = append(res, c)
+	}
+	j.Add(res...)
+}
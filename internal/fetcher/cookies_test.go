@@ -0,0 +1,241 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	nurl "net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadCookiesNetscape(t *testing.T) {
+	cookies, err := ReadCookies([]byte("# Netscape HTTP Cookie File\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tTRUE\t4102444800\tsid\tabc\n" +
+		"#HttpOnly_docs.example.com\tFALSE\t/api\tFALSE\t0\ttoken\ta\tb\n" +
+		"# a comment\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 2 {
+		t.Fatalf("got %d cookies", len(cookies))
+	}
+	if c := cookies[0]; c.Domain != ".example.com" || c.Name != "sid" || c.Value != "abc" || !c.Secure || c.HttpOnly || c.Expires.Unix() != 4102444800 {
+		t.Fatalf("domain cookie: %+v", c)
+	}
+	if c := cookies[1]; c.Domain != "docs.example.com" || c.Path != "/api" || c.Value != "a\tb" || !c.HttpOnly || !c.Expires.IsZero() {
+		t.Fatalf("host-only cookie: %+v", c)
+	}
+
+	if _, err := ReadCookies([]byte("example.com\tFALSE\t/\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected a line error, got %v", err)
+	}
+}
+
+func TestReadCookiesJSON(t *testing.T) {
+	extension := `[
+  {"domain": ".example.com", "hostOnly": false, "name": "sid", "value": "abc", "path": "/", "secure": true, "httpOnly": true, "sameSite": "lax", "expirationDate": 4102444800.5},
+  {"domain": "docs.example.com", "hostOnly": true, "name": "pref", "value": "dark", "path": "/", "session": true}
+]`
+	playwright := `{"cookies": [
+  {"name": "sid", "value": "abc", "domain": ".example.com", "path": "/", "expires": 4102444800, "httpOnly": true, "secure": true, "sameSite": "Lax"},
+  {"name": "pref", "value": "dark", "domain": "docs.example.com", "path": "/", "expires": -1, "httpOnly": false, "secure": false, "sameSite": "None"}
+], "origins": []}`
+	for name, data := range map[string]string{"extension": extension, "playwright": playwright} {
+		cookies, err := ReadCookies([]byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(cookies) != 2 {
+			t.Fatalf("%s: got %d cookies", name, len(cookies))
+		}
+		if c := cookies[0]; c.Domain != ".example.com" || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Expires.Unix() != 4102444800 {
+			t.Fatalf("%s: domain cookie %+v", name, c)
+		}
+		if c := cookies[1]; c.Domain != "docs.example.com" || !c.Expires.IsZero() {
+			t.Fatalf("%s: host-only cookie %+v", name, c)
+		}
+	}
+
+	if _, err := ReadCookies([]byte(`{"origins": []}`)); err == nil {
+		t.Fatal("expected an error for an object without cookies")
+	}
+}
+
+func TestCookieJarScopesCookies(t *testing.T) {
+	jar := NewCookieJar()
+	jar.Add(
+		&http.Cookie{Name: "sid", Value: "1", Domain: ".example.com", Path: "/"},
+		&http.Cookie{Name: "api", Value: "2", Domain: "docs.example.com", Path: "/api"},
+		&http.Cookie{Name: "old", Value: "3", Domain: "example.com", Path: "/", Expires: time.Now().Add(-time.Hour)},
+	)
+	for rawURL, want := range map[string][]string{
+		"https://example.com/":             {"sid"},
+		"https://docs.example.com/api/v1":  {"api", "sid"},
+		"https://docs.example.com/":        {"sid"},
+		"https://sub.docs.example.com/api": {"sid"},
+		"https://example.org/":             nil,
+	} {
+		u, _ := nurl.Parse(rawURL)
+		var got []string
+		for _, c := range jar.Cookies(u) {
+			got = append(got, c.Name)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("Cookies(%s) = %v, want %v", rawURL, got, want)
+		}
+	}
+	if n := len(jar.All()); n != 2 {
+		t.Fatalf("All() has %d cookies, want 2", n)
+	}
+}
+
+func TestCookieJarRefusesPublicSuffixes(t *testing.T) {
+	jar := NewCookieJar()
+	evil, _ := nurl.Parse("https://evil.co.uk/")
+	jar.SetCookies(evil, []*http.Cookie{
+		{Name: "tracker", Value: "1", Domain: "co.uk", Path: "/"},
+		{Name: "own", Value: "2", Domain: "evil.co.uk", Path: "/"},
+	})
+	jar.Add(&http.Cookie{Name: "imported", Value: "3", Domain: ".com", Path: "/"})
+
+	bank, _ := nurl.Parse("https://bank.co.uk/")
+	if got := jar.Cookies(bank); len(got) != 0 {
+		t.Fatalf("bank.co.uk got cookies %v", got)
+	}
+	if got := jar.Cookies(evil); len(got) != 1 || got[0].Name != "own" {
+		t.Fatalf("evil.co.uk got cookies %v", got)
+	}
+	var names []string
+	for _, c := range jar.All() {
+		names = append(names, c.Domain+" "+c.Name)
+	}
+	if !slices.Equal(names, []string{".evil.co.uk own"}) {
+		t.Fatalf("jar keeps %q", names)
+	}
+}
+
+func TestFetchWithCookieJar(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "fresh", Path: "/"})
+			http.Redirect(w, r, "/docs/page", http.StatusFound)
+		default:
+			var names []string
+			for _, c := range r.Cookies() {
+				names = append(names, c.Name+"="+c.Value)
+			}
+			slices.Sort(names)
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(strings.Join(names, " ")))
+		}
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	host = host[:strings.LastIndex(host, ":")]
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.txt")
+	jar := NewCookieJar()
+	jar.Add(
+		&http.Cookie{Name: "sid", Value: "file", Domain: host, Path: "/docs"},
+		&http.Cookie{Name: "admin", Value: "no", Domain: host, Path: "/admin"},
+		&http.Cookie{Name: "other", Value: "no", Domain: ".example.com", Path: "/"},
+	)
+	if err := jar.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCookieJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeRaw
+	cfg.Timeout = 5 * time.Second
+	cfg.CookieJar = loaded
+	res, err := Fetch(context.Background(), ts.URL+"/login", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The cookie set before the redirect is sent after it, with the file's
+	// cookie for /docs but not those of other paths and domains.
+	if res.Markdown != "session=fresh sid=file" {
+		t.Fatalf("server saw cookies %q", res.Markdown)
+	}
+
+	jsonPath := filepath.Join(dir, "cookies.json")
+	if err := loaded.Save(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := LoadCookieJar(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range saved.All() {
+		names = append(names, c.Domain+" "+c.Path+" "+c.Name)
+	}
+	want := []string{".example.com / other", host + " / session", host + " /admin admin", host + " /docs sid"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("saved cookies %q, want %q", names, want)
+	}
+}
+
+func TestFetchWithCookieJarSkipsCache(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := r.Cookie("user")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write([]byte("hello " + c.Value))
+	}))
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+	host = host[:strings.LastIndex(host, ":")]
+
+	cache, err := OpenCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Mode = ModeRaw
+	cfg.Timeout = 5 * time.Second
+	cfg.Cache = cache
+	for _, user := range []string{"alice", "bob"} {
+		jar := NewCookieJar()
+		jar.Add(&http.Cookie{Name: "user", Value: user, Domain: host, Path: "/"})
+		cfg.CookieJar = jar
+		res, err := Fetch(context.Background(), ts.URL, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Markdown != "hello "+user {
+			t.Fatalf("%s got %q", user, res.Markdown)
+		}
+	}
+}
+
+func TestBrowserCookies(t *testing.T) {
+	jar := NewCookieJar()
+	expires := time.Unix(4102444800, 0)
+	jar.Add(
+		&http.Cookie{Name: "sid", Value: "1", Domain: ".example.com", Path: "/", Expires: expires, SameSite: http.SameSiteLaxMode},
+		&http.Cookie{Name: "pref", Value: "2", Domain: "docs.example.com", Path: "/guide", Secure: true},
+	)
+	params := jar.browserCookies()
+	if len(params) != 2 {
+		t.Fatalf("got %d cookie params", len(params))
+	}
+	if p := params[0]; p.Domain != ".example.com" || p.URL != "" || p.SameSite != "Lax" || p.Expires == nil || !time.Time(*p.Expires).Equal(expires) {
+		t.Fatalf("domain cookie param: %+v", p)
+	}
+	if p := params[1]; p.Domain != "" || p.URL != "https://docs.example.com/guide" || p.Expires != nil {
+		t.Fatalf("host-only cookie param: %+v", p)
+	}
+}
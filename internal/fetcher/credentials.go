@@ -0,0 +1,504 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	nurl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// ErrCredentialHelper is wrapped by the errors passed to the warning function
+// of Credentials when the credential helper fails or its answer cannot be
+// read.
+var ErrCredentialHelper = errors.New("credential helper failed")
+
+// Credentials supplies authentication headers by host, from a .netrc file
+// and a credential helper. The headers for a URL are added to requests for
+// that URL only: each redirect hop and each browser request gets the
+// credentials of its own host, so one host's credentials never reach
+// another.
+type Credentials struct {
+	netrc  []netrcMachine
+	helper string
+	// helperHosts are the host patterns whose browser subrequests ask the
+	// helper; see SetHelperHosts.
+	helperHosts []string
+	// warn, if set, is told about helper failures; see SetWarn.
+	warn func(error)
+
+	mu sync.Mutex
+	// helped holds the helper's answers by scheme and host, failures
+	// included, and the runs still in flight.
+	helped map[string]*helperCall
+	// issued records the header values handed to the browser, by canonical
+	// header name, so they can be taken off requests for other hosts.
+	issued map[string]map[string]bool
+}
+
+// helperCall is one run of the credential helper. header and err are set
+// once done is closed.
+type helperCall struct {
+	done   chan struct{}
+	header http.Header
+	err    error
+}
+
+// netrcMachine is a machine entry of a .netrc file.
+type netrcMachine struct {
+	host, login, password string
+}
+
+// NewCredentials returns credentials from the .netrc file at netrcPath and
+// the credential helper command helper. Either may be empty.
+//
+// The helper is run through the shell with the argument "get", as git runs
+// its credential helpers, and is sent the protocol and host of each URL as
+// key=value lines on stdin. It answers with key=value lines: username and
+// password for basic auth, authtype and credential for an Authorization
+// header (e.g. authtype=Bearer), or any number of "header=Name: value"
+// lines. An empty answer, or a failure, leaves the host to .netrc.
+//
+// Errors name the source that failed: "netrc: ..." or "credential helper: ...".
+func NewCredentials(netrcPath, helper string) (*Credentials, error) {
+	c := &Credentials{
+		helper: strings.TrimSpace(helper),
+		helped: make(map[string]*helperCall),
+		issued: make(map[string]map[string]bool),
+	}
+	if helper != "" && c.helper == "" {
+		return nil, errors.New("credential helper: empty command")
+	}
+	if netrcPath != "" {
+		data, err := os.ReadFile(netrcPath)
+		if err != nil {
+			return nil, fmt.Errorf("netrc: %w", err)
+		}
+		if c.netrc, err = parseNetrc(data); err != nil {
+			return nil, fmt.Errorf("netrc: %s: %w", netrcPath, err)
+		}
+	}
+	return c, nil
+}
+
+// SetWarn sets a function to call when the credential helper fails for a
+// host, with an error wrapping ErrCredentialHelper. The host then falls back
+// to its .netrc entry, or gets no credentials, and the fetch goes on. warn is
+// called once per failed host and may be called concurrently.
+func (c *Credentials) SetWarn(warn func(error)) {
+	c.warn = warn
+}
+
+// SetHelperHosts lists the hosts, as names or "*.example.com" patterns, whose
+// subrequests in browser renders are authenticated through the credential
+// helper. Otherwise the browser asks the helper only about the host of the
+// page it renders and the hosts of .netrc entries, so pages with many
+// third-party resources do not run it for each of their hosts.
+func (c *Credentials) SetHelperHosts(hosts ...string) {
+	c.helperHosts = hosts
+}
+
+// DefaultNetrcPath returns $NETRC, or .netrc in the home directory (_netrc
+// on Windows).
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name)
+}
+
+// parseNetrc reads the machine entries of a .netrc file. The default entry
+// is skipped, so credentials only go to the hosts named, and so are macdef
+// macros.
+func parseNetrc(data []byte) ([]netrcMachine, error) {
+	var (
+		machines []netrcMachine
+		cur      *netrcMachine
+		inMacro  bool
+	)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		tokens, err := netrcTokens(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		for i := 0; i < len(tokens); i++ {
+			key := tokens[i]
+			if key == "default" {
+				cur = nil
+				continue
+			}
+			if key == "macdef" {
+				inMacro = true
+				break
+			}
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("line %d: %q needs a value", n, key)
+			}
+			i++
+			val := tokens[i]
+			switch key {
+			case "machine":
+				machines = append(machines, netrcMachine{host: strings.ToLower(val)})
+				cur = &machines[len(machines)-1]
+			case "login":
+				if cur != nil {
+					cur.login = val
+				}
+			case "password":
+				if cur != nil {
+					cur.password = val
+				}
+			case "account":
+			default:
+				return nil, fmt.Errorf("line %d: unknown token %q", n, key)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	res := machines[:0]
+	for _, m := range machines {
+		if m.login != "" {
+			res = append(res, m)
+		}
+	}
+	return res, nil
+}
+
+// netrcTokens splits a .netrc line into tokens, which may be double-quoted
+// with backslash escapes. A "#" token starts a comment.
+func netrcTokens(line string) ([]string, error) {
+	var tokens []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" || line[0] == '#' {
+			return tokens, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t\r")
+			if end < 0 {
+				end = len(line)
+			}
+			tokens = append(tokens, line[:end])
+			line = line[end:]
+			continue
+		}
+		var tok strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			tok.WriteByte(line[i])
+		}
+		if i >= len(line) {
+			return nil, errors.New("unterminated quote")
+		}
+		tokens = append(tokens, tok.String())
+		line = line[i+1:]
+	}
+}
+
+// forURL returns the credential headers for u: the credential helper's, or
+// those of the .netrc entry for its host when the helper has none or fails.
+// It returns nil for other hosts, and an error only when ctx is done.
+func (c *Credentials) forURL(ctx context.Context, u *nurl.URL) (http.Header, error) {
+	return c.headers(ctx, u, true)
+}
+
+// headers is forURL, asking the credential helper only when useHelper is set.
+func (c *Credentials) headers(ctx context.Context, u *nurl.URL, useHelper bool) (http.Header, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil
+	}
+	if c.helper != "" && useHelper {
+		h, err := c.fromHelper(ctx, u)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil && len(h) > 0 {
+			return h, nil
+		}
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, m := range c.netrc {
+		if m.host == host {
+			return http.Header{"Authorization": {basicAuth(m.login, m.password)}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// fromHelper asks the credential helper for the headers of u's host, once
+// per scheme and host: concurrent callers share one run, and its answer,
+// even a failure, is kept for later ones. A failure is passed to the warning
+// function when it is kept. The lock is not held while the helper runs.
+func (c *Credentials) fromHelper(ctx context.Context, u *nurl.URL) (http.Header, error) {
+	key := u.Scheme + "://" + strings.ToLower(u.Host)
+	for {
+		c.mu.Lock()
+		call, ok := c.helped[key]
+		if !ok {
+			call = &helperCall{done: make(chan struct{})}
+			c.helped[key] = call
+			c.mu.Unlock()
+
+			call.header, call.err = c.runHelper(ctx, u)
+			if ctx.Err() != nil {
+				// Cut short by this caller, so not the helper's answer.
+				c.mu.Lock()
+				delete(c.helped, key)
+				c.mu.Unlock()
+			} else if call.err != nil && c.warn != nil {
+				c.warn(call.err)
+			}
+			close(call.done)
+			return call.header, call.err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mu.Lock()
+		kept := c.helped[key] == call
+		c.mu.Unlock()
+		if kept {
+			return call.header, call.err
+		}
+	}
+}
+
+// runHelper runs the credential helper for u's host.
+func (c *Credentials) runHelper(ctx context.Context, u *nurl.URL) (http.Header, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.helper+" get")
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", c.helper+" get")
+	}
+	cmd.Stdin = strings.NewReader("capability[]=authtype\nprotocol=" + u.Scheme + "\nhost=" + u.Host + "\n\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w for %s: %v: %s", ErrCredentialHelper, u.Host, err, msg)
+		}
+		return nil, fmt.Errorf("%w for %s: %v", ErrCredentialHelper, u.Host, err)
+	}
+	h, err := parseHelperAnswer(out)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: %v", ErrCredentialHelper, u.Host, err)
+	}
+	return h, nil
+}
+
+// helperAllowed reports whether browser subrequests to host ask the
+// credential helper: host has a .netrc entry or matches SetHelperHosts.
+func (c *Credentials) helperAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, m := range c.netrc {
+		if m.host == host {
+			return true
+		}
+	}
+	for _, pattern := range c.helperHosts {
+		if (HostRule{Host: pattern}).Matches(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHelperAnswer turns a credential helper's key=value lines into headers.
+func parseHelperAnswer(out []byte) (http.Header, error) {
+	h := make(http.Header)
+	var username, password, authtype, credential string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid line %q: want key=value", line)
+		}
+		switch key {
+		case "username":
+			username = val
+		case "password":
+			password = val
+		case "authtype":
+			authtype = val
+		case "credential":
+			credential = val
+		case "header":
+			name, value, ok := strings.Cut(val, ":")
+			if name = strings.TrimSpace(name); !ok || name == "" {
+				return nil, fmt.Errorf("invalid header %q: want Name: value", val)
+			}
+			h.Add(name, strings.TrimSpace(value))
+		}
+	}
+	switch {
+	case authtype != "" && credential != "":
+		h.Set("Authorization", authtype+" "+credential)
+	case username != "":
+		h.Set("Authorization", basicAuth(username, password))
+	}
+	return h, nil
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// credentialTransport adds the credentials for each request's own URL, so
+// redirects to other hosts do not carry them. Headers already on a request
+// are left alone.
+type credentialTransport struct {
+	base  http.RoundTripper
+	creds *Credentials
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h, err := t.creds.forURL(req.Context(), req.URL)
+	if err != nil {
+		return nil, err
+	}
+	if len(h) > 0 {
+		req = req.Clone(req.Context())
+		for name, vals := range h {
+			if req.Header.Get(name) == "" {
+				req.Header[name] = vals
+			}
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// browserHeaders returns the headers a paused browser request continues
+// with, and whether they differ from its own: credentials issued for other
+// hosts are removed, as Chrome may carry them over a redirect, and those for
+// the request's URL are added. The helper is asked about pageHost, the host
+// of the rendered page, and the hosts helperAllowed accepts.
+func (c *Credentials) browserHeaders(ctx context.Context, req *network.Request, pageHost string) ([]*fetch.HeaderEntry, bool) {
+	u, err := nurl.Parse(req.URL)
+	if err != nil {
+		return nil, false
+	}
+	host := u.Hostname()
+	creds, err := c.headers(ctx, u, strings.EqualFold(host, pageHost) || c.helperAllowed(host))
+	if err != nil {
+		creds = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		entries []*fetch.HeaderEntry
+		changed bool
+		present = make(map[string]bool)
+	)
+	for name, v := range req.Headers {
+		val := fmt.Sprint(v)
+		canonical := http.CanonicalHeaderKey(name)
+		if c.issued[canonical][val] && !slices.Contains(creds.Values(canonical), val) {
+			changed = true
+			continue
+		}
+		present[canonical] = true
+		entries = append(entries, &fetch.HeaderEntry{Name: name, Value: val})
+	}
+	for name, vals := range creds {
+		if present[name] {
+			continue
+		}
+		if c.issued[name] == nil {
+			c.issued[name] = make(map[string]bool)
+		}
+		for _, val := range vals {
+			c.issued[name][val] = true
+			entries = append(entries, &fetch.HeaderEntry{Name: name, Value: val})
+			changed = true
+		}
+	}
+	return entries, changed
+}
+
+// browserInterception returns the action that pauses the requests of the tab
+// of ctx, which renders pageURL, to answer the proxy's credential challenges
+// and to add creds to the requests of matching hosts, or nil when neither is
+// needed.
+func browserInterception(ctx context.Context, pageURL, proxy string, creds *Credentials) (chromedp.Action, error) {
+	proxyUser, err := browserProxyUser(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if proxyUser == nil && creds == nil {
+		return nil, nil
+	}
+	var pageHost string
+	if u, err := nurl.Parse(pageURL); err == nil {
+		pageHost = u.Hostname()
+	}
+	// With interception on, every request pauses and must be continued.
+	chromedp.ListenTarget(ctx, func(ev any) {
+		switch ev := ev.(type) {
+		case *fetch.EventRequestPaused:
+			go func() {
+				action := fetch.ContinueRequest(ev.RequestID)
+				if creds != nil {
+					if headers, changed := creds.browserHeaders(ctx, ev.Request, pageHost); changed {
+						action = action.WithHeaders(headers)
+					}
+				}
+				_ = chromedp.Run(ctx, action)
+			}()
+		case *fetch.EventAuthRequired:
+			resp := &fetch.AuthChallengeResponse{Response: fetch.AuthChallengeResponseResponseDefault}
+			if ev.AuthChallenge.Source == fetch.AuthChallengeSourceProxy && proxyUser != nil {
+				password, _ := proxyUser.Password()
+				resp = &fetch.AuthChallengeResponse{
+					Response: fetch.AuthChallengeResponseResponseProvideCredentials,
+					Username: proxyUser.Username(),
+					Password: password,
+				}
+			}
+			go func() { _ = chromedp.Run(ctx, fetch.ContinueWithAuth(ev.RequestID, resp)) }()
+		}
+	})
+	return fetch.Enable().WithHandleAuthRequests(proxyUser != nil), nil
+}
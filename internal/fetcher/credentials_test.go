@@ -0,0 +1,350 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestParseNetrc(t *testing.T) {
+	machines, err := parseNetrc([]byte(`# personal tokens
+machine api.example.com login alice password s3cret
+machine Docs.Example.com
+  login bob
+  password "pass word \"quoted\""
+  account ignored
+macdef init
+cd /pub
+machine in.macro login x password y
+
+default login anonymous password guest
+machine nologin.example.com password z
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netrcMachine{
+		{host: "api.example.com", login: "alice", password: "s3cret"},
+		{host: "docs.example.com", login: "bob", password: `pass word "quoted"`},
+	}
+	if len(machines) != len(want) {
+		t.Fatalf("got %+v", machines)
+	}
+	for i := range want {
+		if machines[i] != want[i] {
+			t.Fatalf("machine %d = %+v, want %+v", i, machines[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"machine", "machine a login", "machine a bogus b", `machine a password "open`} {
+		if _, err := parseNetrc([]byte(bad)); err == nil {
+			t.Errorf("parseNetrc(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestParseHelperAnswer(t *testing.T) {
+	h, err := parseHelperAnswer([]byte("authtype=Bearer\ncredential=tok\nheader=X-Api-Key: k1\nheader=X-Api-Key: k2\nquit=0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("Authorization") != "Bearer tok" || strings.Join(h.Values("X-Api-Key"), ",") != "k1,k2" {
+		t.Fatalf("headers %v", h)
+	}
+	if h, _ := parseHelperAnswer([]byte("username=user\npassword=secret\n")); h.Get("Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Fatalf("basic auth header %v", h)
+	}
+	if h, err := parseHelperAnswer(nil); err != nil || len(h) != 0 {
+		t.Fatalf("empty answer: %v, %v", h, err)
+	}
+	for _, bad := range []string{"no equals sign", "header=NoColon"} {
+		if _, err := parseHelperAnswer([]byte(bad)); err == nil {
+			t.Errorf("parseHelperAnswer(%q) succeeded", bad)
+		}
+	}
+}
+
+// writeHelper writes a credential helper script that answers for host only
+// and counts its runs in dir/runs.
+func writeHelper(t *testing.T, dir, host string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper test uses sh")
+	}
+	script := filepath.Join(dir, "helper.sh")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+[ "$1" = get ] || exit 1
+echo run >> "`+filepath.Join(dir, "runs")+`"
+while IFS= read -r line && [ -n "$line" ]; do
+	case "$line" in host=*) host=${line#host=} ;; esac
+done
+if [ "$host" = "`+host+`" ]; then
+	echo authtype=Bearer
+	echo credential=helper-token
+	echo "header=X-Api-Key: k1"
+fi
+`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestFetchCredentialsStayOnTheirHost(t *testing.T) {
+	var leaked atomic.Value
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked.Store(r.Header.Get("Authorization") + r.Header.Get("X-Api-Key"))
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("other"))
+	}))
+	defer other.Close()
+	// Reached as localhost, so it is another host than 127.0.0.1.
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	var auth, apiKey atomic.Value
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.Store(r.Header.Get("Authorization"))
+		apiKey.Store(r.Header.Get("X-Api-Key"))
+		if r.URL.Path == "/away" {
+			http.Redirect(w, r, otherURL+"/landing", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("origin"))
+	}))
+	defer origin.Close()
+	originHost := strings.TrimPrefix(origin.URL, "http://")
+
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	if err := os.WriteFile(netrc, []byte("machine 127.0.0.1 login user password secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		helper   bool
+		wantAuth string
+		wantKey  string
+	}{
+		"netrc":  {wantAuth: "Basic dXNlcjpzZWNyZXQ="},
+		"helper": {helper: true, wantAuth: "Bearer helper-token", wantKey: "k1"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			helper := ""
+			if tt.helper {
+				helper = writeHelper(t, t.TempDir(), originHost)
+			}
+			creds, err := NewCredentials(netrc, helper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := DefaultConfig()
+			cfg.Mode = ModeRaw
+			cfg.Timeout = 5 * time.Second
+			cfg.Credentials = creds
+
+			if _, err := Fetch(context.Background(), origin.URL, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if auth.Load() != tt.wantAuth || apiKey.Load() != tt.wantKey {
+				t.Fatalf("origin saw Authorization %q, X-Api-Key %q", auth.Load(), apiKey.Load())
+			}
+
+			leaked.Store("unset")
+			res, err := Fetch(context.Background(), origin.URL+"/away", cfg)
+			if err != nil || res.Markdown != "other" {
+				t.Fatalf("redirect: %q, %v", res.Markdown, err)
+			}
+			if auth.Load() != tt.wantAuth {
+				t.Fatalf("origin saw Authorization %q before the redirect", auth.Load())
+			}
+			if got := leaked.Load(); got != "" {
+				t.Fatalf("credentials followed the redirect to another host: %q", got)
+			}
+
+			// An explicit header wins over the credentials.
+			cfg.Headers = http.Header{"Authorization": {"Bearer explicit"}}
+			if _, err := Fetch(context.Background(), origin.URL, cfg); err != nil {
+				t.Fatal(err)
+			}
+			if auth.Load() != "Bearer explicit" {
+				t.Fatalf("origin saw Authorization %q", auth.Load())
+			}
+		})
+	}
+}
+
+func TestFetchCacheKeepsIdentitiesApart(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write([]byte("hello " + user))
+	}))
+	defer ts.Close()
+
+	cache, err := OpenCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.Mode = ModeRaw
+	cfg.Timeout = 5 * time.Second
+	cfg.Cache = cache
+	for _, user := range []string{"alice", "bob", "alice"} {
+		netrc := filepath.Join(dir, user)
+		if err := os.WriteFile(netrc, []byte("machine 127.0.0.1 login "+user+" password pw\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Credentials, err = NewCredentials(netrc, ""); err != nil {
+			t.Fatal(err)
+		}
+		res, err := Fetch(context.Background(), ts.URL, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Markdown != "hello "+user {
+			t.Fatalf("%s got %q", user, res.Markdown)
+		}
+	}
+}
+
+func TestCredentialHelperRunsOncePerHost(t *testing.T) {
+	dir := t.TempDir()
+	creds, err := NewCredentials("", writeHelper(t, dir, "api.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rawURL := range []string{"https://api.example.com/a", "https://api.example.com/b", "https://www.example.com/"} {
+		u, _ := nurl.Parse(rawURL)
+		h, err := creds.forURL(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.HasPrefix(rawURL, "https://api."); (h.Get("Authorization") != "") != want {
+			t.Fatalf("%s: headers %v", rawURL, h)
+		}
+	}
+	runs, _ := os.ReadFile(filepath.Join(dir, "runs"))
+	if n := strings.Count(string(runs), "run"); n != 2 {
+		t.Fatalf("helper ran %d times, want once per host", n)
+	}
+
+	// Concurrent lookups for a new host share one run.
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, _ := nurl.Parse("https://api.example.com:8443/")
+			if _, err := creds.forURL(context.Background(), u); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	runs, _ = os.ReadFile(filepath.Join(dir, "runs"))
+	if n := strings.Count(string(runs), "run"); n != 3 {
+		t.Fatalf("helper ran %d times, want once more for the new host", n)
+	}
+
+	// Failures are kept too, and warned about once; the host falls back to
+	// .netrc and other hosts get no credentials.
+	fails := filepath.Join(dir, "fails")
+	netrc := filepath.Join(dir, "netrc")
+	if err := os.WriteFile(netrc, []byte("machine api.example.com login user password secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	failing, err := NewCredentials(netrc, "echo run >> '"+fails+"'; exit 3;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warned []error
+	failing.SetWarn(func(err error) { warned = append(warned, err) })
+	u, _ := nurl.Parse("https://api.example.com/")
+	for range 2 {
+		h, err := failing.forURL(context.Background(), u)
+		if err != nil || h.Get("Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+			t.Fatalf("failing helper: headers %v, err %v, want the .netrc entry", h, err)
+		}
+	}
+	u, _ = nurl.Parse("https://www.example.com/")
+	if h, err := failing.forURL(context.Background(), u); err != nil || h != nil {
+		t.Fatalf("failing helper for another host: headers %v, err %v", h, err)
+	}
+	if runs, _ := os.ReadFile(fails); strings.Count(string(runs), "run") != 2 {
+		t.Fatalf("failing helper ran %q, want once per host", runs)
+	}
+	if len(warned) != 2 || !errors.Is(warned[0], ErrCredentialHelper) {
+		t.Fatalf("warnings %v, want one ErrCredentialHelper per host", warned)
+	}
+}
+
+func TestBrowserHeadersScopeCredentials(t *testing.T) {
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	if err := os.WriteFile(netrc, []byte("machine docs.example.com login user password secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds, err := NewCredentials(netrc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	headers, changed := creds.browserHeaders(ctx, &network.Request{URL: "https://docs.example.com/", Headers: network.Headers{"Accept": "text/html"}}, "docs.example.com")
+	if !changed || len(headers) != 2 {
+		t.Fatalf("docs request headers %+v", headers)
+	}
+	// A redirect to another host arrives with the header Chrome carried over.
+	headers, changed = creds.browserHeaders(ctx, &network.Request{URL: "https://cdn.example.net/", Headers: network.Headers{
+		"Accept":        "text/html",
+		"Authorization": "Basic dXNlcjpzZWNyZXQ=",
+	}}, "docs.example.com")
+	if !changed || len(headers) != 1 || headers[0].Name != "Accept" {
+		t.Fatalf("cdn request headers %+v", headers)
+	}
+	// The page's own Authorization header for another host is kept.
+	if _, changed := creds.browserHeaders(ctx, &network.Request{URL: "https://api.example.net/", Headers: network.Headers{"Authorization": "Bearer page"}}, "docs.example.com"); changed {
+		t.Fatal("page header was changed")
+	}
+}
+
+func TestBrowserHeadersAskHelperForChosenHosts(t *testing.T) {
+	dir := t.TempDir()
+	creds, err := NewCredentials("", writeHelper(t, dir, "api.example.net"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	req := &network.Request{URL: "https://api.example.net/v1", Headers: network.Headers{}}
+
+	// A subrequest to another host than the page's does not run the helper.
+	if headers, changed := creds.browserHeaders(ctx, req, "docs.example.com"); changed {
+		t.Fatalf("subrequest headers %+v", headers)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "runs")); !os.IsNotExist(err) {
+		t.Fatal("helper ran for a subrequest host")
+	}
+	// The page's own host does, and so do the hosts chosen for it.
+	if _, changed := creds.browserHeaders(ctx, req, "api.example.net"); !changed {
+		t.Fatal("page request got no credentials")
+	}
+	creds.SetHelperHosts("*.example.net")
+	if _, changed := creds.browserHeaders(ctx, req, "docs.example.com"); !changed {
+		t.Fatal("subrequest to a helper host got no credentials")
+	}
+}
@@ -17,6 +17,7 @@ import (
 
 	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
 	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 	readability "github.com/go-shiori/go-readability"
 	"golang.org/x/net/html"
@@ -50,19 +51,114 @@ type Config struct {
 	MaxBodyBytes   int64
 	MinQualityText int
 	IncludeMeta    bool
+	// MetaFields selects the metadata fields IncludeMeta adds, from MetaTitle,
+	// MetaDescription, MetaAuthor and the other Meta constants, or "all";
+	// empty means title and description.
+	MetaFields []string
+	HTTPClient *http.Client
+	// Proxy is the proxy for HTTP requests and the browser: an http, https,
+	// socks5 or socks5h URL with an optional user:password, or ProxyDirect.
+	// Empty follows HTTP_PROXY, HTTPS_PROXY and NO_PROXY. HTTPClient, when
+	// set, is used as is.
+	Proxy string
+	// NoProxy lists the hosts reached without a proxy, as in NO_PROXY.
+	NoProxy []string
+	// BrowserPool, when set, serves browser renders from long-lived browsers instead of launching one per call.
+	BrowserPool *BrowserPool
+	// Cache, when set, stores HTTP responses and extracted Markdown on disk.
+	Cache *Cache
+	// CollectLinks fills Result.Links and Result.CanonicalURL from the fetched page.
+	CollectLinks bool
+	// Robots, when set, refuses URLs that robots.txt disallows for UserAgent.
+	Robots *Robots
+	// Retry controls retries of failed HTTP requests and browser renders.
+	Retry RetryPolicy
+	// HostRules override settings for the URLs of matching hosts; see ForURL.
+	HostRules []HostRule
+	// SiteRules adjust fetching and extraction by host and path; see ForURL.
+	SiteRules []SiteRule
+	// Rule is the name of the site rule ForURL applied, if any.
+	Rule string
+	// baseProxy is Proxy before ForURL applied HostRules, and proxyRules
+	// are the rules that set a proxy, so redirects to other hosts resolve
+	// their own; see proxyFor.
+	baseProxy  string
+	proxyRules []HostRule
+
+	// Select, when set, extracts the HTML elements matching these CSS
+	// selectors instead of the readability article.
+	Select []string
+	// Exclude removes the HTML elements matching these CSS selectors before
+	// extraction.
+	Exclude []string
+	// Cookies are sent with HTTP requests and set in the browser for the page URL.
+	Cookies []*http.Cookie
+	// CookieJar, when set, sends its cookies by domain and path, also across
+	// redirects, and keeps the cookies responses and browser pages set.
+	CookieJar *CookieJar
+	// Credentials, when set, authenticate HTTP and browser requests to the
+	// hosts they name, hop by hop, so redirects do not carry them elsewhere.
+	Credentials *Credentials
+	// Script is JavaScript run in browser-rendered pages once they are ready,
+	// before they are captured. A returned promise is awaited.
+	Script string
+	// Post rewrites the Markdown of each result.
+	Post PostProcess
 }
 
 type Result struct {
 	Markdown string
 	Source   string
 	FinalURL string
+
+	// HTTP stage details. They stay zero when the HTTP stage was skipped (browser mode).
+	StatusCode  int
+	ContentType string
+	Header      http.Header
+	BodyBytes   int
+	// Charset is the character set the HTTP body was decoded from, such as
+	// "utf-8" or "shift_jis". It is empty for bodies that are not text.
+	Charset string
+
+	// PageCount is the number of pages of a PDF document (Source "http-pdf").
+	PageCount int
+
+	// Set only with Config.CollectLinks: the page's <link rel="canonical"> and
+	// its absolute http(s) links in document order, without fragments.
+	CanonicalURL string
+	Links        []string
+
+	// Attempts is the number of tries the last HTTP or browser stage took.
+	Attempts int
+
+	Timings []StageTiming
+	Trace   []TraceEvent
+}
+
+type StageTiming struct {
+	Stage    string
+	Duration time.Duration
+}
+
+type TraceEvent struct {
+	Stage    string
+	Decision string
+	Detail   string
 }
 
 type responseData struct {
 	Body        []byte
 	ContentType string
+	Header      http.Header
 	FinalURL    string
 	StatusCode  int
+
+	// Set when the response went through Config.Cache.
+	CacheStatus  string
+	CacheExpires time.Time
+
+	// Attempts is the number of requests made under Config.Retry.
+	Attempts int
 }
 
 func DefaultConfig() Config {
@@ -77,6 +173,7 @@ func DefaultConfig() Config {
 		MaxBodyBytes:   8 << 20,
 		MinQualityText: 220,
 		IncludeMeta:    true,
+		Retry:          DefaultRetryPolicy(),
 	}
 }
 
@@ -84,102 +181,231 @@ func Fetch(ctx context.Context, rawURL string, cfg Config) (Result, error) {
 	if _, err := nurl.ParseRequestURI(rawURL); err != nil {
 		return Result{}, fmt.Errorf("invalid URL: %w", err)
 	}
+	cfg = cfg.ForURL(rawURL)
+
+	tr := &pipelineTrace{}
+	if cfg.Rule != "" {
+		tr.note("rules", "matched", cfg.Rule)
+	}
+	cache := cfg.Cache
+	if cfg.CookieJar != nil {
+		// Pages fetched with a cookie jar are personal to its cookies, which
+		// also change as it fetches, so their Markdown is not cached either.
+		cache = nil
+	}
+	// The credentials sent for rawURL key its Markdown, as they key its
+	// HTTP responses.
+	var creds http.Header
+	if cache != nil && cfg.Credentials != nil {
+		u, err := nurl.Parse(rawURL)
+		if err != nil {
+			return Result{}, fmt.Errorf("invalid URL: %w", err)
+		}
+		if creds, err = cfg.Credentials.forURL(ctx, u); err != nil {
+			return Result{}, err
+		}
+	}
+	if cache != nil {
+		// Cached pages are served only while robots.txt still allows them.
+		if cfg.Robots != nil {
+			if err := cfg.Robots.check(ctx, rawURL, cfg); err != nil {
+				tr.note("robots", robotsDecision(err), err.Error())
+				var res Result
+				tr.apply(&res)
+				return res, err
+			}
+		}
+		if res, ok := cache.loadMarkdown(rawURL, cfg, creds); ok {
+			tr.note("cache", "hit", "markdown for mode "+cfg.Mode)
+			tr.apply(&res)
+			res.Markdown = cfg.Post.apply(res.Markdown)
+			return res, nil
+		}
+	}
 
+	var (
+		res Result
+		err error
+	)
 	switch cfg.Mode {
 	case ModeAuto:
-		return fetchAuto(ctx, rawURL, cfg)
+		res, err = fetchAuto(ctx, rawURL, cfg, tr)
 	case ModeStatic:
-		return fetchStaticOnly(ctx, rawURL, cfg)
+		res, err = fetchStaticOnly(ctx, rawURL, cfg, tr)
 	case ModeBrowser:
-		return fetchBrowserOnly(ctx, rawURL, cfg)
+		res, err = fetchBrowserOnly(ctx, rawURL, cfg, tr)
 	case ModeRaw:
-		return fetchRawOnly(ctx, rawURL, cfg)
+		res, err = fetchRawOnly(ctx, rawURL, cfg, tr)
 	default:
 		return Result{}, fmt.Errorf("%w: %s", ErrUnsupportedMode, cfg.Mode)
 	}
+	tr.apply(&res)
+	if err == nil && cache != nil {
+		var httpExpires time.Time
+		if tr.resp != nil {
+			httpExpires = tr.resp.CacheExpires
+		}
+		cache.storeMarkdown(rawURL, cfg, creds, res, httpExpires)
+	}
+	if err == nil {
+		// After caching, so the cache keeps the extracted Markdown and rules
+		// can change without invalidating it.
+		res.Markdown = cfg.Post.apply(res.Markdown)
+	}
+	return res, err
 }
 
-func fetchAuto(ctx context.Context, rawURL string, cfg Config) (Result, error) {
-	resp, err := fetchHTTP(ctx, rawURL, cfg, true)
+func fetchAuto(ctx context.Context, rawURL string, cfg Config, tr *pipelineTrace) (Result, error) {
+	resp, err := tr.fetchHTTP(ctx, rawURL, cfg)
 	if err != nil {
 		if errors.Is(err, ErrHTTPStatus) {
-			return fetchBrowserOnly(ctx, rawURL, cfg)
+			tr.note("browser", "fallback", fmt.Sprintf("HTTP status %d", resp.StatusCode))
+			return fetchBrowserOnly(ctx, rawURL, cfg, tr)
 		}
 		return Result{}, err
 	}
 
+	// A browser renders nothing useful for PDFs, so they never fall back.
+	if isPDFResponse(resp) {
+		return pdfResult(ctx, resp, cfg, tr)
+	}
+	resp = tr.toUTF8(resp)
+
 	// Honor explicit markdown responses from the server, even if the payload is MDX/JSX-heavy.
-	if isMarkdownContentType(resp.ContentType) || isLikelyMarkdown(resp.Body, resp.ContentType) {
+	if isMarkdownResponse(resp, tr) {
 		md := normalizeMarkdown(resp.Body)
 		if md != "" {
+			res := Result{Source: "http-markdown", FinalURL: resp.FinalURL}
+			if cfg.CollectLinks {
+				extractLinksFromMarkdown(md, resp.FinalURL).apply(&res)
+			}
 			if cfg.IncludeMeta {
-				md = withMetaForMarkdownResponse(ctx, rawURL, cfg, md)
+				md = tr.withMetaForMarkdownResponse(ctx, rawURL, cfg, md)
 			}
-			return Result{Markdown: md, Source: "http-markdown", FinalURL: resp.FinalURL}, nil
+			res.Markdown = md
+			return res, nil
 		}
+		tr.note("markdown", "rejected", "markdown body is empty")
 	}
 
-	md, qualityOK, err := staticHTMLToMarkdown(resp.Body, resp.FinalURL, cfg.MinQualityText)
-	if err == nil && qualityOK {
+	start := time.Now()
+	md, qualityOK, err := extractMarkdown(resp.Body, resp.FinalURL, cfg)
+	tr.timing("static", start)
+	switch {
+	case err != nil:
+		tr.note("static", "rejected", err.Error())
+	case !qualityOK:
+		_, reason := markdownQualityVerdict(md, cfg.MinQualityText)
+		tr.note("static", "rejected", reason)
+	default:
+		tr.note("static", "accepted", "quality check passed")
 		if cfg.IncludeMeta {
-			md = prependMetaFrontMatter(md, extractMetaFromHTML(resp.Body))
+			md = htmlMetaFrontMatter(md, resp.Body, resp.FinalURL, cfg)
 		}
-		return Result{Markdown: md, Source: "http-static", FinalURL: resp.FinalURL}, nil
+		res := Result{Markdown: md, Source: "http-static", FinalURL: resp.FinalURL}
+		if cfg.CollectLinks {
+			extractLinksFromHTML(resp.Body, resp.FinalURL).apply(&res)
+		}
+		return res, nil
 	}
 
-	return fetchBrowserOnly(ctx, rawURL, cfg)
+	tr.note("browser", "fallback", "static extraction rejected")
+	return fetchBrowserOnly(ctx, rawURL, cfg, tr)
 }
 
-func fetchStaticOnly(ctx context.Context, rawURL string, cfg Config) (Result, error) {
-	resp, err := fetchHTTP(ctx, rawURL, cfg, true)
+func fetchStaticOnly(ctx context.Context, rawURL string, cfg Config, tr *pipelineTrace) (Result, error) {
+	resp, err := tr.fetchHTTP(ctx, rawURL, cfg)
 	if err != nil {
 		return Result{}, err
 	}
 
-	if isMarkdownContentType(resp.ContentType) || isLikelyMarkdown(resp.Body, resp.ContentType) {
+	if isPDFResponse(resp) {
+		return pdfResult(ctx, resp, cfg, tr)
+	}
+	resp = tr.toUTF8(resp)
+
+	if isMarkdownResponse(resp, tr) {
 		md := normalizeMarkdown(resp.Body)
 		if md != "" {
+			res := Result{Source: "http-markdown", FinalURL: resp.FinalURL}
+			if cfg.CollectLinks {
+				extractLinksFromMarkdown(md, resp.FinalURL).apply(&res)
+			}
 			if cfg.IncludeMeta {
-				md = withMetaForMarkdownResponse(ctx, rawURL, cfg, md)
+				md = tr.withMetaForMarkdownResponse(ctx, rawURL, cfg, md)
 			}
-			return Result{Markdown: md, Source: "http-markdown", FinalURL: resp.FinalURL}, nil
+			res.Markdown = md
+			return res, nil
 		}
+		tr.note("markdown", "rejected", "markdown body is empty")
 		return Result{}, ErrNoContent
 	}
 
-	md, _, err := staticHTMLToMarkdown(resp.Body, resp.FinalURL, cfg.MinQualityText)
+	start := time.Now()
+	md, _, err := extractMarkdown(resp.Body, resp.FinalURL, cfg)
+	tr.timing("static", start)
 	if err != nil {
+		tr.note("static", "rejected", err.Error())
 		return Result{}, err
 	}
 	if strings.TrimSpace(md) == "" {
+		tr.note("static", "rejected", "empty output")
 		return Result{}, ErrNoContent
 	}
+	tr.note("static", "accepted", "static mode skips quality check")
 	if cfg.IncludeMeta {
-		md = prependMetaFrontMatter(md, extractMetaFromHTML(resp.Body))
+		md = htmlMetaFrontMatter(md, resp.Body, resp.FinalURL, cfg)
 	}
 
-	return Result{Markdown: md, Source: "http-static", FinalURL: resp.FinalURL}, nil
+	res := Result{Markdown: md, Source: "http-static", FinalURL: resp.FinalURL}
+	if cfg.CollectLinks {
+		extractLinksFromHTML(resp.Body, resp.FinalURL).apply(&res)
+	}
+	return res, nil
 }
 
-func fetchBrowserOnly(ctx context.Context, rawURL string, cfg Config) (Result, error) {
-	md, finalURL, err := browserHTMLToMarkdownFn(ctx, rawURL, cfg)
+func fetchBrowserOnly(ctx context.Context, rawURL string, cfg Config, tr *pipelineTrace) (Result, error) {
+	if cfg.Robots != nil {
+		if err := cfg.Robots.check(ctx, rawURL, cfg); err != nil {
+			tr.note("robots", robotsDecision(err), err.Error())
+			return Result{}, err
+		}
+	}
+	var page browserPage
+	attempts, err := cfg.Retry.run(ctx, func() (bool, time.Duration, error) {
+		start := time.Now()
+		var err error
+		page, err = browserHTMLToMarkdownFn(ctx, rawURL, cfg)
+		tr.timing("browser", start)
+		if err != nil {
+			tr.note("browser", "error", err.Error())
+			return retryBrowser(err), 0, err
+		}
+		return false, 0, nil
+	})
+	tr.attempts = attempts
 	if err != nil {
 		return Result{}, err
 	}
-	if strings.TrimSpace(md) == "" {
+	if strings.TrimSpace(page.Markdown) == "" {
+		tr.note("browser", "rejected", "empty output")
 		return Result{}, ErrNoContent
 	}
-	return Result{Markdown: md, Source: "browser", FinalURL: finalURL}, nil
+	tr.note("browser", "accepted", "")
+	res := Result{Markdown: page.Markdown, Source: "browser", FinalURL: page.FinalURL}
+	page.Links.apply(&res)
+	return res, nil
 }
 
-func fetchRawOnly(ctx context.Context, rawURL string, cfg Config) (Result, error) {
+func fetchRawOnly(ctx context.Context, rawURL string, cfg Config, tr *pipelineTrace) (Result, error) {
 	// Raw mode is a single pass that still prefers markdown from the server.
 	// It returns that HTTP response body as-is without any extraction/conversion fallback.
-	resp, err := fetchHTTP(ctx, rawURL, cfg, true)
+	resp, err := tr.fetchHTTP(ctx, rawURL, cfg)
 	if err != nil {
 		return Result{}, err
 	}
 	if len(resp.Body) == 0 {
+		tr.note("raw", "rejected", "empty body")
 		return Result{}, ErrNoContent
 	}
 	return Result{
@@ -189,6 +415,55 @@ func fetchRawOnly(ctx context.Context, rawURL string, cfg Config) (Result, error
 	}, nil
 }
 
+func pdfResult(ctx context.Context, resp responseData, cfg Config, tr *pipelineTrace) (Result, error) {
+	start := time.Now()
+	limit := cfg.MaxBodyBytes
+	if limit <= 0 {
+		limit = 8 << 20
+	}
+	doc, err := parsePDF(resp.Body)
+	var md pdfMarkdown
+	if err == nil {
+		md, err = pdfToMarkdown(ctx, doc, int(limit))
+	}
+	tr.timing("pdf", start)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return Result{}, ctxErr
+	}
+	if err != nil {
+		tr.note("pdf", "error", err.Error())
+		return Result{}, fmt.Errorf("%w: %v", ErrNoContent, err)
+	}
+	if strings.TrimSpace(md.text) == "" {
+		tr.note("pdf", "rejected", "no extractable text (scanned or image-only PDF?)")
+		return Result{PageCount: md.pages}, ErrNoContent
+	}
+	detail := fmt.Sprintf("%d pages", md.pages)
+	if md.truncated {
+		detail += fmt.Sprintf(", text truncated at %d bytes", limit)
+	}
+	tr.note("pdf", "accepted", detail)
+	text := md.text
+	if cfg.IncludeMeta {
+		text = prependMetaFrontMatter(text, pageMeta{Title: doc.title(), Pages: md.pages}.only(cfg.metaFields()))
+	}
+	return Result{Markdown: text, Source: "http-pdf", FinalURL: resp.FinalURL, PageCount: md.pages}, nil
+}
+
+func isMarkdownResponse(resp responseData, tr *pipelineTrace) bool {
+	switch {
+	case isMarkdownContentType(resp.ContentType):
+		tr.note("markdown", "accepted", "content type is text/markdown")
+		return true
+	case isLikelyMarkdown(resp.Body, resp.ContentType):
+		tr.note("markdown", "accepted", "body sniffed as markdown")
+		return true
+	default:
+		tr.note("markdown", "rejected", "body does not look like markdown")
+		return false
+	}
+}
+
 func fetchHTTP(ctx context.Context, rawURL string, cfg Config, preferMarkdown bool) (responseData, error) {
 	accept := ""
 	if preferMarkdown {
@@ -198,6 +473,26 @@ func fetchHTTP(ctx context.Context, rawURL string, cfg Config, preferMarkdown bo
 }
 
 func fetchHTTPWithAccept(ctx context.Context, rawURL string, cfg Config, accept string) (responseData, error) {
+	if cfg.Robots != nil {
+		if err := cfg.Robots.check(ctx, rawURL, cfg); err != nil {
+			return responseData{}, err
+		}
+	}
+	var resp responseData
+	attempts, err := cfg.Retry.run(ctx, func() (bool, time.Duration, error) {
+		var err error
+		resp, err = fetchHTTPOnce(ctx, rawURL, cfg, accept)
+		if err != nil {
+			retryable, wait := cfg.Retry.retryHTTP(resp, err, time.Now())
+			return retryable, wait, err
+		}
+		return false, 0, nil
+	})
+	resp.Attempts = attempts
+	return resp, err
+}
+
+func fetchHTTPOnce(ctx context.Context, rawURL string, cfg Config, accept string) (responseData, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
 	if err != nil {
 		return responseData{}, fmt.Errorf("create request: %w", err)
@@ -213,8 +508,34 @@ func fetchHTTPWithAccept(ctx context.Context, rawURL string, cfg Config, accept
 			req.Header.Add(k, v)
 		}
 	}
+	for _, c := range cfg.Cookies {
+		req.AddCookie(c)
+	}
+
+	// Responses to a cookie jar's requests depend on its cookies and may set
+	// new ones, so they skip the HTTP cache.
+	if cfg.Cache != nil && cfg.CookieJar == nil {
+		// Credentials are added by the transport, after the cache has keyed
+		// the request, so the key covers them here.
+		var creds http.Header
+		if cfg.Credentials != nil && cfg.HTTPClient == nil {
+			if creds, err = cfg.Credentials.forURL(ctx, req.URL); err != nil {
+				return responseData{}, err
+			}
+		}
+		return cfg.Cache.roundTrip(req, creds, func(req *http.Request) (responseData, error) {
+			return doHTTP(req, cfg)
+		})
+	}
+	return doHTTP(req, cfg)
+}
 
-	client := &http.Client{Timeout: cfg.Timeout}
+func doHTTP(req *http.Request, cfg Config) (responseData, error) {
+	rawURL := req.URL.String()
+	client, err := cfg.httpClient(req.URL)
+	if err != nil {
+		return responseData{}, err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return responseData{}, fmt.Errorf("http request failed: %w", err)
@@ -235,12 +556,19 @@ func fetchHTTPWithAccept(ctx context.Context, rawURL string, cfg Config, accept
 		finalURL = resp.Request.URL.String()
 	}
 	if resp.StatusCode >= http.StatusBadRequest {
-		return responseData{}, fmt.Errorf("%w: %d %s (%s)", ErrHTTPStatus, resp.StatusCode, http.StatusText(resp.StatusCode), finalURL)
+		// Keep status/header details for diagnostics; the body is dropped.
+		return responseData{
+			ContentType: resp.Header.Get("Content-Type"),
+			Header:      resp.Header.Clone(),
+			FinalURL:    finalURL,
+			StatusCode:  resp.StatusCode,
+		}, fmt.Errorf("%w: %d %s (%s)", ErrHTTPStatus, resp.StatusCode, http.StatusText(resp.StatusCode), finalURL)
 	}
 
 	return responseData{
 		Body:        body,
 		ContentType: resp.Header.Get("Content-Type"),
+		Header:      resp.Header.Clone(),
 		FinalURL:    finalURL,
 		StatusCode:  resp.StatusCode,
 	}, nil
@@ -255,7 +583,8 @@ func withMetaForMarkdownResponse(ctx context.Context, rawURL string, cfg Config,
 	if err != nil {
 		return md
 	}
-	return prependMetaFrontMatter(md, extractMetaFromHTML(resp.Body))
+	body, _, _ := toUTF8(resp.Body, resp.ContentType)
+	return htmlMetaFrontMatter(md, body, resp.FinalURL, cfg)
 }
 
 func staticHTMLToMarkdown(body []byte, pageURL string, minQualityText int) (string, bool, error) {
@@ -295,26 +624,43 @@ func staticHTMLToMarkdown(body []byte, pageURL string, minQualityText int) (stri
 	return md + "\n", markdownQuality(md, minQualityText), nil
 }
 
-func browserHTMLToMarkdown(ctx context.Context, rawURL string, cfg Config) (string, string, error) {
-	browserExecPath, _, err := ResolveBrowserExecutablePath(cfg.BrowserPath)
+// browserPage is a page rendered by browserHTMLToMarkdown.
+type browserPage struct {
+	Markdown string
+	FinalURL string
+	// Links is only filled when Config.CollectLinks is set.
+	Links pageLinks
+}
+
+func browserHTMLToMarkdown(ctx context.Context, rawURL string, cfg Config) (browserPage, error) {
+	// The browser is launched with one proxy, so settle it for this URL.
+	proxy, err := cfg.resolveProxy(rawURL)
 	if err != nil {
-		return "", "", fmt.Errorf("resolve browser executable: %w", err)
+		return browserPage{}, err
 	}
+	cfg.Proxy, cfg.NoProxy = proxy, nil
 
-	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.ExecPath(browserExecPath),
-		chromedp.NoDefaultBrowserCheck,
-		chromedp.NoFirstRun,
-	)
-	if cfg.UserAgent != "" {
-		allocOpts = append(allocOpts, chromedp.UserAgent(cfg.UserAgent))
-	}
+	var tabCtx context.Context
+	if cfg.BrowserPool != nil {
+		pooledCtx, release, err := cfg.BrowserPool.acquire(ctx, cfg)
+		if err != nil {
+			return browserPage{}, err
+		}
+		defer release()
+		tabCtx = pooledCtx
+	} else {
+		browserExecPath, _, err := ResolveBrowserExecutablePath(cfg.BrowserPath)
+		if err != nil {
+			return browserPage{}, fmt.Errorf("resolve browser executable: %w", err)
+		}
 
-	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, allocOpts...)
-	defer cancelAlloc()
+		allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, browserAllocatorOptions(browserExecPath, cfg)...)
+		defer cancelAlloc()
 
-	tabCtx, cancelTab := chromedp.NewContext(allocCtx)
-	defer cancelTab()
+		ownCtx, cancelTab := chromedp.NewContext(allocCtx)
+		defer cancelTab()
+		tabCtx = ownCtx
+	}
 
 	browserCtx, cancelTimeout := context.WithTimeout(tabCtx, cfg.BrowserTimeout)
 	defer cancelTimeout()
@@ -327,35 +673,95 @@ func browserHTMLToMarkdown(ctx context.Context, rawURL string, cfg Config) (stri
 	var htmlDoc string
 	var finalURL string
 	actions := []chromedp.Action{network.Enable()}
+	if cfg.Credentials != nil {
+		// Settle the page's own credentials first, so helper failures fail
+		// the render instead of sending the page without them.
+		u, err := nurl.Parse(rawURL)
+		if err != nil {
+			return browserPage{}, err
+		}
+		if _, err := cfg.Credentials.forURL(browserCtx, u); err != nil {
+			return browserPage{}, err
+		}
+	}
+	intercept, err := browserInterception(browserCtx, rawURL, cfg.Proxy, cfg.Credentials)
+	if err != nil {
+		return browserPage{}, err
+	}
+	if intercept != nil {
+		actions = append(actions, intercept)
+	}
 	if len(extraHeaders) > 0 {
 		actions = append(actions, network.SetExtraHTTPHeaders(extraHeaders))
 	}
+	if cfg.CookieJar != nil {
+		actions = append(actions, network.SetCookies(cfg.CookieJar.browserCookies()))
+	}
+	if len(cfg.Cookies) > 0 {
+		actions = append(actions, network.SetCookies(toCDPCookies(cfg.Cookies, rawURL)))
+	}
 	actions = append(actions,
-		chromedp.Navigate(rawURL),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if err := chromedp.Navigate(rawURL).Do(ctx); err != nil {
+				return fmt.Errorf("%w: %w", errBrowserNavigation, err)
+			}
+			return nil
+		}),
 	)
 	if cfg.WaitSelector != "" {
 		actions = append(actions, chromedp.WaitVisible(cfg.WaitSelector, chromedp.ByQuery))
 	} else {
 		actions = append(actions, chromedp.WaitReady("body", chromedp.ByQuery))
 	}
+	if cfg.Script != "" {
+		actions = append(actions, chromedp.Evaluate(cfg.Script, nil, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		}))
+	}
 	actions = append(actions,
 		chromedp.ActionFunc(watcher.Wait),
 		chromedp.OuterHTML("html", &htmlDoc, chromedp.ByQuery),
 		chromedp.Location(&finalURL),
 	)
+	if cfg.CookieJar != nil {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			cookies, err := network.GetCookies().WithURLs([]string{rawURL, finalURL}).Do(ctx)
+			if err != nil {
+				return err
+			}
+			cfg.CookieJar.addBrowserCookies(cookies)
+			return nil
+		}))
+	}
 
 	if err := chromedp.Run(browserCtx, actions...); err != nil {
-		return "", "", fmt.Errorf("browser render failed: %w", err)
+		return browserPage{}, fmt.Errorf("browser render failed: %w", err)
 	}
 
-	md, _, err := staticHTMLToMarkdown([]byte(htmlDoc), finalURL, cfg.MinQualityText)
+	md, _, err := extractMarkdown([]byte(htmlDoc), finalURL, cfg)
 	if err != nil {
-		return "", "", err
+		return browserPage{}, err
 	}
 	if cfg.IncludeMeta {
-		md = prependMetaFrontMatter(md, extractMetaFromHTML([]byte(htmlDoc)))
+		md = htmlMetaFrontMatter(md, []byte(htmlDoc), finalURL, cfg)
 	}
-	return md, finalURL, nil
+	page := browserPage{Markdown: md, FinalURL: finalURL}
+	if cfg.CollectLinks {
+		page.Links = extractLinksFromHTML([]byte(htmlDoc), finalURL)
+	}
+	return page, nil
+}
+
+func browserAllocatorOptions(execPath string, cfg Config) []chromedp.ExecAllocatorOption {
+	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.ExecPath(execPath),
+		chromedp.NoDefaultBrowserCheck,
+		chromedp.NoFirstRun,
+	)
+	if cfg.UserAgent != "" {
+		allocOpts = append(allocOpts, chromedp.UserAgent(cfg.UserAgent))
+	}
+	return append(allocOpts, browserProxyOptions(cfg.Proxy)...)
 }
 
 func isLikelyMarkdown(body []byte, contentType string) bool {
@@ -470,9 +876,15 @@ var (
 )
 
 func markdownQuality(md string, minQualityText int) bool {
+	ok, _ := markdownQualityVerdict(md, minQualityText)
+	return ok
+}
+
+// markdownQualityVerdict is markdownQuality with a short human-readable reason for the verdict.
+func markdownQualityVerdict(md string, minQualityText int) (bool, string) {
 	trim := strings.TrimSpace(md)
 	if trim == "" {
-		return false
+		return false, "empty output"
 	}
 
 	if minQualityText <= 0 {
@@ -486,7 +898,7 @@ func markdownQuality(md string, minQualityText int) bool {
 		}
 	}
 	if textLen < minQualityText {
-		return false
+		return false, fmt.Sprintf("text too short (%d < %d)", textLen, minQualityText)
 	}
 
 	lines := strings.Split(trim, "\n")
@@ -503,14 +915,17 @@ func markdownQuality(md string, minQualityText int) bool {
 		}
 	}
 	if nonEmpty > 0 && linkOnly*2 > nonEmpty && textLen < minQualityText*3 {
-		return false
+		return false, fmt.Sprintf("mostly link-only lines (%d/%d)", linkOnly, nonEmpty)
 	}
 
 	if markdownScore(trim) >= 2 {
-		return true
+		return true, "structured markdown"
 	}
 
-	return textLen >= minQualityText*2
+	if textLen >= minQualityText*2 {
+		return true, "enough plain text"
+	}
+	return false, fmt.Sprintf("little structure and text too short (%d < %d)", textLen, minQualityText*2)
 }
 
 var linkOnlyRe = regexp.MustCompile(`^\[.+\]\(.+\)$`)
@@ -518,9 +933,20 @@ var linkOnlyRe = regexp.MustCompile(`^\[.+\]\(.+\)$`)
 type pageMeta struct {
 	Title       string
 	Description string
+	Author      string
+	Published   string
+	Modified    string
+	SiteName    string
+	Lang        string
+	Canonical   string
+	Pages       int
 }
 
-func extractMetaFromHTML(body []byte) pageMeta {
+// extractMetaFromHTML reads page metadata from <title>, <meta> description,
+// author and date tags, OpenGraph and Twitter cards, JSON-LD articles,
+// <link rel="canonical"> and <html lang>. pageURL resolves a relative
+// canonical URL.
+func extractMetaFromHTML(body []byte, pageURL string) pageMeta {
 	if len(body) == 0 {
 		return pageMeta{}
 	}
@@ -536,10 +962,12 @@ func extractMetaFromHTML(body []byte) pageMeta {
 	}
 
 	var (
-		titleTag      string
-		ogTitle       string
-		description   string
-		ogDescription string
+		titleTag  string
+		canonical string
+		// props holds the first value of each meta name or property.
+		props   = map[string]string{}
+		article ldArticle
+		hasLD   bool
 	)
 
 	var walk func(*html.Node)
@@ -556,17 +984,17 @@ func extractMetaFromHTML(body []byte) pageMeta {
 			case "meta":
 				content := normalizeMetaValue(htmlAttr(n, "content"))
 				if content != "" {
-					name := strings.ToLower(strings.TrimSpace(htmlAttr(n, "name")))
-					prop := strings.ToLower(strings.TrimSpace(htmlAttr(n, "property")))
-					switch {
-					case name == "description" && description == "":
-						description = content
-					case prop == "og:description" && ogDescription == "":
-						ogDescription = content
-					case prop == "og:title" && ogTitle == "":
-						ogTitle = content
+					for _, attr := range []string{"name", "property", "itemprop"} {
+						key := strings.ToLower(strings.TrimSpace(htmlAttr(n, attr)))
+						if _, seen := props[key]; key != "" && !seen {
+							props[key] = content
+						}
 					}
 				}
+			case "link":
+				if canonical == "" && hasRelToken(htmlAttr(n, "rel"), "canonical") {
+					canonical = strings.TrimSpace(htmlAttr(n, "href"))
+				}
 			}
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
@@ -575,16 +1003,47 @@ func extractMetaFromHTML(body []byte) pageMeta {
 	}
 	walk(head)
 
-	meta := pageMeta{}
-	if titleTag != "" {
-		meta.Title = titleTag
-	} else {
-		meta.Title = ogTitle
+	// JSON-LD may sit anywhere in the document.
+	var walkLD func(*html.Node)
+	walkLD = func(n *html.Node) {
+		if hasLD {
+			return
+		}
+		if n.Type == html.ElementNode && strings.EqualFold(n.Data, "script") &&
+			strings.EqualFold(strings.TrimSpace(htmlAttr(n, "type")), "application/ld+json") {
+			article, hasLD = parseLDArticle(nodeText(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walkLD(c)
+		}
 	}
-	if description != "" {
-		meta.Description = description
-	} else {
-		meta.Description = ogDescription
+	walkLD(doc)
+
+	var lang string
+	if root := findFirstElement(doc, "html"); root != nil {
+		lang = strings.TrimSpace(htmlAttr(root, "lang"))
+	}
+
+	author := props["author"]
+	if a := props["article:author"]; author == "" && a != "" && !strings.Contains(a, "://") {
+		author = a
+	}
+
+	meta := pageMeta{
+		Title:       firstNonEmpty(titleTag, props["og:title"], props["twitter:title"], article.Headline),
+		Description: firstNonEmpty(props["description"], props["og:description"], props["twitter:description"], article.Description),
+		Author:      firstNonEmpty(article.Author, author, props["twitter:creator"]),
+		Published:   firstNonEmpty(article.Published, props["article:published_time"], props["datepublished"], props["date"]),
+		Modified:    firstNonEmpty(article.Modified, props["article:modified_time"], props["og:updated_time"], props["datemodified"]),
+		SiteName:    firstNonEmpty(props["og:site_name"], article.Publisher, props["application-name"]),
+		Lang:        firstNonEmpty(lang, article.Lang, localeToLang(props["og:locale"])),
+		Canonical:   firstNonEmpty(canonical, props["og:url"], article.URL),
+	}
+	if meta.Canonical != "" {
+		if base, err := nurl.Parse(pageURL); err == nil {
+			meta.Canonical, _ = resolveLink(base, meta.Canonical)
+		}
 	}
 	return meta
 }
@@ -597,23 +1056,21 @@ func prependMetaFrontMatter(md string, meta pageMeta) string {
 		return md
 	}
 
-	meta.Title = normalizeMetaValue(meta.Title)
-	meta.Description = normalizeMetaValue(meta.Description)
-	if meta.Title == "" && meta.Description == "" {
+	fields := meta.fields()
+	if len(fields) == 0 && meta.Pages == 0 {
 		return md
 	}
 
 	var b strings.Builder
 	b.WriteString("---\n")
-	if meta.Title != "" {
-		b.WriteString("title: ")
-		b.WriteString(yamlQuote(meta.Title))
+	for _, kv := range fields {
+		b.WriteString(kv[0])
+		b.WriteString(": ")
+		b.WriteString(yamlQuote(kv[1]))
 		b.WriteByte('\n')
 	}
-	if meta.Description != "" {
-		b.WriteString("description: ")
-		b.WriteString(yamlQuote(meta.Description))
-		b.WriteByte('\n')
+	if meta.Pages > 0 {
+		fmt.Fprintf(&b, "pages: %d\n", meta.Pages)
 	}
 	b.WriteString("---\n\n")
 	b.WriteString(md)
@@ -621,6 +1078,13 @@ func prependMetaFrontMatter(md string, meta pageMeta) string {
 }
 
 func hasLeadingYAMLFrontMatter(md string) bool {
+	front, _ := splitYAMLFrontMatter(md)
+	return front != ""
+}
+
+// splitYAMLFrontMatter splits md into its leading front matter, including
+// the closing "---" line, and the rest.
+func splitYAMLFrontMatter(md string) (string, string) {
 	s := strings.TrimPrefix(md, "\ufeff")
 	switch {
 	case strings.HasPrefix(s, "---\n"):
@@ -628,7 +1092,7 @@ func hasLeadingYAMLFrontMatter(md string) bool {
 	case strings.HasPrefix(s, "---\r\n"):
 		s = s[len("---\r\n"):]
 	default:
-		return false
+		return "", md
 	}
 
 	for len(s) > 0 {
@@ -642,10 +1106,10 @@ func hasLeadingYAMLFrontMatter(md string) bool {
 		}
 		line = strings.TrimSuffix(line, "\r")
 		if strings.TrimSpace(line) == "---" {
-			return true
+			return md[:len(md)-len(s)], s
 		}
 	}
-	return false
+	return "", md
 }
 
 func yamlQuote(s string) string {
@@ -747,6 +1211,21 @@ func toCDPHeaders(h http.Header) network.Headers {
 	return res
 }
 
+// toCDPCookies scopes cookies without a domain to pageURL.
+func toCDPCookies(cookies []*http.Cookie, pageURL string) []*network.CookieParam {
+	res := make([]*network.CookieParam, 0, len(cookies))
+	for _, c := range cookies {
+		p := &network.CookieParam{Name: c.Name, Value: c.Value, Path: c.Path, Secure: c.Secure, HTTPOnly: c.HttpOnly}
+		if c.Domain != "" {
+			p.Domain = c.Domain
+		} else {
+			p.URL = pageURL
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
 type networkIdleWatcher struct {
 	idleAfter time.Duration
 
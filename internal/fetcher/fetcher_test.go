@@ -392,8 +392,8 @@ func TestFetchStaticDoesNotAddMetaWhenDisabled(t *testing.T) {
 
 func TestFetchAutoFallsBackWhenMarkdownBodyIsEmpty(t *testing.T) {
 	originalBrowserFn := browserHTMLToMarkdownFn
-	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (string, string, error) {
-		return "# Browser Fallback\n", "https://browser.example/final", nil
+	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (browserPage, error) {
+		return browserPage{Markdown: "# Browser Fallback\n", FinalURL: "https://browser.example/final"}, nil
 	}
 	defer func() {
 		browserHTMLToMarkdownFn = originalBrowserFn
@@ -472,8 +472,8 @@ func TestFetchReturnsErrorOnHTTPStatus(t *testing.T) {
 
 func TestFetchAutoFallsBackToBrowserOnHTTPStatus(t *testing.T) {
 	originalBrowserFn := browserHTMLToMarkdownFn
-	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (string, string, error) {
-		return "# Browser Rendered\n", "https://browser.example/final", nil
+	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (browserPage, error) {
+		return browserPage{Markdown: "# Browser Rendered\n", FinalURL: "https://browser.example/final"}, nil
 	}
 	defer func() {
 		browserHTMLToMarkdownFn = originalBrowserFn
@@ -544,7 +544,7 @@ func TestExtractMetaFromHTMLUsesExpectedPriority(t *testing.T) {
 <body><p>content</p></body>
 </html>`)
 
-	meta := extractMetaFromHTML(doc)
+	meta := extractMetaFromHTML(doc, "https://example.com/")
 	if meta.Title != "Title Tag Value" {
 		t.Fatalf("expected title from <title>, got %q", meta.Title)
 	}
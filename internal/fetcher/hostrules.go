@@ -0,0 +1,121 @@
+package fetcher
+
+import (
+	"net/http"
+	nurl "net/url"
+	"strings"
+	"time"
+)
+
+// HostRule overrides Config fields for the URLs of one host. Zero fields
+// keep the Config value.
+type HostRule struct {
+	// Host is a host name, "*.example.com" for example.com and its
+	// subdomains, or "*" for every host.
+	Host string
+
+	Mode           string
+	UserAgent      string
+	WaitSelector   string
+	Timeout        time.Duration
+	BrowserTimeout time.Duration
+	NetworkIdle    time.Duration
+	// Proxy replaces Config.Proxy; ProxyDirect bypasses proxies.
+	Proxy string
+	// Headers replace request headers of the same name.
+	Headers http.Header
+}
+
+// Matches reports whether the rule applies to host, a host name without port.
+func (r HostRule) Matches(host string) bool {
+	pattern := strings.ToLower(strings.TrimSpace(r.Host))
+	host = strings.ToLower(host)
+	if pattern == "*" {
+		return true
+	}
+	if base, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == base || strings.HasSuffix(host, "."+base)
+	}
+	return host == pattern
+}
+
+// Specificity ranks host patterns from "*" up to exact host names, with
+// longer "*.example.com" suffixes in between.
+func (r HostRule) Specificity() int {
+	host := strings.ToLower(strings.TrimSpace(r.Host))
+	if host == "*" {
+		return 0
+	}
+	if base, ok := strings.CutPrefix(host, "*."); ok {
+		return 2 + 2*strings.Count(base, ".")
+	}
+	return 3 + 2*strings.Count(host, ".")
+}
+
+// ForURL returns cfg with the HostRules that match rawURL's host applied in
+// order, so later rules win, then the most specific matching SiteRule, and
+// with both lists cleared. Fetch calls it itself; callers use it to see the
+// settings a URL will be fetched with.
+func (cfg Config) ForURL(rawURL string) Config {
+	hostRules, siteRules := cfg.HostRules, cfg.SiteRules
+	cfg.HostRules, cfg.SiteRules = nil, nil
+	if len(hostRules) == 0 && len(siteRules) == 0 {
+		return cfg
+	}
+	u, err := nurl.Parse(rawURL)
+	if err != nil {
+		return cfg
+	}
+	for _, rule := range hostRules {
+		if rule.Proxy != "" {
+			if cfg.proxyRules == nil {
+				cfg.baseProxy = cfg.Proxy
+			}
+			cfg.proxyRules = append(cfg.proxyRules, rule)
+		}
+	}
+	for _, rule := range hostRules {
+		if rule.Matches(u.Hostname()) {
+			cfg.applyHostRule(rule)
+		}
+	}
+	if rule, ok := (Config{SiteRules: siteRules}).MatchRule(rawURL); ok {
+		cfg.applySiteRule(rule)
+	}
+	return cfg
+}
+
+func (cfg *Config) applyHostRule(rule HostRule) {
+	if rule.Mode != "" {
+		cfg.Mode = rule.Mode
+	}
+	if rule.UserAgent != "" {
+		cfg.UserAgent = rule.UserAgent
+	}
+	if rule.WaitSelector != "" {
+		cfg.WaitSelector = rule.WaitSelector
+	}
+	if rule.Timeout > 0 {
+		cfg.Timeout = rule.Timeout
+	}
+	if rule.BrowserTimeout > 0 {
+		cfg.BrowserTimeout = rule.BrowserTimeout
+	}
+	if rule.NetworkIdle > 0 {
+		cfg.NetworkIdle = rule.NetworkIdle
+	}
+	if rule.Proxy != "" {
+		cfg.Proxy = rule.Proxy
+	}
+	if len(rule.Headers) > 0 {
+		// Copy, since cfg.Headers may be shared with the caller.
+		h := cfg.Headers.Clone()
+		if h == nil {
+			h = make(http.Header)
+		}
+		for k, vals := range rule.Headers {
+			h[http.CanonicalHeaderKey(k)] = append([]string(nil), vals...)
+		}
+		cfg.Headers = h
+	}
+}
@@ -0,0 +1,75 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHostRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"Example.com", "EXAMPLE.COM", true},
+		{"example.com", "docs.example.com", false},
+		{"*.example.com", "example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "badexample.com", false},
+		{"*", "anything.example", true},
+	}
+	for _, tt := range tests {
+		if got := (HostRule{Host: tt.pattern}).Matches(tt.host); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestConfigForURL(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Headers.Set("Accept-Language", "en")
+	cfg.HostRules = []HostRule{
+		{Host: "*.example.com", Mode: ModeStatic, Timeout: 5 * time.Second, Headers: http.Header{"accept-language": {"de"}}},
+		{Host: "docs.example.com", Mode: ModeBrowser, WaitSelector: "main"},
+	}
+
+	got := cfg.ForURL("https://docs.example.com:8443/guide")
+	if got.Mode != ModeBrowser || got.WaitSelector != "main" || got.Timeout != 5*time.Second {
+		t.Fatalf("unexpected settings: mode %q selector %q timeout %v", got.Mode, got.WaitSelector, got.Timeout)
+	}
+	if got.Headers.Get("Accept-Language") != "de" || cfg.Headers.Get("Accept-Language") != "en" {
+		t.Fatalf("headers not replaced on a copy: %v / %v", got.Headers, cfg.Headers)
+	}
+	if got.HostRules != nil {
+		t.Fatal("HostRules not cleared")
+	}
+
+	other := cfg.ForURL("https://other.test/")
+	if other.Mode != ModeAuto || other.Timeout != cfg.Timeout || other.Headers.Get("Accept-Language") != "en" {
+		t.Fatalf("rules applied to a non-matching host: %+v", other)
+	}
+}
+
+func TestFetchAppliesHostRules(t *testing.T) {
+	var gotUA string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.UserAgent()
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.HostRules = []HostRule{{Host: "127.0.0.1", Mode: ModeRaw, UserAgent: "rule-agent"}}
+	res, err := Fetch(context.Background(), srv.URL, cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if gotUA != "rule-agent" || res.Source != "http-raw" {
+		t.Fatalf("rule not applied: user agent %q, source %q", gotUA, res.Source)
+	}
+}
@@ -0,0 +1,111 @@
+package fetcher
+
+import (
+	"bytes"
+	nurl "net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageLinks are the outgoing links and canonical URL of a page, collected
+// when Config.CollectLinks is set.
+type pageLinks struct {
+	Canonical string
+	Links     []string
+}
+
+func (l pageLinks) apply(res *Result) {
+	res.CanonicalURL = l.Canonical
+	res.Links = l.Links
+}
+
+// extractLinksFromHTML returns the absolute http(s) targets of <a> and <area>
+// elements in document order, resolved against <base href> or pageURL, without
+// fragments and without duplicates.
+func extractLinksFromHTML(body []byte, pageURL string) pageLinks {
+	base, err := nurl.Parse(pageURL)
+	if err != nil || len(body) == 0 {
+		return pageLinks{}
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return pageLinks{}
+	}
+
+	if b := findFirstElement(doc, "base"); b != nil {
+		if href := strings.TrimSpace(htmlAttr(b, "href")); href != "" {
+			if ref, err := base.Parse(href); err == nil {
+				base = ref
+			}
+		}
+	}
+
+	var out pageLinks
+	seen := make(map[string]bool)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch strings.ToLower(n.Data) {
+			case "a", "area":
+				if link, ok := resolveLink(base, htmlAttr(n, "href")); ok && !seen[link] {
+					seen[link] = true
+					out.Links = append(out.Links, link)
+				}
+			case "link":
+				if out.Canonical == "" && hasRelToken(htmlAttr(n, "rel"), "canonical") {
+					out.Canonical, _ = resolveLink(base, htmlAttr(n, "href"))
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return out
+}
+
+var markdownLinkRe = regexp.MustCompile(`\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+
+// extractLinksFromMarkdown returns the inline link targets of a Markdown
+// document, for pages served as text/markdown.
+func extractLinksFromMarkdown(md, pageURL string) pageLinks {
+	base, err := nurl.Parse(pageURL)
+	if err != nil {
+		return pageLinks{}
+	}
+	var out pageLinks
+	seen := make(map[string]bool)
+	for _, m := range markdownLinkRe.FindAllStringSubmatch(md, -1) {
+		if link, ok := resolveLink(base, m[1]); ok && !seen[link] {
+			seen[link] = true
+			out.Links = append(out.Links, link)
+		}
+	}
+	return out
+}
+
+func resolveLink(base *nurl.URL, href string) (string, bool) {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return "", false
+	}
+	u, err := base.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String(), true
+}
+
+func hasRelToken(rel, token string) bool {
+	for _, f := range strings.Fields(strings.ToLower(rel)) {
+		if f == token {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestExtractLinksFromHTML(t *testing.T) {
+	body := []byte(`<html><head>
+<base href="https://example.com/docs/">
+<link rel="alternate canonical" href="/docs/guide">
+</head><body>
+<a href="intro#setup">Intro</a>
+<a href="/docs/intro">Intro again</a>
+<a href="#top">Top</a>
+<a href="mailto:team@example.com">Mail</a>
+<a href="javascript:void(0)">JS</a>
+<map><area href="https://other.example/x"></map>
+<a href="//cdn.example.com/file.pdf">PDF</a>
+</body></html>`)
+
+	got := extractLinksFromHTML(body, "https://example.com/docs/guide?ref=1")
+	want := []string{
+		"https://example.com/docs/intro",
+		"https://other.example/x",
+		"https://cdn.example.com/file.pdf",
+	}
+	if !reflect.DeepEqual(got.Links, want) {
+		t.Fatalf("links = %q, want %q", got.Links, want)
+	}
+	if got.Canonical != "https://example.com/docs/guide" {
+		t.Fatalf("canonical = %q", got.Canonical)
+	}
+}
+
+func TestExtractLinksFromMarkdown(t *testing.T) {
+	md := "See [setup](setup.md) and [API](</docs/api> \"API\"), [home](https://example.com/#top) or [setup](./setup.md).\n"
+	got := extractLinksFromMarkdown(md, "https://example.com/docs/index.md")
+	want := []string{
+		"https://example.com/docs/setup.md",
+		"https://example.com/docs/api",
+		"https://example.com/",
+	}
+	if !reflect.DeepEqual(got.Links, want) {
+		t.Fatalf("links = %q, want %q", got.Links, want)
+	}
+}
+
+func TestFetchCollectLinks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><link rel="canonical" href="/canonical"></head><body><h1>Title</h1><p><a href="/next">Next</a></p></body></html>`))
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	res, err := Fetch(context.Background(), ts.URL+"/page", cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Links != nil || res.CanonicalURL != "" {
+		t.Fatalf("links collected without CollectLinks: %+v", res)
+	}
+
+	cfg.CollectLinks = true
+	res, err = Fetch(context.Background(), ts.URL+"/page", cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if !reflect.DeepEqual(res.Links, []string{ts.URL + "/next"}) || res.CanonicalURL != ts.URL+"/canonical" {
+		t.Fatalf("unexpected links: %q canonical %q", res.Links, res.CanonicalURL)
+	}
+}
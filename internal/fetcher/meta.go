@@ -0,0 +1,236 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Metadata fields for Config.MetaFields, in front matter order.
+const (
+	MetaTitle       = "title"
+	MetaDescription = "description"
+	MetaAuthor      = "author"
+	MetaPublished   = "published"
+	MetaModified    = "modified"
+	MetaSiteName    = "site_name"
+	MetaLang        = "lang"
+	MetaCanonical   = "canonical"
+)
+
+var (
+	allMetaFields     = []string{MetaTitle, MetaDescription, MetaAuthor, MetaPublished, MetaModified, MetaSiteName, MetaLang, MetaCanonical}
+	defaultMetaFields = []string{MetaTitle, MetaDescription}
+)
+
+// ParseMetaFields parses a comma-separated list of metadata fields, or "all".
+// An empty list selects the default fields, title and description.
+func ParseMetaFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch {
+		case f == "":
+			continue
+		case f == "all":
+			return slices.Clone(allMetaFields), nil
+		case !slices.Contains(allMetaFields, f):
+			return nil, fmt.Errorf("unknown metadata field %q (want %s or all)", f, strings.Join(allMetaFields, ", "))
+		case !slices.Contains(fields, f):
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return slices.Clone(defaultMetaFields), nil
+	}
+	return fields, nil
+}
+
+// metaFields returns the fields cfg.MetaFields selects.
+func (cfg Config) metaFields() []string {
+	if len(cfg.MetaFields) == 0 {
+		return defaultMetaFields
+	}
+	fields, err := ParseMetaFields(strings.Join(cfg.MetaFields, ","))
+	if err != nil {
+		return defaultMetaFields
+	}
+	return fields
+}
+
+// only returns m with just the given fields. Pages, which is structural
+// rather than descriptive, is always kept.
+func (m pageMeta) only(fields []string) pageMeta {
+	out := pageMeta{Pages: m.Pages}
+	for _, f := range fields {
+		switch f {
+		case MetaTitle:
+			out.Title = m.Title
+		case MetaDescription:
+			out.Description = m.Description
+		case MetaAuthor:
+			out.Author = m.Author
+		case MetaPublished:
+			out.Published = m.Published
+		case MetaModified:
+			out.Modified = m.Modified
+		case MetaSiteName:
+			out.SiteName = m.SiteName
+		case MetaLang:
+			out.Lang = m.Lang
+		case MetaCanonical:
+			out.Canonical = m.Canonical
+		}
+	}
+	return out
+}
+
+// fields returns the set fields of m as key/value pairs in front matter order.
+func (m pageMeta) fields() [][2]string {
+	var out [][2]string
+	for _, kv := range [][2]string{
+		{MetaTitle, m.Title},
+		{MetaDescription, m.Description},
+		{MetaAuthor, m.Author},
+		{MetaPublished, m.Published},
+		{MetaModified, m.Modified},
+		{MetaSiteName, m.SiteName},
+		{MetaLang, m.Lang},
+		{MetaCanonical, m.Canonical},
+	} {
+		if kv[1] = normalizeMetaValue(kv[1]); kv[1] != "" {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// htmlMetaFrontMatter prepends the metadata of the HTML page body to md.
+func htmlMetaFrontMatter(md string, body []byte, pageURL string, cfg Config) string {
+	return prependMetaFrontMatter(md, extractMetaFromHTML(body, pageURL).only(cfg.metaFields()))
+}
+
+// ldArticle holds the fields of a schema.org Article found in JSON-LD.
+type ldArticle struct {
+	Headline    string
+	Description string
+	Author      string
+	Published   string
+	Modified    string
+	Publisher   string
+	Lang        string
+	URL         string
+}
+
+var ldArticleTypes = []string{"Article", "BlogPosting", "TechArticle", "NewsArticle"}
+
+// parseLDArticle returns the first Article, BlogPosting, TechArticle or
+// NewsArticle in a JSON-LD script, looking through arrays and @graph.
+func parseLDArticle(src string) (ldArticle, bool) {
+	var v any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(src)), &v); err != nil {
+		return ldArticle{}, false
+	}
+	return findLDArticle(v)
+}
+
+func findLDArticle(v any) (ldArticle, bool) {
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			if a, ok := findLDArticle(item); ok {
+				return a, true
+			}
+		}
+	case map[string]any:
+		if ldHasType(v["@type"]) {
+			return ldArticle{
+				Headline:    ldString(v["headline"]),
+				Description: ldString(v["description"]),
+				Author:      ldNames(v["author"]),
+				Published:   ldString(v["datePublished"]),
+				Modified:    ldString(v["dateModified"]),
+				Publisher:   ldNames(v["publisher"]),
+				Lang:        ldString(v["inLanguage"]),
+				URL:         ldID(v["mainEntityOfPage"], v["url"]),
+			}, true
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findLDArticle(graph)
+		}
+	}
+	return ldArticle{}, false
+}
+
+func ldHasType(v any) bool {
+	switch v := v.(type) {
+	case string:
+		return slices.Contains(ldArticleTypes, v)
+	case []any:
+		for _, t := range v {
+			if ldHasType(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ldString(v any) string {
+	if s, ok := v.(string); ok {
+		return normalizeMetaValue(s)
+	}
+	return ""
+}
+
+// ldNames returns a Person or Organization name, or the names of a list of
+// them, comma-separated.
+func ldNames(v any) string {
+	switch v := v.(type) {
+	case string:
+		return normalizeMetaValue(v)
+	case map[string]any:
+		return ldString(v["name"])
+	case []any:
+		var names []string
+		for _, item := range v {
+			if name := ldNames(item); name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+// ldID returns the first URL among values that are URLs or {"@id": URL}.
+func ldID(values ...any) string {
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		case map[string]any:
+			if id := ldString(v["@id"]); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// localeToLang turns an OpenGraph locale such as en_US into a language tag.
+func localeToLang(locale string) string {
+	return strings.ReplaceAll(strings.TrimSpace(locale), "_", "-")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
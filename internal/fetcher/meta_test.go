@@ -0,0 +1,90 @@
+package fetcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractMetaFromHTMLRich(t *testing.T) {
+	doc := []byte(`<!doctype html>
+<html lang="en-GB">
+<head>
+  <title>Release notes</title>
+  <meta property="og:site_name" content="Example Docs">
+  <meta property="og:locale" content="fr_FR">
+  <meta property="article:author" content="https://example.com/authors/ada">
+  <meta property="article:modified_time" content="2024-03-02T00:00:00Z">
+  <meta name="twitter:creator" content="@ada">
+  <link rel="canonical" href="/docs/release-notes">
+  <script type="application/ld+json">{"@type": "Organization", "name": "Example Inc"}</script>
+</head>
+<body>
+  <script type="application/ld+json">{
+    "@context": "https://schema.org",
+    "@graph": [
+      {"@type": "WebPage", "name": "ignored"},
+      {"@type": ["TechArticle"], "headline": "Headline",
+       "author": [{"@type": "Person", "name": "Ada Lovelace"}, {"name": "Charles Babbage"}],
+       "datePublished": "2024-03-01", "publisher": {"name": "Example Inc"}}
+    ]
+  }</script>
+</body>
+</html>`)
+
+	got := extractMetaFromHTML(doc, "https://example.com/docs/page")
+	want := pageMeta{
+		Title:     "Release notes",
+		Author:    "Ada Lovelace, Charles Babbage",
+		Published: "2024-03-01",
+		Modified:  "2024-03-02T00:00:00Z",
+		SiteName:  "Example Docs",
+		Lang:      "en-GB",
+		Canonical: "https://example.com/docs/release-notes",
+	}
+	if got != want {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+
+	// Without JSON-LD and <html lang>, the meta tags fill in.
+	doc = []byte(`<html><head>
+  <meta name="twitter:title" content="Card title">
+  <meta name="author" content="Grace Hopper">
+  <meta property="article:published_time" content="2023-01-01">
+  <meta property="og:locale" content="pt_BR">
+  <meta property="og:url" content="https://example.com/card">
+</head><body></body></html>`)
+	got = extractMetaFromHTML(doc, "https://example.com/other")
+	want = pageMeta{Title: "Card title", Author: "Grace Hopper", Published: "2023-01-01", Lang: "pt-BR", Canonical: "https://example.com/card"}
+	if got != want {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestParseMetaFields(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{"title", "description"}},
+		{"Author, published,author", []string{"author", "published"}},
+		{"title,all", []string{"title", "description", "author", "published", "modified", "site_name", "lang", "canonical"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseMetaFields(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMetaFields(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseMetaFields("title,date"); err == nil {
+		t.Error("unknown field accepted")
+	}
+}
+
+func TestPrependMetaFrontMatterFields(t *testing.T) {
+	meta := pageMeta{Title: "T", Description: "D", Author: "A", Lang: "en", Canonical: "https://example.com/", Pages: 2}
+	got := prependMetaFrontMatter("# Doc\n", meta.only([]string{MetaCanonical, MetaAuthor, MetaLang}))
+	want := "---\nauthor: 'A'\nlang: 'en'\ncanonical: 'https://example.com/'\npages: 2\n---\n\n# Doc\n"
+	if got != want {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
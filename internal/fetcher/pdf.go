@@ -0,0 +1,807 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// This file holds a small, tolerant PDF object reader: enough to walk the page
+// tree, decode content streams and read fonts for text extraction. It locates
+// objects by scanning for "N G obj" rather than trusting the xref table, so
+// truncated (max-body-bytes) or slightly damaged files still yield text.
+
+const (
+	maxPDFStreamBytes = 64 << 20
+	// maxPDFDecodedBytes bounds the inflated size of all streams together, so
+	// a small file cannot expand into gigabytes through many streams.
+	maxPDFDecodedBytes = 256 << 20
+	maxPDFNesting      = 64
+)
+
+var (
+	errPDFEncrypted = errors.New("encrypted PDF is not supported")
+	errPDFTooLarge  = errors.New("PDF streams exceed the decoded size limit")
+
+	pdfObjectHeader = regexp.MustCompile(`(\d+)[ \t\r\n\f\x00]+(\d+)[ \t\r\n\f\x00]+obj\b`)
+)
+
+type (
+	pdfName    string
+	pdfString  string
+	pdfKeyword string
+	pdfDict    map[pdfName]any
+	pdfRef     struct{ num, gen int }
+	pdfStream  struct {
+		dict pdfDict
+		raw  []byte
+	}
+)
+
+type pdfDocument struct {
+	objects map[int]any
+	trailer pdfDict
+
+	// decoded caches stream data by object: pages often share content
+	// streams, fonts and forms, and each is inflated only once.
+	decoded map[*pdfStream]pdfDecoded
+	// budget is what remains of maxPDFDecodedBytes.
+	budget int64
+}
+
+type pdfDecoded struct {
+	data []byte
+	err  error
+}
+
+func isPDFResponse(resp responseData) bool {
+	mediaType, _, _ := strings.Cut(resp.ContentType, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "application/pdf", "application/x-pdf":
+		return true
+	}
+	return hasPDFMagic(resp.Body)
+}
+
+func hasPDFMagic(body []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(body, " \t\r\n\f\x00"), []byte("%PDF-"))
+}
+
+func parsePDF(data []byte) (*pdfDocument, error) {
+	if !hasPDFMagic(data) {
+		return nil, errors.New("missing %PDF header")
+	}
+	doc := &pdfDocument{
+		objects: make(map[int]any),
+		trailer: make(pdfDict),
+		decoded: make(map[*pdfStream]pdfDecoded),
+		budget:  maxPDFDecodedBytes,
+	}
+
+	var objStreams []*pdfStream
+	pos := 0
+	for pos < len(data) {
+		loc := pdfObjectHeader.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		start := pos + loc[0]
+		if start > 0 && !isPDFSpace(data[start-1]) && !isPDFDelimiter(data[start-1]) {
+			pos = pos + loc[1]
+			continue
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		p := &pdfParser{data: data, pos: pos + loc[1]}
+		obj, err := p.parseObject(0)
+		if err != nil {
+			pos = pos + loc[1]
+			continue
+		}
+		if dict, ok := obj.(pdfDict); ok {
+			if stream, ok := p.parseStreamBody(dict); ok {
+				obj = stream
+				if typ, _ := dict["Type"].(pdfName); typ == "ObjStm" {
+					objStreams = append(objStreams, stream)
+				}
+				if typ, _ := dict["Type"].(pdfName); typ == "XRef" {
+					doc.mergeTrailer(dict)
+				}
+			}
+		}
+		doc.objects[num] = obj
+		pos = p.pos
+	}
+
+	for _, idx := range trailerOffsets(data) {
+		p := &pdfParser{data: data, pos: idx}
+		if dict, ok := mustDict(p.parseObject(0)); ok {
+			doc.mergeTrailer(dict)
+		}
+	}
+
+	for _, stream := range objStreams {
+		doc.loadObjectStream(stream)
+	}
+
+	if _, ok := doc.trailer["Encrypt"]; ok {
+		return nil, errPDFEncrypted
+	}
+	if len(doc.objects) == 0 {
+		return nil, errors.New("no PDF objects found")
+	}
+	return doc, nil
+}
+
+func trailerOffsets(data []byte) []int {
+	var offsets []int
+	kw := []byte("trailer")
+	for i := 0; ; {
+		idx := bytes.Index(data[i:], kw)
+		if idx < 0 {
+			return offsets
+		}
+		offsets = append(offsets, i+idx+len(kw))
+		i += idx + len(kw)
+	}
+}
+
+func mustDict(obj any, err error) (pdfDict, bool) {
+	if err != nil {
+		return nil, false
+	}
+	dict, ok := obj.(pdfDict)
+	return dict, ok
+}
+
+// mergeTrailer keeps the entries of later trailers, which belong to newer
+// incremental updates.
+func (d *pdfDocument) mergeTrailer(dict pdfDict) {
+	for _, key := range []pdfName{"Root", "Info", "Encrypt"} {
+		if v, ok := dict[key]; ok {
+			d.trailer[key] = v
+		}
+	}
+}
+
+func (d *pdfDocument) loadObjectStream(stream *pdfStream) {
+	data, err := d.streamData(stream)
+	if err != nil {
+		return
+	}
+	n := d.intValue(stream.dict["N"])
+	first := d.intValue(stream.dict["First"])
+	if n <= 0 || first <= 0 || first > len(data) {
+		return
+	}
+
+	header := &pdfParser{data: data[:first]}
+	for i := 0; i < n; i++ {
+		numObj, err1 := header.parseObject(0)
+		offObj, err2 := header.parseObject(0)
+		if err1 != nil || err2 != nil {
+			return
+		}
+		num, ok1 := numObj.(int)
+		off, ok2 := offObj.(int)
+		if !ok1 || !ok2 || first+off >= len(data) {
+			return
+		}
+		if _, exists := d.objects[num]; exists {
+			continue
+		}
+		p := &pdfParser{data: data, pos: first + off}
+		if obj, err := p.parseObject(0); err == nil {
+			d.objects[num] = obj
+		}
+	}
+}
+
+func (d *pdfDocument) resolve(obj any) any {
+	for i := 0; i < 32; i++ {
+		ref, ok := obj.(pdfRef)
+		if !ok {
+			return obj
+		}
+		obj = d.objects[ref.num]
+	}
+	return nil
+}
+
+func (d *pdfDocument) dict(obj any) pdfDict {
+	switch v := d.resolve(obj).(type) {
+	case pdfDict:
+		return v
+	case *pdfStream:
+		return v.dict
+	default:
+		return nil
+	}
+}
+
+func (d *pdfDocument) array(obj any) []any {
+	arr, _ := d.resolve(obj).([]any)
+	return arr
+}
+
+func (d *pdfDocument) name(obj any) pdfName {
+	n, _ := d.resolve(obj).(pdfName)
+	return n
+}
+
+func (d *pdfDocument) intValue(obj any) int {
+	switch v := d.resolve(obj).(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+func (d *pdfDocument) number(obj any) (float64, bool) {
+	switch v := d.resolve(obj).(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// streamData returns the decoded bytes of a stream, applying its filters.
+// Results are cached, and all decoded data together is bounded by the budget.
+func (d *pdfDocument) streamData(s *pdfStream) ([]byte, error) {
+	if cached, ok := d.decoded[s]; ok {
+		return cached.data, cached.err
+	}
+	data, err := d.decodeStream(s)
+	if err == nil {
+		if int64(len(data)) > d.budget {
+			data, err = nil, errPDFTooLarge
+		} else {
+			d.budget -= int64(len(data))
+		}
+	}
+	d.decoded[s] = pdfDecoded{data: data, err: err}
+	return data, err
+}
+
+func (d *pdfDocument) decodeStream(s *pdfStream) ([]byte, error) {
+	data := s.raw
+	filters := d.filterNames(s.dict["Filter"])
+	for _, f := range filters {
+		var err error
+		switch f {
+		case "FlateDecode", "Fl":
+			data, err = inflatePDF(data, min(maxPDFStreamBytes, d.budget+1))
+		case "ASCIIHexDecode", "AHx":
+			data, err = decodePDFHex(data)
+		case "ASCII85Decode", "A85":
+			data, err = decodePDFASCII85(data)
+		default:
+			err = fmt.Errorf("unsupported PDF filter %s", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (d *pdfDocument) filterNames(obj any) []pdfName {
+	switch v := d.resolve(obj).(type) {
+	case pdfName:
+		return []pdfName{v}
+	case []any:
+		names := make([]pdfName, 0, len(v))
+		for _, item := range v {
+			names = append(names, d.name(item))
+		}
+		return names
+	default:
+		return nil
+	}
+}
+
+func inflatePDF(data []byte, limit int64) ([]byte, error) {
+	var r io.ReadCloser
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, limit))
+	// Many producers write streams with a bad checksum or a truncated tail;
+	// keep whatever inflated cleanly.
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("inflate PDF stream: %w", err)
+	}
+	return out, nil
+}
+
+func decodePDFHex(data []byte) ([]byte, error) {
+	clean := make([]byte, 0, len(data))
+	for _, c := range data {
+		if c == '>' {
+			break
+		}
+		if !isPDFSpace(c) {
+			clean = append(clean, c)
+		}
+	}
+	if len(clean)%2 == 1 {
+		clean = append(clean, '0')
+	}
+	out := make([]byte, len(clean)/2)
+	_, err := hex.Decode(out, clean)
+	return out, err
+}
+
+func decodePDFASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if idx := bytes.Index(data, []byte("~>")); idx >= 0 {
+		data = data[:idx]
+	}
+	out := make([]byte, len(data)*4/5+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	return out[:n], err
+}
+
+// decodePDFTextString decodes a text string from document metadata
+// (UTF-16BE with BOM, UTF-8 with BOM, or PDFDocEncoding).
+func decodePDFTextString(s pdfString) string {
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		return decodeUTF16BE(b[2:])
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		return string(b[3:])
+	default:
+		var sb strings.Builder
+		for _, c := range b {
+			sb.WriteRune(winAnsiRune(c))
+		}
+		return sb.String()
+	}
+}
+
+func decodeUTF16BE(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+type pdfParser struct {
+	data []byte
+	pos  int
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0:
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (p *pdfParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if isPDFSpace(c) {
+			p.pos++
+			continue
+		}
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+func (p *pdfParser) eof() bool {
+	p.skipSpace()
+	return p.pos >= len(p.data)
+}
+
+// parseObject reads the next object. Bare words (content stream operators,
+// "endobj", ...) come back as pdfKeyword.
+func (p *pdfParser) parseObject(depth int) (any, error) {
+	if depth > maxPDFNesting {
+		return nil, errors.New("PDF object nesting too deep")
+	}
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		p.pos++
+		return pdfName(p.readRegular(true)), nil
+	case c == '(':
+		p.pos++
+		return p.readLiteralString(), nil
+	case c == '<':
+		if p.pos+1 < len(p.data) && p.data[p.pos+1] == '<' {
+			p.pos += 2
+			return p.readDict(depth)
+		}
+		p.pos++
+		return p.readHexString(), nil
+	case c == '[':
+		p.pos++
+		var arr []any
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.data) {
+				return arr, io.ErrUnexpectedEOF
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return arr, nil
+			}
+			item, err := p.parseObject(depth + 1)
+			if err != nil {
+				return arr, err
+			}
+			arr = append(arr, item)
+		}
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.readNumberOrRef(), nil
+	case c == ')' || c == '>' || c == ']' || c == '{' || c == '}':
+		p.pos++
+		return pdfKeyword(string(c)), nil
+	default:
+		word := p.readRegular(false)
+		if word == "" {
+			p.pos++
+			return pdfKeyword(string(c)), nil
+		}
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return pdfKeyword(word), nil
+	}
+}
+
+func (p *pdfParser) readRegular(decodeHex bool) string {
+	start := p.pos
+	for p.pos < len(p.data) && !isPDFSpace(p.data[p.pos]) && !isPDFDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	word := string(p.data[start:p.pos])
+	if decodeHex && strings.Contains(word, "#") {
+		var b strings.Builder
+		for i := 0; i < len(word); i++ {
+			if word[i] == '#' && i+2 < len(word) {
+				if v, err := strconv.ParseUint(word[i+1:i+3], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+					i += 2
+					continue
+				}
+			}
+			b.WriteByte(word[i])
+		}
+		word = b.String()
+	}
+	return word
+}
+
+func (p *pdfParser) readNumberOrRef() any {
+	word := p.readRegular(false)
+	if !strings.ContainsAny(word, ".eE") {
+		n, err := strconv.Atoi(word)
+		if err != nil {
+			return 0
+		}
+		// "num gen R" is an indirect reference.
+		save := p.pos
+		p.skipSpace()
+		genStart := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+		}
+		if p.pos > genStart {
+			gen, _ := strconv.Atoi(string(p.data[genStart:p.pos]))
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == 'R' &&
+				(p.pos+1 == len(p.data) || isPDFSpace(p.data[p.pos+1]) || isPDFDelimiter(p.data[p.pos+1])) {
+				p.pos++
+				return pdfRef{num: n, gen: gen}
+			}
+		}
+		p.pos = save
+		return n
+	}
+	f, err := strconv.ParseFloat(word, 64)
+	if err != nil {
+		// Producers emit oddities like "--1" or "1.2.3"; treat them as zero.
+		return 0.0
+	}
+	return f
+}
+
+func (p *pdfParser) readLiteralString() pdfString {
+	var b []byte
+	depth := 1
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(b)
+			}
+		case '\\':
+			if p.pos >= len(p.data) {
+				return pdfString(b)
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return pdfString(b)
+}
+
+func (p *pdfParser) readHexString() pdfString {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] != '>' {
+		p.pos++
+	}
+	raw := p.data[start:p.pos]
+	if p.pos < len(p.data) {
+		p.pos++
+	}
+	out, _ := decodePDFHex(raw)
+	return pdfString(out)
+}
+
+func (p *pdfParser) readDict(depth int) (pdfDict, error) {
+	dict := make(pdfDict)
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return dict, io.ErrUnexpectedEOF
+		}
+		if p.data[p.pos] == '>' {
+			p.pos++
+			if p.pos < len(p.data) && p.data[p.pos] == '>' {
+				p.pos++
+			}
+			return dict, nil
+		}
+		keyObj, err := p.parseObject(depth + 1)
+		if err != nil {
+			return dict, err
+		}
+		key, ok := keyObj.(pdfName)
+		if !ok {
+			continue
+		}
+		val, err := p.parseObject(depth + 1)
+		if err != nil {
+			return dict, err
+		}
+		if _, isKeyword := val.(pdfKeyword); isKeyword {
+			continue
+		}
+		dict[key] = val
+	}
+}
+
+// parseStreamBody reads the stream data that follows dict, if any.
+func (p *pdfParser) parseStreamBody(dict pdfDict) (*pdfStream, bool) {
+	save := p.pos
+	p.skipSpace()
+	if !bytes.HasPrefix(p.data[p.pos:], []byte("stream")) {
+		p.pos = save
+		return nil, false
+	}
+	p.pos += len("stream")
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+		p.pos++
+	}
+	start := p.pos
+
+	if length, ok := dict["Length"].(int); ok && length >= 0 && start+length <= len(p.data) {
+		tail := &pdfParser{data: p.data, pos: start + length}
+		tail.skipSpace()
+		if bytes.HasPrefix(p.data[tail.pos:], []byte("endstream")) {
+			p.pos = tail.pos + len("endstream")
+			return &pdfStream{dict: dict, raw: p.data[start : start+length]}, true
+		}
+	}
+
+	end := bytes.Index(p.data[start:], []byte("endstream"))
+	if end < 0 {
+		p.pos = len(p.data)
+		return &pdfStream{dict: dict, raw: p.data[start:]}, true
+	}
+	raw := p.data[start : start+end]
+	raw = bytes.TrimSuffix(raw, []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	p.pos = start + end + len("endstream")
+	return &pdfStream{dict: dict, raw: raw}, true
+}
+
+type pdfPage struct {
+	dict      pdfDict
+	resources pdfDict
+}
+
+// pages returns the document's pages in order, following the page tree from
+// the catalog and falling back to every /Type /Page object when it is broken.
+func (d *pdfDocument) pages() []pdfPage {
+	var pages []pdfPage
+	visited := make(map[int]bool)
+	var walk func(node any, resources pdfDict, depth int)
+	walk = func(node any, resources pdfDict, depth int) {
+		if depth > maxPDFNesting {
+			return
+		}
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref.num] {
+				return
+			}
+			visited[ref.num] = true
+		}
+		dict := d.dict(node)
+		if dict == nil {
+			return
+		}
+		if res := d.dict(dict["Resources"]); res != nil {
+			resources = res
+		}
+		switch d.name(dict["Type"]) {
+		case "Pages":
+			for _, kid := range d.array(dict["Kids"]) {
+				walk(kid, resources, depth+1)
+			}
+		case "Page":
+			pages = append(pages, pdfPage{dict: dict, resources: resources})
+		default:
+			if kids := d.array(dict["Kids"]); kids != nil {
+				for _, kid := range kids {
+					walk(kid, resources, depth+1)
+				}
+			}
+		}
+	}
+
+	if catalog := d.catalog(); catalog != nil {
+		walk(catalog["Pages"], nil, 0)
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	nums := make([]int, 0, len(d.objects))
+	for num := range d.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if dict, ok := d.objects[num].(pdfDict); ok && d.name(dict["Type"]) == "Page" {
+			pages = append(pages, pdfPage{dict: dict, resources: d.dict(dict["Resources"])})
+		}
+	}
+	return pages
+}
+
+func (d *pdfDocument) catalog() pdfDict {
+	if root := d.dict(d.trailer["Root"]); root != nil {
+		return root
+	}
+	for _, obj := range d.objects {
+		if dict, ok := obj.(pdfDict); ok && d.name(dict["Type"]) == "Catalog" {
+			return dict
+		}
+	}
+	return nil
+}
+
+func (d *pdfDocument) title() string {
+	info := d.dict(d.trailer["Info"])
+	if info == nil {
+		return ""
+	}
+	s, _ := d.resolve(info["Title"]).(pdfString)
+	return strings.TrimSpace(decodePDFTextString(s))
+}
+
+// pageContent concatenates a page's content streams.
+func (d *pdfDocument) pageContent(page pdfDict) []byte {
+	var parts []any
+	switch v := page["Contents"].(type) {
+	case []any:
+		parts = v
+	default:
+		if arr := d.array(v); arr != nil {
+			parts = arr
+		} else {
+			parts = []any{v}
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, part := range parts {
+		stream, ok := d.resolve(part).(*pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := d.streamData(stream)
+		if err != nil {
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
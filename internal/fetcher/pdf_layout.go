@@ -0,0 +1,532 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pdfLine is one visual line of text on a page. Cells are the line's text
+// split at wide horizontal gaps; a line with several cells may be a table row.
+type pdfLine struct {
+	y, x, endX float64
+	size       float64
+	bold       bool
+	cells      []string
+}
+
+func (l pdfLine) text() string {
+	return strings.Join(l.cells, " ")
+}
+
+type pdfBlockKind int
+
+const (
+	pdfBlockParagraph pdfBlockKind = iota
+	pdfBlockHeading
+	pdfBlockListItem
+	pdfBlockTable
+)
+
+type pdfBlock struct {
+	kind  pdfBlockKind
+	level int
+	text  string
+	rows  [][]string
+}
+
+// pdfMarkdown is a converted PDF. Truncated reports that the text reached the
+// size limit and later pages were dropped.
+type pdfMarkdown struct {
+	text      string
+	pages     int
+	truncated bool
+}
+
+// pdfToMarkdown lays out the text of every page and renders it as Markdown
+// with a page marker before each page, stopping once the text exceeds limit
+// bytes. It checks ctx before each page.
+func pdfToMarkdown(ctx context.Context, doc *pdfDocument, limit int) (pdfMarkdown, error) {
+	pages := doc.pages()
+	out := pdfMarkdown{pages: len(pages)}
+	var pageLines [][]pdfLine
+	size := 0
+	for _, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return pdfMarkdown{}, err
+		}
+		if size > limit {
+			out.truncated = true
+			break
+		}
+		lines := layoutPDFPage(doc.pageTextRuns(page))
+		for _, l := range lines {
+			for _, c := range l.cells {
+				size += len(c) + 1
+			}
+		}
+		pageLines = append(pageLines, lines)
+	}
+
+	body := pdfBodySize(pageLines)
+	levels := pdfHeadingLevels(pageLines, body)
+
+	var b strings.Builder
+	hasText := false
+	for i, lines := range pageLines {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "<!-- page %d -->\n", i+1)
+		prev := pdfBlockParagraph
+		for j, block := range pdfBlocks(lines, body, levels) {
+			hasText = true
+			// Keep consecutive list items in one tight list.
+			if j == 0 || block.kind != pdfBlockListItem || prev != pdfBlockListItem {
+				b.WriteString("\n")
+			}
+			b.WriteString(renderPDFBlock(block))
+			b.WriteString("\n")
+			prev = block.kind
+		}
+	}
+	if !hasText {
+		return out, nil
+	}
+	out.text = b.String()
+	if len(out.text) > limit {
+		out.text = strings.ToValidUTF8(out.text[:limit], "")
+		out.truncated = true
+	}
+	return out, nil
+}
+
+// layoutPDFPage groups runs into lines, reading a two-column page column by column.
+func layoutPDFPage(runs []pdfTextRun) []pdfLine {
+	runs = cleanPDFRuns(runs)
+	if len(runs) == 0 {
+		return nil
+	}
+	if gutter, ok := pdfColumnGutter(runs); ok {
+		var left, right []pdfTextRun
+		for _, r := range runs {
+			if r.x < gutter {
+				left = append(left, r)
+			} else {
+				right = append(right, r)
+			}
+		}
+		return append(groupPDFLines(left), groupPDFLines(right)...)
+	}
+	return groupPDFLines(runs)
+}
+
+func cleanPDFRuns(runs []pdfTextRun) []pdfTextRun {
+	out := runs[:0]
+	for _, r := range runs {
+		r.text = strings.Map(func(c rune) rune {
+			if c == ' ' || c == '\t' {
+				return ' '
+			}
+			if unicode.IsControl(c) || c == utf8.RuneError {
+				return -1
+			}
+			return c
+		}, r.text)
+		if strings.TrimSpace(r.text) == "" {
+			continue
+		}
+		if r.endX < r.x {
+			r.endX = r.x
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// pdfColumnGutter looks for a vertical band in the middle of the page that
+// separates nearly every line into a left and a right part by a gap wider
+// than a word space, with a column of full-width text on each side. Tables
+// also leave such bands, but their cells are short.
+func pdfColumnGutter(runs []pdfTextRun) (float64, bool) {
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for _, r := range runs {
+		minX = math.Min(minX, r.x)
+		maxX = math.Max(maxX, r.endX)
+	}
+	width := maxX - minX
+	if width < 200 || len(runs) < 10 {
+		return 0, false
+	}
+
+	groups := groupPDFRuns(runs)
+	best, bestCrossing := 0.0, len(groups)+1
+	for x := minX + width*0.35; x <= minX+width*0.65; x += 2 {
+		crossing := 0
+		for _, g := range groups {
+			if pdfRunsCross(g, x) {
+				crossing++
+			}
+		}
+		if crossing < bestCrossing {
+			best, bestCrossing = x, crossing
+		}
+	}
+	if bestCrossing*10 > len(groups) {
+		return 0, false
+	}
+
+	var left, right []pdfTextRun
+	for _, r := range runs {
+		if r.x < best {
+			left = append(left, r)
+		} else {
+			right = append(right, r)
+		}
+	}
+	if !isPDFTextColumn(left, width) || !isPDFTextColumn(right, width) {
+		return 0, false
+	}
+	return best, true
+}
+
+// pdfRunsCross reports whether a line's text continues across x: a run
+// covers x, or the gap around x is no wider than a word space.
+func pdfRunsCross(line []pdfTextRun, x float64) bool {
+	leftEnd, rightStart := math.Inf(-1), math.Inf(1)
+	for _, r := range line {
+		switch {
+		case r.x < x && r.endX > x:
+			return true
+		case r.endX <= x:
+			leftEnd = math.Max(leftEnd, r.endX)
+		default:
+			rightStart = math.Min(rightStart, r.x)
+		}
+	}
+	return rightStart-leftEnd < line[0].size
+}
+
+func isPDFTextColumn(runs []pdfTextRun, pageWidth float64) bool {
+	lines := groupPDFLines(runs)
+	if len(lines) < 5 {
+		return false
+	}
+	var total float64
+	for _, l := range lines {
+		total += l.endX - l.x
+	}
+	return total/float64(len(lines)) >= pageWidth*0.25
+}
+
+func groupPDFLines(runs []pdfTextRun) []pdfLine {
+	groups := groupPDFRuns(runs)
+	lines := make([]pdfLine, 0, len(groups))
+	for _, g := range groups {
+		lines = append(lines, buildPDFLine(g))
+	}
+	return lines
+}
+
+// groupPDFRuns groups runs sharing a baseline, top to bottom, each group
+// ordered left to right.
+func groupPDFRuns(runs []pdfTextRun) [][]pdfTextRun {
+	sorted := append([]pdfTextRun(nil), runs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].y != sorted[j].y {
+			return sorted[i].y > sorted[j].y
+		}
+		return sorted[i].x < sorted[j].x
+	})
+
+	var groups [][]pdfTextRun
+	for _, r := range sorted {
+		if n := len(groups); n > 0 {
+			last := groups[n-1]
+			tol := math.Max(last[0].size, r.size) * 0.5
+			if math.Abs(last[0].y-r.y) <= tol {
+				groups[n-1] = append(last, r)
+				continue
+			}
+		}
+		groups = append(groups, []pdfTextRun{r})
+	}
+	for _, g := range groups {
+		sort.SliceStable(g, func(i, j int) bool { return g[i].x < g[j].x })
+	}
+	return groups
+}
+
+func buildPDFLine(runs []pdfTextRun) pdfLine {
+	line := pdfLine{y: runs[0].y, x: runs[0].x, endX: runs[0].endX, bold: true}
+	sizeChars := make(map[float64]int)
+	var cell strings.Builder
+	prevEnd := runs[0].x
+	for i, r := range runs {
+		if i > 0 {
+			gap := r.x - prevEnd
+			switch {
+			case gap > math.Max(r.size, 4)*1.5:
+				line.cells = append(line.cells, strings.TrimSpace(cell.String()))
+				cell.Reset()
+			case gap > r.size*0.15:
+				if !strings.HasSuffix(cell.String(), " ") && !strings.HasPrefix(r.text, " ") {
+					cell.WriteByte(' ')
+				}
+			}
+		}
+		cell.WriteString(r.text)
+		prevEnd = math.Max(prevEnd, r.endX)
+		line.endX = math.Max(line.endX, r.endX)
+		sizeChars[math.Round(r.size*2)/2] += utf8.RuneCountInString(r.text)
+		if !r.bold {
+			line.bold = false
+		}
+	}
+	line.cells = append(line.cells, strings.TrimSpace(cell.String()))
+	for i, c := range line.cells {
+		line.cells[i] = strings.Join(strings.Fields(c), " ")
+	}
+	line.size = dominantPDFSize(sizeChars)
+	return line
+}
+
+func dominantPDFSize(sizeChars map[float64]int) float64 {
+	best, bestCount := 0.0, -1
+	for size, count := range sizeChars {
+		if count > bestCount || (count == bestCount && size > best) {
+			best, bestCount = size, count
+		}
+	}
+	return best
+}
+
+// pdfBodySize is the font size that carries most of the document's text.
+func pdfBodySize(pages [][]pdfLine) float64 {
+	sizeChars := make(map[float64]int)
+	for _, lines := range pages {
+		for _, l := range lines {
+			sizeChars[l.size] += utf8.RuneCountInString(l.text())
+		}
+	}
+	return dominantPDFSize(sizeChars)
+}
+
+// pdfHeadingLevels maps font sizes noticeably larger than the body size to
+// heading levels, largest first, using at most three levels.
+func pdfHeadingLevels(pages [][]pdfLine, body float64) map[float64]int {
+	seen := make(map[float64]bool)
+	var sizes []float64
+	for _, lines := range pages {
+		for _, l := range lines {
+			if l.size >= body*1.15 && len(l.cells) == 1 && !seen[l.size] {
+				seen[l.size] = true
+				sizes = append(sizes, l.size)
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(sizes)))
+	levels := make(map[float64]int, len(sizes))
+	for i, size := range sizes {
+		levels[size] = min(i+1, 3)
+	}
+	return levels
+}
+
+func pdfBlocks(lines []pdfLine, body float64, levels map[float64]int) []pdfBlock {
+	lines = dropPDFPageNumbers(lines)
+	boldLevel := 2
+	for _, level := range levels {
+		boldLevel = max(boldLevel, min(level+1, 4))
+	}
+
+	var blocks []pdfBlock
+	var para []pdfLine
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		kind := pdfBlockParagraph
+		text := joinPDFLines(para)
+		if rest, ok := cutPDFBullet(text); ok {
+			kind = pdfBlockListItem
+			text = rest
+		}
+		blocks = append(blocks, pdfBlock{kind: kind, text: text})
+		para = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		text := line.text()
+
+		if rows := pdfTableRows(lines[i:]); len(rows) >= 2 {
+			flush()
+			blocks = append(blocks, pdfBlock{kind: pdfBlockTable, rows: rows})
+			i += len(rows) - 1
+			continue
+		}
+
+		if level, ok := levels[line.size]; ok && len(line.cells) == 1 && utf8.RuneCountInString(text) <= 200 {
+			flush()
+			// Headings that wrap onto a second line at the same size stay one heading.
+			if n := len(blocks); n > 0 && blocks[n-1].kind == pdfBlockHeading && blocks[n-1].level == level &&
+				i > 0 && lines[i-1].size == line.size && lines[i-1].y-line.y <= line.size*1.6 {
+				blocks[n-1].text += " " + text
+				continue
+			}
+			blocks = append(blocks, pdfBlock{kind: pdfBlockHeading, level: level, text: text})
+			continue
+		}
+		if line.bold && len(line.cells) == 1 && line.size >= body*0.95 && isPDFHeadingText(text) &&
+			(i+1 == len(lines) || !lines[i+1].bold) {
+			flush()
+			blocks = append(blocks, pdfBlock{kind: pdfBlockHeading, level: boldLevel, text: text})
+			continue
+		}
+
+		if len(para) > 0 {
+			prev := para[len(para)-1]
+			gap := prev.y - line.y
+			_, bullet := cutPDFBullet(text)
+			if bullet || gap > math.Max(prev.size, line.size)*1.6 || gap < 0 || endsPDFParagraph(prev, para) {
+				flush()
+			}
+		}
+		para = append(para, line)
+	}
+	flush()
+	return blocks
+}
+
+// dropPDFPageNumbers removes a bare page number at the top or bottom of a page.
+func dropPDFPageNumbers(lines []pdfLine) []pdfLine {
+	isNumber := func(l pdfLine) bool {
+		t := strings.TrimSpace(l.text())
+		t = strings.TrimPrefix(strings.TrimPrefix(t, "Page "), "page ")
+		if t == "" || len(t) > 5 {
+			return false
+		}
+		for _, c := range t {
+			if !unicode.IsDigit(c) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(lines) > 0 && isNumber(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && isNumber(lines[0]) {
+		lines = lines[1:]
+	}
+	return lines
+}
+
+// pdfTableRows returns the leading run of lines that share the same number of
+// cells (at least two), with cells starting at roughly the same positions.
+func pdfTableRows(lines []pdfLine) [][]string {
+	if len(lines) == 0 || len(lines[0].cells) < 2 {
+		return nil
+	}
+	cols := len(lines[0].cells)
+	var rows [][]string
+	for i, l := range lines {
+		if len(l.cells) != cols {
+			break
+		}
+		if i > 0 && lines[i-1].y-l.y > math.Max(l.size, lines[i-1].size)*3 {
+			break
+		}
+		rows = append(rows, l.cells)
+	}
+	return rows
+}
+
+func isPDFHeadingText(text string) bool {
+	n := utf8.RuneCountInString(text)
+	if n == 0 || n > 80 {
+		return false
+	}
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return !strings.ContainsRune(".,;:", last)
+}
+
+// endsPDFParagraph reports whether prev looks like the last line of a
+// paragraph: it ends a sentence well short of the paragraph's right edge.
+func endsPDFParagraph(prev pdfLine, para []pdfLine) bool {
+	text := prev.text()
+	last, _ := utf8.DecodeLastRuneInString(text)
+	if !strings.ContainsRune(".!?:", last) {
+		return false
+	}
+	right := prev.endX
+	for _, l := range para {
+		right = math.Max(right, l.endX)
+	}
+	return len(para) > 1 && right-prev.endX > prev.size*4
+}
+
+func joinPDFLines(lines []pdfLine) string {
+	var b strings.Builder
+	for i, l := range lines {
+		text := l.text()
+		if i > 0 {
+			cur := b.String()
+			next, _ := utf8.DecodeRuneInString(text)
+			if strings.HasSuffix(cur, "-") && len(cur) > 1 && unicode.IsLetter(rune(cur[len(cur)-2])) && unicode.IsLower(next) {
+				// Rejoin a word hyphenated across lines.
+				b.Reset()
+				b.WriteString(strings.TrimSuffix(cur, "-"))
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// cutPDFBullet strips a leading bullet glyph, reporting whether there was one.
+func cutPDFBullet(text string) (string, bool) {
+	r, size := utf8.DecodeRuneInString(text)
+	switch r {
+	case '•', '◦', '▪', '‣', '●', '○', '■', '–', '-', '*':
+		rest := strings.TrimSpace(text[size:])
+		if rest == "" || (r == '-' && !strings.HasPrefix(text, "- ")) {
+			return text, false
+		}
+		return rest, true
+	}
+	return text, false
+}
+
+func renderPDFBlock(block pdfBlock) string {
+	switch block.kind {
+	case pdfBlockHeading:
+		return strings.Repeat("#", block.level) + " " + block.text
+	case pdfBlockListItem:
+		return "- " + block.text
+	case pdfBlockTable:
+		var b strings.Builder
+		for i, row := range block.rows {
+			b.WriteString("|")
+			for _, cell := range row {
+				b.WriteString(" ")
+				b.WriteString(strings.ReplaceAll(cell, "|", `\|`))
+				b.WriteString(" |")
+			}
+			b.WriteString("\n")
+			if i == 0 {
+				b.WriteString("|" + strings.Repeat(" --- |", len(row)) + "\n")
+			}
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	default:
+		return block.text
+	}
+}
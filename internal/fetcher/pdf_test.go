@@ -0,0 +1,356 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildTestPDF assembles a PDF from object bodies numbered from 1, with a
+// classic xref table and the given trailer entries.
+func buildTestPDF(objects []string, trailer string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return b.Bytes()
+}
+
+func testPDFStream(dict, content string, compress bool) string {
+	data := []byte(content)
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func textAt(font string, size, x, y float64, text string) string {
+	return fmt.Sprintf("BT /%s %g Tf %g %g Td (%s) Tj ET\n", font, size, x, y, text)
+}
+
+func reportPDF() []byte {
+	page1 := textAt("F2", 24, 72, 720, "Annual Report") +
+		"BT /F1 12 Tf 72 690 Td 14 TL (This first paragraph explains the results and the docu-) Tj" +
+		" (ment continues on the next line.) ' ET\n" +
+		textAt("F2", 12, 72, 640, "Name") + textAt("F2", 12, 250, 640, "Qty") + textAt("F2", 12, 400, 640, "Price") +
+		textAt("F1", 12, 72, 626, "Apple") + textAt("F1", 12, 250, 626, "3") + textAt("F1", 12, 400, 626, "1.20") +
+		textAt("F1", 12, 72, 612, "Pear") + textAt("F1", 12, 250, 612, "5") + textAt("F1", 12, 400, 612, "0.80") +
+		textAt("F1", 12, 300, 40, "1")
+	page2 := textAt("F1", 16, 72, 720, "Section Two") +
+		textAt("F1", 12, 72, 690, "Intro text for the list below.") +
+		textAt("F1", 12, 72, 660, "\\225 first item") +
+		textAt("F1", 12, 72, 646, "\\225 second item") +
+		textAt("F1", 12, 300, 40, "2")
+
+	return buildTestPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 7 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents [8 0 R] >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold >>",
+		testPDFStream("", page1, true),
+		testPDFStream("", page2, false),
+		"<< /Title (Annual Report 2025) >>",
+	}, "/Root 1 0 R /Info 9 0 R")
+}
+
+func TestPDFToMarkdown(t *testing.T) {
+	doc, err := parsePDF(reportPDF())
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, err := pdfToMarkdown(context.Background(), doc, 1<<20)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	md, pages := out.text, out.pages
+	if pages != 2 {
+		t.Fatalf("expected 2 pages, got %d", pages)
+	}
+	if doc.title() != "Annual Report 2025" {
+		t.Fatalf("unexpected title: %q", doc.title())
+	}
+
+	want := `<!-- page 1 -->
+
+# Annual Report
+
+This first paragraph explains the results and the document continues on the next line.
+
+| Name | Qty | Price |
+| --- | --- | --- |
+| Apple | 3 | 1.20 |
+| Pear | 5 | 0.80 |
+
+<!-- page 2 -->
+
+## Section Two
+
+Intro text for the list below.
+
+- first item
+- second item
+`
+	if md != want {
+		t.Fatalf("unexpected markdown:\n%s\nwant:\n%s", md, want)
+	}
+}
+
+func TestPDFType0FontInObjectStream(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+1 beginbfrange
+<0001> <0002> <0048>
+endbfrange
+1 beginbfchar
+<0003> <4E2D>
+endbfchar
+endcmap
+end end`
+	content := "BT /F1 12 Tf 72 700 Td [<0001> -50 <0002>] TJ <0003> Tj ET"
+
+	// Objects 1-3 live inside the object stream (object 7).
+	inner := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 6 0 R >>",
+	}
+	var header, body strings.Builder
+	for i, obj := range inner {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(obj + "\n")
+	}
+	objStm := testPDFStream(
+		fmt.Sprintf("/Type /ObjStm /N 3 /First %d", header.Len()),
+		header.String()+body.String(), true)
+
+	data := buildTestPDF([]string{
+		"null", "null", "null",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Noto /Encoding /Identity-H /DescendantFonts [<< /Subtype /CIDFontType2 /DW 600 >>] /ToUnicode 5 0 R >>",
+		testPDFStream("", cmap, true),
+		testPDFStream("", content, true),
+		objStm,
+	}, "/Root 1 0 R")
+	// Object stream entries only fill objects that are not defined directly.
+	data = bytes.Replace(data, []byte("1 0 obj\nnull\nendobj\n"), nil, 1)
+	data = bytes.Replace(data, []byte("2 0 obj\nnull\nendobj\n"), nil, 1)
+	data = bytes.Replace(data, []byte("3 0 obj\nnull\nendobj\n"), nil, 1)
+
+	doc, err := parsePDF(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, err := pdfToMarkdown(context.Background(), doc, 1<<20)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	md, pages := out.text, out.pages
+	if pages != 1 || md != "<!-- page 1 -->\n\nHI中\n" {
+		t.Fatalf("unexpected result (%d pages):\n%q", pages, md)
+	}
+}
+
+func TestPDFTwoColumnLayout(t *testing.T) {
+	var content strings.Builder
+	for i := 0; i < 6; i++ {
+		y := float64(700 - i*14)
+		content.WriteString(textAt("F1", 10, 50, y, fmt.Sprintf("left column line %d with text", i+1)))
+		content.WriteString(textAt("F1", 10, 320, y, fmt.Sprintf("right column line %d with text", i+1)))
+	}
+	data := buildTestPDF([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Times-Roman >>",
+		testPDFStream("", content.String(), false),
+	}, "/Root 1 0 R")
+
+	doc, err := parsePDF(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, err := pdfToMarkdown(context.Background(), doc, 1<<20)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	md := out.text
+	left := strings.Index(md, "left column line 6")
+	right := strings.Index(md, "right column line 1")
+	if left < 0 || right < 0 || left > right {
+		t.Fatalf("expected left column before right column:\n%s", md)
+	}
+}
+
+func TestPDFParserObjects(t *testing.T) {
+	p := &pdfParser{data: []byte(`<< /Name#20A (a\(b\)\n\101) /Hex <48 65 6C6C6F> /Ref 12 0 R /Arr [1 -2.5 true null /N] >>`)}
+	obj, err := p.parseObject(0)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	dict := obj.(pdfDict)
+	if got := dict["Name A"]; got != pdfString("a(b)\nA") {
+		t.Fatalf("unexpected literal string: %q", got)
+	}
+	if got := dict["Hex"]; got != pdfString("Hello") {
+		t.Fatalf("unexpected hex string: %q", got)
+	}
+	if got := dict["Ref"]; got != (pdfRef{num: 12}) {
+		t.Fatalf("unexpected ref: %#v", got)
+	}
+	arr := dict["Arr"].([]any)
+	if len(arr) != 5 || arr[0] != 1 || arr[1] != -2.5 || arr[2] != true || arr[3] != nil || arr[4] != pdfName("N") {
+		t.Fatalf("unexpected array: %#v", arr)
+	}
+}
+
+func TestFetchPDF(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/octet":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(reportPDF())
+		case "/encrypted":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(buildTestPDF([]string{"<< /Type /Catalog >>"}, "/Root 1 0 R /Encrypt << /Filter /Standard >>"))
+		case "/scanned":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(buildTestPDF([]string{
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R >>",
+			}, "/Root 1 0 R"))
+		default:
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(reportPDF())
+		}
+	}))
+	defer ts.Close()
+
+	for _, mode := range []string{ModeAuto, ModeStatic} {
+		cfg := DefaultConfig()
+		cfg.Mode = mode
+		cfg.Timeout = 5 * time.Second
+		res, err := Fetch(context.Background(), ts.URL+"/doc.pdf", cfg)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+		if res.Source != "http-pdf" || res.PageCount != 2 {
+			t.Fatalf("%s: unexpected result source=%q pages=%d", mode, res.Source, res.PageCount)
+		}
+		if !strings.HasPrefix(res.Markdown, "---\ntitle: 'Annual Report 2025'\npages: 2\n---\n\n<!-- page 1 -->\n") {
+			t.Fatalf("%s: unexpected markdown:\n%s", mode, res.Markdown)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeRaw
+	res, err := Fetch(context.Background(), ts.URL+"/doc.pdf", cfg)
+	if err != nil || res.Source != "http-raw" || !strings.HasPrefix(res.Markdown, "%PDF-") {
+		t.Fatalf("raw mode must return the PDF body verbatim, got %q, %v", res.Source, err)
+	}
+
+	cfg.Mode = ModeStatic
+	res, err = Fetch(context.Background(), ts.URL+"/octet", cfg)
+	if err != nil || res.Source != "http-pdf" {
+		t.Fatalf("expected PDF detected by magic bytes, got %q, %v", res.Source, err)
+	}
+	if got := traceString(res.Trace); got != "http:ok pdf:accepted" {
+		t.Fatalf("unexpected trace: %s", got)
+	}
+
+	_, err = Fetch(context.Background(), ts.URL+"/encrypted", cfg)
+	if !errors.Is(err, ErrNoContent) || !strings.Contains(err.Error(), "encrypted") {
+		t.Fatalf("expected encrypted PDF error, got %v", err)
+	}
+
+	cfg.Mode = ModeAuto
+	res, err = Fetch(context.Background(), ts.URL+"/scanned", cfg)
+	if !errors.Is(err, ErrNoContent) || res.PageCount != 1 {
+		t.Fatalf("expected no content for a PDF without text, got pages=%d err=%v", res.PageCount, err)
+	}
+	if got := traceString(res.Trace); got != "http:ok pdf:rejected" {
+		t.Fatalf("auto mode must not fall back to the browser for PDFs, trace: %s", got)
+	}
+}
+
+// sharedContentPDF has n pages that all draw the same compressed content stream.
+func sharedContentPDF(n int) []byte {
+	var content strings.Builder
+	for i := 0; i < 40; i++ {
+		content.WriteString(textAt("F1", 12, 72, float64(720-i*14), fmt.Sprintf("repeated line %d of shared content", i)))
+	}
+	kids := make([]string, n)
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", "", "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>", testPDFStream("", content.String(), true)}
+	for i := range kids {
+		kids[i] = fmt.Sprintf("%d 0 R", len(objects)+1)
+		objects = append(objects, "<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 3 0 R >> >> /Contents 4 0 R >>")
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n)
+	return buildTestPDF(objects, "/Root 1 0 R")
+}
+
+func TestPDFSharedStreamsAreBounded(t *testing.T) {
+	doc, err := parsePDF(sharedContentPDF(200))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, err := pdfToMarkdown(context.Background(), doc, 4096)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if out.pages != 200 || !out.truncated || len(out.text) > 4096 {
+		t.Fatalf("expected truncated text within the limit, got %d bytes, pages=%d truncated=%v", len(out.text), out.pages, out.truncated)
+	}
+	if len(doc.decoded) != 1 {
+		t.Fatalf("expected the shared stream to be decoded once, got %d entries", len(doc.decoded))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pdfToMarkdown(ctx, doc, 1<<20); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestPDFDecodedBudget(t *testing.T) {
+	doc := &pdfDocument{decoded: make(map[*pdfStream]pdfDecoded), budget: 10}
+	compressed := func(n int) *pdfStream {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(bytes.Repeat([]byte("x"), n))
+		zw.Close()
+		return &pdfStream{dict: pdfDict{"Filter": pdfName("FlateDecode")}, raw: buf.Bytes()}
+	}
+	if data, err := doc.streamData(compressed(6)); err != nil || len(data) != 6 {
+		t.Fatalf("expected 6 bytes within budget, got %d, %v", len(data), err)
+	}
+	if _, err := doc.streamData(compressed(6)); !errors.Is(err, errPDFTooLarge) {
+		t.Fatalf("expected the budget to be exhausted, got %v", err)
+	}
+}
@@ -0,0 +1,575 @@
+package fetcher
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// pdfTextRun is a piece of text placed on a page, in PDF user space (origin
+// bottom-left, y grows upwards).
+type pdfTextRun struct {
+	x, y, endX float64
+	size       float64
+	bold       bool
+	text       string
+}
+
+type pdfMatrix [6]float64
+
+var pdfIdentity = pdfMatrix{1, 0, 0, 1, 0, 0}
+
+func (m pdfMatrix) mul(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func pdfTranslate(tx, ty float64) pdfMatrix {
+	return pdfMatrix{1, 0, 0, 1, tx, ty}
+}
+
+type pdfFont struct {
+	toUnicode    map[uint32]string
+	codeBytes    int
+	widths       map[uint32]float64
+	defaultWidth float64
+	encoding     map[byte]rune
+	bold         bool
+}
+
+func (f *pdfFont) decode(s pdfString) (text string, widths []float64, codes []uint32) {
+	b := []byte(s)
+	var sb strings.Builder
+	for i := 0; i < len(b); {
+		n := f.codeBytes
+		if i+n > len(b) {
+			n = len(b) - i
+		}
+		var code uint32
+		for _, c := range b[i : i+n] {
+			code = code<<8 | uint32(c)
+		}
+		i += n
+
+		if u, ok := f.toUnicode[code]; ok {
+			sb.WriteString(u)
+		} else if f.codeBytes == 1 {
+			if r, ok := f.encoding[byte(code)]; ok {
+				sb.WriteRune(r)
+			} else {
+				sb.WriteRune(winAnsiRune(byte(code)))
+			}
+		}
+		w, ok := f.widths[code]
+		if !ok {
+			w = f.defaultWidth
+		}
+		widths = append(widths, w/1000)
+		codes = append(codes, code)
+	}
+	return sb.String(), widths, codes
+}
+
+func (d *pdfDocument) loadFont(dict pdfDict) *pdfFont {
+	f := &pdfFont{codeBytes: 1, defaultWidth: 500, widths: make(map[uint32]float64)}
+	subtype := d.name(dict["Subtype"])
+	baseFont := string(d.name(dict["BaseFont"]))
+	f.bold = isBoldFontName(baseFont)
+
+	if subtype == "Type0" {
+		f.codeBytes = 2
+		f.defaultWidth = 1000
+		if descendants := d.array(dict["DescendantFonts"]); len(descendants) > 0 {
+			desc := d.dict(descendants[0])
+			if dw, ok := d.number(desc["DW"]); ok {
+				f.defaultWidth = dw
+			}
+			d.loadCIDWidths(f, d.array(desc["W"]))
+			if fd := d.dict(desc["FontDescriptor"]); fd != nil && !f.bold {
+				f.bold = isBoldFontName(string(d.name(fd["FontName"])))
+			}
+		}
+	} else {
+		first := d.intValue(dict["FirstChar"])
+		for i, w := range d.array(dict["Widths"]) {
+			if v, ok := d.number(w); ok {
+				f.widths[uint32(first+i)] = v
+			}
+		}
+		f.encoding = d.simpleEncoding(dict["Encoding"])
+	}
+
+	if stream, ok := d.resolve(dict["ToUnicode"]).(*pdfStream); ok {
+		if data, err := d.streamData(stream); err == nil {
+			cmap, codeBytes := parseToUnicodeCMap(data)
+			f.toUnicode = cmap
+			if codeBytes > 0 {
+				f.codeBytes = codeBytes
+			}
+		}
+	}
+	return f
+}
+
+func isBoldFontName(name string) bool {
+	lower := strings.ToLower(name)
+	for _, marker := range []string{"bold", "black", "heavy", "semibold", "demi"} {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCIDWidths reads a CIDFont /W array: "c [w1 w2 ...]" or "cFirst cLast w".
+func (d *pdfDocument) loadCIDWidths(f *pdfFont, w []any) {
+	for i := 0; i < len(w); {
+		start := d.intValue(w[i])
+		if i+1 >= len(w) {
+			return
+		}
+		if list := d.array(w[i+1]); list != nil {
+			for j, item := range list {
+				if v, ok := d.number(item); ok {
+					f.widths[uint32(start+j)] = v
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		end := d.intValue(w[i+1])
+		v, _ := d.number(w[i+2])
+		for c := start; c <= end && c-start < 1<<16; c++ {
+			f.widths[uint32(c)] = v
+		}
+		i += 3
+	}
+}
+
+// simpleEncoding returns the /Differences overrides of a simple font. Base
+// encodings are all treated as WinAnsi, which matches the ASCII range that
+// matters for text extraction.
+func (d *pdfDocument) simpleEncoding(obj any) map[byte]rune {
+	enc := d.dict(obj)
+	if enc == nil {
+		return nil
+	}
+	diffs := make(map[byte]rune)
+	code := 0
+	for _, item := range d.array(enc["Differences"]) {
+		switch v := d.resolve(item).(type) {
+		case int:
+			code = v
+		case pdfName:
+			if r, ok := glyphNameRune(string(v)); ok && code >= 0 && code < 256 {
+				diffs[byte(code)] = r
+			}
+			code++
+		}
+	}
+	return diffs
+}
+
+var pdfGlyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quotesingle": '\'', "parenleft": '(', "parenright": ')', "asterisk": '*',
+	"plus": '+', "comma": ',', "hyphen": '-', "period": '.', "slash": '/', "colon": ':',
+	"semicolon": ';', "less": '<', "equal": '=', "greater": '>', "question": '?', "at": '@',
+	"bracketleft": '[', "backslash": '\\', "bracketright": ']', "asciicircum": '^', "underscore": '_',
+	"grave": '`', "braceleft": '{', "bar": '|', "braceright": '}', "asciitilde": '~',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4', "five": '5', "six": '6',
+	"seven": '7', "eight": '8', "nine": '9',
+	"quoteleft": '‘', "quoteright": '’', "quotedblleft": '“', "quotedblright": '”',
+	"endash": '–', "emdash": '—', "bullet": '•', "ellipsis": '…', "minus": '−',
+	"fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ', "dagger": '†', "daggerdbl": '‡',
+	"degree": '°', "copyright": '©', "registered": '®', "trademark": '™', "section": '§',
+	"paragraph": '¶', "multiply": '×', "divide": '÷', "periodcentered": '·',
+	"eacute": 'é', "egrave": 'è', "agrave": 'à', "aacute": 'á', "udieresis": 'ü', "odieresis": 'ö',
+	"adieresis": 'ä', "germandbls": 'ß', "ccedilla": 'ç', "ntilde": 'ñ',
+}
+
+func glyphNameRune(name string) (rune, bool) {
+	if r, ok := pdfGlyphNames[name]; ok {
+		return r, true
+	}
+	if len(name) == 1 {
+		return rune(name[0]), true
+	}
+	hexPart, ok := strings.CutPrefix(name, "uni")
+	if ok && len(hexPart) >= 4 {
+		hexPart = hexPart[:4]
+	} else if hexPart, ok = strings.CutPrefix(name, "u"); !ok || len(hexPart) < 4 || len(hexPart) > 6 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(hexPart, 16, 32)
+	if err != nil || !utf8.ValidRune(rune(v)) {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+var winAnsiHigh = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+func winAnsiRune(c byte) rune {
+	if c >= 0x80 && c < 0xA0 {
+		if r := winAnsiHigh[c-0x80]; r != 0 {
+			return r
+		}
+	}
+	return rune(c)
+}
+
+// parseToUnicodeCMap reads bfchar/bfrange mappings and the code length
+// declared by codespacerange (0 when absent).
+func parseToUnicodeCMap(data []byte) (map[uint32]string, int) {
+	cmap := make(map[uint32]string)
+	codeBytes := 0
+	p := &pdfParser{data: data}
+	var operands []any
+	mode := ""
+	for !p.eof() {
+		obj, err := p.parseObject(0)
+		if err != nil {
+			break
+		}
+		kw, isKeyword := obj.(pdfKeyword)
+		if !isKeyword {
+			operands = append(operands, obj)
+			continue
+		}
+		switch string(kw) {
+		case "begincodespacerange", "beginbfchar", "beginbfrange":
+			mode = string(kw)
+		case "endcodespacerange":
+			for _, op := range operands {
+				if s, ok := op.(pdfString); ok && len(s) > codeBytes {
+					codeBytes = len(s)
+				}
+			}
+			mode = ""
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					cmap[pdfCode(src)] = decodeUTF16BE([]byte(dst))
+				}
+			}
+			mode = ""
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := pdfCode(lo), pdfCode(hi)
+				if end < start || end-start > 1<<16 {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case pdfString:
+					units := []byte(dst)
+					for code := start; code <= end; code++ {
+						cmap[code] = decodeUTF16BE(units)
+						incrementUTF16BE(units)
+					}
+				case []any:
+					for j, item := range dst {
+						if s, ok := item.(pdfString); ok && start+uint32(j) <= end {
+							cmap[start+uint32(j)] = decodeUTF16BE([]byte(s))
+						}
+					}
+				}
+			}
+			mode = ""
+		}
+		if mode == "" || strings.HasPrefix(string(kw), "begin") {
+			operands = operands[:0]
+		}
+	}
+	return cmap, codeBytes
+}
+
+func pdfCode(s pdfString) uint32 {
+	var code uint32
+	for _, c := range []byte(s) {
+		code = code<<8 | uint32(c)
+	}
+	return code
+}
+
+func incrementUTF16BE(units []byte) {
+	for i := len(units) - 1; i >= 0; i-- {
+		units[i]++
+		if units[i] != 0 {
+			return
+		}
+	}
+}
+
+// pdfTextExtractor interprets content streams and records where text lands.
+type pdfTextExtractor struct {
+	doc  *pdfDocument
+	runs []pdfTextRun
+}
+
+type pdfTextState struct {
+	ctm         pdfMatrix
+	font        *pdfFont
+	fontSize    float64
+	charSpacing float64
+	wordSpacing float64
+	hScale      float64
+	leading     float64
+	rise        float64
+}
+
+func (d *pdfDocument) pageTextRuns(page pdfPage) []pdfTextRun {
+	ex := &pdfTextExtractor{doc: d}
+	ex.run(d.pageContent(page.dict), page.resources, pdfIdentity, 0)
+	return ex.runs
+}
+
+func (ex *pdfTextExtractor) run(content []byte, resources pdfDict, ctm pdfMatrix, depth int) {
+	if depth > 8 {
+		return
+	}
+	d := ex.doc
+	fonts := make(map[pdfName]*pdfFont)
+	fontDicts := d.dict(resources["Font"])
+	fontFor := func(name pdfName) *pdfFont {
+		if f, ok := fonts[name]; ok {
+			return f
+		}
+		var f *pdfFont
+		if dict := d.dict(fontDicts[name]); dict != nil {
+			f = d.loadFont(dict)
+		} else {
+			f = &pdfFont{codeBytes: 1, defaultWidth: 500}
+		}
+		fonts[name] = f
+		return f
+	}
+
+	gs := pdfTextState{ctm: ctm, hScale: 1, fontSize: 1}
+	var stack []pdfTextState
+	tm, tlm := pdfIdentity, pdfIdentity
+
+	show := func(s pdfString) {
+		if gs.font == nil {
+			gs.font = &pdfFont{codeBytes: 1, defaultWidth: 500}
+		}
+		text, widths, codes := gs.font.decode(s)
+		trm := pdfMatrix{gs.fontSize * gs.hScale, 0, 0, gs.fontSize, 0, gs.rise}.mul(tm).mul(gs.ctm)
+		start := trm
+
+		var advance float64
+		for i, w := range widths {
+			tx := w*gs.fontSize + gs.charSpacing
+			if gs.font.codeBytes == 1 && codes[i] == 32 {
+				tx += gs.wordSpacing
+			}
+			advance += tx * gs.hScale
+		}
+		tm = pdfTranslate(advance, 0).mul(tm)
+		end := pdfMatrix{gs.fontSize * gs.hScale, 0, 0, gs.fontSize, 0, gs.rise}.mul(tm).mul(gs.ctm)
+
+		if text == "" {
+			return
+		}
+		size := math.Hypot(start[2], start[3])
+		if size < 0.1 {
+			size = math.Abs(gs.fontSize)
+		}
+		ex.runs = append(ex.runs, pdfTextRun{
+			x:    start[4],
+			y:    start[5],
+			endX: end[4],
+			size: size,
+			bold: gs.font.bold,
+			text: text,
+		})
+	}
+	nextLine := func() {
+		tlm = pdfTranslate(0, -gs.leading).mul(tlm)
+		tm = tlm
+	}
+
+	p := &pdfParser{data: content}
+	var operands []any
+	for !p.eof() {
+		obj, err := p.parseObject(0)
+		if err != nil {
+			return
+		}
+		op, isOp := obj.(pdfKeyword)
+		if !isOp {
+			operands = append(operands, obj)
+			continue
+		}
+		nums := pdfNumbers(operands)
+
+		switch op {
+		case "q":
+			stack = append(stack, gs)
+		case "Q":
+			if len(stack) > 0 {
+				gs = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(nums) == 6 {
+				gs.ctm = pdfMatrix(nums).mul(gs.ctm)
+			}
+		case "BT":
+			tm, tlm = pdfIdentity, pdfIdentity
+		case "Tf":
+			if len(operands) == 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					gs.font = fontFor(name)
+				}
+				if size, ok := d.number(operands[1]); ok {
+					gs.fontSize = size
+				}
+			}
+		case "Tc":
+			if len(nums) == 1 {
+				gs.charSpacing = nums[0]
+			}
+		case "Tw":
+			if len(nums) == 1 {
+				gs.wordSpacing = nums[0]
+			}
+		case "Tz":
+			if len(nums) == 1 {
+				gs.hScale = nums[0] / 100
+			}
+		case "TL":
+			if len(nums) == 1 {
+				gs.leading = nums[0]
+			}
+		case "Ts":
+			if len(nums) == 1 {
+				gs.rise = nums[0]
+			}
+		case "Td", "TD":
+			if len(nums) == 2 {
+				if op == "TD" {
+					gs.leading = -nums[1]
+				}
+				tlm = pdfTranslate(nums[0], nums[1]).mul(tlm)
+				tm = tlm
+			}
+		case "Tm":
+			if len(nums) == 6 {
+				tlm = pdfMatrix(nums)
+				tm = tlm
+			}
+		case "T*":
+			nextLine()
+		case "Tj":
+			if len(operands) == 1 {
+				if s, ok := operands[0].(pdfString); ok {
+					show(s)
+				}
+			}
+		case "'":
+			nextLine()
+			if len(operands) == 1 {
+				if s, ok := operands[0].(pdfString); ok {
+					show(s)
+				}
+			}
+		case "\"":
+			if len(operands) == 3 {
+				gs.wordSpacing, _ = d.number(operands[0])
+				gs.charSpacing, _ = d.number(operands[1])
+				nextLine()
+				if s, ok := operands[2].(pdfString); ok {
+					show(s)
+				}
+			}
+		case "TJ":
+			if len(operands) == 1 {
+				items, _ := operands[0].([]any)
+				for _, item := range items {
+					if s, ok := item.(pdfString); ok {
+						show(s)
+					} else if n, ok := d.number(item); ok {
+						tm = pdfTranslate(-n/1000*gs.fontSize*gs.hScale, 0).mul(tm)
+					}
+				}
+			}
+		case "Do":
+			if len(operands) == 1 {
+				if name, ok := operands[0].(pdfName); ok {
+					ex.runForm(d.dict(resources["XObject"])[name], resources, gs.ctm, depth)
+				}
+			}
+		case "BI":
+			// Inline image data is binary; skip to the EI that ends it.
+			p.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+func (ex *pdfTextExtractor) runForm(obj any, parentResources pdfDict, ctm pdfMatrix, depth int) {
+	stream, ok := ex.doc.resolve(obj).(*pdfStream)
+	if !ok || ex.doc.name(stream.dict["Subtype"]) != "Form" {
+		return
+	}
+	data, err := ex.doc.streamData(stream)
+	if err != nil {
+		return
+	}
+	resources := ex.doc.dict(stream.dict["Resources"])
+	if resources == nil {
+		resources = parentResources
+	}
+	if nums := pdfNumbers(ex.doc.array(stream.dict["Matrix"])); len(nums) == 6 {
+		ctm = pdfMatrix(nums).mul(ctm)
+	}
+	ex.run(data, resources, ctm, depth+1)
+}
+
+func (p *pdfParser) skipInlineImage() {
+	for p.pos+2 < len(p.data) {
+		if p.data[p.pos] == 'E' && p.data[p.pos+1] == 'I' && isPDFSpace(p.data[p.pos-1]) &&
+			(isPDFSpace(p.data[p.pos+2]) || isPDFDelimiter(p.data[p.pos+2])) {
+			p.pos += 2
+			return
+		}
+		p.pos++
+	}
+	p.pos = len(p.data)
+}
+
+func pdfNumbers(operands []any) []float64 {
+	nums := make([]float64, 0, len(operands))
+	for _, op := range operands {
+		switch v := op.(type) {
+		case int:
+			nums = append(nums, float64(v))
+		case float64:
+			nums = append(nums, v)
+		default:
+			return nil
+		}
+	}
+	return nums
+}
@@ -0,0 +1,228 @@
+package fetcher
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	nurl "net/url"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+// ProxyDirect as Config.Proxy or HostRule.Proxy connects without a proxy,
+// ignoring the proxy environment variables.
+const ProxyDirect = "direct"
+
+// ErrBrowserProxyAuth is returned for browser renders through a SOCKS5 proxy
+// that needs a user name and password, which Chrome cannot send.
+var ErrBrowserProxyAuth = errors.New("browser does not support SOCKS5 proxy authentication")
+
+// ParseProxy parses a proxy URL for Config.Proxy: http, https, socks5 or
+// socks5h, with an optional user:password. It returns nil for ProxyDirect.
+func ParseProxy(proxy string) (*nurl.URL, error) {
+	proxy = strings.TrimSpace(proxy)
+	if proxy == ProxyDirect {
+		return nil, nil
+	}
+	u, err := nurl.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https, socks5 or socks5h", proxy)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", proxy)
+	}
+	return u, nil
+}
+
+// ProxyHostPort returns the host:port of a proxy URL, with the default port
+// of its scheme when it has none.
+func ProxyHostPort(u *nurl.URL) string {
+	if port := u.Port(); port != "" {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	port := "80"
+	switch u.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// resolveProxy returns the proxy for rawURL: a proxy URL, ProxyDirect when
+// cfg.NoProxy or cfg.Proxy bypasses proxies, or "" when cfg sets nothing and
+// the environment names no proxy.
+func (cfg Config) resolveProxy(rawURL string) (string, error) {
+	u, err := nurl.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if matchNoProxy(cfg.NoProxy, u.Hostname()) {
+		return ProxyDirect, nil
+	}
+	switch proxy := strings.TrimSpace(cfg.Proxy); proxy {
+	case "":
+		p, err := http.ProxyFromEnvironment(&http.Request{URL: u})
+		if err != nil || p == nil {
+			return "", err
+		}
+		return p.String(), nil
+	case ProxyDirect:
+		return ProxyDirect, nil
+	default:
+		if _, err := ParseProxy(proxy); err != nil {
+			return "", err
+		}
+		return proxy, nil
+	}
+}
+
+// matchNoProxy reports whether host is among patterns, which follow NO_PROXY:
+// a host name also matches its subdomains, a leading "." or "*." matches
+// the name and its subdomains, "*" matches every host, and IP addresses and
+// CIDR ranges match IP hosts.
+func matchNoProxy(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if h, _, err := net.SplitHostPort(p); err == nil {
+			p = h
+		}
+		if p == "*" {
+			return true
+		}
+		p = strings.TrimPrefix(strings.TrimPrefix(p, "*"), ".")
+		switch {
+		case p == "":
+			continue
+		case ip != nil:
+			if _, cidr, err := net.ParseCIDR(p); err == nil && cidr.Contains(ip) {
+				return true
+			}
+			if pip := net.ParseIP(strings.Trim(p, "[]")); pip != nil && pip.Equal(ip) {
+				return true
+			}
+		case host == p || strings.HasSuffix(host, "."+p):
+			return true
+		}
+	}
+	return false
+}
+
+// proxyFor returns the proxy for rawURL like resolveProxy, but matches the
+// proxy rules ForURL kept against rawURL's host first, so a redirect away
+// from the URL cfg was resolved for gets the proxy of its own host.
+func (cfg Config) proxyFor(rawURL string) (string, error) {
+	if len(cfg.proxyRules) > 0 {
+		u, err := nurl.Parse(rawURL)
+		if err != nil {
+			return "", err
+		}
+		cfg.Proxy = cfg.baseProxy
+		for _, rule := range cfg.proxyRules {
+			if rule.Matches(u.Hostname()) {
+				cfg.Proxy = rule.Proxy
+			}
+		}
+	}
+	return cfg.resolveProxy(rawURL)
+}
+
+var proxyTransports sync.Map // proxy settings -> *http.Transport
+
+// proxyTransport returns a transport, shared by configs with the same proxy
+// settings, that resolves the proxy of each request, redirects included.
+func (cfg Config) proxyTransport() http.RoundTripper {
+	pc := Config{Proxy: cfg.Proxy, NoProxy: cfg.NoProxy, baseProxy: cfg.baseProxy, proxyRules: cfg.proxyRules}
+	key := fmt.Sprintf("%q %q %q", pc.Proxy, pc.NoProxy, pc.baseProxy)
+	for _, rule := range pc.proxyRules {
+		key += fmt.Sprintf(" %q=%q", rule.Host, rule.Proxy)
+	}
+	if t, ok := proxyTransports.Load(key); ok {
+		return t.(*http.Transport)
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = func(req *http.Request) (*nurl.URL, error) {
+		proxy, err := pc.proxyFor(req.URL.String())
+		if err != nil || proxy == "" || proxy == ProxyDirect {
+			return nil, err
+		}
+		return ParseProxy(proxy)
+	}
+	actual, _ := proxyTransports.LoadOrStore(key, t)
+	return actual.(*http.Transport)
+}
+
+// httpClient returns the client for a request to u: cfg.HTTPClient when set,
+// otherwise one whose transport resolves the proxy of each request from cfg,
+// that keeps cookies in cfg.CookieJar and adds cfg.Credentials.
+func (cfg Config) httpClient(u *nurl.URL) (*http.Client, error) {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient, nil
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	if cfg.CookieJar != nil {
+		client.Jar = cfg.CookieJar
+	}
+	// Without a proxy setting, http.DefaultTransport follows the
+	// environment for every redirect.
+	if strings.TrimSpace(cfg.Proxy) != "" || len(cfg.NoProxy) > 0 || len(cfg.proxyRules) > 0 {
+		// Settle u's proxy now, so a bad one fails before any request.
+		if _, err := cfg.proxyFor(u.String()); err != nil {
+			return nil, err
+		}
+		client.Transport = cfg.proxyTransport()
+	}
+	if cfg.Credentials != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &credentialTransport{base: base, creds: cfg.Credentials}
+	}
+	return client, nil
+}
+
+// browserProxyOptions returns the Chrome flags for cfg.Proxy once
+// resolveProxy has set it.
+func browserProxyOptions(proxy string) []chromedp.ExecAllocatorOption {
+	switch proxy {
+	case "":
+		return nil
+	case ProxyDirect:
+		return []chromedp.ExecAllocatorOption{chromedp.Flag("no-proxy-server", true)}
+	}
+	u, err := ParseProxy(proxy)
+	if err != nil {
+		return nil
+	}
+	scheme := u.Scheme
+	if scheme == "socks5h" {
+		// Chrome resolves host names through SOCKS5 proxies already.
+		scheme = "socks5"
+	}
+	return []chromedp.ExecAllocatorOption{chromedp.ProxyServer(scheme + "://" + u.Host)}
+}
+
+// browserProxyUser returns the user and password the browser answers the
+// proxy's credential challenges with, or nil when the proxy has no user.
+func browserProxyUser(proxy string) (*nurl.Userinfo, error) {
+	u, err := ParseProxy(proxy)
+	if err != nil || u == nil || u.User == nil {
+		return nil, nil
+	}
+	if strings.HasPrefix(u.Scheme, "socks5") {
+		return nil, ErrBrowserProxyAuth
+	}
+	return u.User, nil
+}
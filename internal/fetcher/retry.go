@@ -0,0 +1,178 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how failed HTTP requests and browser renders are
+// retried. The zero value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries per stage, including the first.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles on every
+	// further retry, up to MaxDelay when that is positive.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Jitter shortens each backoff by a random fraction of up to Jitter (0-1),
+	// so concurrent fetches do not retry in lockstep.
+	Jitter float64
+	// Statuses are the HTTP status codes worth retrying. On 429 and 503 a
+	// Retry-After header replaces the backoff; if it asks for longer than
+	// MaxDelay, the response is returned as an error instead.
+	Statuses []int
+}
+
+// DefaultRetryPolicy makes up to three attempts with a 500ms, then 1s backoff
+// and retries the statuses that usually signal a transient failure.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+		Jitter:      0.2,
+		Statuses: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// Budget returns the longest a stage can take under p when each attempt takes
+// up to perAttempt: every attempt plus the longest wait before each retry.
+func (p RetryPolicy) Budget(perAttempt time.Duration) time.Duration {
+	n := p.attempts()
+	total := perAttempt * time.Duration(n)
+	for retry := 1; retry < n; retry++ {
+		wait := p.backoff(retry, 0)
+		if p.MaxDelay > 0 {
+			// Retry-After may ask for up to MaxDelay.
+			wait = p.MaxDelay
+		}
+		total += wait
+	}
+	return total
+}
+
+func (p RetryPolicy) attempts() int {
+	return max(1, p.MaxAttempts)
+}
+
+// backoff returns the wait before the given retry (1 for the first), reduced
+// by jitter times the random fraction r in [0, 1).
+func (p RetryPolicy) backoff(retry int, r float64) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if j := min(max(p.Jitter, 0), 1); j > 0 {
+		d -= time.Duration(j * r * float64(d))
+	}
+	return d
+}
+
+// run calls attempt until it succeeds, fails with an error that is not
+// retryable, or the policy is spent, and returns the number of attempts and
+// the last error. attempt may ask for a specific wait (from Retry-After). No
+// retry is made when the wait would outlast ctx.
+func (p RetryPolicy) run(ctx context.Context, attempt func() (retryable bool, wait time.Duration, err error)) (int, error) {
+	n := p.attempts()
+	for i := 1; ; i++ {
+		retryable, wait, err := attempt()
+		if err == nil || !retryable || i >= n || ctx.Err() != nil {
+			return i, err
+		}
+		if wait <= 0 {
+			wait = p.backoff(i, rand.Float64())
+		} else if p.MaxDelay > 0 && wait > p.MaxDelay {
+			return i, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return i, err
+		}
+		if sleepContext(ctx, wait) != nil {
+			return i, err
+		}
+	}
+}
+
+// retryHTTP reports whether a failed HTTP attempt may be retried, and the
+// Retry-After wait the server asked for, if any.
+func (p RetryPolicy) retryHTTP(resp responseData, err error, now time.Time) (bool, time.Duration) {
+	if errors.Is(err, ErrHTTPStatus) {
+		if !slices.Contains(p.Statuses, resp.StatusCode) {
+			return false, 0
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+				return true, wait
+			}
+		}
+		return true, 0
+	}
+	return isTransientNetworkError(err), 0
+}
+
+// errBrowserNavigation marks a browser render that failed while loading the
+// page, before it waited for the page to be ready.
+var errBrowserNavigation = errors.New("navigate")
+
+// retryBrowser reports whether a failed browser render may be retried: only
+// navigation failures and transient network errors are. Waiting for
+// --wait-selector or the page to settle, scripts and extraction fail the same
+// way again.
+func retryBrowser(err error) bool {
+	if errors.Is(err, errBrowserNavigation) {
+		return true
+	}
+	return !errors.Is(err, context.DeadlineExceeded) && isTransientNetworkError(err)
+}
+
+// isTransientNetworkError matches timeouts, refused or reset connections and
+// truncated responses. Other errors, such as unknown hosts, TLS failures,
+// unsupported schemes and redirect errors, fail the same way again.
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// parseRetryAfter reads a Retry-After value in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
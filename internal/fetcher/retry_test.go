@@ -0,0 +1,219 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func fastRetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 50 * time.Millisecond
+	policy.Jitter = 0
+	return policy
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, Jitter: 0.5}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, w := range want {
+		if got := policy.backoff(i+1, 0); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := policy.backoff(2, 0.5); got != 150*time.Millisecond {
+		t.Errorf("jittered backoff = %v, want 150ms", got)
+	}
+
+	// Five attempts of 1s plus four waits of at most MaxDelay.
+	if got := policy.Budget(time.Second); got != 6200*time.Millisecond {
+		t.Errorf("budget = %v", got)
+	}
+	if got := (RetryPolicy{}).Budget(time.Second); got != time.Second {
+		t.Errorf("zero policy budget = %v, want 1s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"3", 3 * time.Second, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.in, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFetchRetriesTransientHTTPStatus(t *testing.T) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		switch hits.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("recovered"))
+		}
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeRaw
+	cfg.Retry = fastRetryPolicy()
+	res, err := Fetch(context.Background(), ts.URL, cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Markdown != "recovered" || res.Attempts != 3 {
+		t.Fatalf("markdown %q attempts %d", res.Markdown, res.Attempts)
+	}
+	if got := traceString(res.Trace); !strings.HasPrefix(got, "http:retried http:ok") {
+		t.Fatalf("unexpected trace: %s", got)
+	}
+}
+
+func TestFetchRetryLimits(t *testing.T) {
+	var hits atomic.Int32
+	status, retryAfter := http.StatusNotFound, ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.Retry = fastRetryPolicy()
+
+	// Statuses outside the policy fail on the first attempt.
+	res, err := Fetch(context.Background(), ts.URL, cfg)
+	if !errors.Is(err, ErrHTTPStatus) || res.Attempts != 1 || hits.Load() != 1 {
+		t.Fatalf("404: err %v attempts %d hits %d", err, res.Attempts, hits.Load())
+	}
+
+	// A Retry-After longer than MaxDelay is not waited for.
+	hits.Store(0)
+	status, retryAfter = http.StatusServiceUnavailable, "120"
+	res, err = Fetch(context.Background(), ts.URL, cfg)
+	if !errors.Is(err, ErrHTTPStatus) || res.Attempts != 1 || hits.Load() != 1 {
+		t.Fatalf("long Retry-After: err %v attempts %d hits %d", err, res.Attempts, hits.Load())
+	}
+
+	// Retryable failures stop after MaxAttempts.
+	hits.Store(0)
+	retryAfter = ""
+	res, err = Fetch(context.Background(), ts.URL, cfg)
+	if !errors.Is(err, ErrHTTPStatus) || res.Attempts != 3 || hits.Load() != 3 {
+		t.Fatalf("503: err %v attempts %d hits %d", err, res.Attempts, hits.Load())
+	}
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status code = %d", res.StatusCode)
+	}
+}
+
+func TestFetchDoesNotRetryPermanentNetworkErrors(t *testing.T) {
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("unreachable"))
+	}))
+	tlsServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.Retry = fastRetryPolicy()
+
+	// The test server's certificate is not trusted.
+	res, err := Fetch(context.Background(), tlsServer.URL, cfg)
+	if err == nil || res.Attempts != 1 {
+		t.Fatalf("untrusted certificate: err %v attempts %d", err, res.Attempts)
+	}
+	res, err = Fetch(context.Background(), ts.URL, cfg)
+	if err == nil || !strings.Contains(err.Error(), "unsupported protocol scheme") || res.Attempts != 1 || hits.Load() != 1 {
+		t.Fatalf("unsupported scheme: err %v attempts %d hits %d", err, res.Attempts, hits.Load())
+	}
+
+	if !isTransientNetworkError(fmt.Errorf("read: %w", syscall.ECONNRESET)) {
+		t.Fatal("connection reset should be retried")
+	}
+}
+
+func TestFetchRetriesBrowserRender(t *testing.T) {
+	originalBrowserFn := browserHTMLToMarkdownFn
+	var calls int
+	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (browserPage, error) {
+		calls++
+		if calls == 1 {
+			return browserPage{}, fmt.Errorf("browser render failed: %w: page load error net::ERR_CONNECTION_RESET", errBrowserNavigation)
+		}
+		return browserPage{Markdown: "# Rendered\n", FinalURL: "https://example.com/"}, nil
+	}
+	defer func() {
+		browserHTMLToMarkdownFn = originalBrowserFn
+	}()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeBrowser
+	cfg.Retry = fastRetryPolicy()
+	res, err := Fetch(context.Background(), "https://example.com/", cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Attempts != 2 || traceString(res.Trace) != "browser:error browser:accepted" {
+		t.Fatalf("attempts %d trace %s", res.Attempts, traceString(res.Trace))
+	}
+
+	calls = 0
+	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (browserPage, error) {
+		calls++
+		return browserPage{}, ErrBrowserExecutableNotFound
+	}
+	if _, err := Fetch(context.Background(), "https://example.com/", cfg); !errors.Is(err, ErrBrowserExecutableNotFound) || calls != 1 {
+		t.Fatalf("missing browser: err %v after %d calls, want 1 call", err, calls)
+	}
+
+	// A page that loaded but never showed --wait-selector would time out again.
+	calls = 0
+	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (browserPage, error) {
+		calls++
+		return browserPage{}, fmt.Errorf("browser render failed: %w", context.DeadlineExceeded)
+	}
+	if _, err := Fetch(context.Background(), "https://example.com/", cfg); !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Fatalf("wait timeout: err %v after %d calls, want 1 call", err, calls)
+	}
+}
@@ -0,0 +1,387 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	nurl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDisallowedByRobots is returned when robots.txt forbids fetching a URL for
+// the configured user agent.
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+const (
+	// robotsCacheTTL follows RFC 9309, which asks crawlers not to use a cached
+	// robots.txt for more than 24 hours.
+	robotsCacheTTL = 24 * time.Hour
+	// robotsMaxBytes is the parsing limit required by RFC 9309.
+	robotsMaxBytes = 500 << 10
+)
+
+// Robots fetches, caches and evaluates robots.txt per host. It is safe for
+// concurrent use and meant to be shared by every fetch of a run.
+type Robots struct {
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+type robotsHost struct {
+	ready     chan struct{}
+	fetchedAt time.Time
+	rules     *robotsRules
+	err       error
+
+	// next is the earliest time the next request may start under Crawl-delay.
+	next time.Time
+}
+
+// NewRobots returns an empty robots.txt cache.
+func NewRobots() *Robots {
+	return &Robots{
+		hosts: make(map[string]*robotsHost),
+		now:   time.Now,
+		sleep: sleepContext,
+	}
+}
+
+// check returns an ErrDisallowedByRobots error when robots.txt forbids rawURL
+// for cfg.UserAgent. robots.txt that cannot be fetched because of a network
+// error is reported as a plain error.
+func (r *Robots) check(ctx context.Context, rawURL string, cfg Config) error {
+	u, err := nurl.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	if u.EscapedPath() == "/robots.txt" {
+		return nil
+	}
+	rules, err := r.rules(ctx, u, cfg)
+	if err != nil {
+		return err
+	}
+	group := rules.group(cfg.UserAgent)
+	if ok, rule := group.allowed(robotsPath(u)); !ok {
+		return fmt.Errorf("%w: %s (%s)", ErrDisallowedByRobots, rawURL, rule)
+	}
+	return nil
+}
+
+// Wait blocks until the Crawl-delay of rawURL's host for cfg.UserAgent has
+// passed since the previous Wait for that host, and reserves the next slot.
+// Fetch does not wait on its own, so callers fetching many pages call Wait
+// before each fetch (and outside its timeout).
+func (r *Robots) Wait(ctx context.Context, rawURL string, cfg Config) error {
+	delay := r.CrawlDelay(ctx, rawURL, cfg)
+	if delay <= 0 {
+		return nil
+	}
+	u, err := nurl.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	host := r.hosts[robotsKey(u)]
+	if host == nil {
+		r.mu.Unlock()
+		return nil
+	}
+	now := r.now()
+	slot := host.next
+	if slot.Before(now) {
+		slot = now
+	}
+	host.next = slot.Add(delay)
+	r.mu.Unlock()
+
+	return r.sleep(ctx, slot.Sub(now))
+}
+
+// CrawlDelay returns the Crawl-delay robots.txt sets for rawURL's host and
+// cfg.UserAgent, or 0 when it sets none or cannot be fetched, for callers
+// that schedule fetches themselves instead of calling Wait.
+func (r *Robots) CrawlDelay(ctx context.Context, rawURL string, cfg Config) time.Duration {
+	u, err := nurl.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0
+	}
+	rules, err := r.rules(ctx, u, cfg)
+	if err != nil {
+		// The fetch itself reports the robots.txt failure.
+		return 0
+	}
+	return rules.group(cfg.UserAgent).crawlDelay
+}
+
+func (r *Robots) rules(ctx context.Context, u *nurl.URL, cfg Config) (*robotsRules, error) {
+	key := robotsKey(u)
+
+	r.mu.Lock()
+	host := r.hosts[key]
+	if host != nil {
+		select {
+		case <-host.ready:
+			if r.now().Sub(host.fetchedAt) >= robotsCacheTTL {
+				// Reload, but keep the Crawl-delay reservation.
+				host = &robotsHost{ready: make(chan struct{}), next: host.next}
+				r.hosts[key] = host
+				r.mu.Unlock()
+				return r.load(ctx, key, host, cfg)
+			}
+		default:
+		}
+		r.mu.Unlock()
+		select {
+		case <-host.ready:
+			return host.rules, host.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	host = &robotsHost{ready: make(chan struct{})}
+	r.hosts[key] = host
+	r.mu.Unlock()
+	return r.load(ctx, key, host, cfg)
+}
+
+func (r *Robots) load(ctx context.Context, key string, host *robotsHost, cfg Config) (*robotsRules, error) {
+	rules, err := fetchRobots(ctx, key+"/robots.txt", cfg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	host.rules, host.err, host.fetchedAt = rules, err, r.now()
+	if err != nil {
+		// Network failures are retried by the next fetch of this host.
+		delete(r.hosts, key)
+	}
+	close(host.ready)
+	return rules, err
+}
+
+// fetchRobots downloads and parses robots.txt. As in RFC 9309, a 4xx status
+// allows everything and a 5xx status disallows everything.
+func fetchRobots(ctx context.Context, robotsURL string, cfg Config) (*robotsRules, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, robotsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create robots.txt request: %w", err)
+	}
+	req.Header.Set("Accept", "text/plain, */*;q=0.1")
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+	robotsCfg := cfg
+	robotsCfg.MaxBodyBytes = robotsMaxBytes
+
+	resp, err := doHTTP(req, robotsCfg)
+	switch {
+	case err == nil:
+		return parseRobots(string(resp.Body)), nil
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return &robotsRules{unreachable: fmt.Sprintf("robots.txt returned HTTP %d", resp.StatusCode)}, nil
+	case resp.StatusCode >= http.StatusBadRequest:
+		return &robotsRules{}, nil
+	default:
+		return nil, fmt.Errorf("fetch robots.txt: %w", err)
+	}
+}
+
+// robotsDecision names a robots check failure for the pipeline trace.
+func robotsDecision(err error) string {
+	if errors.Is(err, ErrDisallowedByRobots) {
+		return "disallowed"
+	}
+	return "error"
+}
+
+func robotsKey(u *nurl.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+func robotsPath(u *nurl.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+	return p
+}
+
+type robotsRules struct {
+	groups []*robotsGroup
+	// sitemaps lists the Sitemap URLs, which apply to every user agent.
+	sitemaps []string
+	// unreachable disallows everything, with the reason.
+	unreachable string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+
+	unreachableReason string
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// parseRobots reads the groups and Sitemap lines of a robots.txt file.
+// Consecutive user-agent lines start a group that collects the following
+// allow, disallow and crawl-delay lines.
+func parseRobots(body string) *robotsRules {
+	rules := &robotsRules{}
+	var cur *robotsGroup
+	inAgents := false
+	for _, line := range strings.Split(body, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				cur = &robotsGroup{}
+				rules.groups = append(rules.groups, cur)
+				inAgents = true
+			}
+			cur.agents = append(cur.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			if cur == nil || value == "" {
+				continue
+			}
+			cur.rules = append(cur.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inAgents = false
+			if cur == nil {
+				continue
+			}
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				cur.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		case "sitemap":
+			// Sitemap lines are independent of groups and do not end a
+			// user-agent list.
+			if value != "" {
+				rules.sitemaps = append(rules.sitemaps, value)
+			}
+		default:
+			// Unknown lines do not end a user-agent list.
+		}
+	}
+	return rules
+}
+
+// group merges the groups that apply to userAgent: the ones naming its
+// product token (the part before "/"), or else the "*" groups.
+func (r *robotsRules) group(userAgent string) *robotsGroup {
+	if r.unreachable != "" {
+		return &robotsGroup{rules: []robotsRule{{pattern: "/"}}, unreachableReason: r.unreachable}
+	}
+	token := strings.ToLower(strings.TrimSpace(userAgent))
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+
+	merged := &robotsGroup{}
+	for _, wildcard := range []bool{false, true} {
+		for _, g := range r.groups {
+			for _, agent := range g.agents {
+				if (wildcard && agent == "*") || (!wildcard && token != "" && agent == token) {
+					merged.rules = append(merged.rules, g.rules...)
+					merged.crawlDelay = max(merged.crawlDelay, g.crawlDelay)
+					merged.agents = append(merged.agents, agent)
+					break
+				}
+			}
+		}
+		if len(merged.agents) > 0 {
+			break
+		}
+	}
+	return merged
+}
+
+// allowed applies the longest matching rule to path; on a tie allow wins. The
+// returned string describes the deciding disallow rule.
+func (g *robotsGroup) allowed(path string) (bool, string) {
+	if g.unreachableReason != "" {
+		return false, g.unreachableReason
+	}
+	best, bestLen := robotsRule{allow: true}, -1
+	for _, rule := range g.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		n := len(rule.pattern)
+		if n > bestLen || (n == bestLen && rule.allow) {
+			best, bestLen = rule, n
+		}
+	}
+	if best.allow {
+		return true, ""
+	}
+	return false, "Disallow: " + best.pattern
+}
+
+// robotsMatch reports whether pattern matches the start of path. "*" matches
+// any run of characters and a trailing "$" anchors the end.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		last := i == len(parts)-2
+		if last && anchored {
+			return strings.HasSuffix(path[pos:], part)
+		}
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+	return !anchored || pos == len(path)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
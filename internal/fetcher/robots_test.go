@@ -0,0 +1,216 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobotsTxt = `# comment
+User-agent: *
+Disallow: /private/
+Allow: /private/public$
+Crawl-delay: 5
+
+User-agent: Agent-Fetch
+User-agent: other-bot
+Disallow: /secret
+Disallow: /*.json$
+Allow: /secret/ok
+Crawl-delay: 0.5
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestRobotsRules(t *testing.T) {
+	rules := parseRobots(testRobotsTxt)
+
+	tests := []struct {
+		ua, path string
+		want     bool
+	}{
+		{"agent-fetch/0.1", "/private/page", true},
+		{"agent-fetch/0.1", "/secret", false},
+		{"agent-fetch/0.1", "/secret/ok/page", true},
+		{"agent-fetch/0.1", "/secret/no", false},
+		{"agent-fetch/0.1", "/data.json", false},
+		{"agent-fetch/0.1", "/data.json?x=1", true},
+		{"Mozilla/5.0", "/private/page", false},
+		{"Mozilla/5.0", "/private/public", true},
+		{"Mozilla/5.0", "/private/public/x", false},
+		{"Mozilla/5.0", "/secret", true},
+	}
+	for _, tt := range tests {
+		if got, _ := rules.group(tt.ua).allowed(tt.path); got != tt.want {
+			t.Errorf("allowed(%q, %q) = %v, want %v", tt.ua, tt.path, got, tt.want)
+		}
+	}
+
+	if d := rules.group("agent-fetch/0.1").crawlDelay; d != 500*time.Millisecond {
+		t.Fatalf("agent-fetch crawl delay = %v", d)
+	}
+	if d := rules.group("curl/8").crawlDelay; d != 5*time.Second {
+		t.Fatalf("wildcard crawl delay = %v", d)
+	}
+	if len(rules.sitemaps) != 1 || rules.sitemaps[0] != "https://example.com/sitemap.xml" {
+		t.Fatalf("sitemaps = %q", rules.sitemaps)
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/", "/anything", true},
+		{"/a*c", "/abbbc/d", true},
+		{"/a*c$", "/abbbc/d", false},
+		{"/a*c$", "/abbbc", true},
+		{"*.pdf$", "/docs/x.pdf", true},
+		{"/fish", "/Fish", false},
+	}
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFetchRespectsRobots(t *testing.T) {
+	var robotsHits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsHits.Add(1)
+			_, _ = w.Write([]byte("User-agent: *\nDisallow: /blocked\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<html><body><h1>Open</h1><p>Allowed page.</p></body></html>"))
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.Robots = NewRobots()
+
+	if _, err := Fetch(context.Background(), ts.URL+"/open", cfg); err != nil {
+		t.Fatalf("fetch allowed page: %v", err)
+	}
+	res, err := Fetch(context.Background(), ts.URL+"/blocked/page", cfg)
+	if !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("expected ErrDisallowedByRobots, got %v", err)
+	}
+	if got := traceString(res.Trace); got != "robots:disallowed" {
+		t.Fatalf("unexpected trace: %s", got)
+	}
+	if robotsHits.Load() != 1 {
+		t.Fatalf("robots.txt fetched %d times, want 1", robotsHits.Load())
+	}
+
+	cfg.Mode = ModeBrowser
+	if _, err := Fetch(context.Background(), ts.URL+"/blocked", cfg); !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("browser mode: expected ErrDisallowedByRobots, got %v", err)
+	}
+}
+
+func TestFetchChecksRobotsBeforeCache(t *testing.T) {
+	var disallow atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			if disallow.Load() {
+				_, _ = w.Write([]byte("User-agent: *\nDisallow: /\n"))
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		_, _ = w.Write([]byte("cached page"))
+	}))
+	defer ts.Close()
+
+	cache, err := OpenCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Mode = ModeRaw
+	cfg.Cache = cache
+	cfg.Robots = NewRobots()
+	if _, err := Fetch(context.Background(), ts.URL+"/page", cfg); err != nil {
+		t.Fatalf("fetch allowed page: %v", err)
+	}
+
+	// A later run sees the page disallowed, though its Markdown is cached.
+	disallow.Store(true)
+	cfg.Robots = NewRobots()
+	res, err := Fetch(context.Background(), ts.URL+"/page", cfg)
+	if !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("expected ErrDisallowedByRobots, got %q, %v", res.Markdown, err)
+	}
+	if got := traceString(res.Trace); got != "robots:disallowed" {
+		t.Fatalf("unexpected trace: %s", got)
+	}
+}
+
+func TestRobotsStatusHandling(t *testing.T) {
+	status := http.StatusNotFound
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	if err := NewRobots().check(context.Background(), ts.URL+"/page", cfg); err != nil {
+		t.Fatalf("404 robots.txt should allow everything: %v", err)
+	}
+
+	status = http.StatusServiceUnavailable
+	err := NewRobots().check(context.Background(), ts.URL+"/page", cfg)
+	if !errors.Is(err, ErrDisallowedByRobots) {
+		t.Fatalf("5xx robots.txt should disallow everything, got %v", err)
+	}
+}
+
+func TestRobotsWaitHonorsCrawlDelay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("User-agent: *\nCrawl-delay: 2\n"))
+	}))
+	defer ts.Close()
+
+	robots := NewRobots()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	robots.now = func() time.Time { return now }
+	var slept []time.Duration
+	robots.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+
+	cfg := DefaultConfig()
+	for range 3 {
+		if err := robots.Wait(context.Background(), ts.URL+"/page", cfg); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	now = now.Add(10 * time.Second)
+	if err := robots.Wait(context.Background(), ts.URL+"/page", cfg); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+
+	want := []time.Duration{0, 2 * time.Second, 4 * time.Second, 0}
+	if len(slept) != len(want) {
+		t.Fatalf("slept %v, want %v", slept, want)
+	}
+	for i := range want {
+		if slept[i] != want[i] {
+			t.Fatalf("slept %v, want %v", slept, want)
+		}
+	}
+}
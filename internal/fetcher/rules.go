@@ -0,0 +1,195 @@
+package fetcher
+
+import (
+	"net/http"
+	nurl "net/url"
+	"regexp"
+	"strings"
+)
+
+// SiteRule adjusts how the pages under a host and path are fetched and
+// extracted. Of the Config.SiteRules that match a URL, the most specific one
+// applies, after the HostRules.
+type SiteRule struct {
+	// Name identifies the rule in traces.
+	Name string
+	// HostRule holds the host pattern and the settings the rule overrides.
+	HostRule
+	// Path is matched against the URL path, where "*" matches any run of
+	// characters including "/". Empty matches every path.
+	Path string
+
+	// Select and Exclude replace Config.Select and Config.Exclude when set.
+	Select  []string
+	Exclude []string
+	// Cookies are sent to matching URLs, over HTTP and in the browser.
+	Cookies []*http.Cookie
+	// Script replaces Config.Script when set.
+	Script string
+	// Post replaces Config.Post when set.
+	Post PostProcess
+}
+
+// PostProcess rewrites the Markdown body of a result; front matter and fenced
+// code blocks are left alone.
+type PostProcess struct {
+	// StripImages drops ![alt](src) images.
+	StripImages bool
+	// StripLinks replaces [text](href) links with their text.
+	StripLinks bool
+	// Replace rewrites every match of each pattern, in order.
+	Replace []Replacement
+}
+
+// Replacement is a regular expression and its replacement, which may refer
+// to groups as $1.
+type Replacement struct {
+	Pattern *regexp.Regexp
+	With    string
+}
+
+// IsZero reports whether p leaves Markdown unchanged.
+func (p PostProcess) IsZero() bool {
+	return !p.StripImages && !p.StripLinks && len(p.Replace) == 0
+}
+
+// Matches reports whether the rule applies to u.
+func (r SiteRule) Matches(u *nurl.URL) bool {
+	return r.HostRule.Matches(u.Hostname()) && matchPathPattern(r.Path, u.Path)
+}
+
+// MatchRule returns the most specific of cfg.SiteRules that matches rawURL:
+// the one with the most specific host pattern, then the longest literal path
+// pattern. Equal rules resolve to the first one.
+func (cfg Config) MatchRule(rawURL string) (SiteRule, bool) {
+	u, err := nurl.Parse(rawURL)
+	if err != nil {
+		return SiteRule{}, false
+	}
+	best := -1
+	for i, rule := range cfg.SiteRules {
+		if !rule.Matches(u) {
+			continue
+		}
+		if best < 0 || moreSpecific(rule, cfg.SiteRules[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return SiteRule{}, false
+	}
+	return cfg.SiteRules[best], true
+}
+
+func moreSpecific(a, b SiteRule) bool {
+	if sa, sb := a.Specificity(), b.Specificity(); sa != sb {
+		return sa > sb
+	}
+	return len(strings.ReplaceAll(a.Path, "*", "")) > len(strings.ReplaceAll(b.Path, "*", ""))
+}
+
+func (cfg *Config) applySiteRule(rule SiteRule) {
+	cfg.applyHostRule(rule.HostRule)
+	cfg.Rule = rule.Name
+	if len(rule.Select) > 0 {
+		cfg.Select = rule.Select
+	}
+	if len(rule.Exclude) > 0 {
+		cfg.Exclude = rule.Exclude
+	}
+	if len(rule.Cookies) > 0 {
+		cfg.Cookies = append(append([]*http.Cookie(nil), cfg.Cookies...), rule.Cookies...)
+	}
+	if rule.Script != "" {
+		cfg.Script = rule.Script
+	}
+	if !rule.Post.IsZero() {
+		cfg.Post = rule.Post
+	}
+}
+
+// matchPathPattern matches path against pattern, where "*" matches any run
+// of characters. An empty pattern matches every path.
+func matchPathPattern(pattern, path string) bool {
+	if pattern == "" {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	if len(parts) == 1 {
+		return path == ""
+	}
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(path, part)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(part):]
+	}
+	return strings.HasSuffix(path, last)
+}
+
+// markdownInlineRe matches an inline link or, with a leading "!", an image.
+var markdownInlineRe = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)]*)\)`)
+
+// apply rewrites the body of md, keeping its front matter and code blocks.
+func (p PostProcess) apply(md string) string {
+	if p.IsZero() {
+		return md
+	}
+	front, body := splitYAMLFrontMatter(md)
+
+	var out strings.Builder
+	var text strings.Builder
+	flush := func() {
+		out.WriteString(p.rewrite(text.String()))
+		text.Reset()
+	}
+	inFence := false
+	for _, line := range strings.SplitAfter(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		isFence := strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+		switch {
+		case isFence && !inFence:
+			flush()
+			inFence = true
+			out.WriteString(line)
+		case inFence:
+			out.WriteString(line)
+			if isFence {
+				inFence = false
+			}
+		default:
+			text.WriteString(line)
+		}
+	}
+	flush()
+	return front + out.String()
+}
+
+func (p PostProcess) rewrite(text string) string {
+	if p.StripImages || p.StripLinks {
+		text = markdownInlineRe.ReplaceAllStringFunc(text, func(m string) string {
+			sub := markdownInlineRe.FindStringSubmatch(m)
+			switch {
+			case sub[1] == "!" && p.StripImages:
+				return ""
+			case sub[1] == "" && p.StripLinks:
+				return sub[2]
+			default:
+				return m
+			}
+		})
+	}
+	for _, r := range p.Replace {
+		text = r.Pattern.ReplaceAllString(text, r.With)
+	}
+	return text
+}
@@ -0,0 +1,123 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestMatchPathPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"", "/anything", true},
+		{"/", "", true},
+		{"/docs", "/docs", true},
+		{"/docs", "/docs/intro", false},
+		{"/docs/*", "/docs/a/b", true},
+		{"/docs/*", "/blog/a", false},
+		{"*/edit", "/wiki/page/edit", true},
+		{"/a/*/c/*", "/a/b/c/d", true},
+		{"/a/*/c/*", "/a/b/d", false},
+		{"/a*a", "/a", false},
+	}
+	for _, tt := range tests {
+		if got := matchPathPattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchPathPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestConfigMatchRule(t *testing.T) {
+	cfg := Config{SiteRules: []SiteRule{
+		{Name: "any", HostRule: HostRule{Host: "*"}},
+		{Name: "site", HostRule: HostRule{Host: "*.example.com"}},
+		{Name: "docs", HostRule: HostRule{Host: "docs.example.com"}, Path: "/docs/*"},
+		{Name: "api", HostRule: HostRule{Host: "docs.example.com"}, Path: "/docs/api/*"},
+		{Name: "docs-again", HostRule: HostRule{Host: "docs.example.com"}, Path: "/docs/*"},
+	}}
+	for rawURL, want := range map[string]string{
+		"https://docs.example.com/docs/api/x": "api",
+		"https://docs.example.com/docs/guide": "docs",
+		"https://docs.example.com/blog":       "site",
+		"https://example.com/":                "site",
+		"https://other.test/":                 "any",
+	} {
+		rule, ok := cfg.MatchRule(rawURL)
+		if !ok || rule.Name != want {
+			t.Errorf("MatchRule(%q) = %q, %v, want %q", rawURL, rule.Name, ok, want)
+		}
+	}
+	if _, ok := (Config{SiteRules: cfg.SiteRules[2:]}).MatchRule("https://other.test/docs/x"); ok {
+		t.Error("rule matched another host")
+	}
+}
+
+func TestPostProcessApply(t *testing.T) {
+	p := PostProcess{
+		StripImages: true,
+		StripLinks:  true,
+		Replace:     []Replacement{{Pattern: regexp.MustCompile(`(?m)^Edit this page$\n?`), With: ""}},
+	}
+	md := "---\ntitle: \"[a](b)\"\n---\n\n# Title ![logo](/l.png)\n\nSee [the guide](/guide).\nEdit this page\n\n```\n[kept](/x) ![kept](/y)\n```\n"
+	want := "---\ntitle: \"[a](b)\"\n---\n\n# Title \n\nSee the guide.\n\n```\n[kept](/x) ![kept](/y)\n```\n"
+	if got := p.apply(md); got != want {
+		t.Fatalf("apply:\n%q\nwant\n%q", got, want)
+	}
+	if got := (PostProcess{}).apply(md); got != md {
+		t.Fatalf("zero PostProcess changed markdown: %q", got)
+	}
+}
+
+func TestFetchAppliesSiteRule(t *testing.T) {
+	var gotCookie string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("consent"); err == nil {
+			gotCookie = c.Value
+		}
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><title>T</title></head><body>
+<nav>Menu</nav>
+<main><h1>Guide</h1><p>Read <a href="/more">more</a>.</p><aside class="ad">Buy now</aside></main>
+</body></html>`))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.IncludeMeta = false
+	cfg.SiteRules = []SiteRule{{
+		Name:     "docs",
+		HostRule: HostRule{Host: "127.0.0.1"},
+		Path:     "/docs/*",
+		Select:   []string{"main"},
+		Exclude:  []string{".ad"},
+		Cookies:  []*http.Cookie{{Name: "consent", Value: "yes"}},
+		Post:     PostProcess{StripLinks: true},
+	}}
+	res, err := Fetch(context.Background(), srv.URL+"/docs/guide", cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Markdown != "# Guide\n\nRead more.\n" {
+		t.Fatalf("markdown %q", res.Markdown)
+	}
+	if gotCookie != "yes" {
+		t.Fatalf("cookie %q", gotCookie)
+	}
+	if len(res.Trace) == 0 || res.Trace[0] != (TraceEvent{Stage: "rules", Decision: "matched", Detail: "docs"}) {
+		t.Fatalf("trace %+v", res.Trace)
+	}
+
+	gotCookie = ""
+	res, err = Fetch(context.Background(), srv.URL+"/blog", cfg)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if gotCookie != "" || res.Trace[0].Stage == "rules" {
+		t.Fatalf("rule applied outside its path: cookie %q, trace %+v", gotCookie, res.Trace)
+	}
+}
@@ -0,0 +1,114 @@
+package fetcher
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// CheckSelector reports whether sel, possibly a comma-separated group, is a
+// valid CSS selector for Config.Select and Config.Exclude.
+func CheckSelector(sel string) error {
+	if _, err := cascadia.ParseGroup(sel); err != nil {
+		return fmt.Errorf("invalid selector %q: %w", sel, err)
+	}
+	return nil
+}
+
+// extractMarkdown converts an HTML page to Markdown. Without Config.Select and
+// Config.Exclude it is staticHTMLToMarkdown. Exclude removes nodes before
+// extraction; Select replaces the readability article with the matching
+// nodes, whose content is trusted and so always passes the quality check.
+func extractMarkdown(body []byte, pageURL string, cfg Config) (string, bool, error) {
+	if len(cfg.Select) == 0 && len(cfg.Exclude) == 0 {
+		return staticHTMLToMarkdown(body, pageURL, cfg.MinQualityText)
+	}
+	selected, err := selectHTML(body, cfg.Select, cfg.Exclude)
+	if err != nil {
+		return "", false, err
+	}
+	if len(cfg.Select) == 0 {
+		return staticHTMLToMarkdown(selected, pageURL, cfg.MinQualityText)
+	}
+	// Resolve relative links and images against the page, as readability
+	// does for the article.
+	md, err := htmltomarkdown.ConvertString(string(selected), converter.WithDomain(pageURL))
+	if err != nil {
+		return "", false, fmt.Errorf("convert HTML to markdown: %w", err)
+	}
+	md = strings.TrimSpace(md)
+	if md == "" {
+		return "", false, fmt.Errorf("%w: selected elements have no text", ErrNoContent)
+	}
+	return md + "\n", true, nil
+}
+
+// selectHTML removes the nodes matching exclude from the page, then returns
+// the nodes matching sel in document order, or the whole page when sel is
+// empty. Nodes inside an already selected node are not repeated.
+func selectHTML(body []byte, sel, exclude []string) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("parse HTML: %w", err)
+	}
+	for _, s := range exclude {
+		group, err := cascadia.ParseGroup(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		for _, n := range cascadia.QueryAll(doc, group) {
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(sel) == 0 {
+		if err := html.Render(&buf, doc); err != nil {
+			return nil, fmt.Errorf("render HTML: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	matchers := make([]cascadia.SelectorGroup, 0, len(sel))
+	for _, s := range sel {
+		group, err := cascadia.ParseGroup(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		matchers = append(matchers, group)
+	}
+	matches := func(n *html.Node) bool {
+		for _, m := range matchers {
+			if m.Match(n) {
+				return true
+			}
+		}
+		return false
+	}
+	var walk func(n *html.Node) error
+	walk = func(n *html.Node) error {
+		if n.Type == html.ElementNode && matches(n) {
+			return html.Render(&buf, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(doc); err != nil {
+		return nil, fmt.Errorf("render HTML: %w", err)
+	}
+	if buf.Len() == 0 {
+		return nil, fmt.Errorf("%w: no element matches %s", ErrNoContent, strings.Join(sel, ", "))
+	}
+	return buf.Bytes(), nil
+}
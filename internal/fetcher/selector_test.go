@@ -0,0 +1,62 @@
+package fetcher
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSelectHTML(t *testing.T) {
+	page := []byte(`<html><body>
+<div class="post"><p>One</p><div class="post"><p>Nested</p></div></div>
+<div class="post"><p>Two</p><span class="share">Share</span></div>
+<footer>Foot</footer>
+</body></html>`)
+
+	got, err := selectHTML(page, []string{".post"}, []string{".share"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<div class="post"><p>One</p><div class="post"><p>Nested</p></div></div><div class="post"><p>Two</p></div>`
+	if string(got) != want {
+		t.Fatalf("got %s", got)
+	}
+
+	got, err = selectHTML(page, nil, []string{"footer", ".share"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(got), "Foot") || !strings.Contains(string(got), "Two") {
+		t.Fatalf("exclude only: %s", got)
+	}
+
+	if _, err := selectHTML(page, []string{"article"}, nil); !errors.Is(err, ErrNoContent) {
+		t.Fatalf("expected ErrNoContent, got %v", err)
+	}
+	if err := CheckSelector("div[class"); err == nil {
+		t.Fatal("invalid selector accepted")
+	}
+}
+
+func TestExtractMarkdownSelect(t *testing.T) {
+	page := []byte(`<html><body><nav>Menu</nav><article><h2>Short</h2><p>Body text.</p></article></body></html>`)
+	md, ok, err := extractMarkdown(page, "https://example.com/", Config{Select: []string{"article"}, MinQualityText: 220})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || md != "## Short\n\nBody text.\n" {
+		t.Fatalf("got %q, quality %v", md, ok)
+	}
+
+	// Links resolve against the page URL, as on the readability path.
+	page = []byte(`<html><body><main><p><a href="intro">Intro</a> <img src="/img/a.png" alt="A"></p></main></body></html>`)
+	md, _, err = extractMarkdown(page, "https://example.com/docs/guide", Config{Select: []string{"main"}})
+	if err != nil || md != "[Intro](https://example.com/docs/intro) ![A](https://example.com/img/a.png)\n" {
+		t.Fatalf("got %q, %v", md, err)
+	}
+
+	_, _, err = extractMarkdown([]byte(`<html><body><main> <br> </main></body></html>`), "https://example.com/", Config{Select: []string{"main"}})
+	if !errors.Is(err, ErrNoContent) {
+		t.Fatalf("expected ErrNoContent, got %v", err)
+	}
+}
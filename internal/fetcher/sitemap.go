@@ -0,0 +1,242 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	nurl "net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// sitemapMaxBytes is the size limit of the sitemap protocol, applied to the
+	// download and to the decompressed document.
+	sitemapMaxBytes = 50 << 20
+	// sitemapMaxDepth bounds nested sitemap indexes.
+	sitemapMaxDepth = 4
+	// sitemapMaxFiles bounds the number of sitemap documents read per call.
+	sitemapMaxFiles = 1000
+)
+
+// SitemapEntry is a page listed in a sitemap.
+type SitemapEntry struct {
+	URL string
+	// LastMod is zero when the sitemap gives no (or an unparsable) lastmod.
+	LastMod time.Time
+}
+
+// Sitemap lists the pages in the sitemaps of rawURL. A site root (an empty or
+// "/" path) is looked up through the Sitemap lines of its robots.txt and
+// /sitemap.xml; any other URL is read as a sitemap itself. Sitemap indexes are
+// followed, gzip-compressed and plain-text sitemaps are accepted, and pages
+// are returned once each, in document order.
+//
+// Sitemaps that cannot be read are reported in the returned error, alongside
+// the entries of the ones that could.
+func Sitemap(ctx context.Context, rawURL string, cfg Config) ([]SitemapEntry, error) {
+	u, err := nurl.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid sitemap URL: %q", rawURL)
+	}
+
+	type pending struct {
+		url      string
+		depth    int
+		optional bool
+	}
+	var queue []pending
+	if u.Path == "" || u.Path == "/" {
+		for _, loc := range discoverSitemaps(ctx, u, cfg) {
+			queue = append(queue, pending{url: loc})
+		}
+		fallback := robotsKey(u) + "/sitemap.xml"
+		if !slices.ContainsFunc(queue, func(p pending) bool { return p.url == fallback }) {
+			// /sitemap.xml is only a guess when robots.txt lists other sitemaps.
+			queue = append(queue, pending{url: fallback, optional: len(queue) > 0})
+		}
+	} else {
+		queue = append(queue, pending{url: rawURL})
+	}
+
+	var (
+		entries []SitemapEntry
+		errs    []error
+		read    int
+	)
+	seenEntries := map[string]bool{}
+	seenFiles := map[string]bool{}
+	for len(queue) > 0 && ctx.Err() == nil {
+		next := queue[0]
+		queue = queue[1:]
+		if seenFiles[next.url] {
+			continue
+		}
+		seenFiles[next.url] = true
+		if read >= sitemapMaxFiles {
+			errs = append(errs, fmt.Errorf("sitemap %s: skipped, more than %d sitemaps", next.url, sitemapMaxFiles))
+			continue
+		}
+		read++
+
+		doc, err := fetchSitemap(ctx, next.url, cfg)
+		if err != nil {
+			if !next.optional || !errors.Is(err, ErrHTTPStatus) {
+				errs = append(errs, fmt.Errorf("sitemap %s: %w", next.url, err))
+			}
+			continue
+		}
+		for _, entry := range doc.entries {
+			if !seenEntries[entry.URL] {
+				seenEntries[entry.URL] = true
+				entries = append(entries, entry)
+			}
+		}
+		for _, child := range doc.sitemaps {
+			if next.depth+1 > sitemapMaxDepth {
+				errs = append(errs, fmt.Errorf("sitemap %s: skipped, indexes nested deeper than %d", child, sitemapMaxDepth))
+				continue
+			}
+			queue = append(queue, pending{url: child, depth: next.depth + 1})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return entries, errors.Join(errs...)
+}
+
+// discoverSitemaps returns the Sitemap lines of the robots.txt of u's host.
+// It reuses cfg.Robots when set; an unreadable robots.txt yields nothing.
+func discoverSitemaps(ctx context.Context, u *nurl.URL, cfg Config) []string {
+	var (
+		rules *robotsRules
+		err   error
+	)
+	if cfg.Robots != nil {
+		rules, err = cfg.Robots.rules(ctx, u, cfg)
+	} else {
+		rules, err = fetchRobots(ctx, robotsKey(u)+"/robots.txt", cfg)
+	}
+	if err != nil || rules == nil {
+		return nil
+	}
+	var locs []string
+	for _, loc := range rules.sitemaps {
+		if resolved, ok := resolveLink(u, loc); ok {
+			locs = append(locs, resolved)
+		}
+	}
+	return locs
+}
+
+type sitemapDocument struct {
+	entries  []SitemapEntry
+	sitemaps []string
+}
+
+func fetchSitemap(ctx context.Context, sitemapURL string, cfg Config) (sitemapDocument, error) {
+	sitemapCfg := cfg
+	sitemapCfg.MaxBodyBytes = sitemapMaxBytes
+	resp, err := fetchHTTPWithAccept(ctx, sitemapURL, sitemapCfg, "application/xml, text/xml;q=0.9, text/plain;q=0.8, */*;q=0.1")
+	if err != nil {
+		return sitemapDocument{}, err
+	}
+	base, err := nurl.Parse(resp.FinalURL)
+	if err != nil {
+		return sitemapDocument{}, fmt.Errorf("parse final URL: %w", err)
+	}
+	return parseSitemap(resp.Body, base)
+}
+
+// parseSitemap reads a <urlset>, a <sitemapindex> or a plain-text list of
+// URLs, gunzipping body first when needed.
+func parseSitemap(body []byte, base *nurl.URL) (sitemapDocument, error) {
+	if len(body) >= 2 && body[0] == 0x1f && body[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return sitemapDocument{}, fmt.Errorf("gunzip sitemap: %w", err)
+		}
+		body, err = io.ReadAll(io.LimitReader(zr, sitemapMaxBytes+1))
+		if err != nil {
+			return sitemapDocument{}, fmt.Errorf("gunzip sitemap: %w", err)
+		}
+		if len(body) > sitemapMaxBytes {
+			return sitemapDocument{}, fmt.Errorf("sitemap larger than %d bytes", sitemapMaxBytes)
+		}
+	}
+
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] != '<' {
+		return parseTextSitemap(trimmed, base), nil
+	}
+
+	var raw struct {
+		XMLName  xml.Name
+		URLs     []sitemapLoc `xml:"url"`
+		Sitemaps []sitemapLoc `xml:"sitemap"`
+	}
+	if err := xml.Unmarshal(body, &raw); err != nil {
+		return sitemapDocument{}, fmt.Errorf("parse sitemap XML: %w", err)
+	}
+	var doc sitemapDocument
+	switch raw.XMLName.Local {
+	case "urlset":
+		for _, loc := range raw.URLs {
+			if resolved, ok := resolveLink(base, loc.Loc); ok {
+				doc.entries = append(doc.entries, SitemapEntry{URL: resolved, LastMod: parseLastMod(loc.LastMod)})
+			}
+		}
+	case "sitemapindex":
+		for _, loc := range raw.Sitemaps {
+			if resolved, ok := resolveLink(base, loc.Loc); ok {
+				doc.sitemaps = append(doc.sitemaps, resolved)
+			}
+		}
+	default:
+		return sitemapDocument{}, fmt.Errorf("not a sitemap: root element <%s>", raw.XMLName.Local)
+	}
+	return doc, nil
+}
+
+type sitemapLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+func parseTextSitemap(body []byte, base *nurl.URL) sitemapDocument {
+	var doc sitemapDocument
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64<<10), sitemapMaxBytes)
+	for sc.Scan() {
+		if resolved, ok := resolveLink(base, sc.Text()); ok {
+			doc.entries = append(doc.entries, SitemapEntry{URL: resolved})
+		}
+	}
+	return doc
+}
+
+// lastModLayouts are the W3C Datetime forms allowed for <lastmod>.
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseLastMod(v string) time.Time {
+	v = strings.TrimSpace(v)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,114 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSitemapTestSite(t *testing.T, robots string) *httptest.Server {
+	t.Helper()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := func(s string) string { return strings.ReplaceAll(s, "BASE", ts.URL) }
+		switch r.URL.Path {
+		case "/robots.txt":
+			if robots == "" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(body(robots)))
+		case "/sitemap_index.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(body(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>BASE/docs.xml.gz</loc><lastmod>2025-01-01</lastmod></sitemap>
+  <sitemap><loc>/blog.txt</loc></sitemap>
+  <sitemap><loc>BASE/sitemap_index.xml</loc></sitemap>
+</sitemapindex>`)))
+		case "/docs.xml.gz":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write([]byte(body(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> BASE/docs/a </loc><lastmod>2025-03-04T05:06:07+00:00</lastmod></url>
+  <url><loc>BASE/docs/b</loc><lastmod>2024-12</lastmod></url>
+  <url><loc>BASE/docs/a</loc></url>
+  <url><loc>mailto:team@example.com</loc></url>
+</urlset>`)))
+			_ = zw.Close()
+			w.Header().Set("Content-Type", "application/gzip")
+			_, _ = w.Write(buf.Bytes())
+		case "/blog.txt":
+			_, _ = w.Write([]byte(body("BASE/blog/1\n\nBASE/blog/2\n")))
+		case "/sitemap.xml":
+			_, _ = w.Write([]byte(body(`<urlset><url><loc>BASE/home</loc></url></urlset>`)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func sitemapURLs(base string, entries []SitemapEntry) string {
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, strings.TrimPrefix(entry.URL, base))
+	}
+	return strings.Join(urls, " ")
+}
+
+func TestSitemapDiscoversIndexesThroughRobots(t *testing.T) {
+	ts := newSitemapTestSite(t, "User-agent: *\nSitemap: BASE/sitemap_index.xml\n")
+
+	entries, err := Sitemap(context.Background(), ts.URL, DefaultConfig())
+	if err != nil {
+		t.Fatalf("sitemap: %v", err)
+	}
+	// /sitemap.xml is read too; the index lists itself, which is not re-read.
+	if got := sitemapURLs(ts.URL, entries); got != "/home /docs/a /docs/b /blog/1 /blog/2" {
+		t.Fatalf("unexpected URLs: %s", got)
+	}
+	if want := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC); !entries[1].LastMod.Equal(want) {
+		t.Fatalf("lastmod = %v, want %v", entries[1].LastMod, want)
+	}
+	if want := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC); !entries[2].LastMod.Equal(want) {
+		t.Fatalf("lastmod = %v, want %v", entries[2].LastMod, want)
+	}
+	if !entries[3].LastMod.IsZero() {
+		t.Fatalf("text sitemap lastmod = %v, want zero", entries[3].LastMod)
+	}
+}
+
+func TestSitemapFallbackAndErrors(t *testing.T) {
+	ts := newSitemapTestSite(t, "")
+
+	entries, err := Sitemap(context.Background(), ts.URL+"/", DefaultConfig())
+	if err != nil {
+		t.Fatalf("sitemap: %v", err)
+	}
+	if got := sitemapURLs(ts.URL, entries); got != "/home" {
+		t.Fatalf("unexpected URLs: %s", got)
+	}
+
+	entries, err = Sitemap(context.Background(), ts.URL+"/docs.xml.gz", DefaultConfig())
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("direct sitemap: %d entries, err %v", len(entries), err)
+	}
+
+	_, err = Sitemap(context.Background(), ts.URL+"/missing.xml", DefaultConfig())
+	if err == nil || !strings.Contains(err.Error(), "missing.xml") {
+		t.Fatalf("expected error naming the sitemap, got %v", err)
+	}
+}
+
+func TestParseSitemapRejectsOtherXML(t *testing.T) {
+	if _, err := parseSitemap([]byte(`<rss><channel/></rss>`), nil); err == nil {
+		t.Fatal("expected error for non-sitemap XML")
+	}
+}
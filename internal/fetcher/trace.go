@@ -0,0 +1,85 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// pipelineTrace collects per-stage timings and decisions while a single Fetch runs.
+type pipelineTrace struct {
+	resp     *responseData
+	attempts int
+	charset  string
+	timings  []StageTiming
+	events   []TraceEvent
+}
+
+func (t *pipelineTrace) note(stage, decision, detail string) {
+	t.events = append(t.events, TraceEvent{Stage: stage, Decision: decision, Detail: detail})
+}
+
+func (t *pipelineTrace) timing(stage string, start time.Time) {
+	t.timings = append(t.timings, StageTiming{Stage: stage, Duration: time.Since(start)})
+}
+
+func (t *pipelineTrace) fetchHTTP(ctx context.Context, rawURL string, cfg Config) (responseData, error) {
+	start := time.Now()
+	resp, err := fetchHTTP(ctx, rawURL, cfg, true)
+	t.timing("http", start)
+	if resp.StatusCode != 0 {
+		t.resp = &resp
+	}
+	t.attempts = resp.Attempts
+	if resp.Attempts > 1 {
+		t.note("http", "retried", fmt.Sprintf("%d attempts", resp.Attempts))
+	}
+	if resp.CacheStatus != "" {
+		t.note("cache", resp.CacheStatus, "http response")
+	}
+	if err != nil {
+		if errors.Is(err, ErrDisallowedByRobots) {
+			t.note("robots", "disallowed", err.Error())
+		} else {
+			t.note("http", "error", err.Error())
+		}
+		return resp, err
+	}
+	t.note("http", "ok", resp.ContentType)
+	return resp, nil
+}
+
+// toUTF8 returns resp with its body transcoded to UTF-8, noting the charset
+// when the body was in another one.
+func (t *pipelineTrace) toUTF8(resp responseData) responseData {
+	body, name, source := toUTF8(resp.Body, resp.ContentType)
+	t.charset = name
+	if name != "" && name != "utf-8" {
+		t.note("charset", "transcoded", name+" from "+source)
+	}
+	resp.Body = body
+	return resp
+}
+
+func (t *pipelineTrace) withMetaForMarkdownResponse(ctx context.Context, rawURL string, cfg Config, md string) string {
+	start := time.Now()
+	out := withMetaForMarkdownResponse(ctx, rawURL, cfg, md)
+	t.timing("meta", start)
+	return out
+}
+
+func (t *pipelineTrace) apply(res *Result) {
+	if t.resp != nil {
+		res.StatusCode = t.resp.StatusCode
+		res.ContentType = t.resp.ContentType
+		res.Header = t.resp.Header
+		res.BodyBytes = len(t.resp.Body)
+	}
+	if t.charset != "" {
+		res.Charset = t.charset
+	}
+	res.Attempts = t.attempts
+	res.Timings = t.timings
+	res.Trace = t.events
+}
@@ -0,0 +1,132 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchRecordsHTTPDiagnostics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("X-Served-By", "test")
+		fmt.Fprint(w, "# Title\n\nBody text.\n")
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.IncludeMeta = false
+	cfg.Timeout = 5 * time.Second
+
+	res, err := Fetch(context.Background(), ts.URL, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", res.StatusCode)
+	}
+	if res.ContentType != "text/markdown; charset=utf-8" {
+		t.Fatalf("unexpected content type: %q", res.ContentType)
+	}
+	if res.Header.Get("X-Served-By") != "test" {
+		t.Fatalf("expected response headers to be kept, got %v", res.Header)
+	}
+	if res.BodyBytes != len("# Title\n\nBody text.\n") {
+		t.Fatalf("unexpected body bytes: %d", res.BodyBytes)
+	}
+	if len(res.Timings) == 0 || res.Timings[0].Stage != "http" {
+		t.Fatalf("expected http timing first, got %+v", res.Timings)
+	}
+	if got := traceString(res.Trace); got != "http:ok markdown:accepted" {
+		t.Fatalf("unexpected trace: %s", got)
+	}
+}
+
+func TestFetchAutoTraceExplainsBrowserFallback(t *testing.T) {
+	originalBrowserFn := browserHTMLToMarkdownFn
+	browserHTMLToMarkdownFn = func(_ context.Context, _ string, _ Config) (browserPage, error) {
+		return browserPage{Markdown: "# Browser\n", FinalURL: "https://browser.example/final"}, nil
+	}
+	defer func() {
+		browserHTMLToMarkdownFn = originalBrowserFn
+	}()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<!doctype html><html><body><div id=\"app\">Loading</div></body></html>")
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeAuto
+	cfg.Timeout = 5 * time.Second
+
+	res, err := Fetch(context.Background(), ts.URL, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Source != "browser" {
+		t.Fatalf("expected browser source, got %q", res.Source)
+	}
+	if got := traceString(res.Trace); got != "http:ok markdown:rejected static:rejected browser:fallback browser:accepted" {
+		t.Fatalf("unexpected trace: %s", got)
+	}
+	if !strings.Contains(res.Trace[2].Detail, "text too short") {
+		t.Fatalf("expected quality reason in static trace, got %q", res.Trace[2].Detail)
+	}
+	stages := make([]string, 0, len(res.Timings))
+	for _, timing := range res.Timings {
+		stages = append(stages, timing.Stage)
+	}
+	if strings.Join(stages, ",") != "http,static,browser" {
+		t.Fatalf("unexpected timing stages: %v", stages)
+	}
+}
+
+func TestFetchKeepsStatusCodeOnHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeStatic
+	cfg.Timeout = 5 * time.Second
+
+	res, err := Fetch(context.Background(), ts.URL, cfg)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status code in diagnostics, got %d", res.StatusCode)
+	}
+	if res.Markdown != "" {
+		t.Fatalf("expected no markdown on error, got %q", res.Markdown)
+	}
+}
+
+func TestMarkdownQualityVerdictReasons(t *testing.T) {
+	if ok, reason := markdownQualityVerdict("", 10); ok || reason != "empty output" {
+		t.Fatalf("unexpected verdict for empty input: %v %q", ok, reason)
+	}
+	if ok, reason := markdownQualityVerdict("short", 100); ok || !strings.Contains(reason, "text too short") {
+		t.Fatalf("unexpected verdict for short input: %v %q", ok, reason)
+	}
+	md := "# Heading\n\n## Second\n\n" + strings.Repeat("word ", 40)
+	if ok, _ := markdownQualityVerdict(md, 20); !ok {
+		t.Fatal("expected structured markdown to pass")
+	}
+}
+
+func traceString(events []TraceEvent) string {
+	parts := make([]string, 0, len(events))
+	for _, ev := range events {
+		parts = append(parts, ev.Stage+":"+ev.Decision)
+	}
+	return strings.Join(parts, " ")
+}
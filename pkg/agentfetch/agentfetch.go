@@ -0,0 +1,592 @@
+// Package agentfetch fetches web pages as clean Markdown for AI-agent workflows.
+//
+// It is the importable form of the agent-fetch CLI: the same three-stage
+// pipeline (native Markdown -> static HTML extraction -> headless browser
+// rendering) exposed through a reusable Client.
+//
+//	client, err := agentfetch.New(agentfetch.WithMode(agentfetch.ModeStatic))
+//	if err != nil {
+//		return err
+//	}
+//	res, err := client.Fetch(ctx, "https://example.com")
+package agentfetch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/firede/agent-fetch/internal/fetcher"
+)
+
+// Fetch modes accepted by WithMode.
+const (
+	// ModeAuto tries native Markdown, then static extraction, then falls back
+	// to a headless browser when the static result is low quality.
+	ModeAuto = fetcher.ModeAuto
+	// ModeStatic only performs HTTP fetching and static HTML extraction.
+	ModeStatic = fetcher.ModeStatic
+	// ModeBrowser always renders the page in headless Chrome/Chromium.
+	ModeBrowser = fetcher.ModeBrowser
+	// ModeRaw returns the HTTP response body verbatim.
+	ModeRaw = fetcher.ModeRaw
+)
+
+// Metadata fields accepted by WithMetaFields, in front matter order.
+const (
+	MetaTitle       = fetcher.MetaTitle
+	MetaDescription = fetcher.MetaDescription
+	// MetaAuthor is the author name, from JSON-LD or author meta tags.
+	MetaAuthor = fetcher.MetaAuthor
+	// MetaPublished and MetaModified are the dates as the page states them,
+	// usually ISO 8601.
+	MetaPublished = fetcher.MetaPublished
+	MetaModified  = fetcher.MetaModified
+	MetaSiteName  = fetcher.MetaSiteName
+	// MetaLang is the page language, such as "en" or "en-US".
+	MetaLang = fetcher.MetaLang
+	// MetaCanonical is the absolute canonical URL of the page.
+	MetaCanonical = fetcher.MetaCanonical
+)
+
+// Values reported in Result.Source.
+const (
+	SourceMarkdown = "http-markdown"
+	SourceStatic   = "http-static"
+	SourceBrowser  = "browser"
+	SourceRaw      = "http-raw"
+	SourcePDF      = "http-pdf"
+)
+
+// Errors returned by Client.Fetch. They are wrapped with request context, so
+// compare them with errors.Is.
+var (
+	// ErrUnsupportedMode is returned when the configured mode is unknown.
+	ErrUnsupportedMode = fetcher.ErrUnsupportedMode
+	// ErrNoContent is returned when a response yields no extractable content.
+	ErrNoContent = fetcher.ErrNoContent
+	// ErrHTTPStatus is returned when the server answers with a 4xx/5xx status.
+	ErrHTTPStatus = fetcher.ErrHTTPStatus
+	// ErrBrowserExecutableNotFound is returned by browser rendering when no
+	// Chrome/Chromium executable can be located.
+	ErrBrowserExecutableNotFound = fetcher.ErrBrowserExecutableNotFound
+	// ErrBrowserPoolClosed is returned when a browser render is attempted on a
+	// closed BrowserPool.
+	ErrBrowserPoolClosed = fetcher.ErrBrowserPoolClosed
+	// ErrDisallowedByRobots is returned when WithRobots is set and robots.txt
+	// forbids the URL for the configured user agent.
+	ErrDisallowedByRobots = fetcher.ErrDisallowedByRobots
+	// ErrBrowserProxyAuth is returned for browser renders through a SOCKS5
+	// proxy with a user name and password, which Chrome cannot send.
+	ErrBrowserProxyAuth = fetcher.ErrBrowserProxyAuth
+	// ErrCredentialHelper is wrapped by the errors passed to
+	// Credentials.SetWarn when the credential helper fails or answers with
+	// malformed lines.
+	ErrCredentialHelper = fetcher.ErrCredentialHelper
+)
+
+// ProxyDirect, passed to WithProxy or set as a HostRule proxy, connects
+// without a proxy and ignores the proxy environment variables.
+const ProxyDirect = fetcher.ProxyDirect
+
+// Cache is an on-disk store for HTTP responses and extracted Markdown. Stale
+// responses are revalidated with ETag/Last-Modified. A directory may be shared
+// by several clients and processes.
+type Cache = fetcher.Cache
+
+// DefaultCacheTTL is the freshness used by the agent-fetch CLI for Markdown and
+// for responses without Cache-Control or Expires.
+const DefaultCacheTTL = fetcher.DefaultCacheTTL
+
+// OpenCache creates dir if needed and returns a cache rooted there. ttl is the
+// freshness of cached Markdown and of responses without an explicit lifetime.
+func OpenCache(dir string, ttl time.Duration) (*Cache, error) {
+	return fetcher.OpenCache(dir, ttl)
+}
+
+// CookieJar is an http.CookieJar that also hands its cookies to the browser
+// and can be saved to a file. Share one across calls to keep a session.
+type CookieJar = fetcher.CookieJar
+
+// NewCookieJar returns an empty cookie jar.
+func NewCookieJar() *CookieJar {
+	return fetcher.NewCookieJar()
+}
+
+// LoadCookieJar returns a jar holding the cookies of a Netscape cookies.txt
+// file or a JSON cookie export, such as those of browser extensions,
+// Playwright or Puppeteer. CookieJar.Save writes either format back.
+func LoadCookieJar(path string) (*CookieJar, error) {
+	return fetcher.LoadCookieJar(path)
+}
+
+// Credentials authenticate requests to the hosts named in a .netrc file or
+// answered for by a credential helper. Each request, including every
+// redirect hop and browser subrequest, gets only its own host's credentials.
+type Credentials = fetcher.Credentials
+
+// NewCredentials reads the .netrc file at netrcPath and sets the credential
+// helper command, either of which may be empty. The helper is run through
+// the shell with the argument "get", is sent "protocol=" and "host=" lines,
+// and answers with username/password, authtype/credential or
+// "header=Name: value" lines, like a git credential helper. It runs once
+// per host; browser subrequests ask it only about the page's host, .netrc
+// hosts and those given to Credentials.SetHelperHosts. When it fails, the
+// host falls back to .netrc and the failure goes to Credentials.SetWarn.
+func NewCredentials(netrcPath, helper string) (*Credentials, error) {
+	return fetcher.NewCredentials(netrcPath, helper)
+}
+
+// DefaultNetrcPath returns $NETRC, or the .netrc file in the home directory.
+func DefaultNetrcPath() string {
+	return fetcher.DefaultNetrcPath()
+}
+
+// BrowserPool keeps browser processes alive between fetches and renders each
+// page in an isolated tab. Share one pool across calls (and goroutines) that
+// render many pages, and Close it when done.
+type BrowserPool = fetcher.BrowserPool
+
+// NewBrowserPool returns a pool of at most instances browsers, each serving up
+// to tabsPerBrowser concurrent renders. Values below 1 are treated as 1.
+// Browsers are launched on first use.
+func NewBrowserPool(instances, tabsPerBrowser int) *BrowserPool {
+	return fetcher.NewBrowserPool(instances, tabsPerBrowser)
+}
+
+// Robots fetches and caches robots.txt per host. Share one across the fetches
+// of a crawl.
+type Robots = fetcher.Robots
+
+// NewRobots returns an empty robots.txt cache.
+func NewRobots() *Robots {
+	return fetcher.NewRobots()
+}
+
+// RetryPolicy controls how failed HTTP requests and browser renders are
+// retried with exponential backoff. Retry-After is honored on 429 and 503.
+type RetryPolicy = fetcher.RetryPolicy
+
+// DefaultRetryPolicy returns the policy used by the agent-fetch CLI: three
+// attempts on timeouts, connection errors and 408/429/5xx gateway statuses.
+func DefaultRetryPolicy() RetryPolicy {
+	return fetcher.DefaultRetryPolicy()
+}
+
+// HostRule overrides settings for the URLs of one host. Host is a host name,
+// "*.example.com" for example.com and its subdomains, or "*" for every host.
+type HostRule = fetcher.HostRule
+
+// SiteRule adjusts fetching and extraction for the URLs of a host and path:
+// CSS selectors, cookies, a browser script and Markdown post-processing on top
+// of the HostRule settings.
+type SiteRule = fetcher.SiteRule
+
+// PostProcess rewrites the Markdown body of results a SiteRule applies to.
+type PostProcess = fetcher.PostProcess
+
+// Replacement is a regular expression rewrite used by PostProcess.
+type Replacement = fetcher.Replacement
+
+// Chunk is one piece of a Markdown document split by ChunkMarkdown, together
+// with the heading path it belongs to.
+type Chunk = fetcher.Chunk
+
+// TokenCounter estimates the token length of a string. Plug in a real
+// tokenizer to size chunks exactly.
+type TokenCounter = fetcher.TokenCounter
+
+// EstimateTokens is the default TokenCounter: about four bytes per token, one
+// per CJK character.
+func EstimateTokens(s string) int {
+	return fetcher.EstimateTokens(s)
+}
+
+// ChunkMarkdown splits md into chunks of at most maxTokens tokens along
+// heading, paragraph and line boundaries. Code blocks and tables are never
+// split. count may be nil to use EstimateTokens.
+func ChunkMarkdown(md string, maxTokens int, count TokenCounter) []Chunk {
+	return fetcher.ChunkMarkdown(md, maxTokens, count)
+}
+
+// Result is the outcome of a fetch.
+type Result struct {
+	// Markdown is the extracted content, prefixed with YAML front matter when
+	// metadata is enabled and available.
+	Markdown string
+	// Source identifies the pipeline stage that produced Markdown; one of the
+	// Source* constants.
+	Source string
+	// FinalURL is the URL after redirects.
+	FinalURL string
+
+	// StatusCode, ContentType, Header and BodyBytes describe the HTTP stage
+	// response. They are zero when no HTTP request was made (ModeBrowser).
+	StatusCode  int
+	ContentType string
+	Header      http.Header
+	BodyBytes   int
+	// Charset is the character set the body was transcoded to UTF-8 from,
+	// such as "shift_jis"; "utf-8" when it needed no transcoding.
+	Charset string
+
+	// PageCount is the number of pages when the response was a PDF (SourcePDF).
+	PageCount int
+	// Attempts is the number of tries the last HTTP or browser stage took.
+	Attempts int
+
+	// CanonicalURL and Links are only set with WithCollectLinks: the page's
+	// <link rel="canonical"> and its absolute http(s) links in document order.
+	CanonicalURL string
+	Links        []string
+
+	// Timings lists how long each pipeline stage took, in execution order.
+	Timings []StageTiming
+	// Trace lists the decisions taken by the pipeline, in order, e.g. why
+	// auto mode fell back to the browser.
+	Trace []TraceEvent
+}
+
+// StageTiming is the wall-clock duration of one pipeline stage
+// ("http", "static", "browser", "meta").
+type StageTiming struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// TraceEvent records a single pipeline decision, such as
+// {Stage: "static", Decision: "rejected", Detail: "text too short (80 < 220)"}.
+type TraceEvent struct {
+	Stage    string
+	Decision string
+	Detail   string
+}
+
+// Client fetches pages with a fixed set of options. It is safe for
+// concurrent use.
+type Client struct {
+	cfg fetcher.Config
+}
+
+// Option configures a Client or a single Fetch call. Options are created by
+// the With functions of this package.
+type Option interface {
+	apply(*fetcher.Config)
+}
+
+type optionFunc func(*fetcher.Config)
+
+func (f optionFunc) apply(cfg *fetcher.Config) { f(cfg) }
+
+// New returns a Client using the CLI defaults, adjusted by opts.
+func New(opts ...Option) (*Client, error) {
+	cfg := fetcher.DefaultConfig()
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &Client{cfg: cfg}, nil
+}
+
+// Fetch retrieves rawURL and converts it to Markdown. Options passed here
+// apply to this call only and are layered on top of the Client options.
+//
+// Fetch does not impose an overall deadline beyond the configured HTTP and
+// browser timeouts and retry policy; use ctx to bound the whole call. On error the returned
+// Result still carries whatever diagnostics were gathered (status code,
+// timings, trace).
+func (c *Client) Fetch(ctx context.Context, rawURL string, opts ...Option) (Result, error) {
+	cfg := c.config()
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+	if len(opts) > 0 {
+		if err := validateConfig(cfg); err != nil {
+			return Result{}, err
+		}
+	}
+
+	res, err := fetcher.Fetch(ctx, rawURL, cfg)
+	return resultFromFetcher(res), err
+}
+
+// SitemapEntry is a page listed in a sitemap. LastMod is zero when unknown.
+type SitemapEntry = fetcher.SitemapEntry
+
+// Sitemap lists the pages in the sitemaps of rawURL. A site root is looked up
+// through its robots.txt Sitemap lines and /sitemap.xml; any other URL is read
+// as a sitemap. Indexes, gzip and plain-text sitemaps are supported. When some
+// sitemaps fail, the entries of the others are returned with the error.
+func (c *Client) Sitemap(ctx context.Context, rawURL string, opts ...Option) ([]SitemapEntry, error) {
+	cfg := c.config()
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+	return fetcher.Sitemap(ctx, rawURL, cfg)
+}
+
+func (c *Client) config() fetcher.Config {
+	cfg := c.cfg
+	cfg.Headers = c.cfg.Headers.Clone()
+	if cfg.Headers == nil {
+		cfg.Headers = make(http.Header)
+	}
+	return cfg
+}
+
+func resultFromFetcher(res fetcher.Result) Result {
+	out := Result{
+		Markdown:     res.Markdown,
+		Source:       res.Source,
+		FinalURL:     res.FinalURL,
+		StatusCode:   res.StatusCode,
+		ContentType:  res.ContentType,
+		Header:       res.Header,
+		BodyBytes:    res.BodyBytes,
+		Charset:      res.Charset,
+		PageCount:    res.PageCount,
+		Attempts:     res.Attempts,
+		CanonicalURL: res.CanonicalURL,
+		Links:        res.Links,
+	}
+	for _, timing := range res.Timings {
+		out.Timings = append(out.Timings, StageTiming{Stage: timing.Stage, Duration: timing.Duration})
+	}
+	for _, ev := range res.Trace {
+		out.Trace = append(out.Trace, TraceEvent{Stage: ev.Stage, Decision: ev.Decision, Detail: ev.Detail})
+	}
+	return out
+}
+
+func validateConfig(cfg fetcher.Config) error {
+	if !validMode(cfg.Mode) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMode, cfg.Mode)
+	}
+	if _, err := fetcher.ParseMetaFields(strings.Join(cfg.MetaFields, ",")); err != nil {
+		return err
+	}
+	for _, sel := range append(append([]string(nil), cfg.Select...), cfg.Exclude...) {
+		if err := fetcher.CheckSelector(sel); err != nil {
+			return err
+		}
+	}
+	if cfg.Proxy != "" {
+		if _, err := fetcher.ParseProxy(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	for _, rule := range cfg.HostRules {
+		if rule.Mode != "" && !validMode(rule.Mode) {
+			return fmt.Errorf("%w: %s (host rule %s)", ErrUnsupportedMode, rule.Mode, rule.Host)
+		}
+		if rule.Proxy != "" {
+			if _, err := fetcher.ParseProxy(rule.Proxy); err != nil {
+				return fmt.Errorf("host rule %s: %w", rule.Host, err)
+			}
+		}
+	}
+	for _, rule := range cfg.SiteRules {
+		if rule.Mode != "" && !validMode(rule.Mode) {
+			return fmt.Errorf("%w: %s (site rule %s)", ErrUnsupportedMode, rule.Mode, rule.Name)
+		}
+		if rule.Proxy != "" {
+			if _, err := fetcher.ParseProxy(rule.Proxy); err != nil {
+				return fmt.Errorf("site rule %s: %w", rule.Name, err)
+			}
+		}
+		for _, sel := range append(append([]string(nil), rule.Select...), rule.Exclude...) {
+			if err := fetcher.CheckSelector(sel); err != nil {
+				return fmt.Errorf("site rule %s: %w", rule.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validMode(mode string) bool {
+	switch mode {
+	case ModeAuto, ModeStatic, ModeBrowser, ModeRaw:
+		return true
+	}
+	return false
+}
+
+// WithMode selects the fetch mode: ModeAuto (default), ModeStatic,
+// ModeBrowser or ModeRaw.
+func WithMode(mode string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Mode = mode })
+}
+
+// WithTimeout sets the HTTP request timeout used by static and auto modes.
+// It is ignored when a custom client is supplied with WithHTTPClient.
+func WithTimeout(d time.Duration) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Timeout = d })
+}
+
+// WithBrowserTimeout sets the page-load timeout used by browser and auto modes.
+func WithBrowserTimeout(d time.Duration) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.BrowserTimeout = d })
+}
+
+// WithBrowserPath overrides the Chrome/Chromium executable path or name.
+func WithBrowserPath(path string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.BrowserPath = path })
+}
+
+// WithNetworkIdle sets how long the browser waits after the last network
+// activity before capturing the page.
+func WithNetworkIdle(d time.Duration) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.NetworkIdle = d })
+}
+
+// WithWaitSelector makes the browser wait until the CSS selector is visible
+// before capturing the page.
+func WithWaitSelector(selector string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.WaitSelector = selector })
+}
+
+// WithUserAgent sets the User-Agent header for HTTP and browser requests.
+func WithUserAgent(ua string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.UserAgent = ua })
+}
+
+// WithHeader adds a request header. It may be repeated for the same key.
+func WithHeader(key, value string) Option {
+	return optionFunc(func(cfg *fetcher.Config) {
+		if cfg.Headers == nil {
+			cfg.Headers = make(http.Header)
+		}
+		cfg.Headers.Add(key, value)
+	})
+}
+
+// WithHeaders replaces all custom request headers with h.
+func WithHeaders(h http.Header) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Headers = h.Clone() })
+}
+
+// WithMaxBodyBytes caps the number of response bytes read.
+func WithMaxBodyBytes(n int64) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.MaxBodyBytes = n })
+}
+
+// WithMinQualityText sets the minimum amount of text a static extraction must
+// contain before auto mode accepts it without a browser fallback.
+func WithMinQualityText(n int) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.MinQualityText = n })
+}
+
+// WithMeta controls whether title/description front matter is prepended to
+// the Markdown. It is enabled by default.
+func WithMeta(include bool) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.IncludeMeta = include })
+}
+
+// WithMetaFields selects the metadata fields written to the front matter,
+// such as MetaAuthor and MetaPublished, or "all". The default is MetaTitle
+// and MetaDescription.
+func WithMetaFields(fields ...string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.MetaFields = fields })
+}
+
+// WithProxy sends HTTP requests and browser renders through proxy: an
+// http://, https://, socks5:// or socks5h:// URL with an optional
+// user:password, or ProxyDirect. Without it the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables apply. Host rules may set their own proxy.
+// It is ignored for HTTP requests when a custom client is supplied with
+// WithHTTPClient.
+func WithProxy(proxy string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Proxy = proxy })
+}
+
+// WithNoProxy lists hosts reached without the proxy, in NO_PROXY syntax:
+// a host name (matching its subdomains too), ".example.com", an IP address
+// or a CIDR range.
+func WithNoProxy(hosts ...string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.NoProxy = hosts })
+}
+
+// WithCookieJar sends the cookies of jar by domain and path, also across
+// redirects and in the browser, and stores the cookies responses and pages
+// set. Fetches with a jar are then not cached, and for HTTP requests it is
+// ignored when a custom client is supplied with WithHTTPClient.
+func WithCookieJar(jar *CookieJar) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.CookieJar = jar })
+}
+
+// WithCredentials authenticates requests with creds. Headers set with
+// WithHeader win over them. For HTTP requests it is ignored when a custom
+// client is supplied with WithHTTPClient.
+func WithCredentials(creds *Credentials) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Credentials = creds })
+}
+
+// WithHTTPClient sets the client used for HTTP requests. Use it to plug in
+// custom transports, proxies or instrumentation. The browser stage does not
+// use this client.
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.HTTPClient = client })
+}
+
+// WithCache stores HTTP responses and extracted Markdown in cache and serves
+// later fetches from it while fresh.
+func WithCache(cache *Cache) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Cache = cache })
+}
+
+// WithCollectLinks fills Result.Links and Result.CanonicalURL, e.g. to crawl
+// from the fetched page.
+func WithCollectLinks(collect bool) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.CollectLinks = collect })
+}
+
+// WithRobots refuses URLs that robots.txt disallows for the configured user
+// agent. Fetch does not sleep for Crawl-delay; call robots.Wait before each
+// fetch to honor it, or schedule fetches by robots.CrawlDelay.
+func WithRobots(robots *Robots) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Robots = robots })
+}
+
+// WithRetry sets the retry policy. The zero RetryPolicy disables retries.
+func WithRetry(policy RetryPolicy) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Retry = policy })
+}
+
+// WithSelect extracts the elements matching these CSS selectors instead of
+// the readability article, for pages where the heuristic picks the wrong part.
+func WithSelect(selectors ...string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Select = selectors })
+}
+
+// WithExclude removes the elements matching these CSS selectors before
+// extraction.
+func WithExclude(selectors ...string) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.Exclude = selectors })
+}
+
+// WithHostRules adds rules that override the mode, user agent, wait
+// selector, timeouts and headers for matching hosts. When several rules match
+// a URL, later ones win.
+func WithHostRules(rules ...HostRule) Option {
+	return optionFunc(func(cfg *fetcher.Config) {
+		cfg.HostRules = append(append([]HostRule(nil), cfg.HostRules...), rules...)
+	})
+}
+
+// WithSiteRules adds site rules. Of the rules that match a URL, the one with
+// the most specific host and then the longest path applies, after host rules.
+func WithSiteRules(rules ...SiteRule) Option {
+	return optionFunc(func(cfg *fetcher.Config) {
+		cfg.SiteRules = append(append([]SiteRule(nil), cfg.SiteRules...), rules...)
+	})
+}
+
+// WithBrowserPool renders pages in tabs of pool instead of launching a browser
+// per fetch. The caller owns the pool and must Close it.
+func WithBrowserPool(pool *BrowserPool) Option {
+	return optionFunc(func(cfg *fetcher.Config) { cfg.BrowserPool = pool })
+}
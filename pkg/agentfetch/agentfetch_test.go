@@ -0,0 +1,236 @@
+package agentfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientFetchStatic(t *testing.T) {
+	var gotUA, gotHeader string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.Header.Get("User-Agent")
+		gotHeader = r.Header.Get("X-Test")
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<!doctype html>
+<html><head><title>Library Page</title></head><body>
+<main>
+  <h1>Library Page</h1>
+  <p>This body should be extracted and converted into markdown with enough text to pass quality checks.</p>
+</main>
+</body></html>`)
+	}))
+	defer ts.Close()
+
+	client, err := New(
+		WithMode(ModeStatic),
+		WithTimeout(5*time.Second),
+		WithUserAgent("agentfetch-test"),
+		WithHeader("X-Test", "client"),
+	)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	res, err := client.Fetch(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Source != SourceStatic {
+		t.Fatalf("expected source %q, got %q", SourceStatic, res.Source)
+	}
+	if !strings.Contains(res.Markdown, "title: 'Library Page'") {
+		t.Fatalf("expected front matter title, got: %q", res.Markdown)
+	}
+	if gotUA != "agentfetch-test" {
+		t.Fatalf("unexpected user agent: %q", gotUA)
+	}
+	if gotHeader != "client" {
+		t.Fatalf("unexpected header: %q", gotHeader)
+	}
+	if res.StatusCode != http.StatusOK || res.ContentType != "text/html" {
+		t.Fatalf("unexpected response diagnostics: %d %q", res.StatusCode, res.ContentType)
+	}
+	if len(res.Trace) == 0 || res.Trace[len(res.Trace)-1].Stage != "static" {
+		t.Fatalf("expected static decision in trace, got %+v", res.Trace)
+	}
+}
+
+func TestClientFetchPerCallOptionsDoNotLeak(t *testing.T) {
+	var headers []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, strings.Join(r.Header.Values("X-Test"), ","))
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "plain body")
+	}))
+	defer ts.Close()
+
+	client, err := New(WithMode(ModeRaw), WithHeader("X-Test", "base"))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	if _, err := client.Fetch(context.Background(), ts.URL, WithHeader("X-Test", "call")); err != nil {
+		t.Fatalf("first fetch: %v", err)
+	}
+	if _, err := client.Fetch(context.Background(), ts.URL); err != nil {
+		t.Fatalf("second fetch: %v", err)
+	}
+
+	want := []string{"base,call", "base"}
+	if len(headers) != len(want) || headers[0] != want[0] || headers[1] != want[1] {
+		t.Fatalf("unexpected headers per call: got %v want %v", headers, want)
+	}
+}
+
+func TestClientUsesCustomHTTPClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "raw")
+	}))
+	defer ts.Close()
+
+	var used bool
+	hc := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	client, err := New(WithMode(ModeRaw), WithHTTPClient(hc))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	if _, err := client.Fetch(context.Background(), ts.URL); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !used {
+		t.Fatal("expected custom HTTP client to be used")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	if _, err := New(WithMode("nope")); !errors.Is(err, ErrUnsupportedMode) {
+		t.Fatalf("expected ErrUnsupportedMode, got %v", err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	client, err := New(WithMode(ModeStatic))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	res, err := client.Fetch(context.Background(), ts.URL)
+	if !errors.Is(err, ErrHTTPStatus) {
+		t.Fatalf("expected ErrHTTPStatus, got %v", err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status code on error result, got %d", res.StatusCode)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		if hits == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client, err := New(WithMode(ModeRaw), WithRetry(DefaultRetryPolicy()))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	res, err := client.Fetch(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Attempts != 2 || res.Markdown != "ok" {
+		t.Fatalf("attempts %d markdown %q", res.Attempts, res.Markdown)
+	}
+
+	hits = 0
+	if _, err := client.Fetch(context.Background(), ts.URL, WithRetry(RetryPolicy{})); !errors.Is(err, ErrHTTPStatus) || hits != 1 {
+		t.Fatalf("retries disabled: err %v after %d requests", err, hits)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestClientHostRules(t *testing.T) {
+	var gotUA string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA = r.UserAgent()
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client, err := New(WithMode(ModeStatic), WithHostRules(HostRule{Host: "127.0.0.1", Mode: ModeRaw, UserAgent: "docs-bot"}))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	res, err := client.Fetch(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Source != SourceRaw || gotUA != "docs-bot" {
+		t.Fatalf("source %q user agent %q", res.Source, gotUA)
+	}
+
+	if _, err := New(WithHostRules(HostRule{Host: "example.com", Mode: "fast"})); !errors.Is(err, ErrUnsupportedMode) {
+		t.Fatalf("expected ErrUnsupportedMode, got %v", err)
+	}
+}
+
+func TestClientSiteRules(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><body><nav>Menu</nav><main><p>Only this</p></main></body></html>`))
+	}))
+	defer ts.Close()
+
+	client, err := New(WithMode(ModeStatic), WithMeta(false), WithSiteRules(SiteRule{Name: "main", HostRule: HostRule{Host: "127.0.0.1"}, Select: []string{"main"}}))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	res, err := client.Fetch(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if res.Markdown != "Only this\n" {
+		t.Fatalf("markdown %q", res.Markdown)
+	}
+
+	if _, err := New(WithSiteRules(SiteRule{Name: "bad", HostRule: HostRule{Host: "*"}, Select: []string{"div["}})); err == nil {
+		t.Fatal("invalid selector accepted")
+	}
+
+	plain, err := New(WithMode(ModeStatic), WithMeta(false))
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	res, err = plain.Fetch(context.Background(), ts.URL, WithSelect("nav"))
+	if err != nil || res.Markdown != "Menu\n" {
+		t.Fatalf("per-call select: %q, %v", res.Markdown, err)
+	}
+	if _, err := New(WithExclude("div[")); err == nil {
+		t.Fatal("invalid exclude selector accepted")
+	}
+}